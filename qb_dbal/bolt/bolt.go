@@ -16,6 +16,9 @@ var (
 	ErrDatabaseIsNotConnected = errors.New("database_is_not_connected")
 	ErrCollectionDoesNotExists = errors.New("collection_does_not_exists")
 	ErrMissingDocumentKey = errors.New("document_missing_key")
+	ErrInvalidIndex = errors.New("invalid_index")
+	ErrUniqueConstraint = errors.New("unique_constraint_violation")
+	ErrTransactionManaged = errors.New("transaction_managed")
 )
 
 //----------------------------------------------------------------------------------------------------------------------
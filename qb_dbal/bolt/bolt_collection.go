@@ -57,6 +57,9 @@ func (instance *BoltCollection) Drop() error {
 		err := instance.boltdb.Update(func(tx *bbolt.Tx) error {
 			b := tx.Bucket([]byte(instance.name))
 			if nil != b {
+				if err := dropIndexes(tx, instance.name); nil != err {
+					return err
+				}
 				return tx.DeleteBucket([]byte(instance.name))
 			}
 			return nil
@@ -94,15 +97,22 @@ func (instance *BoltCollection) CountByFieldValue(fieldName string, fieldValue i
 		err := instance.boltdb.View(func(tx *bbolt.Tx) error {
 			b := tx.Bucket([]byte(instance.name))
 			if nil != b {
+				if keys, ok := instance.indexedKeys(tx, fieldName, ComparatorEqual, fieldValue); ok {
+					// candidates are checked as in the full scan
+					for _, key := range keys {
+						var entity interface{}
+						if v := b.Get(key); nil != v && nil == json.Unmarshal(v, &entity) && matchFieldValue(entity, fieldName, fieldValue) {
+							response++
+						}
+					}
+					return nil
+				}
 				c := b.Cursor()
 				for k, v := c.First(); k != nil; k, v = c.Next() {
 					var entity interface{}
 					err := json.Unmarshal(v, &entity)
-					if nil == err {
-						value := qbc.Reflect.Get(entity, fieldName)
-						if qbc.Compare.Equals(fieldValue, value) {
-							response++
-						}
+					if nil == err && matchFieldValue(entity, fieldName, fieldValue) {
+						response++
 					}
 				}
 			} else {
@@ -170,15 +180,22 @@ func (instance *BoltCollection) GetByFieldValue(fieldName string, fieldValue int
 		err := instance.boltdb.View(func(tx *bbolt.Tx) error {
 			b := tx.Bucket([]byte(instance.name))
 			if nil != b {
+				if keys, ok := instance.indexedKeys(tx, fieldName, ComparatorEqual, fieldValue); ok {
+					// candidates are checked as in the full scan
+					for _, key := range keys {
+						var entity interface{}
+						if v := b.Get(key); nil != v && nil == json.Unmarshal(v, &entity) && matchFieldValue(entity, fieldName, fieldValue) {
+							response = append(response, entity)
+						}
+					}
+					return nil
+				}
 				c := b.Cursor()
 				for k, v := c.First(); k != nil; k, v = c.Next() {
 					var entity interface{}
 					err := json.Unmarshal(v, &entity)
-					if nil == err {
-						value := qbc.Reflect.Get(entity, fieldName)
-						if qbc.Compare.Equals(fieldValue, value) {
-							response = append(response, entity)
-						}
+					if nil == err && matchFieldValue(entity, fieldName, fieldValue) {
+						response = append(response, entity)
 					}
 				}
 			} else {
@@ -191,12 +208,22 @@ func (instance *BoltCollection) GetByFieldValue(fieldName string, fieldValue int
 	return response, ErrDatabaseIsNotConnected
 }
 
+// Find returns documents matching the query, sorted and paginated as declared in the query
 func (instance *BoltCollection) Find(query *BoltQuery) ([]interface{}, error) {
 	response := make([]interface{}, 0)
 	if nil != instance && nil != instance.boltdb {
 		err := instance.boltdb.View(func(tx *bbolt.Tx) error {
 			b := tx.Bucket([]byte(instance.name))
 			if nil != b {
+				if keys, ok := instance.plan(tx, query); ok {
+					for _, key := range keys {
+						var entity map[string]interface{}
+						if v := b.Get(key); nil != v && nil == json.Unmarshal(v, &entity) && query.MatchFilter(entity) {
+							response = append(response, entity)
+						}
+					}
+					return nil
+				}
 				c := b.Cursor()
 				for k, v := c.First(); k != nil; k, v = c.Next() {
 					var entity map[string]interface{}
@@ -212,7 +239,7 @@ func (instance *BoltCollection) Find(query *BoltQuery) ([]interface{}, error) {
 			}
 			return nil
 		})
-		return response, err
+		return query.SortAndLimit(response), err
 	}
 	return response, ErrDatabaseIsNotConnected
 }
@@ -267,27 +294,26 @@ func unmarshal(v []byte, entity interface{}) (interface{}, error) {
 	}
 }
 
+// matchFieldValue is the equality of GetByFieldValue and CountByFieldValue, with or without an index.
+// Numbers are compared by value (stored numbers are float64), other values must have the same type.
+func matchFieldValue(entity interface{}, fieldName string, fieldValue interface{}) bool {
+	value := qbc.Reflect.Get(entity, fieldName)
+	if f1, b := toNumber(fieldValue); b {
+		f2, b := toNumber(value)
+		return b && f1 == f2
+	}
+	return qbc.Compare.Equals(fieldValue, value)
+}
+
 func (instance *BoltCollection) remove(key string, batch bool) (err error) {
 	if nil != instance && nil != instance.boltdb {
 		if batch {
 			err = instance.boltdb.Batch(func(tx *bbolt.Tx) error {
-				b := tx.Bucket([]byte(instance.name))
-				if nil != b {
-					err := b.Delete([]byte(key))
-					return err
-				} else {
-					return ErrCollectionDoesNotExists
-				}
+				return instance.delete(tx, []byte(key))
 			})
 		} else {
 			err = instance.boltdb.Update(func(tx *bbolt.Tx) error {
-				b := tx.Bucket([]byte(instance.name))
-				if nil != b {
-					err := b.Delete([]byte(key))
-					return err
-				} else {
-					return ErrCollectionDoesNotExists
-				}
+				return instance.delete(tx, []byte(key))
 			})
 		}
 	} else {
@@ -296,6 +322,30 @@ func (instance *BoltCollection) remove(key string, batch bool) (err error) {
 	return err
 }
 
+func (instance *BoltCollection) put(tx *bbolt.Tx, key, buf []byte) error {
+	b := tx.Bucket([]byte(instance.name))
+	if nil == b {
+		return ErrCollectionDoesNotExists
+	}
+	if err := updateIndexes(tx, instance.name, key, b.Get(key), buf); nil != err {
+		return err
+	}
+	return b.Put(key, buf)
+}
+
+func (instance *BoltCollection) delete(tx *bbolt.Tx, key []byte) error {
+	b := tx.Bucket([]byte(instance.name))
+	if nil == b {
+		return ErrCollectionDoesNotExists
+	}
+	if old := b.Get(key); nil != old {
+		if err := updateIndexes(tx, instance.name, key, old, nil); nil != err {
+			return err
+		}
+	}
+	return b.Delete(key)
+}
+
 func (instance *BoltCollection) update(entity interface{}, batch bool) (err error) {
 	if nil != instance && nil != instance.boltdb {
 		// check key
@@ -304,27 +354,17 @@ func (instance *BoltCollection) update(entity interface{}, batch bool) (err erro
 			return ErrMissingDocumentKey
 		}
 		// get array of bytes
-		buf, err := json.Marshal(entity)
-		if nil != err {
-			return err
+		buf, marshalErr := json.Marshal(entity)
+		if nil != marshalErr {
+			return marshalErr
 		}
 		if batch {
 			err = instance.boltdb.Batch(func(tx *bbolt.Tx) error {
-				b := tx.Bucket([]byte(instance.name))
-				if nil != b {
-					return b.Put(key, buf)
-				} else {
-					return ErrCollectionDoesNotExists
-				}
+				return instance.put(tx, key, buf)
 			})
 		} else {
 			err = instance.boltdb.Update(func(tx *bbolt.Tx) error {
-				b := tx.Bucket([]byte(instance.name))
-				if nil != b {
-					return b.Put(key, buf)
-				} else {
-					return ErrCollectionDoesNotExists
-				}
+				return instance.put(tx, key, buf)
 			})
 		}
 	} else {
@@ -0,0 +1,455 @@
+package bolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+
+	qbc "github.com/rskvp/qb-core"
+	"go.etcd.io/bbolt"
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//	c o n s t
+//----------------------------------------------------------------------------------------------------------------------
+
+const (
+	IndexesBucket     = "__indexes" // index definitions: a nested bucket for each collection
+	indexBucketPrefix = "__idx."    // index entries: "__idx.<collection>.<index>"
+)
+
+// type of encoded values, in sort order
+const (
+	indexTypeNil byte = iota + 1
+	indexTypeBool
+	indexTypeNumber
+	indexTypeString
+	indexTypeOther
+)
+
+var indexTerminator = []byte{0x00, 0x01}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	t y p e
+//----------------------------------------------------------------------------------------------------------------------
+
+// BoltIndex is a secondary index on one or more fields of a collection.
+// Index entries are stored in a companion bucket, sorted by the encoded values of the fields, and are updated in
+// the same transaction that changes the documents.
+// Indexed lookups compare values of the same type: the number 22 does not match the string "22".
+// GetByFieldValue and CountByFieldValue return the same documents with or without an index.
+type BoltIndex struct {
+	Name   string   `json:"name"`
+	Fields []string `json:"fields"` // "name" or nested "address.city"
+	Unique bool     `json:"unique"`
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	BoltCollection
+//----------------------------------------------------------------------------------------------------------------------
+
+func IndexName(fields []string) string {
+	return "idx_" + strings.Join(fields, "_")
+}
+
+// EnsureIndex creates an index and fills it with existing documents.
+// If an index on the same fields already exists it is rebuilt only when the "unique" flag changed.
+func (instance *BoltCollection) EnsureIndex(fields []string, unique bool) (*BoltIndex, error) {
+	if nil == instance || nil == instance.boltdb {
+		return nil, ErrDatabaseIsNotConnected
+	}
+	if len(fields) == 0 {
+		return nil, ErrInvalidIndex
+	}
+	index := &BoltIndex{Name: IndexName(fields), Fields: fields, Unique: unique}
+	err := instance.boltdb.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(instance.name))
+		if nil == b {
+			return ErrCollectionDoesNotExists
+		}
+		for _, existing := range loadIndexes(tx, instance.name) {
+			if existing.Name == index.Name {
+				if existing.Unique == index.Unique {
+					return nil
+				}
+				if err := dropIndex(tx, instance.name, existing.Name); nil != err {
+					return err
+				}
+			}
+		}
+		meta, err := tx.CreateBucketIfNotExists([]byte(IndexesBucket))
+		if nil != err {
+			return err
+		}
+		defs, err := meta.CreateBucketIfNotExists([]byte(instance.name))
+		if nil != err {
+			return err
+		}
+		data, err := json.Marshal(index)
+		if nil != err {
+			return err
+		}
+		if err = defs.Put([]byte(index.Name), data); nil != err {
+			return err
+		}
+		ib, err := tx.CreateBucketIfNotExists(indexBucketName(instance.name, index.Name))
+		if nil != err {
+			return err
+		}
+		// index existing documents
+		return b.ForEach(func(k, v []byte) error {
+			var doc map[string]interface{}
+			if e := json.Unmarshal(v, &doc); nil != e {
+				return nil // not a document
+			}
+			return index.add(ib, doc, k)
+		})
+	})
+	if nil != err {
+		return nil, err
+	}
+	return index, nil
+}
+
+func (instance *BoltCollection) RemoveIndex(name string) error {
+	if nil == instance || nil == instance.boltdb {
+		return ErrDatabaseIsNotConnected
+	}
+	return instance.boltdb.Update(func(tx *bbolt.Tx) error {
+		return dropIndex(tx, instance.name, name)
+	})
+}
+
+func (instance *BoltCollection) Indexes() ([]*BoltIndex, error) {
+	response := make([]*BoltIndex, 0)
+	if nil == instance || nil == instance.boltdb {
+		return response, ErrDatabaseIsNotConnected
+	}
+	err := instance.boltdb.View(func(tx *bbolt.Tx) error {
+		response = loadIndexes(tx, instance.name)
+		return nil
+	})
+	return response, err
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	BoltIndex
+//----------------------------------------------------------------------------------------------------------------------
+
+func (instance *BoltIndex) key(doc map[string]interface{}) ([]byte, bool) {
+	var buf bytes.Buffer
+	hasNil := false
+	for _, field := range instance.Fields {
+		value, _ := lookupValue(doc, documentAlias+field)
+		if nil == value {
+			hasNil = true
+		}
+		encodeIndexValue(&buf, value)
+	}
+	return buf.Bytes(), hasNil
+}
+
+func (instance *BoltIndex) add(b *bbolt.Bucket, doc map[string]interface{}, docKey []byte) error {
+	prefix, hasNil := instance.key(doc)
+	if instance.Unique && !hasNil {
+		// null values are not checked, as in SQL databases
+		c := b.Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			if !bytes.Equal(v, docKey) {
+				return qbc.Errors.Prefix(ErrUniqueConstraint, instance.Name+": ")
+			}
+		}
+	}
+	return b.Put(append(prefix, docKey...), docKey)
+}
+
+func (instance *BoltIndex) remove(b *bbolt.Bucket, doc map[string]interface{}, docKey []byte) error {
+	prefix, _ := instance.key(doc)
+	return b.Delete(append(prefix, docKey...))
+}
+
+// scan returns the document keys whose first indexed field satisfies "comparator value"
+func (instance *BoltIndex) scan(b *bbolt.Bucket, comparator string, value interface{}) [][]byte {
+	response := make([][]byte, 0)
+	var buf bytes.Buffer
+	encodeIndexValue(&buf, value)
+	prefix := buf.Bytes()
+	typ := prefix[0]
+
+	c := b.Cursor()
+	var k, v []byte
+	defer func() {
+		// values belong to the transaction
+		for i, key := range response {
+			response[i] = append([]byte{}, key...)
+		}
+	}()
+	switch comparator {
+	case ComparatorEqual:
+		for k, v = c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			response = append(response, v)
+		}
+	case ComparatorGreater, ComparatorGreaterEqual:
+		for k, v = c.Seek(prefix); k != nil && k[0] == typ; k, v = c.Next() {
+			if comparator == ComparatorGreater && bytes.HasPrefix(k, prefix) {
+				continue
+			}
+			response = append(response, v)
+		}
+	case ComparatorLower, ComparatorLowerEqual:
+		for k, v = c.Seek([]byte{typ}); k != nil && k[0] == typ; k, v = c.Next() {
+			if bytes.Compare(k, prefix) >= 0 && !(comparator == ComparatorLowerEqual && bytes.HasPrefix(k, prefix)) {
+				break
+			}
+			response = append(response, v)
+		}
+	}
+	return response
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p r i v a t e
+//----------------------------------------------------------------------------------------------------------------------
+
+// indexedKeys returns the keys of documents where "fieldName comparator value" using an index.
+// Returns false if no index can be used.
+func (instance *BoltCollection) indexedKeys(tx *bbolt.Tx, fieldName, comparator string, value interface{}) ([][]byte, bool) {
+	index := findIndex(loadIndexes(tx, instance.name), fieldName, value)
+	if nil == index {
+		return nil, false
+	}
+	b := tx.Bucket(indexBucketName(instance.name, index.Name))
+	if nil == b {
+		return nil, false
+	}
+	switch comparator {
+	case ComparatorEqual, ComparatorGreater, ComparatorGreaterEqual, ComparatorLower, ComparatorLowerEqual:
+		if _, isDate := toDate(value); isDate && comparator != ComparatorEqual {
+			return nil, false // dates are compared as dates, not as text
+		}
+		return sortKeys(index.scan(b, comparator, value)), true
+	}
+	return nil, false
+}
+
+// plan looks for a filter that can be resolved with an index and returns the candidate keys.
+// Candidates still need to be matched with the whole query.
+func (instance *BoltCollection) plan(tx *bbolt.Tx, query *BoltQuery) ([][]byte, bool) {
+	if nil == query {
+		return nil, false
+	}
+	var best [][]byte
+	found := false
+	for _, group := range query.Conditions {
+		if nil == group || (group.Operator != OperatorAnd && group.Operator != "") {
+			continue
+		}
+		for _, filter := range group.Filters {
+			if nil == filter {
+				continue
+			}
+			field, b := filter.Field.(string)
+			if !b || !isProperty(field) {
+				continue
+			}
+			if s, b := filter.Value.(string); b && isProperty(s) {
+				continue // may be a property of the document
+			}
+			fieldName := field[strings.Index(field, ".")+1:]
+			var keys [][]byte
+			var ok bool
+			if filter.Comparator == ComparatorIn && isArray(filter.Value) {
+				keys, ok = instance.indexedKeysIn(tx, fieldName, filter.Value)
+			} else {
+				keys, ok = instance.indexedKeys(tx, fieldName, filter.Comparator, filter.Value)
+			}
+			if ok && (!found || len(keys) < len(best)) {
+				best = keys
+				found = true
+			}
+		}
+	}
+	return best, found
+}
+
+func (instance *BoltCollection) indexedKeysIn(tx *bbolt.Tx, fieldName string, values interface{}) ([][]byte, bool) {
+	response := make([][]byte, 0)
+	unique := map[string]bool{}
+	rv := reflect.ValueOf(values)
+	for i := 0; i < rv.Len(); i++ {
+		keys, ok := instance.indexedKeys(tx, fieldName, ComparatorEqual, rv.Index(i).Interface())
+		if !ok {
+			return nil, false
+		}
+		for _, key := range keys {
+			if !unique[string(key)] {
+				unique[string(key)] = true
+				response = append(response, key)
+			}
+		}
+	}
+	return sortKeys(response), true
+}
+
+// sortKeys returns keys in the same order of a full scan of the collection
+func sortKeys(keys [][]byte) [][]byte {
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) < 0
+	})
+	return keys
+}
+
+func indexBucketName(collection, name string) []byte {
+	return []byte(indexBucketPrefix + collection + "." + name)
+}
+
+func loadIndexes(tx *bbolt.Tx, collection string) []*BoltIndex {
+	response := make([]*BoltIndex, 0)
+	meta := tx.Bucket([]byte(IndexesBucket))
+	if nil == meta {
+		return response
+	}
+	defs := meta.Bucket([]byte(collection))
+	if nil == defs {
+		return response
+	}
+	_ = defs.ForEach(func(k, v []byte) error {
+		var index BoltIndex
+		if nil == json.Unmarshal(v, &index) && len(index.Fields) > 0 {
+			response = append(response, &index)
+		}
+		return nil
+	})
+	return response
+}
+
+func dropIndex(tx *bbolt.Tx, collection, name string) error {
+	if meta := tx.Bucket([]byte(IndexesBucket)); nil != meta {
+		if defs := meta.Bucket([]byte(collection)); nil != defs {
+			if err := defs.Delete([]byte(name)); nil != err {
+				return err
+			}
+		}
+	}
+	if nil != tx.Bucket(indexBucketName(collection, name)) {
+		return tx.DeleteBucket(indexBucketName(collection, name))
+	}
+	return nil
+}
+
+func dropIndexes(tx *bbolt.Tx, collection string) error {
+	for _, index := range loadIndexes(tx, collection) {
+		if err := dropIndex(tx, collection, index.Name); nil != err {
+			return err
+		}
+	}
+	if meta := tx.Bucket([]byte(IndexesBucket)); nil != meta && nil != meta.Bucket([]byte(collection)) {
+		return meta.DeleteBucket([]byte(collection))
+	}
+	return nil
+}
+
+// updateIndexes replaces index entries of oldDoc with entries of newDoc (both may be nil)
+func updateIndexes(tx *bbolt.Tx, collection string, docKey []byte, oldData, newData []byte) error {
+	indexes := loadIndexes(tx, collection)
+	if len(indexes) == 0 {
+		return nil
+	}
+	var oldDoc, newDoc map[string]interface{}
+	if nil != oldData {
+		_ = json.Unmarshal(oldData, &oldDoc)
+	}
+	if nil != newData {
+		_ = json.Unmarshal(newData, &newDoc)
+	}
+	for _, index := range indexes {
+		b := tx.Bucket(indexBucketName(collection, index.Name))
+		if nil == b {
+			continue
+		}
+		if nil != oldDoc {
+			if err := index.remove(b, oldDoc, docKey); nil != err {
+				return err
+			}
+		}
+		if nil != newDoc {
+			if err := index.add(b, newDoc, docKey); nil != err {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// findIndex returns an index whose first field is fieldName and can compare value
+func findIndex(indexes []*BoltIndex, fieldName string, value interface{}) *BoltIndex {
+	if !isIndexable(value) {
+		return nil
+	}
+	for _, index := range indexes {
+		if index.Fields[0] == fieldName {
+			return index
+		}
+	}
+	return nil
+}
+
+func isIndexable(value interface{}) bool {
+	if nil == value {
+		return true
+	}
+	if _, b := value.(bool); b {
+		return true
+	}
+	if _, b := value.(string); b {
+		return true
+	}
+	_, b := toNumber(value)
+	return b
+}
+
+// encodeIndexValue writes a value so that byte order is the order of values of the same type
+func encodeIndexValue(buf *bytes.Buffer, value interface{}) {
+	var payload []byte
+	if nil == value {
+		buf.WriteByte(indexTypeNil)
+	} else if v, b := value.(bool); b {
+		buf.WriteByte(indexTypeBool)
+		if v {
+			payload = []byte{1}
+		} else {
+			payload = []byte{0}
+		}
+	} else if v, b := value.(string); b {
+		buf.WriteByte(indexTypeString)
+		payload = []byte(v)
+	} else if f, b := toNumber(value); b {
+		buf.WriteByte(indexTypeNumber)
+		bits := math.Float64bits(f)
+		if f >= 0 {
+			bits ^= 1 << 63
+		} else {
+			bits = ^bits
+		}
+		payload = make([]byte, 8)
+		binary.BigEndian.PutUint64(payload, bits)
+	} else {
+		buf.WriteByte(indexTypeOther)
+		if rv := reflect.ValueOf(value); rv.Kind() == reflect.Ptr && !rv.IsNil() {
+			value = rv.Elem().Interface()
+		}
+		payload, _ = json.Marshal(value)
+	}
+	// escape 0x00 to keep the terminator as the lowest sequence
+	for _, c := range payload {
+		buf.WriteByte(c)
+		if c == 0x00 {
+			buf.WriteByte(0xFF)
+		}
+	}
+	buf.Write(indexTerminator)
+}
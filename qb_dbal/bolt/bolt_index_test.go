@@ -0,0 +1,136 @@
+package bolt
+
+import (
+	"path/filepath"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+func TestIndex(t *testing.T) {
+	config := NewBoltConfig()
+	config.Name = filepath.Join(t.TempDir(), "index")
+	db := NewBoltDatabase(config)
+	if err := db.Open(); nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	defer db.Close()
+
+	coll, err := db.Collection("people", true)
+	if nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	for i, name := range []string{"Mario", "Maria", "Luca", "Anna", "Giorgio"} {
+		err = coll.Upsert(map[string]interface{}{"_key": name, "name": name, "age": 10 * (i + 1), "email": name + "@test.com"})
+		if nil != err {
+			t.Error(err)
+			t.FailNow()
+		}
+	}
+	if _, err = coll.EnsureIndex([]string{"age"}, false); nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	if _, err = coll.EnsureIndex([]string{"email"}, true); nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	// unique constraint
+	err = coll.Upsert(map[string]interface{}{"_key": "other", "name": "Other", "email": "Mario@test.com"})
+	if nil == err {
+		t.Error("Expected unique constraint violation")
+		t.FailNow()
+	}
+	// update of the same document does not violate the constraint
+	err = coll.Upsert(map[string]interface{}{"_key": "Mario", "name": "Mario", "age": 35, "email": "Mario@test.com"})
+	if nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	data, err := coll.GetByFieldValue("age", 35)
+	if nil != err || len(data) != 1 {
+		t.Error("Expected 1 item, got ", data, err)
+		t.FailNow()
+	}
+	count, _ := coll.CountByFieldValue("age", 10)
+	if count != 0 {
+		t.Error("Old index entry was not removed")
+		t.FailNow()
+	}
+
+	query := &BoltQuery{Conditions: []*BoltQueryConditionGroup{{
+		Operator: OperatorAnd,
+		Filters: []*BoltQueryCondition{
+			{Field: "doc.age", Comparator: ComparatorGreaterEqual, Value: 30},
+			{Field: "doc.age", Comparator: ComparatorLower, Value: 50},
+		},
+	}}}
+	var keys [][]byte
+	_ = coll.boltdb.View(func(tx *bbolt.Tx) error {
+		keys, _ = coll.plan(tx, query)
+		return nil
+	})
+	if len(keys) != 4 {
+		t.Error("Expected 4 candidates from index, got ", len(keys))
+		t.FailNow()
+	}
+	data, err = coll.Find(query)
+	if nil != err || len(data) != 3 {
+		t.Error("Expected 3 items, got ", data, err)
+		t.FailNow()
+	}
+
+	if err = coll.Remove("Luca"); nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	data, _ = coll.Find(query)
+	if len(data) != 2 {
+		t.Error("Expected 2 items after remove, got ", data)
+		t.FailNow()
+	}
+}
+
+func TestIndexSameResultsAsScan(t *testing.T) {
+	config := NewBoltConfig()
+	config.Name = filepath.Join(t.TempDir(), "index")
+	db := NewBoltDatabase(config)
+	if err := db.Open(); nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	defer db.Close()
+
+	coll, err := db.Collection("codes", true)
+	if nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	_ = coll.Upsert(map[string]interface{}{"_key": "1", "code": 22})
+	_ = coll.Upsert(map[string]interface{}{"_key": "2", "code": "22"})
+	_ = coll.Upsert(map[string]interface{}{"_key": "3"})
+	values := []interface{}{22, 22.0, "22", nil, true}
+	scan := make([]int64, 0)
+	for _, value := range values {
+		count, _ := coll.CountByFieldValue("code", value)
+		scan = append(scan, count)
+	}
+	if scan[0] != 1 || scan[1] != 1 || scan[2] != 1 {
+		t.Error("Unexpected scan results", scan)
+	}
+	if _, err = coll.EnsureIndex([]string{"code"}, false); nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	for i, value := range values {
+		count, _ := coll.CountByFieldValue("code", value)
+		data, _ := coll.GetByFieldValue("code", value)
+		if count != scan[i] || int64(len(data)) != scan[i] {
+			t.Error("Index changed the result of", value, count, len(data), "expected", scan[i])
+		}
+	}
+}
@@ -2,7 +2,12 @@ package bolt
 
 import (
 	"encoding/json"
+	"reflect"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	qbc "github.com/rskvp/qb-core"
 )
@@ -14,22 +19,41 @@ const (
 	ComparatorLower        = "<"
 	ComparatorLowerEqual   = "<="
 	ComparatorGreaterEqual = ">="
+	ComparatorContains     = "contains"    // substring of a string or item of an array
+	ComparatorStartsWith   = "startsWith"  // string prefix
+	ComparatorEndsWith     = "endsWith"    // string suffix
+	ComparatorRegex        = "regex"       // value is a regular expression
+	ComparatorIn           = "in"          // value is an array
+	ComparatorNotIn        = "not in"      // value is an array
+	ComparatorIsNull       = "is null"     // value is ignored
+	ComparatorIsNotNull    = "is not null" // value is ignored
+	ComparatorExists       = "exists"      // value is an optional boolean (default true)
 
 	OperatorAnd = "&&"
 	OperatorOr  = "||"
+	OperatorNot = "!" // none of the filters and groups match
+
+	documentAlias = "doc." // prefix of the properties of the document: "doc.name"
 )
 
+var dateLayouts = []string{time.RFC3339Nano, time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"}
+
 //----------------------------------------------------------------------------------------------------------------------
 //	t y p e
 //----------------------------------------------------------------------------------------------------------------------
 
+// BoltQuery matches documents when all the condition groups match.
 type BoltQuery struct {
 	Conditions []*BoltQueryConditionGroup `json:"conditions"`
+	Sort       []*BoltQuerySort           `json:"sort"`
+	Skip       int                        `json:"skip"`
+	Limit      int                        `json:"limit"` // 0 means no limit
 }
 
 type BoltQueryConditionGroup struct {
-	Operator string                `json:"operator"`
-	Filters  []*BoltQueryCondition `json:"filters"`
+	Operator string                     `json:"operator"` // &&, ||, !
+	Filters  []*BoltQueryCondition      `json:"filters"`
+	Groups   []*BoltQueryConditionGroup `json:"groups"` // nested groups, evaluated with the same operator of filters
 }
 
 type BoltQueryCondition struct {
@@ -38,6 +62,11 @@ type BoltQueryCondition struct {
 	Value      interface{} `json:"value"`      // absolute value or field "doc.surname", "Rossi"
 }
 
+type BoltQuerySort struct {
+	Field      string `json:"field"` // "doc.name"
+	Descending bool   `json:"descending"`
+}
+
 //----------------------------------------------------------------------------------------------------------------------
 //	BoltQuery
 //----------------------------------------------------------------------------------------------------------------------
@@ -68,84 +97,363 @@ func (instance *BoltQuery) ToString() string {
 }
 
 func (instance *BoltQuery) MatchFilter(entity interface{}) bool {
-	response := false
-	if nil != instance {
-		if nil != instance.Conditions && nil != entity {
-			conditions := instance.Conditions
-			if len(conditions) > 0 {
-				// OPTIMISTIC CONDITION
-				response = true
-				for _, condition := range conditions {
-					if nil != condition {
-						operator := condition.Operator
-						filters := condition.Filters
-						for _, filter := range filters {
-							f1 := getValue(entity, filter.Field)
-							f2 := getValue(entity, filter.Value)
-							cp := filter.Comparator
-
-							match := false
-							switch cp {
-							case ComparatorEqual:
-								match = qbc.Compare.Equals(f1, f2)
-							case ComparatorNotEqual:
-								match = qbc.Compare.NotEquals(f1, f2)
-							default:
-								match = false
-							}
-
-							switch operator {
-							case OperatorAnd:
-								// AND
-								response = response && match
-							case OperatorOr:
-								// OR
-								response = response || match
-							default:
-								// invalid operator
-								response = false
-							}
-							if !response {
-								break
-							}
-						} // filters
-						if !response {
-							break
-						}
+	if nil == instance || nil == entity || len(instance.Conditions) == 0 {
+		return false
+	}
+	for _, group := range instance.Conditions {
+		if nil != group && !group.Match(entity) {
+			return false
+		}
+	}
+	return true
+}
+
+// SortAndLimit sorts the documents that matched the filter and applies skip and limit.
+func (instance *BoltQuery) SortAndLimit(entities []interface{}) []interface{} {
+	if nil == instance {
+		return entities
+	}
+	if len(instance.Sort) > 0 {
+		sort.SliceStable(entities, func(i, j int) bool {
+			for _, s := range instance.Sort {
+				if nil == s {
+					continue
+				}
+				a, _ := lookupValue(entities[i], s.Field)
+				b, _ := lookupValue(entities[j], s.Field)
+				c, _ := compare(a, b)
+				if c != 0 {
+					if s.Descending {
+						return c > 0
 					}
-				} // conditions
+					return c < 0
+				}
 			}
+			return false
+		})
+	}
+	if instance.Skip > 0 {
+		if instance.Skip >= len(entities) {
+			entities = entities[:0]
+		} else {
+			entities = entities[instance.Skip:]
 		}
+	}
+	if instance.Limit > 0 && len(entities) > instance.Limit {
+		entities = entities[:instance.Limit]
+	}
+	return entities
+}
 
+//----------------------------------------------------------------------------------------------------------------------
+//	BoltQueryConditionGroup
+//----------------------------------------------------------------------------------------------------------------------
+
+func (instance *BoltQueryConditionGroup) Match(entity interface{}) bool {
+	if nil == instance {
+		return true
+	}
+	matches := make([]func() bool, 0, len(instance.Filters)+len(instance.Groups))
+	for _, filter := range instance.Filters {
+		if nil != filter {
+			f := filter
+			matches = append(matches, func() bool { return f.Match(entity) })
+		}
+	}
+	for _, group := range instance.Groups {
+		if nil != group {
+			g := group
+			matches = append(matches, func() bool { return g.Match(entity) })
+		}
+	}
+
+	switch instance.Operator {
+	case OperatorAnd, "":
+		for _, match := range matches {
+			if !match() {
+				return false
+			}
+		}
+		return true
+	case OperatorOr:
+		for _, match := range matches {
+			if match() {
+				return true
+			}
+		}
+		return false
+	case OperatorNot:
+		for _, match := range matches {
+			if match() {
+				return false
+			}
+		}
+		return true
+	default:
+		// invalid operator
+		return false
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	BoltQueryCondition
+//----------------------------------------------------------------------------------------------------------------------
+
+func (instance *BoltQueryCondition) Match(entity interface{}) bool {
+	f1, found := getFieldValue(entity, instance.Field)
+	f2 := getValue(entity, instance.Value)
+
+	switch instance.Comparator {
+	case ComparatorEqual:
+		return equals(f1, f2)
+	case ComparatorNotEqual:
+		return !equals(f1, f2)
+	case ComparatorGreater, ComparatorGreaterEqual, ComparatorLower, ComparatorLowerEqual:
+		c, ok := compare(f1, f2)
+		if !ok || nil == f1 || nil == f2 {
+			return false
+		}
+		switch instance.Comparator {
+		case ComparatorGreater:
+			return c > 0
+		case ComparatorGreaterEqual:
+			return c >= 0
+		case ComparatorLower:
+			return c < 0
+		default:
+			return c <= 0
+		}
+	case ComparatorContains:
+		if s, b := f1.(string); b {
+			return strings.Contains(s, qbc.Convert.ToString(f2))
+		}
+		return contains(f1, f2)
+	case ComparatorStartsWith:
+		s, b := f1.(string)
+		return b && strings.HasPrefix(s, qbc.Convert.ToString(f2))
+	case ComparatorEndsWith:
+		s, b := f1.(string)
+		return b && strings.HasSuffix(s, qbc.Convert.ToString(f2))
+	case ComparatorRegex:
+		s, b := f1.(string)
+		if !b {
+			return false
+		}
+		re, err := regex(qbc.Convert.ToString(f2))
+		return nil == err && re.MatchString(s)
+	case ComparatorIn:
+		if isArray(f2) {
+			return contains(f2, f1)
+		}
+		return contains(f1, f2)
+	case ComparatorNotIn:
+		if isArray(f2) {
+			return !contains(f2, f1)
+		}
+		return !contains(f1, f2)
+	case ComparatorIsNull:
+		return nil == f1
+	case ComparatorIsNotNull:
+		return nil != f1
+	case ComparatorExists:
+		if b, ok := f2.(bool); ok {
+			return found == b
+		}
+		return found
+	default:
+		return false
 	}
-	return response
 }
 
 //----------------------------------------------------------------------------------------------------------------------
 //	p r i v a t e
 //----------------------------------------------------------------------------------------------------------------------
 
+var regexCache sync.Map
+
+func regex(pattern string) (*regexp.Regexp, error) {
+	if re, b := regexCache.Load(pattern); b {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if nil != err {
+		return nil, err
+	}
+	regexCache.Store(pattern, re)
+	return re, nil
+}
+
+// getValue returns the value of a "doc.surname" property (nil if missing) or the literal value,
+// i.e. "mario.rossi@gmail.com"
 func getValue(entity interface{}, propertyOrValue interface{}) interface{} {
-	if b, property := qbc.Compare.IsString(propertyOrValue); b {
-		if strings.Index(property, ".") > -1 {
-			// found a property
-			field := ""
-			tokens := qbc.Strings.Split(property, ".")
-			switch len(tokens) {
-			case 1:
-				field = tokens[0]
-			default:
-				field = tokens[1]
+	if b, property := qbc.Compare.IsString(propertyOrValue); b && strings.HasPrefix(property, documentAlias) {
+		value, _ := lookupValue(entity, property)
+		return value
+	}
+	return propertyOrValue
+}
+
+// getFieldValue returns the value of a "doc.name" property. Literal values are returned as they are.
+// Return false if the property does not exist.
+func getFieldValue(entity interface{}, propertyOrValue interface{}) (interface{}, bool) {
+	if b, property := qbc.Compare.IsString(propertyOrValue); b && isProperty(property) {
+		return lookupValue(entity, property)
+	}
+	return propertyOrValue, true
+}
+
+func isProperty(value string) bool {
+	return strings.Index(value, ".") > -1
+}
+
+// lookupValue navigates "doc.address.city": the first token is the document alias
+func lookupValue(entity interface{}, property string) (interface{}, bool) {
+	tokens := qbc.Strings.Split(property, ".")
+	if len(tokens) > 1 {
+		tokens = tokens[1:]
+	}
+	current := entity
+	for _, field := range tokens {
+		if len(field) == 0 {
+			return nil, false
+		}
+		value, found := lookupField(current, field)
+		if !found {
+			return nil, false
+		}
+		current = value
+	}
+	return current, true
+}
+
+func lookupField(entity interface{}, field string) (interface{}, bool) {
+	if nil == entity {
+		return nil, false
+	}
+	rv := reflect.ValueOf(entity)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() == reflect.Map && rv.Type().Key().Kind() == reflect.String {
+		v := rv.MapIndex(reflect.ValueOf(field))
+		if !v.IsValid() {
+			return nil, false
+		}
+		return v.Interface(), true
+	}
+	r := qbc.Reflect.Get(entity, field)
+	if nil != r {
+		return r, true
+	}
+	r = qbc.Reflect.Get(entity, qbc.Strings.CapitalizeAll(field))
+	return r, nil != r
+}
+
+func equals(a, b interface{}) bool {
+	if nil == a || nil == b {
+		// missing properties are nil on both sides of the comparison
+		return nil == a && nil == b
+	}
+	if fa, ok := toNumber(a); ok {
+		if fb, ok := toNumber(b); ok {
+			return fa == fb
+		}
+	}
+	return qbc.Compare.Equals(a, b)
+}
+
+// compare returns -1, 0, 1 and false if values cannot be ordered.
+// Numbers, dates (time.Time or date strings) and strings are supported. nil is lower than any value.
+func compare(a, b interface{}) (int, bool) {
+	if nil == a || nil == b {
+		switch {
+		case nil == a && nil == b:
+			return 0, true
+		case nil == a:
+			return -1, true
+		default:
+			return 1, true
+		}
+	}
+	if fa, ok := toNumber(a); ok {
+		if fb, ok := toNumber(b); ok {
+			switch {
+			case fa < fb:
+				return -1, true
+			case fa > fb:
+				return 1, true
 			}
-			if len(field) > 0 {
-				r := qbc.Reflect.Get(entity, field)
-				if nil!=r{
-					return r
-				}
-				return qbc.Reflect.Get(entity, qbc.Strings.CapitalizeAll(field))
+			return 0, true
+		}
+	}
+	if ta, ok := toDate(a); ok {
+		if tb, ok := toDate(b); ok {
+			switch {
+			case ta.Before(tb):
+				return -1, true
+			case ta.After(tb):
+				return 1, true
 			}
+			return 0, true
 		}
 	}
+	sa, okA := a.(string)
+	sb, okB := b.(string)
+	if okA && okB {
+		return strings.Compare(sa, sb), true
+	}
+	return 0, false
+}
 
-	return propertyOrValue
+func toNumber(value interface{}) (float64, bool) {
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
+
+func toDate(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, true
+	case *time.Time:
+		if nil != v {
+			return *v, true
+		}
+	case string:
+		for _, layout := range dateLayouts {
+			if t, err := time.Parse(layout, v); nil == err {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+func isArray(value interface{}) bool {
+	if nil == value {
+		return false
+	}
+	kind := reflect.ValueOf(value).Kind()
+	return kind == reflect.Slice || kind == reflect.Array
+}
+
+// contains returns true if array has an item equal to value
+func contains(array interface{}, value interface{}) bool {
+	if !isArray(array) {
+		return false
+	}
+	rv := reflect.ValueOf(array)
+	for i := 0; i < rv.Len(); i++ {
+		if equals(rv.Index(i).Interface(), value) {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,96 @@
+package bolt
+
+import "testing"
+
+func TestMatchFilter(t *testing.T) {
+	query := new(BoltQuery)
+	err := query.Parse(`{
+  "conditions": [
+    {
+      "operator": "&&",
+      "filters": [
+        {"field": "doc.age", "comparator": ">=", "value": 18},
+        {"field": "doc.born", "comparator": "<", "value": "2000-01-01"}
+      ],
+      "groups": [
+        {
+          "operator": "||",
+          "filters": [
+            {"field": "doc.name", "comparator": "startsWith", "value": "Mar"},
+            {"field": "doc.tags", "comparator": "contains", "value": "vip"}
+          ]
+        },
+        {
+          "operator": "!",
+          "filters": [
+            {"field": "doc.city", "comparator": "in", "value": ["Milan", "Turin"]},
+            {"field": "doc.deleted", "comparator": "exists"}
+          ]
+        }
+      ]
+    }
+  ]
+}`)
+	if nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	values := []struct {
+		doc      map[string]interface{}
+		expected bool
+	}{
+		{map[string]interface{}{"name": "Mario", "age": 40, "born": "1983-05-01T00:00:00Z", "city": "Rome"}, true},
+		{map[string]interface{}{"name": "Luca", "age": 40, "born": "1983-05-01", "tags": []interface{}{"vip"}}, true},
+		{map[string]interface{}{"name": "Mario", "age": 17, "born": "1983-05-01"}, false},
+		{map[string]interface{}{"name": "Mario", "age": 40, "born": "2003-05-01"}, false},
+		{map[string]interface{}{"name": "Mario", "age": 40, "born": "1983-05-01", "city": "Milan"}, false},
+		{map[string]interface{}{"name": "Mario", "age": 40, "born": "1983-05-01", "deleted": true}, false},
+	}
+	for i, v := range values {
+		if query.MatchFilter(v.doc) != v.expected {
+			t.Error("Unexpected match at index ", i, ": ", v.doc)
+			t.FailNow()
+		}
+	}
+
+	// properties on the value side are nil when missing, other strings are literals
+	fields := []struct {
+		filter   *BoltQueryCondition
+		doc      map[string]interface{}
+		expected bool
+	}{
+		{&BoltQueryCondition{Field: "doc.a", Comparator: ComparatorEqual, Value: "doc.b"}, map[string]interface{}{}, true},
+		{&BoltQueryCondition{Field: "doc.a", Comparator: ComparatorEqual, Value: "doc.b"}, map[string]interface{}{"a": "doc.b"}, false},
+		{&BoltQueryCondition{Field: "doc.a", Comparator: ComparatorEqual, Value: "doc.b"}, map[string]interface{}{"a": 1, "b": 1.0}, true},
+		{&BoltQueryCondition{Field: "doc.email", Comparator: ComparatorEqual, Value: "mario.rossi@gmail.com"}, map[string]interface{}{"email": "mario.rossi@gmail.com"}, true},
+	}
+	for i, v := range fields {
+		if v.filter.Match(v.doc) != v.expected {
+			t.Error("Unexpected field match at index ", i, ": ", v.doc)
+			t.FailNow()
+		}
+	}
+}
+
+func TestSortAndLimit(t *testing.T) {
+	query := &BoltQuery{
+		Sort:  []*BoltQuerySort{{Field: "doc.age", Descending: true}, {Field: "doc.name"}},
+		Skip:  1,
+		Limit: 2,
+	}
+	entities := []interface{}{
+		map[string]interface{}{"name": "A", "age": 10.0},
+		map[string]interface{}{"name": "B", "age": 30.0},
+		map[string]interface{}{"name": "C", "age": 20.0},
+		map[string]interface{}{"name": "D", "age": 30.0},
+	}
+	response := query.SortAndLimit(entities)
+	if len(response) != 2 {
+		t.Error("Expected 2 items, got ", len(response))
+		t.FailNow()
+	}
+	if response[0].(map[string]interface{})["name"] != "D" || response[1].(map[string]interface{})["name"] != "C" {
+		t.Error("Unexpected order: ", response)
+		t.FailNow()
+	}
+}
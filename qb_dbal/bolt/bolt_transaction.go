@@ -0,0 +1,133 @@
+package bolt
+
+import (
+	"encoding/json"
+
+	qbc "github.com/rskvp/qb-core"
+	"go.etcd.io/bbolt"
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//	t y p e
+//----------------------------------------------------------------------------------------------------------------------
+
+// BoltTransaction is a read-write bbolt transaction shared by all collections of a database.
+// Bolt allows a single writer: until Commit or Rollback any other write on the same database blocks,
+// so a transaction must be used from one goroutine and must not call the database outside the transaction.
+type BoltTransaction struct {
+
+	//-- private --//
+	db      *BoltDatabase
+	tx      *bbolt.Tx
+	managed bool // created by Update: commit and rollback are up to the database
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	B o l t D a t a b a s e
+//----------------------------------------------------------------------------------------------------------------------
+
+// Begin starts a read-write transaction. The caller must end it with Commit or Rollback.
+func (instance *BoltDatabase) Begin() (*BoltTransaction, error) {
+	if nil != instance && nil != instance.db {
+		tx, err := instance.db.Begin(true)
+		if nil != err {
+			return nil, err
+		}
+		return &BoltTransaction{db: instance, tx: tx}, nil
+	}
+	return nil, ErrDatabaseIsNotConnected
+}
+
+// Update runs callback into a read-write transaction: the transaction is committed if callback
+// returns nil, otherwise it is rolled back and the error is returned.
+func (instance *BoltDatabase) Update(callback func(tx *BoltTransaction) error) error {
+	if nil != instance && nil != instance.db {
+		return instance.db.Update(func(tx *bbolt.Tx) error {
+			return callback(&BoltTransaction{db: instance, tx: tx, managed: true})
+		})
+	}
+	return ErrDatabaseIsNotConnected
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p u b l i c
+//----------------------------------------------------------------------------------------------------------------------
+
+func (instance *BoltTransaction) Get(collectionName string, key string) (interface{}, error) {
+	if nil != instance && nil != instance.tx {
+		b := instance.tx.Bucket([]byte(collectionName))
+		if nil == b {
+			return nil, nil
+		}
+		buf := b.Get([]byte(key))
+		if nil == buf {
+			return nil, nil
+		}
+		var response interface{}
+		err := json.Unmarshal(buf, &response)
+		return response, err
+	}
+	return nil, ErrDatabaseIsNotConnected
+}
+
+// Upsert writes the entity into the collection (created if missing). Indexes are updated into the same transaction.
+func (instance *BoltTransaction) Upsert(collectionName string, entity interface{}) error {
+	if nil != instance && nil != instance.tx {
+		key := []byte(qbc.Reflect.GetString(entity, "_key"))
+		if len(key) == 0 {
+			return ErrMissingDocumentKey
+		}
+		buf, err := json.Marshal(entity)
+		if nil != err {
+			return err
+		}
+		coll, err := instance.collection(collectionName)
+		if nil != err {
+			return err
+		}
+		return coll.put(instance.tx, key, buf)
+	}
+	return ErrDatabaseIsNotConnected
+}
+
+func (instance *BoltTransaction) Remove(collectionName string, key string) error {
+	if nil != instance && nil != instance.tx {
+		coll, err := instance.collection(collectionName)
+		if nil != err {
+			return err
+		}
+		return coll.delete(instance.tx, []byte(key))
+	}
+	return ErrDatabaseIsNotConnected
+}
+
+func (instance *BoltTransaction) Commit() error {
+	if nil != instance && nil != instance.tx {
+		if instance.managed {
+			return ErrTransactionManaged
+		}
+		return instance.tx.Commit()
+	}
+	return ErrDatabaseIsNotConnected
+}
+
+func (instance *BoltTransaction) Rollback() error {
+	if nil != instance && nil != instance.tx {
+		if instance.managed {
+			return ErrTransactionManaged
+		}
+		return instance.tx.Rollback()
+	}
+	return ErrDatabaseIsNotConnected
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p r i v a t e
+//----------------------------------------------------------------------------------------------------------------------
+
+func (instance *BoltTransaction) collection(name string) (*BoltCollection, error) {
+	if _, err := instance.tx.CreateBucketIfNotExists([]byte(name)); nil != err {
+		return nil, err
+	}
+	return NewBoltCollection(instance.db, instance.db.db, name), nil
+}
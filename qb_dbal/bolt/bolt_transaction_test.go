@@ -0,0 +1,91 @@
+package bolt
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestTransaction(t *testing.T) {
+	config := NewBoltConfig()
+	config.Name = filepath.Join(t.TempDir(), "transaction")
+	db := NewBoltDatabase(config)
+	if err := db.Open(); nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	defer db.Close()
+
+	// commit
+	err := db.Update(func(tx *BoltTransaction) error {
+		if e := tx.Upsert("accounts", map[string]interface{}{"_key": "a", "amount": 100}); nil != e {
+			return e
+		}
+		return tx.Upsert("accounts", map[string]interface{}{"_key": "b", "amount": 0})
+	})
+	if nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	coll, err := db.Collection("accounts", false)
+	if nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	if count, _ := coll.Count(); count != 2 {
+		t.Error("Expected 2 documents, got", count)
+	}
+	if _, err = coll.EnsureIndex([]string{"owner"}, true); nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	// rollback on error: nothing is written, indexes included
+	failure := errors.New("failure")
+	err = db.Update(func(tx *BoltTransaction) error {
+		if e := tx.Remove("accounts", "a"); nil != e {
+			return e
+		}
+		if e := tx.Upsert("accounts", map[string]interface{}{"_key": "c", "owner": "Mario"}); nil != e {
+			return e
+		}
+		if item, _ := tx.Get("accounts", "a"); nil != item {
+			t.Error("Expected document removed into the transaction")
+		}
+		return failure
+	})
+	if err != failure {
+		t.Error("Expected callback error, got", err)
+	}
+	if item, _ := coll.Get("a"); nil == item {
+		t.Error("Expected document restored by rollback")
+	}
+	if items, _ := coll.GetByFieldValue("owner", "Mario"); len(items) != 0 {
+		t.Error("Expected index entries rolled back, got", items)
+	}
+
+	// unique constraint into a transaction
+	tx, err := db.Begin()
+	if nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	_ = tx.Upsert("accounts", map[string]interface{}{"_key": "d", "owner": "Luca"})
+	if err = tx.Upsert("accounts", map[string]interface{}{"_key": "e", "owner": "Luca"}); nil == err {
+		t.Error("Expected unique constraint violation")
+	}
+	if err = tx.Rollback(); nil != err {
+		t.Error(err)
+	}
+	if count, _ := coll.Count(); count != 2 {
+		t.Error("Expected 2 documents, got", count)
+	}
+
+	// managed transactions cannot be committed by the callback
+	err = db.Update(func(tx *BoltTransaction) error {
+		return tx.Commit()
+	})
+	if err != ErrTransactionManaged {
+		t.Error("Expected ErrTransactionManaged, got", err)
+	}
+}
@@ -15,9 +15,12 @@ var (
 	ErrorMissingTransactionOptions     = errors.New("missing_transaction_options")
 	ErrorMissingTransactionCollections = errors.New("missing_transaction_collections")
 	ErrorCommandAndParamsDoNotMatch    = errors.New("commands_and_params_do_not_match")
+	ErrorTransactionNotSupported       = errors.New("transaction_not_supported")
+	ErrorTransactionClosed             = errors.New("transaction_closed")
 
 	ErrorEngineNotReady      = errors.New("engine_not_ready")
 	ErrorCommandNotSupported = errors.New("command_not_supported")
+	ErrorMissingTextFields   = errors.New("missing_text_fields")
 )
 
 //----------------------------------------------------------------------------------------------------------------------
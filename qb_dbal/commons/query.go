@@ -0,0 +1,529 @@
+package commons
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	qbc "github.com/rskvp/qb-core"
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//	c o n s t
+//----------------------------------------------------------------------------------------------------------------------
+
+const (
+	QueryEqual        = "=="
+	QueryNotEqual     = "!="
+	QueryLower        = "<"
+	QueryLowerEqual   = "<="
+	QueryGreater      = ">"
+	QueryGreaterEqual = ">="
+	QueryIn           = "in"
+	QueryLike         = "like"   // SQL pattern: "%" any sequence, "_" any character
+	QueryExists       = "exists" // value is a boolean
+
+	QueryAnd = "and"
+	QueryOr  = "or"
+)
+
+var (
+	ErrorInvalidQuery = errors.New("invalid_query")
+)
+
+var queryFieldRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*$`)
+
+//----------------------------------------------------------------------------------------------------------------------
+//	t y p e s
+//----------------------------------------------------------------------------------------------------------------------
+
+// Query is a driver-neutral query that each IDatabase translates into its own language.
+//
+//	q := commons.NewQuery().
+//		Where("age", commons.QueryGreaterEqual, 18).
+//		WhereGroup(commons.Or(commons.Cond("city", "==", "Rome"), commons.Cond("city", "==", "Milan"))).
+//		OrderBy("name").Paginate(0, 10)
+type Query struct {
+	Filter *QueryGroup  `json:"filter"`
+	Sort   []*QuerySort `json:"sort"`
+	Offset int          `json:"offset"`
+	Limit  int          `json:"limit"`  // 0 means no limit
+	Fields []string     `json:"fields"` // projection. empty means all fields
+}
+
+type QueryGroup struct {
+	Operator   string            `json:"operator"` // "and", "or"
+	Conditions []*QueryCondition `json:"conditions"`
+	Groups     []*QueryGroup     `json:"groups"`
+}
+
+type QueryCondition struct {
+	Field      string      `json:"field"`      // "name" or nested "address.city" (not in SQL databases)
+	Comparator string      `json:"comparator"` // ==, !=, <, <=, >, >=, in, like, exists
+	Value      interface{} `json:"value"`
+}
+
+type QuerySort struct {
+	Field      string `json:"field"`
+	Descending bool   `json:"descending"`
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	c o n s t r u c t o r
+//----------------------------------------------------------------------------------------------------------------------
+
+func NewQuery() *Query {
+	instance := new(Query)
+	instance.Filter = And()
+	instance.Sort = make([]*QuerySort, 0)
+	instance.Fields = make([]string, 0)
+	return instance
+}
+
+func ParseQuery(text string) (*Query, error) {
+	instance := NewQuery()
+	err := qbc.JSON.Read(text, &instance)
+	if nil != err {
+		return nil, err
+	}
+	return instance, instance.Validate()
+}
+
+func Cond(field, comparator string, value interface{}) *QueryCondition {
+	return &QueryCondition{Field: field, Comparator: strings.ToLower(comparator), Value: value}
+}
+
+func And(items ...interface{}) *QueryGroup {
+	return newQueryGroup(QueryAnd, items)
+}
+
+func Or(items ...interface{}) *QueryGroup {
+	return newQueryGroup(QueryOr, items)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p u b l i c
+//----------------------------------------------------------------------------------------------------------------------
+
+func (instance *Query) String() string {
+	return qbc.JSON.Stringify(instance)
+}
+
+// Where adds a condition in AND with the others of the root group
+func (instance *Query) Where(field, comparator string, value interface{}) *Query {
+	instance.root().Conditions = append(instance.root().Conditions, Cond(field, comparator, value))
+	return instance
+}
+
+// WhereGroup adds a group of conditions in AND with the others of the root group
+func (instance *Query) WhereGroup(group *QueryGroup) *Query {
+	if nil != group {
+		instance.root().Groups = append(instance.root().Groups, group)
+	}
+	return instance
+}
+
+func (instance *Query) OrderBy(fields ...string) *Query {
+	for _, field := range fields {
+		instance.Sort = append(instance.Sort, &QuerySort{Field: field})
+	}
+	return instance
+}
+
+func (instance *Query) OrderByDesc(fields ...string) *Query {
+	for _, field := range fields {
+		instance.Sort = append(instance.Sort, &QuerySort{Field: field, Descending: true})
+	}
+	return instance
+}
+
+func (instance *Query) Paginate(offset, limit int) *Query {
+	instance.Offset = offset
+	instance.Limit = limit
+	return instance
+}
+
+func (instance *Query) Select(fields ...string) *Query {
+	instance.Fields = append(instance.Fields, fields...)
+	return instance
+}
+
+func (instance *Query) HasFilter() bool {
+	return nil != instance && !instance.Filter.IsEmpty()
+}
+
+// Validate checks operators, comparators and field names.
+// Field names are written into native queries, so only letters, digits, "_" and "." are allowed.
+func (instance *Query) Validate() error {
+	if nil == instance {
+		return ErrorInvalidQuery
+	}
+	if instance.Offset < 0 || instance.Limit < 0 {
+		return qbc.Errors.Prefix(ErrorInvalidQuery, "negative offset or limit: ")
+	}
+	if err := instance.Filter.Validate(); nil != err {
+		return err
+	}
+	for _, s := range instance.Sort {
+		if nil == s || !IsValidQueryField(s.Field) {
+			return invalidField(s)
+		}
+	}
+	for _, field := range instance.Fields {
+		if !IsValidQueryField(field) {
+			return invalidField(field)
+		}
+	}
+	return nil
+}
+
+// Match evaluates the filter against a document. Used by drivers without a query language.
+func (instance *Query) Match(doc map[string]interface{}) bool {
+	if nil == instance {
+		return true
+	}
+	return instance.Filter.Match(doc)
+}
+
+// Apply sorts, paginates and projects documents already filtered with Match.
+func (instance *Query) Apply(docs []map[string]interface{}) []map[string]interface{} {
+	if nil == instance {
+		return docs
+	}
+	if len(instance.Sort) > 0 {
+		sort.SliceStable(docs, func(i, j int) bool {
+			for _, s := range instance.Sort {
+				c := CompareValues(GetFieldValue(docs[i], s.Field), GetFieldValue(docs[j], s.Field))
+				if c != 0 {
+					if s.Descending {
+						return c > 0
+					}
+					return c < 0
+				}
+			}
+			return false
+		})
+	}
+	if instance.Offset > 0 {
+		if instance.Offset >= len(docs) {
+			docs = docs[:0]
+		} else {
+			docs = docs[instance.Offset:]
+		}
+	}
+	if instance.Limit > 0 && len(docs) > instance.Limit {
+		docs = docs[:instance.Limit]
+	}
+	if len(instance.Fields) > 0 {
+		response := make([]map[string]interface{}, 0, len(docs))
+		for _, doc := range docs {
+			item := map[string]interface{}{}
+			for _, field := range instance.Fields {
+				if value, b := lookupField(doc, field); b {
+					item[field] = value
+				}
+			}
+			response = append(response, item)
+		}
+		return response
+	}
+	return docs
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	QueryGroup
+//----------------------------------------------------------------------------------------------------------------------
+
+func (instance *QueryGroup) IsEmpty() bool {
+	if nil == instance {
+		return true
+	}
+	if len(instance.Conditions) > 0 {
+		return false
+	}
+	for _, group := range instance.Groups {
+		if !group.IsEmpty() {
+			return false
+		}
+	}
+	return true
+}
+
+func (instance *QueryGroup) Validate() error {
+	if nil == instance {
+		return nil
+	}
+	op := strings.ToLower(instance.Operator)
+	if len(op) > 0 && op != QueryAnd && op != QueryOr {
+		return qbc.Errors.Prefix(ErrorInvalidQuery, fmt.Sprintf("unknown operator '%s': ", instance.Operator))
+	}
+	for _, condition := range instance.Conditions {
+		if err := condition.Validate(); nil != err {
+			return err
+		}
+	}
+	for _, group := range instance.Groups {
+		if err := group.Validate(); nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+func (instance *QueryGroup) IsOr() bool {
+	return nil != instance && strings.ToLower(instance.Operator) == QueryOr
+}
+
+func (instance *QueryGroup) Match(doc map[string]interface{}) bool {
+	if instance.IsEmpty() {
+		return true
+	}
+	isOr := instance.IsOr()
+	for _, condition := range instance.Conditions {
+		match := condition.Match(doc)
+		if isOr && match {
+			return true
+		}
+		if !isOr && !match {
+			return false
+		}
+	}
+	for _, group := range instance.Groups {
+		if group.IsEmpty() {
+			continue
+		}
+		match := group.Match(doc)
+		if isOr && match {
+			return true
+		}
+		if !isOr && !match {
+			return false
+		}
+	}
+	return !isOr
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	QueryCondition
+//----------------------------------------------------------------------------------------------------------------------
+
+func (instance *QueryCondition) Validate() error {
+	if nil == instance || !IsValidQueryField(instance.Field) {
+		return invalidField(instance)
+	}
+	switch strings.ToLower(instance.Comparator) {
+	case QueryEqual, QueryNotEqual, QueryLower, QueryLowerEqual, QueryGreater, QueryGreaterEqual, QueryLike:
+		return nil
+	case QueryIn:
+		if nil != instance.Value && !isList(instance.Value) {
+			return qbc.Errors.Prefix(ErrorInvalidQuery, fmt.Sprintf("'%s' requires an array: ", instance.Field))
+		}
+		return nil
+	case QueryExists:
+		if _, b := instance.Value.(bool); !b && nil != instance.Value {
+			return qbc.Errors.Prefix(ErrorInvalidQuery, fmt.Sprintf("'%s' requires a boolean: ", instance.Field))
+		}
+		return nil
+	}
+	return qbc.Errors.Prefix(ErrorInvalidQuery, fmt.Sprintf("unknown comparator '%s': ", instance.Comparator))
+}
+
+// ExistsValue returns the value of an "exists" condition (default is true)
+func (instance *QueryCondition) ExistsValue() bool {
+	if b, ok := instance.Value.(bool); ok {
+		return b
+	}
+	return true
+}
+
+// ValueList returns the items of an "in" condition
+func (instance *QueryCondition) ValueList() []interface{} {
+	response := make([]interface{}, 0)
+	if isList(instance.Value) {
+		rv := reflect.ValueOf(instance.Value)
+		for i := 0; i < rv.Len(); i++ {
+			response = append(response, rv.Index(i).Interface())
+		}
+	}
+	return response
+}
+
+func (instance *QueryCondition) Match(doc map[string]interface{}) bool {
+	value, found := lookupField(doc, instance.Field)
+	switch strings.ToLower(instance.Comparator) {
+	case QueryExists:
+		return (found && nil != value) == instance.ExistsValue()
+	case QueryEqual:
+		return CompareValues(value, instance.Value) == 0
+	case QueryNotEqual:
+		return CompareValues(value, instance.Value) != 0
+	case QueryLower:
+		return nil != value && CompareValues(value, instance.Value) < 0
+	case QueryLowerEqual:
+		return nil != value && CompareValues(value, instance.Value) <= 0
+	case QueryGreater:
+		return nil != value && CompareValues(value, instance.Value) > 0
+	case QueryGreaterEqual:
+		return nil != value && CompareValues(value, instance.Value) >= 0
+	case QueryIn:
+		for _, item := range instance.ValueList() {
+			if CompareValues(value, item) == 0 {
+				return true
+			}
+		}
+		return false
+	case QueryLike:
+		if s, b := value.(string); b {
+			return likeRegex(qbc.Convert.ToString(instance.Value)).MatchString(s)
+		}
+		return false
+	}
+	return false
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	S T A T I C
+//----------------------------------------------------------------------------------------------------------------------
+
+func IsValidQueryField(name string) bool {
+	return queryFieldRegex.MatchString(name)
+}
+
+// GetFieldValue returns the value of a field, navigating nested maps with "." ("address.city")
+func GetFieldValue(doc map[string]interface{}, field string) interface{} {
+	value, _ := lookupField(doc, field)
+	return value
+}
+
+// CompareValues returns -1, 0 or 1. Numbers are compared as numbers, everything else as text.
+// nil is lower than any other value.
+func CompareValues(a, b interface{}) int {
+	if nil == a || nil == b {
+		if nil == a && nil == b {
+			return 0
+		}
+		if nil == a {
+			return -1
+		}
+		return 1
+	}
+	if fa, ok := toFloat(a); ok {
+		if fb, ok := toFloat(b); ok {
+			switch {
+			case fa < fb:
+				return -1
+			case fa > fb:
+				return 1
+			}
+			return 0
+		}
+	}
+	if ba, ok := a.(bool); ok {
+		if bb, ok := b.(bool); ok {
+			if ba == bb {
+				return 0
+			}
+			if !ba {
+				return -1
+			}
+			return 1
+		}
+	}
+	return strings.Compare(qbc.Convert.ToString(a), qbc.Convert.ToString(b))
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p r i v a t e
+//----------------------------------------------------------------------------------------------------------------------
+
+func (instance *Query) root() *QueryGroup {
+	if nil == instance.Filter {
+		instance.Filter = And()
+	}
+	return instance.Filter
+}
+
+func newQueryGroup(operator string, items []interface{}) *QueryGroup {
+	instance := new(QueryGroup)
+	instance.Operator = operator
+	instance.Conditions = make([]*QueryCondition, 0)
+	instance.Groups = make([]*QueryGroup, 0)
+	for _, item := range items {
+		switch v := item.(type) {
+		case *QueryCondition:
+			instance.Conditions = append(instance.Conditions, v)
+		case *QueryGroup:
+			instance.Groups = append(instance.Groups, v)
+		}
+	}
+	return instance
+}
+
+func invalidField(item interface{}) error {
+	return qbc.Errors.Prefix(ErrorInvalidQuery, fmt.Sprintf("invalid field %s: ", qbc.JSON.Stringify(item)))
+}
+
+func lookupField(doc map[string]interface{}, field string) (interface{}, bool) {
+	if nil == doc {
+		return nil, false
+	}
+	if value, b := doc[field]; b {
+		return value, true
+	}
+	var current interface{} = doc
+	for _, name := range strings.Split(field, ".") {
+		m, b := current.(map[string]interface{})
+		if !b {
+			return nil, false
+		}
+		current, b = m[name]
+		if !b {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func isList(value interface{}) bool {
+	if nil == value {
+		return false
+	}
+	if _, b := value.([]byte); b {
+		return false
+	}
+	kind := reflect.ValueOf(value).Kind()
+	return kind == reflect.Slice || kind == reflect.Array
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
+
+func likeRegex(pattern string) *regexp.Regexp {
+	var buf strings.Builder
+	buf.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			buf.WriteString(".*")
+		case '_':
+			buf.WriteString(".")
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	buf.WriteString("$")
+	return regexp.MustCompile("(?s)" + buf.String())
+}
@@ -0,0 +1,61 @@
+package commons
+
+import "testing"
+
+func TestQueryMatch(t *testing.T) {
+	docs := []map[string]interface{}{
+		{"name": "Mario", "age": 40.0, "address": map[string]interface{}{"city": "Rome"}},
+		{"name": "Maria", "age": 17.0, "address": map[string]interface{}{"city": "Milan"}},
+		{"name": "Giorgio", "age": 25.0},
+	}
+	query := NewQuery().
+		Where("age", QueryGreaterEqual, 18).
+		WhereGroup(Or(Cond("address.city", "==", "Rome"), Cond("address", "exists", false))).
+		OrderByDesc("name").
+		Select("name")
+	if err := query.Validate(); nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	response := make([]map[string]interface{}, 0)
+	for _, doc := range docs {
+		if query.Match(doc) {
+			response = append(response, doc)
+		}
+	}
+	response = query.Apply(response)
+	if len(response) != 2 || response[0]["name"] != "Mario" || response[1]["name"] != "Giorgio" {
+		t.Error("Unexpected response: ", response)
+		t.FailNow()
+	}
+	if _, b := response[0]["age"]; b {
+		t.Error("Expected projection on 'name'")
+		t.FailNow()
+	}
+
+	query = NewQuery().Where("name", "like", "Mari_").Where("age", "in", []int{17, 18})
+	if query.Match(docs[0]) || !query.Match(docs[1]) {
+		t.Error("Unexpected match for like/in")
+		t.FailNow()
+	}
+}
+
+func TestQueryValidate(t *testing.T) {
+	if err := NewQuery().Where("name; DROP", "==", 1).Validate(); nil == err {
+		t.Error("Expected invalid field error")
+		t.FailNow()
+	}
+	if err := NewQuery().Where("name", "~", 1).Validate(); nil == err {
+		t.Error("Expected invalid comparator error")
+		t.FailNow()
+	}
+	query, err := ParseQuery(`{"filter":{"operator":"or","conditions":[{"field":"age","comparator":">","value":10}]},"limit":5}`)
+	if nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	if !query.Filter.IsOr() || query.Limit != 5 {
+		t.Error("Unexpected parsed query: ", query)
+		t.FailNow()
+	}
+}
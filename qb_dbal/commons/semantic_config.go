@@ -28,8 +28,11 @@ func (instance *SemanticConfigDb) Parse(text string) {
 
 type SemanticConfig struct {
 	CaseSensitive bool              `json:"case_sensitive"`
-	DbInternal    *SemanticConfigDb `json:"db_internal"` // internal storage
-	DbExternal    *SemanticConfigDb `json:"db_external"` // db containing indexed data
+	Language      string            `json:"language"`     // stop words and stemmer ("en", "it"). Empty: no stemming
+	StopWords     []string          `json:"stop_words"`   // added to the stop words of Language
+	KeepAccents   bool              `json:"keep_accents"` // accents are folded by default ("perché" matches "perche")
+	DbInternal    *SemanticConfigDb `json:"db_internal"`  // internal storage
+	DbExternal    *SemanticConfigDb `json:"db_external"`  // db containing indexed data
 }
 
 func NewSemanticConfig() *SemanticConfig {
@@ -0,0 +1,51 @@
+package commons
+
+import "time"
+
+//----------------------------------------------------------------------------------------------------------------------
+//	t y p e
+//----------------------------------------------------------------------------------------------------------------------
+
+// TransactionOptions declares the collections a transaction works on.
+// ArangoDB stream transactions must declare them in advance, the other drivers ignore collections.
+type TransactionOptions struct {
+	Read        []string      `json:"read,omitempty"`
+	Write       []string      `json:"write,omitempty"`
+	Exclusive   []string      `json:"exclusive,omitempty"`
+	LockTimeout time.Duration `json:"lock_timeout,omitempty"`
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	c o n s t r u c t o r
+//----------------------------------------------------------------------------------------------------------------------
+
+func NewTransactionOptions() *TransactionOptions {
+	return &TransactionOptions{
+		Read:      make([]string, 0),
+		Write:     make([]string, 0),
+		Exclusive: make([]string, 0),
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p u b l i c
+//----------------------------------------------------------------------------------------------------------------------
+
+func (instance *TransactionOptions) ReadFrom(collections ...string) *TransactionOptions {
+	instance.Read = append(instance.Read, collections...)
+	return instance
+}
+
+func (instance *TransactionOptions) WriteTo(collections ...string) *TransactionOptions {
+	instance.Write = append(instance.Write, collections...)
+	return instance
+}
+
+func (instance *TransactionOptions) Lock(collections ...string) *TransactionOptions {
+	instance.Exclusive = append(instance.Exclusive, collections...)
+	return instance
+}
+
+func (instance *TransactionOptions) HasCollections() bool {
+	return nil != instance && len(instance.Read)+len(instance.Write)+len(instance.Exclusive) > 0
+}
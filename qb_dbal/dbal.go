@@ -4,6 +4,7 @@ import (
 	qbc "github.com/rskvp/qb-core"
 	"github.com/rskvp/qb-lib/qb_dbal/commons"
 	"github.com/rskvp/qb-lib/qb_dbal/drivers"
+	"github.com/rskvp/qb-lib/qb_dbal/migrations"
 	"github.com/rskvp/qb-lib/qb_dbal/semantic_search"
 	"github.com/rskvp/qb-lib/qb_dbal/showcase_search"
 )
@@ -24,6 +25,26 @@ func OpenDatabase(driver, connectionString string) (drivers.IDatabase, error) {
 	return drivers.OpenDatabase(driver, connectionString)
 }
 
+// NewQuery returns a driver-neutral query for IDatabase.Query
+func NewQuery() *commons.Query {
+	return commons.NewQuery()
+}
+
+// NewTransactionOptions returns the collections declaration for IDatabase.Begin (required by ArangoDB)
+func NewTransactionOptions() *commons.TransactionOptions {
+	return commons.NewTransactionOptions()
+}
+
+// WithTransaction runs callback into a transaction of db: commit on success, rollback on error
+func WithTransaction(db drivers.IDatabase, options *commons.TransactionOptions, callback drivers.TransactionCallback) error {
+	return drivers.WithTransaction(db, options, callback)
+}
+
+// NewMigrator returns a schema migrations runner for db
+func NewMigrator(db drivers.IDatabase) *migrations.Migrator {
+	return migrations.NewMigrator(db)
+}
+
 func NewSemanticEngine(c interface{}) (*semantic_search.SemanticEngine, error) {
 	config, err := getConfig(c)
 	if nil != err {
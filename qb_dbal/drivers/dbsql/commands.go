@@ -2,6 +2,7 @@ package dbsql
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	qbc "github.com/rskvp/qb-core"
@@ -15,6 +16,8 @@ func BuildSelect(tableOrQuery string) string {
 	}
 }
 
+// BuildInsertCommands returns INSERT statements with values written as literals.
+// Deprecated: use BuildInsertCommand that binds values as parameters.
 func BuildInsertCommands(tableName string, data []interface{}) []string {
 	// INSERT INTO test.table1 (age, first_name)
 	// VALUES (12, 'Giorgio');
@@ -27,7 +30,7 @@ func BuildInsertCommands(tableName string, data []interface{}) []string {
 				for k, v := range item {
 					names = append(names, k)
 					if vv, b := v.(string); b {
-						values = append(values, "'"+vv+"'")
+						values = append(values, toString(vv))
 					} else {
 						values = append(values, qbc.Convert.ToString(v))
 					}
@@ -62,6 +65,8 @@ func BuildInsertCommands(tableName string, data []interface{}) []string {
 	return response
 }
 
+// BuildUpdateCommand returns an UPDATE statement with values written as literals.
+// Deprecated: use BuildUpdateByKeyCommand that binds values as parameters.
 func BuildUpdateCommand(tableName string, keyName string, keyValue interface{}, data map[string]interface{}) string {
 	// UPDATE test.table1 t
 	// SET t.age = 23,
@@ -178,14 +183,228 @@ func BuildCountDistinctCommand(tableName, fieldName, filter string) string {
 	return buf.String()
 }
 
+//----------------------------------------------------------------------------------------------------------------------
+//	p a r a m e t e r i z e d
+//----------------------------------------------------------------------------------------------------------------------
+
+// BuildSelectByKeyCommand returns "SELECT t.* FROM table t WHERE t.key = ?" and its arguments.
+func BuildSelectByKeyCommand(dialect *Dialect, tableName, keyName string, keyValue interface{}) (string, []interface{}, error) {
+	if err := CheckIdentifiers(tableName, keyName); nil != err {
+		return "", nil, err
+	}
+	query := fmt.Sprintf("SELECT t.* FROM %s t WHERE t.%s = %s", tableName, keyName, dialect.Param(1))
+	return query, []interface{}{ToArg(keyValue)}, nil
+}
+
+// BuildInsertCommand returns "INSERT INTO table (a, b) VALUES (?, ?)" and its arguments.
+func BuildInsertCommand(dialect *Dialect, tableName string, data map[string]interface{}) (string, []interface{}, error) {
+	return BuildInsertReturningCommand(dialect, tableName, "", data)
+}
+
+// BuildInsertReturningCommand works like BuildInsertCommand but, when keyName is not empty and the database
+// supports it (postgres, sqlite, mssql), the statement returns the value of keyName generated by the database.
+func BuildInsertReturningCommand(dialect *Dialect, tableName, keyName string, data map[string]interface{}) (string, []interface{}, error) {
+	names := sortedNames(data)
+	if err := CheckIdentifiers(append([]string{tableName}, names...)...); nil != err {
+		return "", nil, err
+	}
+	if len(keyName) > 0 {
+		if err := CheckIdentifiers(keyName); nil != err {
+			return "", nil, err
+		}
+	}
+	args := make([]interface{}, 0, len(names))
+	params := make([]string, 0, len(names))
+	for i, name := range names {
+		args = append(args, ToArg(data[name]))
+		params = append(params, dialect.Param(i+1))
+	}
+
+	var buf strings.Builder
+	buf.WriteString("INSERT INTO ")
+	buf.WriteString(tableName)
+	if len(names) > 0 || dialect.Family == FamilyMySQL {
+		buf.WriteString(" (")
+		buf.WriteString(strings.Join(names, ", "))
+		buf.WriteString(")")
+	}
+	if len(keyName) > 0 && dialect.Family == FamilyMsSQL {
+		buf.WriteString(" OUTPUT INSERTED." + keyName)
+	}
+	if len(names) > 0 || dialect.Family == FamilyMySQL {
+		buf.WriteString(" VALUES (")
+		buf.WriteString(strings.Join(params, ", "))
+		buf.WriteString(")")
+	} else {
+		buf.WriteString(" DEFAULT VALUES")
+	}
+	if len(keyName) > 0 && (dialect.Family == FamilyPostgres || dialect.Family == FamilySQLite) {
+		buf.WriteString(" RETURNING " + keyName)
+	}
+	return buf.String(), args, nil
+}
+
+// BuildUpsertCommand returns a single statement that inserts data or, if a row with the same keyName
+// already exists, updates it:
+// "INSERT ... ON DUPLICATE KEY UPDATE" for mysql, "INSERT ... ON CONFLICT" for postgres and sqlite
+// and "MERGE" for oracle and mssql.
+// Generic (odbc) databases have no portable syntax and return ErrorUpsertNotSupported.
+func BuildUpsertCommand(dialect *Dialect, tableName, keyName string, data map[string]interface{}) (string, []interface{}, error) {
+	if _, b := data[keyName]; !b {
+		return "", nil, qbc.Errors.Prefix(MissingKeyError, fmt.Sprintf("'%s': ", keyName))
+	}
+	names := sortedNames(data)
+	if err := CheckIdentifiers(append([]string{tableName, keyName}, names...)...); nil != err {
+		return "", nil, err
+	}
+	updates := make([]string, 0, len(names))
+	for _, name := range names {
+		if name != keyName {
+			updates = append(updates, name)
+		}
+	}
+	args := make([]interface{}, 0, len(names))
+	params := make([]string, 0, len(names))
+	for i, name := range names {
+		args = append(args, ToArg(data[name]))
+		params = append(params, dialect.Param(i+1))
+	}
+
+	var buf strings.Builder
+	switch dialect.Family {
+	case FamilyMySQL:
+		// INSERT INTO t (id, name) VALUES (?, ?) ON DUPLICATE KEY UPDATE name = VALUES(name)
+		buf.WriteString("INSERT INTO " + tableName)
+		buf.WriteString(" (" + strings.Join(names, ", ") + ")")
+		buf.WriteString(" VALUES (" + strings.Join(params, ", ") + ")")
+		buf.WriteString(" ON DUPLICATE KEY UPDATE ")
+		if len(updates) == 0 {
+			buf.WriteString(keyName + " = " + keyName)
+		}
+		for i, name := range updates {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString(name + " = VALUES(" + name + ")")
+		}
+	case FamilyPostgres, FamilySQLite:
+		// INSERT INTO t (id, name) VALUES ($1, $2) ON CONFLICT (id) DO UPDATE SET name = excluded.name
+		buf.WriteString("INSERT INTO " + tableName)
+		buf.WriteString(" (" + strings.Join(names, ", ") + ")")
+		buf.WriteString(" VALUES (" + strings.Join(params, ", ") + ")")
+		buf.WriteString(" ON CONFLICT (" + keyName + ")")
+		if len(updates) == 0 {
+			buf.WriteString(" DO NOTHING")
+		} else {
+			buf.WriteString(" DO UPDATE SET ")
+		}
+		for i, name := range updates {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString(name + " = excluded." + name)
+		}
+	case FamilyOracle, FamilyMsSQL:
+		// MERGE INTO t target USING (SELECT :1 AS id, :2 AS name FROM dual) source ON (target.id = source.id)
+		// WHEN MATCHED THEN UPDATE SET target.name = source.name
+		// WHEN NOT MATCHED THEN INSERT (id, name) VALUES (source.id, source.name)
+		columns := make([]string, 0, len(names))
+		sources := make([]string, 0, len(names))
+		for i, name := range names {
+			columns = append(columns, params[i]+" AS "+name)
+			sources = append(sources, "source."+name)
+		}
+		buf.WriteString("MERGE INTO " + tableName)
+		if dialect.Family == FamilyMsSQL {
+			// without HOLDLOCK concurrent upserts of a new key may both insert it
+			buf.WriteString(" WITH (HOLDLOCK) AS")
+		}
+		buf.WriteString(" target USING (SELECT ")
+		buf.WriteString(strings.Join(columns, ", "))
+		if dialect.Family == FamilyOracle {
+			buf.WriteString(" FROM dual")
+		}
+		buf.WriteString(") source ON (target." + keyName + " = source." + keyName + ")")
+		if len(updates) > 0 {
+			buf.WriteString(" WHEN MATCHED THEN UPDATE SET ")
+			for i, name := range updates {
+				if i > 0 {
+					buf.WriteString(", ")
+				}
+				buf.WriteString("target." + name + " = source." + name)
+			}
+		}
+		buf.WriteString(" WHEN NOT MATCHED THEN INSERT (" + strings.Join(names, ", ") + ")")
+		buf.WriteString(" VALUES (" + strings.Join(sources, ", ") + ")")
+		if dialect.Family == FamilyMsSQL {
+			buf.WriteString(";") // mssql requires MERGE to be terminated
+		}
+	default:
+		return "", nil, UpsertNotSupportedError
+	}
+	return buf.String(), args, nil
+}
+
+// BuildUpdateByKeyCommand returns "UPDATE table SET a = ?, b = ? WHERE key = ?" and its arguments.
+// The key field is never updated.
+func BuildUpdateByKeyCommand(dialect *Dialect, tableName, keyName string, keyValue interface{}, data map[string]interface{}) (string, []interface{}, error) {
+	names := make([]string, 0, len(data))
+	for _, name := range sortedNames(data) {
+		if name != keyName {
+			names = append(names, name)
+		}
+	}
+	if err := CheckIdentifiers(append([]string{tableName, keyName}, names...)...); nil != err {
+		return "", nil, err
+	}
+	args := make([]interface{}, 0, len(names)+1)
+
+	var buf strings.Builder
+	buf.WriteString("UPDATE ")
+	buf.WriteString(tableName)
+	buf.WriteString(" SET ")
+	for i, name := range names {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(name + " = " + dialect.Param(i+1))
+		args = append(args, ToArg(data[name]))
+	}
+	if len(names) == 0 {
+		buf.WriteString(keyName + " = " + keyName)
+	}
+	buf.WriteString(" WHERE ")
+	buf.WriteString(keyName + " = " + dialect.Param(len(names)+1))
+	args = append(args, ToArg(keyValue))
+	return buf.String(), args, nil
+}
+
+// BuildDeleteByKeyCommand returns "DELETE FROM table WHERE key = ?" and its arguments.
+func BuildDeleteByKeyCommand(dialect *Dialect, tableName, keyName string, keyValue interface{}) (string, []interface{}, error) {
+	if err := CheckIdentifiers(tableName, keyName); nil != err {
+		return "", nil, err
+	}
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s = %s", tableName, keyName, dialect.Param(1))
+	return query, []interface{}{ToArg(keyValue)}, nil
+}
+
 //----------------------------------------------------------------------------------------------------------------------
 //	p r i v a t e
 //----------------------------------------------------------------------------------------------------------------------
 
+func sortedNames(data map[string]interface{}) []string {
+	names := make([]string, 0, len(data))
+	for k := range data {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func toString(val interface{}) string {
 	str := qbc.Convert.ToString(val)
 	if v, b := val.(string); b {
-		return "'" + v + "'"
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
 	}
 	return str
 }
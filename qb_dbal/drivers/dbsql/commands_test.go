@@ -0,0 +1,155 @@
+package dbsql
+
+import (
+	"strings"
+	"testing"
+
+	dbalcommons "github.com/rskvp/qb-lib/qb_dbal/commons"
+)
+
+func TestDialectParam(t *testing.T) {
+	values := map[string]string{
+		"mysql":    "?",
+		"odbc":     "?",
+		"postgres": "$2",
+		"oracle":   ":2",
+		"mssql":    "@p2",
+	}
+	for driver, expected := range values {
+		got := NewDialect(driver).Param(2)
+		if got != expected {
+			t.Error("Expected '"+expected+"', got ", got, driver)
+			t.FailNow()
+		}
+	}
+}
+
+func TestBuildUpdateByKeyCommand(t *testing.T) {
+	item := map[string]interface{}{"id": 1, "name": "O'Hara", "age": 23}
+	query, args, err := BuildUpdateByKeyCommand(NewDialect("postgres"), "users", "id", item["id"], item)
+	if nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	expected := "UPDATE users SET age = $1, name = $2 WHERE id = $3"
+	if query != expected {
+		t.Error("Expected '"+expected+"', got ", query)
+		t.FailNow()
+	}
+	if len(args) != 3 || args[1] != "O'Hara" || args[2] != 1 {
+		t.Error("Unexpected arguments: ", args)
+		t.FailNow()
+	}
+}
+
+func TestBuildInsertCommand(t *testing.T) {
+	item := map[string]interface{}{"id": 1, "tags": []string{"a", "b"}}
+	query, args, err := BuildInsertCommand(NewDialect("mssql"), "users", item)
+	if nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	expected := "INSERT INTO users (id, tags) VALUES (@p1, @p2)"
+	if query != expected {
+		t.Error("Expected '"+expected+"', got ", query)
+		t.FailNow()
+	}
+	if args[1] != `["a","b"]` {
+		t.Error("Expected JSON array, got ", args[1])
+		t.FailNow()
+	}
+}
+
+func TestBuildCommandInjection(t *testing.T) {
+	query, args, err := BuildSelectByKeyCommand(NewDialect("mysql"), "users", "id", "1 OR 1=1")
+	if nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	if query != "SELECT t.* FROM users t WHERE t.id = ?" || args[0] != "1 OR 1=1" {
+		t.Error("Key must be bound as parameter, got ", query)
+		t.FailNow()
+	}
+	_, _, err = BuildDeleteByKeyCommand(NewDialect("mysql"), "users; DROP TABLE users", "id", 1)
+	if nil == err {
+		t.Error("Expected invalid identifier error")
+		t.FailNow()
+	}
+	_, _, err = BuildInsertCommand(NewDialect("mysql"), "users", map[string]interface{}{"a=1;--": 1})
+	if nil == err {
+		t.Error("Expected invalid identifier error")
+		t.FailNow()
+	}
+}
+
+func TestBuildUpsertCommand(t *testing.T) {
+	item := map[string]interface{}{"code": "A1", "name": "Mario"}
+	values := map[string]string{
+		"mysql":    "INSERT INTO users (code, name) VALUES (?, ?) ON DUPLICATE KEY UPDATE name = VALUES(name)",
+		"postgres": "INSERT INTO users (code, name) VALUES ($1, $2) ON CONFLICT (code) DO UPDATE SET name = excluded.name",
+		"oracle": "MERGE INTO users target USING (SELECT :1 AS code, :2 AS name FROM dual) source ON (target.code = source.code)" +
+			" WHEN MATCHED THEN UPDATE SET target.name = source.name" +
+			" WHEN NOT MATCHED THEN INSERT (code, name) VALUES (source.code, source.name)",
+		"mssql": "MERGE INTO users WITH (HOLDLOCK) AS target USING (SELECT @p1 AS code, @p2 AS name) source ON (target.code = source.code)" +
+			" WHEN MATCHED THEN UPDATE SET target.name = source.name" +
+			" WHEN NOT MATCHED THEN INSERT (code, name) VALUES (source.code, source.name);",
+	}
+	for driver, expected := range values {
+		query, args, err := BuildUpsertCommand(NewDialect(driver), "users", "code", item)
+		if nil != err {
+			t.Error(err)
+			t.FailNow()
+		}
+		if query != expected {
+			t.Error("Expected '"+expected+"', got ", query)
+			t.FailNow()
+		}
+		if len(args) != 2 {
+			t.Error("Unexpected arguments: ", args)
+			t.FailNow()
+		}
+	}
+	_, _, err := BuildUpsertCommand(NewDialect("odbc"), "users", "code", item)
+	if err != UpsertNotSupportedError {
+		t.Error("Expected upsert_not_supported, got ", err)
+		t.FailNow()
+	}
+}
+
+func TestBuildQueryCommand(t *testing.T) {
+	query := dbalcommons.NewQuery().
+		Where("age", ">=", 18).
+		WhereGroup(dbalcommons.Or(dbalcommons.Cond("city", "in", []string{"Rome", "Milan"}), dbalcommons.Cond("city", "exists", false))).
+		OrderBy("name").Paginate(10, 5).Select("name", "age")
+	command, args, err := BuildQueryCommand(NewDialect("postgres"), "users", query)
+	if nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	expected := "SELECT name, age FROM users WHERE (age >= $1 AND (city IN ($2, $3) OR city IS NULL)) ORDER BY name ASC LIMIT 5 OFFSET 10"
+	if command != expected {
+		t.Error("Expected '"+expected+"', got ", command)
+		t.FailNow()
+	}
+	if len(args) != 3 {
+		t.Error("Unexpected arguments: ", args)
+		t.FailNow()
+	}
+}
+
+func TestBuildQueryCommandNestedField(t *testing.T) {
+	queries := []*dbalcommons.Query{
+		dbalcommons.NewQuery().Where("address.city", "==", "Rome"),
+		dbalcommons.NewQuery().WhereGroup(dbalcommons.Or(dbalcommons.Cond("name", "==", "Mario"), dbalcommons.Cond("address.city", "==", "Rome"))),
+		dbalcommons.NewQuery().OrderBy("address.city"),
+		dbalcommons.NewQuery().Select("name", "address.city"),
+	}
+	for _, query := range queries {
+		if _, _, err := BuildQueryCommand(NewDialect("postgres"), "users", query); nil == err || !strings.Contains(err.Error(), NestedFieldError.Error()) {
+			t.Error("Expected nested field error, got ", err)
+		}
+	}
+	if _, _, err := BuildQueryCommand(NewDialect("postgres"), "public.users", dbalcommons.NewQuery().Where("name", "==", "Mario")); nil != err {
+		t.Error(err)
+	}
+}
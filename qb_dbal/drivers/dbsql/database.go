@@ -16,6 +16,8 @@ import (
 
 var (
 	DatabaseNotInitializedError = errors.New("database_not_initialized")
+	NotInTransactionError       = errors.New("not_in_transaction")
+	NestedTransactionError      = errors.New("nested_transaction_not_supported")
 )
 
 //----------------------------------------------------------------------------------------------------------------------
@@ -26,6 +28,7 @@ type Database struct {
 	driver         string // i.e. "mysql"
 	dataSourceName string // i.e. "user:password@/dbname", "admin:admin@tcp(localhost:3306)/test"
 	db             *sql.DB
+	tx             *sql.Tx // not nil for a database returned from Begin
 }
 
 //----------------------------------------------------------------------------------------------------------------------
@@ -49,7 +52,11 @@ func NewDatabase(driverName, connectionString string) (*Database, error) {
 //	p u b l i c
 //----------------------------------------------------------------------------------------------------------------------
 
+// Close closes the database. On a database returned from Begin it rolls back the transaction.
 func (instance *Database) Close() error {
+	if nil != instance.tx {
+		return instance.Rollback()
+	}
 	if nil != instance.db {
 		err := instance.db.Close()
 		instance.db = nil
@@ -58,7 +65,50 @@ func (instance *Database) Close() error {
 	return DatabaseNotInitializedError
 }
 
+// Begin starts a transaction and returns a Database running all commands into it.
+// The returned database must be ended with Commit or Rollback.
+func (instance *Database) Begin() (*Database, error) {
+	if nil == instance.db {
+		return nil, DatabaseNotInitializedError
+	}
+	if nil != instance.tx {
+		return nil, NestedTransactionError
+	}
+	tx, err := instance.db.Begin()
+	if nil != err {
+		return nil, err
+	}
+	return &Database{driver: instance.driver, dataSourceName: instance.dataSourceName, db: instance.db, tx: tx}, nil
+}
+
+func (instance *Database) InTransaction() bool {
+	return nil != instance.tx
+}
+
+func (instance *Database) Commit() error {
+	if nil == instance.tx {
+		return NotInTransactionError
+	}
+	err := instance.tx.Commit()
+	instance.tx = nil
+	instance.db = nil // the transaction database is no longer usable
+	return err
+}
+
+func (instance *Database) Rollback() error {
+	if nil == instance.tx {
+		return NotInTransactionError
+	}
+	err := instance.tx.Rollback()
+	instance.tx = nil
+	instance.db = nil
+	return err
+}
+
 func (instance *Database) Query(query string, args ...interface{}) *DatabaseRows {
+	if nil != instance.tx {
+		return NewDatabaseRows(instance.tx.Query(query, args...))
+	}
 	if nil != instance.db {
 		return NewDatabaseRows(instance.db.Query(query, args...))
 	}
@@ -66,6 +116,9 @@ func (instance *Database) Query(query string, args ...interface{}) *DatabaseRows
 }
 
 func (instance *Database) QueryRow(query string, response interface{}, args ...interface{}) *DatabaseRow {
+	if nil != instance.tx {
+		return NewDatabaseRow(instance.tx.QueryRow(query, args...), response)
+	}
 	if nil != instance.db {
 		return NewDatabaseRow(instance.db.QueryRow(query, args...), response)
 	}
@@ -73,16 +126,174 @@ func (instance *Database) QueryRow(query string, response interface{}, args ...i
 }
 
 func (instance *Database) Exec(query string, args ...interface{}) *DatabaseResult {
+	if nil != instance.tx {
+		return NewDatabaseResult(instance.tx.Exec(query, args...))
+	}
 	if nil != instance.db {
 		return NewDatabaseResult(instance.db.Exec(query, args...))
 	}
 	return nil
 }
 
+// Upsert inserts data into tableName or updates the row having the same keyName.
+// If data has no value for keyName a new row is inserted and the key generated by the database is
+// read back (RETURNING/OUTPUT clause or LastInsertId).
+// Returns the row as stored into the database.
+func (instance *Database) Upsert(dialect *Dialect, tableName, keyName string, data map[string]interface{}) (map[string]interface{}, error) {
+	if nil == instance.db {
+		return nil, DatabaseNotInitializedError
+	}
+	if nil == dialect {
+		dialect = NewDialect(instance.driver)
+	}
+	keyValue := data[keyName]
+	if nil == keyValue {
+		values := map[string]interface{}{}
+		for k, v := range data {
+			if k != keyName {
+				values[k] = v
+			}
+		}
+		var err error
+		keyValue, err = instance.insert(dialect, tableName, keyName, values)
+		if nil != err {
+			return nil, err
+		}
+	} else {
+		query, args, err := BuildUpsertCommand(dialect, tableName, keyName, data)
+		if nil == err {
+			result := instance.Exec(query, args...)
+			if result.HasError() {
+				return nil, result.GetError()
+			}
+		} else if errors.Is(err, UpsertNotSupportedError) {
+			err = instance.updateOrInsert(dialect, tableName, keyName, keyValue, data)
+			if nil != err {
+				return nil, err
+			}
+		} else {
+			return nil, err
+		}
+	}
+
+	return instance.readBack(dialect, tableName, keyName, keyValue, data)
+}
+
+// Insert adds a new row into tableName. Unlike Upsert it fails if a row with the same key already exists.
+// Returns the row as stored into the database.
+func (instance *Database) Insert(dialect *Dialect, tableName, keyName string, data map[string]interface{}) (map[string]interface{}, error) {
+	if nil == instance.db {
+		return nil, DatabaseNotInitializedError
+	}
+	if nil == dialect {
+		dialect = NewDialect(instance.driver)
+	}
+	keyValue := data[keyName]
+	if nil == keyValue {
+		return instance.Upsert(dialect, tableName, keyName, data) // a new key is always generated
+	}
+	query, args, err := BuildInsertCommand(dialect, tableName, data)
+	if nil != err {
+		return nil, err
+	}
+	if err = instance.Exec(query, args...).GetError(); nil != err {
+		return nil, err
+	}
+	return instance.readBack(dialect, tableName, keyName, keyValue, data)
+}
+
 //----------------------------------------------------------------------------------------------------------------------
 //	p r i v a t e
 //----------------------------------------------------------------------------------------------------------------------
 
+// insert adds a new row and returns the key generated by the database (nil if not available)
+func (instance *Database) insert(dialect *Dialect, tableName, keyName string, data map[string]interface{}) (interface{}, error) {
+	switch dialect.Family {
+	case FamilyPostgres, FamilySQLite, FamilyMsSQL:
+		query, args, err := BuildInsertReturningCommand(dialect, tableName, keyName, data)
+		if nil != err {
+			return nil, err
+		}
+		rows := instance.Query(query, args...)
+		if rows.HasError() {
+			return nil, rows.GetError()
+		}
+		defer rows.Close()
+		row, err := rows.First()
+		if nil != err || nil == row {
+			return nil, err
+		}
+		return row[keyName], nil
+	default:
+		query, args, err := BuildInsertCommand(dialect, tableName, data)
+		if nil != err {
+			return nil, err
+		}
+		result := instance.Exec(query, args...)
+		if result.HasError() {
+			return nil, result.GetError()
+		}
+		if id, idErr := result.LastInsertId(); nil == idErr && id > 0 {
+			return id, nil
+		}
+		return nil, nil // database does not return generated keys
+	}
+}
+
+// readBack returns the row stored with keyValue, or data if the key is not available
+func (instance *Database) readBack(dialect *Dialect, tableName, keyName string, keyValue interface{}, data map[string]interface{}) (map[string]interface{}, error) {
+	response := map[string]interface{}{}
+	for k, v := range data {
+		response[k] = v
+	}
+	if nil != keyValue {
+		response[keyName] = keyValue
+		query, args, err := BuildSelectByKeyCommand(dialect, tableName, keyName, keyValue)
+		if nil != err {
+			return nil, err
+		}
+		rows := instance.Query(query, args...)
+		if rows.HasError() {
+			return nil, rows.GetError()
+		}
+		defer rows.Close()
+		row, err := rows.First()
+		if nil != err {
+			return nil, err
+		}
+		if nil != row {
+			response = row
+		}
+	}
+	return response, nil
+}
+
+// updateOrInsert is the fallback for databases without a native upsert statement
+func (instance *Database) updateOrInsert(dialect *Dialect, tableName, keyName string, keyValue interface{}, data map[string]interface{}) error {
+	query, args, err := BuildSelectByKeyCommand(dialect, tableName, keyName, keyValue)
+	if nil != err {
+		return err
+	}
+	rows := instance.Query(query, args...)
+	if rows.HasError() {
+		return rows.GetError()
+	}
+	row, err := rows.First()
+	_ = rows.Close()
+	if nil != err {
+		return err
+	}
+	if nil != row {
+		query, args, err = BuildUpdateByKeyCommand(dialect, tableName, keyName, keyValue, data)
+	} else {
+		query, args, err = BuildInsertCommand(dialect, tableName, data)
+	}
+	if nil != err {
+		return err
+	}
+	return instance.Exec(query, args...).GetError()
+}
+
 func (instance *Database) init() error {
 	db, err := sql.Open(instance.driver, instance.dataSourceName)
 	if nil != err {
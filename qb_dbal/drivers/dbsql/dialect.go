@@ -0,0 +1,128 @@
+package dbsql
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	qbc "github.com/rskvp/qb-core"
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//	c o n s t
+//----------------------------------------------------------------------------------------------------------------------
+
+var (
+	InvalidIdentifierError  = errors.New("invalid_identifier")
+	UpsertNotSupportedError = errors.New("upsert_not_supported")
+	MissingKeyError         = errors.New("missing_key")
+	NestedFieldError        = errors.New("nested_field_not_supported")
+)
+
+const (
+	PlaceholderQuestion = "?"  // mysql, sqlite, odbc
+	PlaceholderDollar   = "$"  // postgres
+	PlaceholderColon    = ":"  // oracle
+	PlaceholderAtP      = "@p" // mssql, sqlserver
+)
+
+const (
+	FamilyGeneric  = "generic" // odbc and unknown drivers
+	FamilyMySQL    = "mysql"
+	FamilyPostgres = "postgres"
+	FamilySQLite   = "sqlite"
+	FamilyOracle   = "oracle"
+	FamilyMsSQL    = "mssql"
+)
+
+var identifierRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$]*(\.[A-Za-z_][A-Za-z0-9_$]*)*$`)
+
+//----------------------------------------------------------------------------------------------------------------------
+//	t y p e s
+//----------------------------------------------------------------------------------------------------------------------
+
+// Dialect describes how a database/sql driver wants bound parameters to be written.
+type Dialect struct {
+	Name        string
+	Family      string
+	Placeholder string
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	c o n s t r u c t o r
+//----------------------------------------------------------------------------------------------------------------------
+
+func NewDialect(driverName string) *Dialect {
+	instance := new(Dialect)
+	instance.Name = strings.ToLower(driverName)
+	switch instance.Name {
+	case "mysql", "mariadb":
+		instance.Family = FamilyMySQL
+		instance.Placeholder = PlaceholderQuestion
+	case "postgres", "postgresql", "pgx":
+		instance.Family = FamilyPostgres
+		instance.Placeholder = PlaceholderDollar
+	case "sqlite", "sqlite3":
+		instance.Family = FamilySQLite
+		instance.Placeholder = PlaceholderQuestion
+	case "oracle", "godror", "oci8":
+		instance.Family = FamilyOracle
+		instance.Placeholder = PlaceholderColon
+	case "mssql", "sqlserver":
+		instance.Family = FamilyMsSQL
+		instance.Placeholder = PlaceholderAtP
+	default:
+		instance.Family = FamilyGeneric
+		instance.Placeholder = PlaceholderQuestion
+	}
+	return instance
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p u b l i c
+//----------------------------------------------------------------------------------------------------------------------
+
+// Param returns the placeholder for the parameter at position "index" (1-based).
+func (instance *Dialect) Param(index int) string {
+	if nil == instance || instance.Placeholder == PlaceholderQuestion {
+		return "?"
+	}
+	return fmt.Sprintf("%s%d", instance.Placeholder, index)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	S T A T I C
+//----------------------------------------------------------------------------------------------------------------------
+
+// IsValidIdentifier returns true if name can be safely written into a statement as a table or column name.
+func IsValidIdentifier(name string) bool {
+	return identifierRegex.MatchString(name)
+}
+
+func CheckIdentifiers(names ...string) error {
+	for _, name := range names {
+		if !IsValidIdentifier(name) {
+			return qbc.Errors.Prefix(InvalidIdentifierError, fmt.Sprintf("'%s': ", name))
+		}
+	}
+	return nil
+}
+
+// ToArg converts a value into something a database/sql driver can bind.
+// Strings, numbers, booleans, dates and binary values are passed as they are, maps and arrays are
+// serialized to JSON.
+func ToArg(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil, string, []byte, bool, time.Time, *time.Time,
+		int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return v
+	}
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array, reflect.Struct:
+		return qbc.JSON.Stringify(value)
+	}
+	return value
+}
@@ -0,0 +1,198 @@
+package dbsql
+
+import (
+	"fmt"
+	"strings"
+
+	qbc "github.com/rskvp/qb-core"
+	dbalcommons "github.com/rskvp/qb-lib/qb_dbal/commons"
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p u b l i c
+//----------------------------------------------------------------------------------------------------------------------
+
+// BuildQueryCommand translates a driver-neutral query into a SELECT statement with bound parameters.
+// Nested fields ("address.city") are not supported: in SQL they are a column of another table.
+func BuildQueryCommand(dialect *Dialect, tableName string, query *dbalcommons.Query) (string, []interface{}, error) {
+	if nil == query {
+		query = dbalcommons.NewQuery()
+	}
+	if err := query.Validate(); nil != err {
+		return "", nil, err
+	}
+	if err := CheckIdentifiers(tableName); nil != err {
+		return "", nil, err
+	}
+	if err := checkColumns(query); nil != err {
+		return "", nil, err
+	}
+	builder := &queryBuilder{dialect: dialect, args: make([]interface{}, 0)}
+
+	var buf strings.Builder
+	buf.WriteString("SELECT ")
+	if len(query.Fields) > 0 {
+		buf.WriteString(strings.Join(query.Fields, ", "))
+	} else {
+		buf.WriteString("*")
+	}
+	buf.WriteString(" FROM ")
+	buf.WriteString(tableName)
+
+	// filter
+	if query.HasFilter() {
+		buf.WriteString(" WHERE ")
+		buf.WriteString(builder.group(query.Filter))
+	}
+
+	// sort
+	if len(query.Sort) > 0 {
+		buf.WriteString(" ORDER BY ")
+		for i, s := range query.Sort {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString(s.Field)
+			if s.Descending {
+				buf.WriteString(" DESC")
+			} else {
+				buf.WriteString(" ASC")
+			}
+		}
+	}
+
+	// pagination
+	if query.Offset > 0 || query.Limit > 0 {
+		switch dialect.Family {
+		case FamilyOracle, FamilyMsSQL:
+			if len(query.Sort) == 0 && dialect.Family == FamilyMsSQL {
+				buf.WriteString(" ORDER BY (SELECT NULL)") // OFFSET requires ORDER BY
+			}
+			buf.WriteString(fmt.Sprintf(" OFFSET %d ROWS", query.Offset))
+			if query.Limit > 0 {
+				buf.WriteString(fmt.Sprintf(" FETCH NEXT %d ROWS ONLY", query.Limit))
+			}
+		case FamilyPostgres:
+			if query.Limit > 0 {
+				buf.WriteString(fmt.Sprintf(" LIMIT %d", query.Limit))
+			}
+			buf.WriteString(fmt.Sprintf(" OFFSET %d", query.Offset))
+		default:
+			// mysql and sqlite do not accept OFFSET without LIMIT
+			if query.Limit > 0 {
+				buf.WriteString(fmt.Sprintf(" LIMIT %d", query.Limit))
+			} else if dialect.Family == FamilySQLite {
+				buf.WriteString(" LIMIT -1")
+			} else {
+				buf.WriteString(" LIMIT 18446744073709551615")
+			}
+			buf.WriteString(fmt.Sprintf(" OFFSET %d", query.Offset))
+		}
+	}
+
+	return buf.String(), builder.args, nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p r i v a t e
+//----------------------------------------------------------------------------------------------------------------------
+
+// checkColumns rejects nested fields, that the other drivers read from sub-documents
+func checkColumns(query *dbalcommons.Query) error {
+	columns := append([]string{}, query.Fields...)
+	for _, s := range query.Sort {
+		columns = append(columns, s.Field)
+	}
+	columns = append(columns, groupColumns(query.Filter)...)
+	for _, column := range columns {
+		if strings.Contains(column, ".") {
+			return qbc.Errors.Prefix(NestedFieldError, fmt.Sprintf("'%s': ", column))
+		}
+	}
+	return nil
+}
+
+func groupColumns(group *dbalcommons.QueryGroup) []string {
+	response := make([]string, 0)
+	if nil != group {
+		for _, condition := range group.Conditions {
+			if nil != condition {
+				response = append(response, condition.Field)
+			}
+		}
+		for _, child := range group.Groups {
+			response = append(response, groupColumns(child)...)
+		}
+	}
+	return response
+}
+
+type queryBuilder struct {
+	dialect *Dialect
+	args    []interface{}
+}
+
+func (instance *queryBuilder) param(value interface{}) string {
+	instance.args = append(instance.args, ToArg(value))
+	return instance.dialect.Param(len(instance.args))
+}
+
+func (instance *queryBuilder) group(group *dbalcommons.QueryGroup) string {
+	items := make([]string, 0)
+	for _, condition := range group.Conditions {
+		items = append(items, instance.condition(condition))
+	}
+	for _, child := range group.Groups {
+		if !child.IsEmpty() {
+			items = append(items, instance.group(child))
+		}
+	}
+	operator := " AND "
+	if group.IsOr() {
+		operator = " OR "
+	}
+	return "(" + strings.Join(items, operator) + ")"
+}
+
+func (instance *queryBuilder) condition(condition *dbalcommons.QueryCondition) string {
+	field := condition.Field
+	switch strings.ToLower(condition.Comparator) {
+	case dbalcommons.QueryEqual:
+		if nil == condition.Value {
+			return field + " IS NULL"
+		}
+		return field + " = " + instance.param(condition.Value)
+	case dbalcommons.QueryNotEqual:
+		if nil == condition.Value {
+			return field + " IS NOT NULL"
+		}
+		// NULL is different from any value, as in all other drivers
+		return "(" + field + " <> " + instance.param(condition.Value) + " OR " + field + " IS NULL)"
+	case dbalcommons.QueryLower:
+		return field + " < " + instance.param(condition.Value)
+	case dbalcommons.QueryLowerEqual:
+		return field + " <= " + instance.param(condition.Value)
+	case dbalcommons.QueryGreater:
+		return field + " > " + instance.param(condition.Value)
+	case dbalcommons.QueryGreaterEqual:
+		return field + " >= " + instance.param(condition.Value)
+	case dbalcommons.QueryLike:
+		return field + " LIKE " + instance.param(condition.Value)
+	case dbalcommons.QueryExists:
+		if condition.ExistsValue() {
+			return field + " IS NOT NULL"
+		}
+		return field + " IS NULL"
+	case dbalcommons.QueryIn:
+		values := condition.ValueList()
+		if len(values) == 0 {
+			return "1 = 0"
+		}
+		params := make([]string, 0, len(values))
+		for _, value := range values {
+			params = append(params, instance.param(value))
+		}
+		return field + " IN (" + strings.Join(params, ", ") + ")"
+	}
+	return "1 = 0" // never reached: query is validated
+}
@@ -109,6 +109,14 @@ func (instance *DriverArango) Close() error {
 	return nil
 }
 
+// SetPrimaryKey does nothing: documents are always identified by "_key"
+func (instance *DriverArango) SetPrimaryKey(collection, keyName string) {
+}
+
+func (instance *DriverArango) GetPrimaryKey(collection string) string {
+	return ArangoConst.KeyFieldName
+}
+
 func (instance *DriverArango) Remove(collection, key string) error {
 	if nil != instance && nil != instance.db {
 		coll, err := instance.Collection(collection, true)
@@ -260,6 +268,42 @@ func (instance *DriverArango) ExecMultiple(commands []string, bindVars []map[str
 	return nil, dbalcommons.ErrorDatabaseDoesNotExists
 }
 
+// Begin starts an ArangoDB stream transaction (ArangoDB 3.5 or later).
+// Options are required and must declare all collections used into the transaction.
+func (instance *DriverArango) Begin(options *dbalcommons.TransactionOptions) (ITransaction, error) {
+	if nil != instance && nil != instance.db {
+		if nil == options {
+			return nil, dbalcommons.ErrorMissingTransactionOptions
+		}
+		if !options.HasCollections() {
+			return nil, dbalcommons.ErrorMissingTransactionCollections
+		}
+		if instance.version.Version.CompareTo("3.5") < 0 {
+			return nil, fmt.Errorf("ArangoDB %v: %w", instance.version.Version, dbalcommons.ErrorTransactionNotSupported)
+		}
+		// stream transactions cannot create collections
+		for _, names := range [][]string{options.Read, options.Write, options.Exclusive} {
+			for _, name := range names {
+				if _, err := instance.collection(name, true); nil != err {
+					return nil, err
+				}
+			}
+		}
+		ctx := context.Background()
+		cols := driver.TransactionCollections{Read: options.Read, Write: options.Write, Exclusive: options.Exclusive}
+		id, err := instance.db.BeginTransaction(ctx, cols, &driver.BeginTransactionOptions{LockTimeout: options.LockTimeout})
+		if nil != err {
+			return nil, err
+		}
+		return &arangoTransaction{db: instance, id: id, ctx: driver.WithTransactionID(ctx, id)}, nil
+	}
+	return nil, dbalcommons.ErrorDatabaseDoesNotExists
+}
+
+func (instance *DriverArango) WithTransaction(options *dbalcommons.TransactionOptions, callback TransactionCallback) error {
+	return WithTransaction(instance, options, callback)
+}
+
 func (instance *DriverArango) EnsureIndex(collection string, typeName string, fields []string, unique bool) (bool, error) {
 	if nil != instance && nil != instance.db {
 		coll, err := instance.Collection(collection, true)
@@ -300,6 +344,38 @@ func (instance *DriverArango) Find(collection string, fieldName string, fieldVal
 	return nil, dbalcommons.ErrorDatabaseDoesNotExists
 }
 
+// Query runs a driver-neutral query translated into AQL
+func (instance *DriverArango) Query(collection string, query *dbalcommons.Query) ([]map[string]interface{}, error) {
+	if nil != instance && nil != instance.db {
+		command, bindVars, err := BuildAQLQuery(collection, query)
+		if nil != err {
+			return nil, err
+		}
+		ctx := context.Background()
+		cursor, err := instance.db.Query(ctx, command, bindVars)
+		if nil != err {
+			return nil, err
+		}
+		defer cursor.Close()
+
+		response := make([]map[string]interface{}, 0)
+		for {
+			var doc map[string]interface{}
+			_, err := cursor.ReadDocument(ctx, &doc)
+			if driver.IsNoMoreDocuments(err) {
+				break
+			} else if nil != err {
+				return nil, err
+			}
+			if nil != doc {
+				response = append(response, doc)
+			}
+		}
+		return response, nil
+	}
+	return nil, dbalcommons.ErrorDatabaseDoesNotExists
+}
+
 func (instance *DriverArango) QueryGetParamNames(query string) []string {
 	return QueryGetParamNames(query)
 }
@@ -633,6 +709,93 @@ func (instance *DriverArango) exec(ctx context.Context, command string, bindVars
 	return response, nil
 }
 
+//----------------------------------------------------------------------------------------------------------------------
+//	arangoTransaction
+//----------------------------------------------------------------------------------------------------------------------
+
+// arangoTransaction runs AQL commands into a stream transaction
+type arangoTransaction struct {
+	db     *DriverArango
+	id     driver.TransactionID
+	ctx    context.Context // carries the transaction id
+	closed bool
+}
+
+func (instance *arangoTransaction) Upsert(collection string, doc map[string]interface{}) (map[string]interface{}, error) {
+	if instance.closed {
+		return nil, dbalcommons.ErrorTransactionClosed
+	}
+	bindVars := map[string]interface{}{
+		"@collection": collection,
+		"doc":         doc,
+	}
+	query := "INSERT @doc INTO @@collection RETURN NEW"
+	if key := qbc.Reflect.GetString(doc, ArangoConst.KeyFieldName); len(key) > 0 {
+		bindVars["key"] = key
+		query = "UPSERT { _key: @key } INSERT @doc UPDATE @doc IN @@collection RETURN NEW"
+	}
+	return instance.first(query, bindVars)
+}
+
+func (instance *arangoTransaction) Remove(collection string, key string) error {
+	if instance.closed {
+		return dbalcommons.ErrorTransactionClosed
+	}
+	bindVars := map[string]interface{}{
+		"@collection": collection,
+		"key":         key,
+	}
+	_, err := instance.db.exec(instance.ctx, "REMOVE @key IN @@collection", bindVars)
+	return err
+}
+
+func (instance *arangoTransaction) Get(collection string, key string) (map[string]interface{}, error) {
+	if instance.closed {
+		return nil, dbalcommons.ErrorTransactionClosed
+	}
+	bindVars := map[string]interface{}{
+		"@collection": collection,
+		"key":         key,
+	}
+	return instance.first("FOR doc IN @@collection FILTER doc.`_key`==@key LIMIT 1 RETURN doc", bindVars)
+}
+
+func (instance *arangoTransaction) ExecNative(command string, bindVars map[string]interface{}) (interface{}, error) {
+	if instance.closed {
+		return nil, dbalcommons.ErrorTransactionClosed
+	}
+	return instance.db.exec(instance.ctx, command, bindVars)
+}
+
+func (instance *arangoTransaction) Commit() error {
+	if instance.closed {
+		return dbalcommons.ErrorTransactionClosed
+	}
+	instance.closed = true
+	return instance.db.db.CommitTransaction(context.Background(), instance.id, nil)
+}
+
+func (instance *arangoTransaction) Rollback() error {
+	if instance.closed {
+		return dbalcommons.ErrorTransactionClosed
+	}
+	instance.closed = true
+	return instance.db.db.AbortTransaction(context.Background(), instance.id, nil)
+}
+
+func (instance *arangoTransaction) first(query string, bindVars map[string]interface{}) (map[string]interface{}, error) {
+	data, err := instance.db.exec(instance.ctx, query, bindVars)
+	if nil != err {
+		return nil, err
+	}
+	if items, b := data.([]interface{}); b && len(items) > 0 {
+		if doc, b := items[0].(map[string]interface{}); b {
+			return doc, nil
+		}
+	}
+	return nil, nil
+}
+
 //----------------------------------------------------------------------------------------------------------------------
 //	DriverArangoCollection
 //----------------------------------------------------------------------------------------------------------------------
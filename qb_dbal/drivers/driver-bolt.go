@@ -61,7 +61,7 @@ func (instance *DriverBolt) Enabled() bool {
 
 func (instance *DriverBolt) Open() error {
 	if nil != instance {
-		if nil == instance.err {
+		if nil == instance.err && nil == instance.db { // already open: bbolt locks the file
 			filename := qbc.Paths.Absolute(instance.dsn.Database)
 			err := qbc.Paths.Mkdir(filename)
 			if nil != err {
@@ -85,6 +85,14 @@ func (instance *DriverBolt) Close() error {
 	return nil
 }
 
+// SetPrimaryKey does nothing: documents are always identified by "_key"
+func (instance *DriverBolt) SetPrimaryKey(collection, keyName string) {
+}
+
+func (instance *DriverBolt) GetPrimaryKey(collection string) string {
+	return KeyFieldName
+}
+
 func (instance *DriverBolt) Remove(collection string, key string) error {
 	if nil != instance && nil != instance.db {
 		return instance.remove(collection, key)
@@ -137,8 +145,8 @@ func (instance *DriverBolt) ForEach(collection string, callback ForEachCallback)
 			if nil != err {
 				return err
 			}
-			var doc map[string]interface{}
 			err = coll.ForEach(func(k, v []byte) bool {
+				var doc map[string]interface{}
 				e := json.Unmarshal(v, &doc)
 				if nil != e {
 					err = e
@@ -170,9 +178,31 @@ func (instance *DriverBolt) ExecMultiple(commands []string, bindVars []map[strin
 	return nil, dbalcommons.ErrorDatabaseDoesNotExists
 }
 
+// Begin starts a bbolt read-write transaction. Options are ignored: Bolt locks the whole database.
+// Until Commit or Rollback any other write on the database blocks.
+func (instance *DriverBolt) Begin(options *dbalcommons.TransactionOptions) (ITransaction, error) {
+	if nil != instance && nil != instance.db {
+		tx, err := instance.db.Begin()
+		if nil != err {
+			return nil, err
+		}
+		return &boltTransaction{tx: tx}, nil
+	}
+	return nil, dbalcommons.ErrorDatabaseDoesNotExists
+}
+
+func (instance *DriverBolt) WithTransaction(options *dbalcommons.TransactionOptions, callback TransactionCallback) error {
+	return WithTransaction(instance, options, callback)
+}
+
+// EnsureIndex creates a persistent secondary index. typeName is ignored: Bolt indexes are always sorted
 func (instance *DriverBolt) EnsureIndex(collection string, typeName string, fields []string, unique bool) (bool, error) {
 	if nil != instance && nil != instance.db {
-		_, err := instance.db.Collection(collection, true)
+		coll, err := instance.db.Collection(collection, true)
+		if nil != err {
+			return false, err
+		}
+		_, err = coll.EnsureIndex(fields, unique)
 		if nil != err {
 			return false, err
 		}
@@ -203,6 +233,14 @@ func (instance *DriverBolt) Find(collection string, fieldName string, fieldValue
 	return nil, dbalcommons.ErrorDatabaseDoesNotExists
 }
 
+// Query runs a driver-neutral query scanning the collection in memory
+func (instance *DriverBolt) Query(collection string, query *dbalcommons.Query) ([]map[string]interface{}, error) {
+	if nil != instance && nil != instance.db {
+		return queryForEach(instance, collection, query)
+	}
+	return nil, dbalcommons.ErrorDatabaseDoesNotExists
+}
+
 func (instance *DriverBolt) QueryGetParamNames(query string) []string {
 	return QueryGetParamNames(query)
 }
@@ -225,3 +263,67 @@ func (instance *DriverBolt) remove(collectionName string, key string) (err error
 	}
 	return err
 }
+
+//----------------------------------------------------------------------------------------------------------------------
+//	boltTransaction
+//----------------------------------------------------------------------------------------------------------------------
+
+type boltTransaction struct {
+	tx     *bolt.BoltTransaction
+	closed bool
+}
+
+func (instance *boltTransaction) Upsert(collection string, doc map[string]interface{}) (map[string]interface{}, error) {
+	if instance.closed {
+		return nil, dbalcommons.ErrorTransactionClosed
+	}
+	if _, b := doc["_key"]; !b {
+		doc["_key"] = qbc.Rnd.Uuid()
+	}
+	err := instance.tx.Upsert(collection, doc)
+	if nil != err {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func (instance *boltTransaction) Remove(collection string, key string) error {
+	if instance.closed {
+		return dbalcommons.ErrorTransactionClosed
+	}
+	return instance.tx.Remove(collection, key)
+}
+
+func (instance *boltTransaction) Get(collection string, key string) (map[string]interface{}, error) {
+	if instance.closed {
+		return nil, dbalcommons.ErrorTransactionClosed
+	}
+	item, err := instance.tx.Get(collection, key)
+	if nil != err {
+		return nil, err
+	}
+	if v, b := item.(map[string]interface{}); b {
+		return v, nil
+	}
+	return nil, nil
+}
+
+func (instance *boltTransaction) ExecNative(command string, bindingVars map[string]interface{}) (interface{}, error) {
+	return nil, dbalcommons.ErrorCommandNotSupported
+}
+
+func (instance *boltTransaction) Commit() error {
+	if instance.closed {
+		return dbalcommons.ErrorTransactionClosed
+	}
+	instance.closed = true
+	return instance.tx.Commit()
+}
+
+func (instance *boltTransaction) Rollback() error {
+	if instance.closed {
+		return dbalcommons.ErrorTransactionClosed
+	}
+	instance.closed = true
+	return instance.tx.Rollback()
+}
@@ -2,6 +2,7 @@ package drivers
 
 import (
 	"fmt"
+	"sort"
 
 	qbc "github.com/rskvp/qb-core"
 	dbalcommons "github.com/rskvp/qb-lib/qb_dbal/commons"
@@ -12,6 +13,7 @@ import (
 	"gorm.io/driver/sqlite"
 	"gorm.io/driver/sqlserver"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 //----------------------------------------------------------------------------------------------------------------------
@@ -23,12 +25,14 @@ import (
 //----------------------------------------------------------------------------------------------------------------------
 
 type DriverGorm struct {
-	uid    string
-	driver string
-	dsn    string
-	db     *gorm.DB
-	err    error
-	mode   string
+	uid     string
+	driver  string
+	dsn     string
+	db      *gorm.DB
+	dialect *dbsql.Dialect // gorm rewrites "?" into the placeholders of the underlying database
+	keys    *primaryKeys
+	err     error
+	mode    string
 }
 
 //----------------------------------------------------------------------------------------------------------------------
@@ -40,6 +44,9 @@ func NewDriverGorm(driver string, dsn ...interface{}) *DriverGorm {
 	instance := new(DriverGorm)
 	instance.driver = driver
 	instance.mode = qbc.ModeProduction
+	instance.dialect = dbsql.NewDialect(driver)
+	instance.dialect.Placeholder = dbsql.PlaceholderQuestion
+	instance.keys = new(primaryKeys)
 
 	if len(dsn) == 1 {
 		if s, b := dsn[0].(string); b {
@@ -103,10 +110,22 @@ func (instance *DriverGorm) Close() error {
 	return nil
 }
 
+// SetPrimaryKey declares the key field of a collection (default is "id").
+func (instance *DriverGorm) SetPrimaryKey(collection, keyName string) {
+	instance.keys.Set(collection, keyName)
+}
+
+func (instance *DriverGorm) GetPrimaryKey(collection string) string {
+	return instance.keys.Get(collection)
+}
+
 func (instance *DriverGorm) Remove(collection, key string) (err error) {
 	if nil != instance && nil != instance.db {
-		query := dbsql.BuildDeleteCommand(collection, "id="+key)
-		tx := instance.db.Exec(query)
+		query, args, buildErr := dbsql.BuildDeleteByKeyCommand(instance.dialect, collection, instance.keys.Get(collection), key)
+		if nil != buildErr {
+			return buildErr
+		}
+		tx := instance.db.Exec(query, args...)
 		if nil != tx.Error && !IsRecordNotFoundError(tx.Error) {
 			err = tx.Error
 		}
@@ -118,10 +137,11 @@ func (instance *DriverGorm) Remove(collection, key string) (err error) {
 
 func (instance *DriverGorm) Get(collection string, key string) (response map[string]interface{}, err error) {
 	if nil != instance && nil != instance.db && len(key) > 0 && len(collection) > 0 {
-		query := dbsql.BuildSelect(collection) +
-			fmt.Sprintf(" WHERE t.id=%v", key)
-
-		tx := instance.db.Raw(query).First(&response)
+		query, args, buildErr := dbsql.BuildSelectByKeyCommand(instance.dialect, collection, instance.keys.Get(collection), key)
+		if nil != buildErr {
+			return nil, buildErr
+		}
+		tx := instance.db.Raw(query, args...).First(&response)
 		if nil != tx.Error && !IsRecordNotFoundError(tx.Error) {
 			err = tx.Error
 		}
@@ -133,19 +153,74 @@ func (instance *DriverGorm) Get(collection string, key string) (response map[str
 
 func (instance *DriverGorm) Upsert(collection string, item map[string]interface{}) (map[string]interface{}, error) {
 	if nil != instance && nil != instance.db && len(collection) > 0 {
-		id := qbc.Convert.ToString(item["id"])
+		keyName := instance.keys.Get(collection)
+		keyValue := item[keyName]
 		var tx *gorm.DB
-		if ok, _ := instance.Exists(collection, id); ok {
-			query := dbsql.BuildUpdateCommand(collection, "id", id, item)
-			tx = instance.db.Exec(query)
+		if nil == keyValue {
+			// insert and read back the generated key
+			values := map[string]interface{}{}
+			for k, v := range item {
+				if k != keyName {
+					values[k] = v
+				}
+			}
+			query, args, err := dbsql.BuildInsertReturningCommand(instance.dialect, collection, keyName, values)
+			if nil != err {
+				return nil, err
+			}
+			if instance.dialect.Family == dbsql.FamilyMySQL || instance.dialect.Family == dbsql.FamilyGeneric {
+				tx = instance.db.Exec(query, args...)
+			} else {
+				var row map[string]interface{}
+				tx = instance.db.Raw(query, args...).Scan(&row)
+				if nil != row {
+					keyValue = row[keyName]
+				}
+			}
 		} else {
-			query := dbsql.BuildInsertCommands(collection, []interface{}{item})[0]
-			tx = instance.db.Exec(query)
+			// a single statement: concurrent upserts of the same key do not fail
+			values, onConflict, err := upsertClause(collection, keyName, item)
+			if nil != err {
+				return nil, err
+			}
+			tx = instance.db.Table(collection).Clauses(onConflict).Create(values)
 		}
 
 		if nil != tx.Error && !IsRecordNotFoundError(tx.Error) {
 			return nil, tx.Error
 		}
+		if nil != keyValue {
+			if ok, stored := instance.Exists(collection, qbc.Convert.ToString(keyValue)); ok {
+				if m, b := stored.(map[string]interface{}); b {
+					return m, nil
+				}
+			}
+		}
+		return item, nil
+	}
+	return nil, dbalcommons.ErrorDatabaseDoesNotExists
+}
+
+// Insert adds a new row. Unlike Upsert it fails if a row with the same key already exists.
+func (instance *DriverGorm) Insert(collection string, item map[string]interface{}) (map[string]interface{}, error) {
+	if nil != instance && nil != instance.db && len(collection) > 0 {
+		keyName := instance.keys.Get(collection)
+		keyValue := item[keyName]
+		if nil == keyValue {
+			return instance.Upsert(collection, item) // a new key is always generated
+		}
+		query, args, err := dbsql.BuildInsertCommand(instance.dialect, collection, item)
+		if nil != err {
+			return nil, err
+		}
+		if tx := instance.db.Exec(query, args...); nil != tx.Error {
+			return nil, tx.Error
+		}
+		if ok, stored := instance.Exists(collection, qbc.Convert.ToString(keyValue)); ok {
+			if m, b := stored.(map[string]interface{}); b {
+				return m, nil
+			}
+		}
 		return item, nil
 	}
 	return nil, dbalcommons.ErrorDatabaseDoesNotExists
@@ -188,8 +263,9 @@ func (instance *DriverGorm) ExecNative(command string, bindVars map[string]inter
 			}
 			return nil, tx.Error
 		}
+		return result, nil
 	}
-	return result, dbalcommons.ErrorDatabaseDoesNotExists
+	return nil, dbalcommons.ErrorDatabaseDoesNotExists
 }
 
 func (instance *DriverGorm) ExecMultiple(commands []string, bindVars []map[string]interface{}, options interface{}) (response []interface{}, err error) {
@@ -212,6 +288,28 @@ func (instance *DriverGorm) ExecMultiple(commands []string, bindVars []map[strin
 	return nil, dbalcommons.ErrorDatabaseDoesNotExists
 }
 
+// Begin starts a gorm transaction. Options are ignored: SQL transactions do not declare collections.
+func (instance *DriverGorm) Begin(options *dbalcommons.TransactionOptions) (ITransaction, error) {
+	if nil != instance && nil != instance.db {
+		tx := instance.db.Begin()
+		if nil != tx.Error {
+			return nil, tx.Error
+		}
+		bound := *instance
+		bound.db = tx
+		return newDriverTransaction(&bound, func() error {
+			return tx.Commit().Error
+		}, func() error {
+			return tx.Rollback().Error
+		}), nil
+	}
+	return nil, dbalcommons.ErrorDatabaseDoesNotExists
+}
+
+func (instance *DriverGorm) WithTransaction(options *dbalcommons.TransactionOptions, callback TransactionCallback) error {
+	return WithTransaction(instance, options, callback)
+}
+
 func (instance *DriverGorm) EnsureIndex(collection string, typeName string, fields []string, unique bool) (bool, error) {
 	if nil != instance && nil != instance.db {
 
@@ -235,6 +333,23 @@ func (instance *DriverGorm) Find(collection string, fieldName string, fieldValue
 	return nil, dbalcommons.ErrorDatabaseDoesNotExists
 }
 
+// Query runs a driver-neutral query translated into SQL
+func (instance *DriverGorm) Query(collection string, query *dbalcommons.Query) ([]map[string]interface{}, error) {
+	if nil != instance && nil != instance.db {
+		command, args, err := dbsql.BuildQueryCommand(instance.dialect, collection, query)
+		if nil != err {
+			return nil, err
+		}
+		response := make([]map[string]interface{}, 0)
+		tx := instance.db.Raw(command, args...).Scan(&response)
+		if nil != tx.Error && !IsRecordNotFoundError(tx.Error) {
+			return nil, tx.Error
+		}
+		return response, nil
+	}
+	return nil, dbalcommons.ErrorDatabaseDoesNotExists
+}
+
 func (instance *DriverGorm) QueryGetParamNames(query string) []string {
 	return QueryGetParamNames(query)
 }
@@ -283,3 +398,26 @@ func (instance *DriverGorm) init() (err error) {
 	}
 	return
 }
+
+// upsertClause returns the values to insert and the update of the existing row with the same key
+func upsertClause(collection, keyName string, item map[string]interface{}) (map[string]interface{}, clause.OnConflict, error) {
+	onConflict := clause.OnConflict{Columns: []clause.Column{{Name: keyName}}}
+	values := make(map[string]interface{}, len(item))
+	updates := make([]string, 0, len(item))
+	for name, value := range item {
+		values[name] = dbsql.ToArg(value)
+		if name != keyName {
+			updates = append(updates, name)
+		}
+	}
+	sort.Strings(updates)
+	if err := dbsql.CheckIdentifiers(append([]string{collection, keyName}, updates...)...); nil != err {
+		return nil, onConflict, err
+	}
+	if len(updates) > 0 {
+		onConflict.DoUpdates = clause.AssignmentColumns(updates)
+	} else {
+		onConflict.DoNothing = true
+	}
+	return values, onConflict, nil
+}
@@ -1,8 +1,6 @@
 package drivers
 
 import (
-	"fmt"
-
 	dbalcommons "github.com/rskvp/qb-lib/qb_dbal/commons"
 	"github.com/rskvp/qb-lib/qb_dbal/drivers/dbsql"
 )
@@ -21,11 +19,13 @@ const NameOracle = "oracle"
 //----------------------------------------------------------------------------------------------------------------------
 
 type DriverSQL struct {
-	uid    string
-	driver string
-	dsn    *dbalcommons.Dsn
-	db     *dbsql.Database
-	err    error
+	uid     string
+	driver  string
+	dsn     *dbalcommons.Dsn
+	db      *dbsql.Database
+	dialect *dbsql.Dialect
+	keys    *primaryKeys
+	err     error
 }
 
 //----------------------------------------------------------------------------------------------------------------------
@@ -36,6 +36,8 @@ type DriverSQL struct {
 func NewDriverSQL(driver string, dsn ...interface{}) *DriverSQL {
 	instance := new(DriverSQL)
 	instance.driver = driver
+	instance.dialect = dbsql.NewDialect(driver)
+	instance.keys = new(primaryKeys)
 
 	if len(dsn) == 1 {
 		if s, b := dsn[0].(string); b {
@@ -90,10 +92,22 @@ func (instance *DriverSQL) Close() error {
 	return nil
 }
 
+// SetPrimaryKey declares the key field of a collection (default is "id").
+func (instance *DriverSQL) SetPrimaryKey(collection, keyName string) {
+	instance.keys.Set(collection, keyName)
+}
+
+func (instance *DriverSQL) GetPrimaryKey(collection string) string {
+	return instance.keys.Get(collection)
+}
+
 func (instance *DriverSQL) Remove(collection, key string) error {
 	if nil != instance && nil != instance.db {
-		query := dbsql.BuildDeleteCommand(collection, "id="+key)
-		response := instance.db.Exec(query)
+		query, args, err := dbsql.BuildDeleteByKeyCommand(instance.dialect, collection, instance.keys.Get(collection), key)
+		if nil != err {
+			return err
+		}
+		response := instance.db.Exec(query, args...)
 		return response.GetError()
 	}
 	return dbalcommons.ErrorDatabaseDoesNotExists
@@ -101,12 +115,15 @@ func (instance *DriverSQL) Remove(collection, key string) error {
 
 func (instance *DriverSQL) Get(collection string, key string) (map[string]interface{}, error) {
 	if nil != instance && nil != instance.db {
-		query := dbsql.BuildSelect(collection) +
-			fmt.Sprintf(" WHERE t.id=%v", key)
-		rows := instance.db.Query(query)
+		query, args, err := dbsql.BuildSelectByKeyCommand(instance.dialect, collection, instance.keys.Get(collection), key)
+		if nil != err {
+			return nil, err
+		}
+		rows := instance.db.Query(query, args...)
 		if rows.HasError() {
 			return nil, rows.GetError()
 		}
+		defer rows.Close()
 		return rows.First()
 	}
 	return nil, dbalcommons.ErrorDatabaseDoesNotExists
@@ -114,13 +131,15 @@ func (instance *DriverSQL) Get(collection string, key string) (map[string]interf
 
 func (instance *DriverSQL) Upsert(collection string, item map[string]interface{}) (map[string]interface{}, error) {
 	if nil != instance && nil != instance.db {
-		id := fmt.Sprintf("%v", item["id"])
-		query := dbsql.BuildUpdateCommand(collection, "id", id, item)
-		response := instance.db.Exec(query)
-		if response.HasError() {
-			return nil, response.GetError()
-		}
-		return item, nil
+		return instance.db.Upsert(instance.dialect, collection, instance.keys.Get(collection), item)
+	}
+	return nil, dbalcommons.ErrorDatabaseDoesNotExists
+}
+
+// Insert adds a new row. Unlike Upsert it fails if a row with the same key already exists.
+func (instance *DriverSQL) Insert(collection string, item map[string]interface{}) (map[string]interface{}, error) {
+	if nil != instance && nil != instance.db {
+		return instance.db.Insert(instance.dialect, collection, instance.keys.Get(collection), item)
 	}
 	return nil, dbalcommons.ErrorDatabaseDoesNotExists
 }
@@ -144,6 +163,7 @@ func (instance *DriverSQL) ExecNative(command string, bindVars map[string]interf
 		if result.HasError() {
 			return nil, result.GetError()
 		}
+		defer result.Close()
 		return result.All()
 	}
 	return nil, dbalcommons.ErrorDatabaseDoesNotExists
@@ -169,6 +189,24 @@ func (instance *DriverSQL) ExecMultiple(commands []string, bindVars []map[string
 	return nil, dbalcommons.ErrorDatabaseDoesNotExists
 }
 
+// Begin starts a database/sql transaction. Options are ignored: SQL transactions do not declare collections.
+func (instance *DriverSQL) Begin(options *dbalcommons.TransactionOptions) (ITransaction, error) {
+	if nil != instance && nil != instance.db {
+		db, err := instance.db.Begin()
+		if nil != err {
+			return nil, err
+		}
+		bound := *instance
+		bound.db = db
+		return newDriverTransaction(&bound, db.Commit, db.Rollback), nil
+	}
+	return nil, dbalcommons.ErrorDatabaseDoesNotExists
+}
+
+func (instance *DriverSQL) WithTransaction(options *dbalcommons.TransactionOptions, callback TransactionCallback) error {
+	return WithTransaction(instance, options, callback)
+}
+
 func (instance *DriverSQL) EnsureIndex(collection string, typeName string, fields []string, unique bool) (bool, error) {
 	if nil != instance && nil != instance.db {
 
@@ -192,6 +230,23 @@ func (instance *DriverSQL) Find(collection string, fieldName string, fieldValue
 	return nil, dbalcommons.ErrorDatabaseDoesNotExists
 }
 
+// Query runs a driver-neutral query translated into SQL
+func (instance *DriverSQL) Query(collection string, query *dbalcommons.Query) ([]map[string]interface{}, error) {
+	if nil != instance && nil != instance.db {
+		command, args, err := dbsql.BuildQueryCommand(instance.dialect, collection, query)
+		if nil != err {
+			return nil, err
+		}
+		rows := instance.db.Query(command, args...)
+		if rows.HasError() {
+			return nil, rows.GetError()
+		}
+		defer rows.Close()
+		return rows.All()
+	}
+	return nil, dbalcommons.ErrorDatabaseDoesNotExists
+}
+
 func (instance *DriverSQL) QueryGetParamNames(query string) []string {
 	return QueryGetParamNames(query)
 }
@@ -19,6 +19,12 @@ type IDatabase interface {
 	Get(collection string, key string) (map[string]interface{}, error)
 	ForEach(collection string, callback ForEachCallback) error
 	Find(collection string, fieldName string, fieldValue interface{}) (interface{}, error)
+	Query(collection string, query *dbalcommons.Query) ([]map[string]interface{}, error)
+
+	// key field of the documents: "_key" for document databases (not changed by SetPrimaryKey),
+	// "id" or the field declared with SetPrimaryKey for SQL tables
+	SetPrimaryKey(collection string, keyName string)
+	GetPrimaryKey(collection string) string
 
 	// optional methods. May be not supported from all databases
 	EnsureIndex(collection string, typeName string, fields []string, unique bool) (bool, error)
@@ -28,6 +34,10 @@ type IDatabase interface {
 	ExecNative(command string, bindingVars map[string]interface{}) (interface{}, error)
 	ExecMultiple(commands []string, bindVars []map[string]interface{}, options interface{}) ([]interface{}, error)
 
+	// transactions. Return ErrorTransactionNotSupported if the database cannot run them
+	Begin(options *dbalcommons.TransactionOptions) (ITransaction, error)
+	WithTransaction(options *dbalcommons.TransactionOptions, callback TransactionCallback) error
+
 	// utils
 	QueryGetParamNames(query string) []string
 	QuerySelectParams(query string, allParams map[string]interface{}) map[string]interface{}
@@ -0,0 +1,131 @@
+package drivers
+
+import (
+	"fmt"
+	"strings"
+
+	dbalcommons "github.com/rskvp/qb-lib/qb_dbal/commons"
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//	A Q L
+//----------------------------------------------------------------------------------------------------------------------
+
+// BuildAQLQuery translates a driver-neutral query into AQL with bind variables.
+func BuildAQLQuery(collection string, query *dbalcommons.Query) (string, map[string]interface{}, error) {
+	if nil == query {
+		query = dbalcommons.NewQuery()
+	}
+	if err := query.Validate(); nil != err {
+		return "", nil, err
+	}
+	builder := &aqlBuilder{bindVars: map[string]interface{}{"@collection": collection}}
+
+	var buf strings.Builder
+	buf.WriteString("FOR doc IN @@collection")
+	if query.HasFilter() {
+		buf.WriteString(" FILTER ")
+		buf.WriteString(builder.group(query.Filter))
+	}
+	if len(query.Sort) > 0 {
+		buf.WriteString(" SORT ")
+		for i, s := range query.Sort {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString("doc." + s.Field)
+			if s.Descending {
+				buf.WriteString(" DESC")
+			} else {
+				buf.WriteString(" ASC")
+			}
+		}
+	}
+	if query.Offset > 0 || query.Limit > 0 {
+		limit := query.Limit
+		if limit == 0 {
+			limit = 9007199254740991 // max safe integer: AQL has no offset without count
+		}
+		buf.WriteString(fmt.Sprintf(" LIMIT %d, %d", query.Offset, limit))
+	}
+	if len(query.Fields) > 0 {
+		buf.WriteString(" RETURN KEEP(doc, " + builder.param(query.Fields) + ")")
+	} else {
+		buf.WriteString(" RETURN doc")
+	}
+	return buf.String(), builder.bindVars, nil
+}
+
+type aqlBuilder struct {
+	bindVars map[string]interface{}
+	count    int
+}
+
+func (instance *aqlBuilder) param(value interface{}) string {
+	name := fmt.Sprintf("p%d", instance.count)
+	instance.count++
+	instance.bindVars[name] = value
+	return "@" + name
+}
+
+func (instance *aqlBuilder) group(group *dbalcommons.QueryGroup) string {
+	items := make([]string, 0)
+	for _, condition := range group.Conditions {
+		items = append(items, instance.condition(condition))
+	}
+	for _, child := range group.Groups {
+		if !child.IsEmpty() {
+			items = append(items, instance.group(child))
+		}
+	}
+	operator := " && "
+	if group.IsOr() {
+		operator = " || "
+	}
+	return "(" + strings.Join(items, operator) + ")"
+}
+
+func (instance *aqlBuilder) condition(condition *dbalcommons.QueryCondition) string {
+	field := "doc." + condition.Field
+	switch strings.ToLower(condition.Comparator) {
+	case dbalcommons.QueryLike:
+		return "LIKE(" + field + ", " + instance.param(condition.Value) + ")"
+	case dbalcommons.QueryIn:
+		return field + " IN " + instance.param(condition.ValueList())
+	case dbalcommons.QueryExists:
+		if condition.ExistsValue() {
+			return field + " != null"
+		}
+		return field + " == null"
+	case dbalcommons.QueryLower, dbalcommons.QueryLowerEqual:
+		// in AQL null is lower than any value, in the other drivers null never matches a range
+		return "(" + field + " != null && " + field + " " + condition.Comparator + " " + instance.param(condition.Value) + ")"
+	default:
+		return field + " " + condition.Comparator + " " + instance.param(condition.Value)
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	i n   m e m o r y
+//----------------------------------------------------------------------------------------------------------------------
+
+// queryForEach runs a driver-neutral query scanning all documents of a collection
+func queryForEach(db IDatabase, collection string, query *dbalcommons.Query) ([]map[string]interface{}, error) {
+	if nil == query {
+		query = dbalcommons.NewQuery()
+	}
+	if err := query.Validate(); nil != err {
+		return nil, err
+	}
+	response := make([]map[string]interface{}, 0)
+	err := db.ForEach(collection, func(doc map[string]interface{}) bool {
+		if query.Match(doc) {
+			response = append(response, doc)
+		}
+		return false
+	})
+	if nil != err {
+		return nil, err
+	}
+	return query.Apply(response), nil
+}
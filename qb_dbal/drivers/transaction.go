@@ -0,0 +1,129 @@
+package drivers
+
+import (
+	"sync"
+
+	dbalcommons "github.com/rskvp/qb-lib/qb_dbal/commons"
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//	t y p e s
+//----------------------------------------------------------------------------------------------------------------------
+
+// ITransaction groups commands that are committed or rolled back together.
+// A transaction is not thread safe: use it from the goroutine that called Begin.
+type ITransaction interface {
+	Upsert(collection string, doc map[string]interface{}) (map[string]interface{}, error)
+	Remove(collection string, key string) error
+	Get(collection string, key string) (map[string]interface{}, error)
+	ExecNative(command string, bindingVars map[string]interface{}) (interface{}, error)
+
+	Commit() error
+	Rollback() error
+}
+
+// TransactionCallback runs into a transaction. Returning an error rolls back the transaction.
+type TransactionCallback func(tx ITransaction) error
+
+//----------------------------------------------------------------------------------------------------------------------
+//	S T A T I C
+//----------------------------------------------------------------------------------------------------------------------
+
+// WithTransaction begins a transaction on db and runs callback into it.
+// The transaction is committed if callback returns nil and rolled back if callback returns an error or panics:
+// the panic is propagated to the caller.
+func WithTransaction(db IDatabase, options *dbalcommons.TransactionOptions, callback TransactionCallback) (err error) {
+	if nil == db {
+		return dbalcommons.ErrorDatabaseDoesNotExists
+	}
+	tx, err := db.Begin(options)
+	if nil != err {
+		return err
+	}
+	defer func() {
+		if r := recover(); nil != r {
+			_ = tx.Rollback()
+			panic(r)
+		}
+	}()
+	if nil != callback {
+		if err = callback(tx); nil != err {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	d r i v e r T r a n s a c t i o n
+//----------------------------------------------------------------------------------------------------------------------
+
+// driverTransaction delegates commands to a copy of a driver bound to a native transaction (sql.Tx, gorm.DB)
+type driverTransaction struct {
+	db       IDatabase
+	commit   func() error
+	rollback func() error
+	closed   bool
+	mux      sync.Mutex
+}
+
+func newDriverTransaction(db IDatabase, commit, rollback func() error) *driverTransaction {
+	instance := new(driverTransaction)
+	instance.db = db
+	instance.commit = commit
+	instance.rollback = rollback
+	return instance
+}
+
+func (instance *driverTransaction) Upsert(collection string, doc map[string]interface{}) (map[string]interface{}, error) {
+	if instance.isClosed() {
+		return nil, dbalcommons.ErrorTransactionClosed
+	}
+	return instance.db.Upsert(collection, doc)
+}
+
+func (instance *driverTransaction) Remove(collection string, key string) error {
+	if instance.isClosed() {
+		return dbalcommons.ErrorTransactionClosed
+	}
+	return instance.db.Remove(collection, key)
+}
+
+func (instance *driverTransaction) Get(collection string, key string) (map[string]interface{}, error) {
+	if instance.isClosed() {
+		return nil, dbalcommons.ErrorTransactionClosed
+	}
+	return instance.db.Get(collection, key)
+}
+
+func (instance *driverTransaction) ExecNative(command string, bindingVars map[string]interface{}) (interface{}, error) {
+	if instance.isClosed() {
+		return nil, dbalcommons.ErrorTransactionClosed
+	}
+	return instance.db.ExecNative(command, bindingVars)
+}
+
+func (instance *driverTransaction) Commit() error {
+	return instance.close(instance.commit)
+}
+
+func (instance *driverTransaction) Rollback() error {
+	return instance.close(instance.rollback)
+}
+
+func (instance *driverTransaction) isClosed() bool {
+	instance.mux.Lock()
+	defer instance.mux.Unlock()
+	return instance.closed
+}
+
+func (instance *driverTransaction) close(end func() error) error {
+	instance.mux.Lock()
+	defer instance.mux.Unlock()
+	if instance.closed {
+		return dbalcommons.ErrorTransactionClosed
+	}
+	instance.closed = true
+	return end()
+}
@@ -2,6 +2,7 @@ package drivers
 
 import (
 	"strings"
+	"sync"
 
 	qbc "github.com/rskvp/qb-core"
 )
@@ -33,6 +34,37 @@ func QuerySelectParams(query string, allParams map[string]interface{}) map[strin
 	return params
 }
 
+// DefaultPrimaryKey is the key field of SQL tables when not declared with SetPrimaryKey
+const DefaultPrimaryKey = "id"
+
+// primaryKeys holds the key field declared for each collection (table)
+type primaryKeys struct {
+	names map[string]string
+	mux   sync.RWMutex
+}
+
+func (instance *primaryKeys) Set(collection, keyName string) {
+	instance.mux.Lock()
+	defer instance.mux.Unlock()
+	if nil == instance.names {
+		instance.names = make(map[string]string)
+	}
+	if len(keyName) > 0 {
+		instance.names[collection] = keyName
+	} else {
+		delete(instance.names, collection)
+	}
+}
+
+func (instance *primaryKeys) Get(collection string) string {
+	instance.mux.RLock()
+	defer instance.mux.RUnlock()
+	if name, b := instance.names[collection]; b {
+		return name
+	}
+	return DefaultPrimaryKey
+}
+
 func IsRecordNotFoundError(err error) bool {
 	return nil != err && err.Error() == "record not found"
 }
@@ -0,0 +1,223 @@
+package migrations
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rskvp/qb-lib/qb_dbal/drivers"
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//	e r r o r s
+//----------------------------------------------------------------------------------------------------------------------
+
+var (
+	ErrorInvalidMigration      = errors.New("invalid_migration")
+	ErrorDuplicateMigration    = errors.New("duplicate_migration")
+	ErrorMissingMigration      = errors.New("missing_migration")
+	ErrorIrreversibleMigration = errors.New("irreversible_migration")
+	ErrorMigrationsLocked      = errors.New("migrations_locked")
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//	t y p e s
+//----------------------------------------------------------------------------------------------------------------------
+
+// MigrationStep changes the schema (or data) of a database
+type MigrationStep func(db drivers.IDatabase) error
+
+// Migration is a versioned schema change. Down is optional: a migration without Down cannot be rolled back.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      MigrationStep
+	Down    MigrationStep
+}
+
+// jsonStep is a driver-neutral command of a ".json" migration file
+type jsonStep struct {
+	EnsureCollection string `json:"ensure_collection,omitempty"`
+	EnsureIndex      *struct {
+		Collection string   `json:"collection"`
+		Type       string   `json:"type"`
+		Fields     []string `json:"fields"`
+		Unique     bool     `json:"unique"`
+	} `json:"ensure_index,omitempty"`
+	Upsert *struct {
+		Collection string                 `json:"collection"`
+		Doc        map[string]interface{} `json:"doc"`
+	} `json:"upsert,omitempty"`
+	Remove *struct {
+		Collection string `json:"collection"`
+		Key        string `json:"key"`
+	} `json:"remove,omitempty"`
+	Exec *struct {
+		Command  string                 `json:"command"`
+		BindVars map[string]interface{} `json:"bind_vars"`
+	} `json:"exec,omitempty"`
+}
+
+// i.e. "0001_create_users.up.sql"
+var fileNamePattern = regexp.MustCompile(`^(\d+)_([^.]+)\.(up|down)\.(sql|aql|json)$`)
+
+//----------------------------------------------------------------------------------------------------------------------
+//	S T A T I C
+//----------------------------------------------------------------------------------------------------------------------
+
+// LoadDir reads migrations from a directory. See LoadFS for the file names.
+func LoadDir(dir string) ([]*Migration, error) {
+	return LoadFS(os.DirFS(dir), ".")
+}
+
+// LoadFS reads migrations from dir of an embedded or os file system.
+// Files are named "<version>_<name>.<up|down>.<ext>":
+// ".sql" and ".aql" files contain native statements separated by ";" at end of line and are run with ExecNative,
+// ".json" files contain an array of driver-neutral steps (ensure_collection, ensure_index, upsert, remove, exec).
+// Other files are ignored.
+func LoadFS(fsys fs.FS, dir string) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if nil != err {
+		return nil, err
+	}
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		tokens := fileNamePattern.FindStringSubmatch(entry.Name())
+		if nil == tokens {
+			continue
+		}
+		version, err := strconv.ParseInt(tokens[1], 10, 64)
+		if nil != err || version <= 0 {
+			return nil, fmt.Errorf("%w: '%s' has not a valid version", ErrorInvalidMigration, entry.Name())
+		}
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if nil != err {
+			return nil, err
+		}
+		step, err := parseStep(tokens[4], string(data))
+		if nil != err {
+			return nil, fmt.Errorf("%w: '%s': %s", ErrorInvalidMigration, entry.Name(), err)
+		}
+
+		migration, b := byVersion[version]
+		if !b {
+			migration = &Migration{Version: version, Name: tokens[2]}
+			byVersion[version] = migration
+		} else if migration.Name != tokens[2] {
+			return nil, fmt.Errorf("%w: version %d is used by '%s' and '%s'", ErrorDuplicateMigration, version, migration.Name, tokens[2])
+		}
+		if tokens[3] == "up" {
+			if nil != migration.Up {
+				return nil, fmt.Errorf("%w: '%s'", ErrorDuplicateMigration, entry.Name())
+			}
+			migration.Up = step
+		} else {
+			if nil != migration.Down {
+				return nil, fmt.Errorf("%w: '%s'", ErrorDuplicateMigration, entry.Name())
+			}
+			migration.Down = step
+		}
+	}
+
+	response := make([]*Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if nil == migration.Up {
+			return nil, fmt.Errorf("%w: version %d has no up step", ErrorInvalidMigration, migration.Version)
+		}
+		response = append(response, migration)
+	}
+	sortMigrations(response)
+	return response, nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p r i v a t e
+//----------------------------------------------------------------------------------------------------------------------
+
+func sortMigrations(migrations []*Migration) {
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+}
+
+func parseStep(ext, text string) (MigrationStep, error) {
+	if ext == "json" {
+		var steps []*jsonStep
+		if err := json.Unmarshal([]byte(text), &steps); nil != err {
+			return nil, err
+		}
+		return func(db drivers.IDatabase) error {
+			for _, step := range steps {
+				if err := step.run(db); nil != err {
+					return err
+				}
+			}
+			return nil
+		}, nil
+	}
+	statements := splitStatements(text)
+	return func(db drivers.IDatabase) error {
+		for _, statement := range statements {
+			if _, err := db.ExecNative(statement, nil); nil != err {
+				return err
+			}
+		}
+		return nil
+	}, nil
+}
+
+// splitStatements splits a script on ";" at end of line. Lines starting with "--" or "//" are comments.
+func splitStatements(text string) []string {
+	response := make([]string, 0)
+	var buf strings.Builder
+	flush := func() {
+		if statement := strings.TrimSpace(buf.String()); len(statement) > 0 {
+			response = append(response, statement)
+		}
+		buf.Reset()
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "--") || strings.HasPrefix(trimmed, "//") {
+			continue
+		}
+		if strings.HasSuffix(trimmed, ";") {
+			buf.WriteString(strings.TrimSuffix(trimmed, ";"))
+			flush()
+			continue
+		}
+		buf.WriteString(line)
+		buf.WriteString("\n")
+	}
+	flush()
+	return response
+}
+
+func (instance *jsonStep) run(db drivers.IDatabase) (err error) {
+	switch {
+	case len(instance.EnsureCollection) > 0:
+		_, err = db.EnsureCollection(instance.EnsureCollection)
+	case nil != instance.EnsureIndex:
+		_, err = db.EnsureIndex(instance.EnsureIndex.Collection, instance.EnsureIndex.Type,
+			instance.EnsureIndex.Fields, instance.EnsureIndex.Unique)
+	case nil != instance.Upsert:
+		_, err = db.Upsert(instance.Upsert.Collection, instance.Upsert.Doc)
+	case nil != instance.Remove:
+		err = db.Remove(instance.Remove.Collection, instance.Remove.Key)
+	case nil != instance.Exec:
+		_, err = db.ExecNative(instance.Exec.Command, instance.Exec.BindVars)
+	default:
+		err = fmt.Errorf("%w: empty step", ErrorInvalidMigration)
+	}
+	return
+}
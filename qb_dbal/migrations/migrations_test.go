@@ -0,0 +1,152 @@
+package migrations
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	qbc "github.com/rskvp/qb-core"
+	dbalcommons "github.com/rskvp/qb-lib/qb_dbal/commons"
+	"github.com/rskvp/qb-lib/qb_dbal/drivers"
+)
+
+func TestLoadFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"db/0002_add_email.up.sql":      {Data: []byte("ALTER TABLE users ADD email VARCHAR(255);")},
+		"db/0001_create_users.up.sql":   {Data: []byte("-- users\nCREATE TABLE users (\n  id VARCHAR(32) PRIMARY KEY,\n  name VARCHAR(255)\n);\nCREATE INDEX idx_name ON users (name);\n")},
+		"db/0001_create_users.down.sql": {Data: []byte("DROP TABLE users")},
+		"db/readme.md":                  {Data: []byte("ignored")},
+	}
+	migrations, err := LoadFS(fsys, "db")
+	if nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	if len(migrations) != 2 || migrations[0].Version != 1 || migrations[1].Version != 2 {
+		t.Error("Unexpected migrations", migrations)
+		t.FailNow()
+	}
+	if migrations[0].Name != "create_users" || nil == migrations[0].Down || nil != migrations[1].Down {
+		t.Error("Unexpected migration", migrations[0])
+	}
+	if statements := splitStatements(string(fsys["db/0001_create_users.up.sql"].Data)); len(statements) != 2 {
+		t.Error("Expected 2 statements, got", statements)
+	}
+
+	fsys["db/0002_other.down.sql"] = &fstest.MapFile{Data: []byte("")}
+	if _, err = LoadFS(fsys, "db"); !errors.Is(err, ErrorDuplicateMigration) {
+		t.Error("Expected duplicate migration, got", err)
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	dir := t.TempDir()
+	dsn := dbalcommons.NewDsn("root:root@file:bolt.dat")
+	dsn.Database = filepath.Join(dir, "bolt.dat") // absolute paths are not supported by the dsn syntax
+	bolt := drivers.NewDriverBolt(dsn)
+	gorm := drivers.NewDriverGorm("sqlite", filepath.Join(dir, "sqlite.db"))
+	for _, db := range []drivers.IDatabase{bolt, gorm} {
+		if err := db.Open(); nil != err {
+			t.Error(err)
+			t.FailNow()
+		}
+		fsys := fstest.MapFS{
+			"0001_users.up.json":   {Data: []byte(`[{"ensure_collection": "users"}, {"upsert": {"collection": "settings", "doc": {"id": "1", "_key": "1", "value": "a"}}}]`)},
+			"0001_users.down.json": {Data: []byte(`[{"remove": {"collection": "settings", "key": "1"}}]`)},
+			"0002_seed.up.json":    {Data: []byte(`[{"upsert": {"collection": "settings", "doc": {"id": "2", "_key": "2", "value": "b"}}}]`)},
+		}
+		if db == gorm {
+			_, _ = db.ExecNative("CREATE TABLE settings (id VARCHAR(32) PRIMARY KEY, value VARCHAR(32))", nil)
+			fsys["0001_users.up.json"] = &fstest.MapFile{Data: []byte(`[{"upsert": {"collection": "settings", "doc": {"id": "1", "value": "a"}}}]`)}
+			fsys["0002_seed.up.json"] = &fstest.MapFile{Data: []byte(`[{"upsert": {"collection": "settings", "doc": {"id": "2", "value": "b"}}}]`)}
+		}
+		migrator := NewMigrator(db)
+		if err := migrator.LoadFS(fsys, "."); nil != err {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		if err := migrator.Migrate(1); nil != err {
+			t.Error(db.DriverName(), err)
+			t.FailNow()
+		}
+		if version, _ := migrator.Version(); version != 1 {
+			t.Error(db.DriverName(), "Expected version 1, got", version)
+		}
+		if err := migrator.Up(); nil != err {
+			t.Error(db.DriverName(), err)
+			t.FailNow()
+		}
+		applied, _ := migrator.Applied()
+		if len(applied) != 2 || applied[1].Name != "seed" {
+			t.Error(db.DriverName(), "Unexpected applied migrations", applied)
+		}
+		// running again does nothing
+		if err := migrator.Up(); nil != err {
+			t.Error(db.DriverName(), err)
+		}
+
+		// locked by another runner
+		if err := migrator.lock(); nil != err {
+			t.Error(db.DriverName(), err)
+			t.FailNow()
+		}
+		other := NewMigrator(db)
+		_ = other.LoadFS(fsys, ".")
+		if err := other.Up(); !errors.Is(err, ErrorMigrationsLocked) {
+			t.Error(db.DriverName(), "Expected locked migrations, got", err)
+		}
+		migrator.unlock()
+
+		// a stale lock is taken over, a lock replaced meanwhile is not removed
+		stale := map[string]interface{}{migrator.keyField(): lockKey, FLD_OWNER: "dead", FLD_EXPIRES_AT: "1"}
+		if _, err := db.Upsert(migrator.lockCollection(), stale); nil != err {
+			t.Error(db.DriverName(), err)
+		}
+		if err := migrator.lock(); nil != err {
+			t.Error(db.DriverName(), "Expected stale lock taken over, got", err)
+		}
+		if db == gorm {
+			if err := other.removeStale(other.lockCollection(), stale); nil != err {
+				t.Error(db.DriverName(), err)
+			}
+		}
+		if current, _ := db.Get(migrator.lockCollection(), lockKey); qbc.Convert.ToString(current[FLD_OWNER]) != migrator.owner {
+			t.Error(db.DriverName(), "Expected lock of the runner, got", current)
+		}
+		// the lock of another runner is not removed
+		other.unlock()
+		if current, _ := db.Get(migrator.lockCollection(), lockKey); qbc.Convert.ToString(current[FLD_OWNER]) != migrator.owner {
+			t.Error(db.DriverName(), "Expected lock not removed by another runner, got", current)
+		}
+		migrator.unlock()
+		if current, _ := db.Get(migrator.lockCollection(), lockKey); nil != current {
+			t.Error(db.DriverName(), "Expected lock removed, got", current)
+		}
+
+		// version 2 has no down step
+		if err := migrator.Migrate(0); !errors.Is(err, ErrorIrreversibleMigration) {
+			t.Error(db.DriverName(), "Expected irreversible migration, got", err)
+		}
+		migrator.find(2).Down = func(db drivers.IDatabase) error {
+			return db.Remove("settings", "2")
+		}
+		if err := migrator.Down(); nil != err {
+			t.Error(db.DriverName(), err)
+		}
+		if version, _ := migrator.Version(); version != 1 {
+			t.Error(db.DriverName(), "Expected version 1, got", version)
+		}
+		if err := migrator.Migrate(0); nil != err {
+			t.Error(db.DriverName(), err)
+		}
+		if version, _ := migrator.Version(); version != 0 {
+			t.Error(db.DriverName(), "Expected version 0, got", version)
+		}
+		if item, _ := db.Get("settings", "1"); len(item) > 0 {
+			t.Error(db.DriverName(), "Expected document removed by rollback, got", item)
+		}
+		_ = db.Close()
+	}
+}
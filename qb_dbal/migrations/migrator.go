@@ -0,0 +1,457 @@
+package migrations
+
+import (
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	qbc "github.com/rskvp/qb-core"
+	dbalcommons "github.com/rskvp/qb-lib/qb_dbal/commons"
+	"github.com/rskvp/qb-lib/qb_dbal/drivers"
+	"github.com/rskvp/qb-lib/qb_dbal/drivers/dbsql"
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//	c o n s t
+//----------------------------------------------------------------------------------------------------------------------
+
+const (
+	DefaultCollection  = "qb_migrations"
+	DefaultLockTimeout = 15 * time.Minute
+
+	FLD_VERSION    = "version"
+	FLD_NAME       = "name"
+	FLD_APPLIED_AT = "applied_at"
+	FLD_OWNER      = "owner"
+	FLD_EXPIRES_AT = "expires_at"
+
+	lockKey = "lock"
+)
+
+// AppliedMigration is a migration recorded into the bookkeeping collection
+type AppliedMigration struct {
+	Version   int64     `json:"version"`
+	Name      string    `json:"name"`
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+// inserter is implemented by drivers that can create a document failing if the key already exists
+type inserter interface {
+	Insert(collection string, item map[string]interface{}) (map[string]interface{}, error)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	Migrator
+//----------------------------------------------------------------------------------------------------------------------
+
+// Migrator applies and rolls back versioned migrations on any IDatabase.
+// Applied versions are recorded into a bookkeeping collection (table) and a lock record, stored into
+// "<collection>_lock", prevents concurrent runners. A lock older than the lock timeout is considered stale.
+type Migrator struct {
+	db          drivers.IDatabase
+	migrations  []*Migration
+	collection  string
+	lockTimeout time.Duration
+	owner       string
+}
+
+func NewMigrator(db drivers.IDatabase) *Migrator {
+	instance := new(Migrator)
+	instance.db = db
+	instance.migrations = make([]*Migration, 0)
+	instance.collection = DefaultCollection
+	instance.lockTimeout = DefaultLockTimeout
+	instance.owner = qbc.Rnd.Uuid()
+	return instance
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p u b l i c
+//----------------------------------------------------------------------------------------------------------------------
+
+// SetCollection changes the name of the bookkeeping collection (default "qb_migrations")
+func (instance *Migrator) SetCollection(name string) *Migrator {
+	if len(name) > 0 {
+		instance.collection = name
+	}
+	return instance
+}
+
+func (instance *Migrator) SetLockTimeout(value time.Duration) *Migrator {
+	if value > 0 {
+		instance.lockTimeout = value
+	}
+	return instance
+}
+
+// Add registers migrations. Versions must be unique and greater than zero.
+func (instance *Migrator) Add(migrations ...*Migration) error {
+	for _, migration := range migrations {
+		if nil == migration || migration.Version <= 0 || nil == migration.Up {
+			return ErrorInvalidMigration
+		}
+		if nil != instance.find(migration.Version) {
+			return fmt.Errorf("%w: version %d", ErrorDuplicateMigration, migration.Version)
+		}
+		instance.migrations = append(instance.migrations, migration)
+	}
+	sortMigrations(instance.migrations)
+	return nil
+}
+
+// Register adds a migration written in Go
+func (instance *Migrator) Register(version int64, name string, up, down MigrationStep) error {
+	return instance.Add(&Migration{Version: version, Name: name, Up: up, Down: down})
+}
+
+func (instance *Migrator) LoadDir(dir string) error {
+	migrations, err := LoadDir(dir)
+	if nil != err {
+		return err
+	}
+	return instance.Add(migrations...)
+}
+
+func (instance *Migrator) LoadFS(fsys fs.FS, dir string) error {
+	migrations, err := LoadFS(fsys, dir)
+	if nil != err {
+		return err
+	}
+	return instance.Add(migrations...)
+}
+
+// Migrations returns the registered migrations sorted by version
+func (instance *Migrator) Migrations() []*Migration {
+	return instance.migrations
+}
+
+// Applied returns the applied migrations sorted by version
+func (instance *Migrator) Applied() ([]*AppliedMigration, error) {
+	if err := instance.ensureCollection(instance.collection); nil != err {
+		return nil, err
+	}
+	return instance.applied()
+}
+
+// Version returns the last applied version, 0 if none
+func (instance *Migrator) Version() (int64, error) {
+	applied, err := instance.Applied()
+	if nil != err {
+		return 0, err
+	}
+	if len(applied) == 0 {
+		return 0, nil
+	}
+	return applied[len(applied)-1].Version, nil
+}
+
+// Up applies all pending migrations
+func (instance *Migrator) Up() error {
+	if len(instance.migrations) == 0 {
+		return nil
+	}
+	return instance.Migrate(instance.migrations[len(instance.migrations)-1].Version)
+}
+
+// Down rolls back the last applied migration
+func (instance *Migrator) Down() error {
+	return instance.run(func(applied []*AppliedMigration) error {
+		if len(applied) == 0 {
+			return nil
+		}
+		var target int64
+		if len(applied) > 1 {
+			target = applied[len(applied)-2].Version
+		}
+		return instance.migrate(applied, target)
+	})
+}
+
+// Migrate applies pending migrations up to target and rolls back applied migrations greater than target.
+// Migrate(0) rolls back everything.
+func (instance *Migrator) Migrate(target int64) error {
+	if target < 0 {
+		return ErrorInvalidMigration
+	}
+	return instance.run(func(applied []*AppliedMigration) error {
+		return instance.migrate(applied, target)
+	})
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p r i v a t e
+//----------------------------------------------------------------------------------------------------------------------
+
+func (instance *Migrator) find(version int64) *Migration {
+	for _, migration := range instance.migrations {
+		if migration.Version == version {
+			return migration
+		}
+	}
+	return nil
+}
+
+// run locks the database and calls callback with the applied migrations
+func (instance *Migrator) run(callback func(applied []*AppliedMigration) error) error {
+	if nil == instance.db {
+		return dbalcommons.ErrorDatabaseDoesNotExists
+	}
+	if err := instance.ensureCollection(instance.collection); nil != err {
+		return err
+	}
+	if err := instance.ensureCollection(instance.lockCollection()); nil != err {
+		return err
+	}
+	if err := instance.lock(); nil != err {
+		return err
+	}
+	defer instance.unlock()
+
+	applied, err := instance.applied()
+	if nil != err {
+		return err
+	}
+	return callback(applied)
+}
+
+func (instance *Migrator) migrate(applied []*AppliedMigration, target int64) error {
+	done := make(map[int64]bool)
+	for _, item := range applied {
+		done[item.Version] = true
+	}
+
+	// roll back from the last applied
+	for i := len(applied) - 1; i >= 0; i-- {
+		version := applied[i].Version
+		if version <= target {
+			break
+		}
+		migration := instance.find(version)
+		if nil == migration {
+			return fmt.Errorf("%w: version %d is applied but not registered", ErrorMissingMigration, version)
+		}
+		if nil == migration.Down {
+			return fmt.Errorf("%w: version %d", ErrorIrreversibleMigration, version)
+		}
+		if err := migration.Down(instance.db); nil != err {
+			return fmt.Errorf("rolling back migration %d '%s': %w", version, migration.Name, err)
+		}
+		if err := instance.db.Remove(instance.collection, strconv.FormatInt(version, 10)); nil != err {
+			return err
+		}
+	}
+
+	// apply pending
+	for _, migration := range instance.migrations {
+		if migration.Version > target {
+			break
+		}
+		if done[migration.Version] {
+			continue
+		}
+		if err := migration.Up(instance.db); nil != err {
+			return fmt.Errorf("applying migration %d '%s': %w", migration.Version, migration.Name, err)
+		}
+		_, err := instance.db.Upsert(instance.collection, map[string]interface{}{
+			instance.keyField(): strconv.FormatInt(migration.Version, 10),
+			FLD_VERSION:         migration.Version,
+			FLD_NAME:            migration.Name,
+			FLD_APPLIED_AT:      time.Now().UTC().Format(time.RFC3339),
+		})
+		if nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+func (instance *Migrator) applied() ([]*AppliedMigration, error) {
+	response := make([]*AppliedMigration, 0)
+	keyField := instance.keyField()
+	err := instance.db.ForEach(instance.collection, func(doc map[string]interface{}) bool {
+		item := new(AppliedMigration)
+		// the key is the version as string: numeric columns may be scanned as driver specific types
+		item.Version, _ = strconv.ParseInt(qbc.Convert.ToString(doc[keyField]), 10, 64)
+		item.Name = qbc.Convert.ToString(doc[FLD_NAME])
+		item.AppliedAt, _ = time.Parse(time.RFC3339, qbc.Convert.ToString(doc[FLD_APPLIED_AT]))
+		if item.Version > 0 {
+			response = append(response, item)
+		}
+		return false
+	})
+	if nil != err {
+		return nil, err
+	}
+	sort.Slice(response, func(i, j int) bool {
+		return response[i].Version < response[j].Version
+	})
+	return response, nil
+}
+
+func (instance *Migrator) lockCollection() string {
+	return instance.collection + "_lock"
+}
+
+// lock creates the lock record. Drivers with Insert fail atomically on an existing record,
+// the others check and write the record into a transaction.
+func (instance *Migrator) lock() error {
+	collection := instance.lockCollection()
+	keyField := instance.keyField()
+	doc := func() map[string]interface{} {
+		return map[string]interface{}{
+			keyField:       lockKey,
+			FLD_OWNER:      instance.owner,
+			FLD_EXPIRES_AT: strconv.FormatInt(time.Now().Add(instance.lockTimeout).Unix(), 10),
+		}
+	}
+
+	if db, b := instance.db.(inserter); b {
+		for attempt := 0; attempt < 2; attempt++ {
+			if _, err := db.Insert(collection, doc()); nil == err {
+				return nil
+			}
+			current, err := instance.db.Get(collection, lockKey)
+			if nil != err {
+				return err
+			}
+			if isLocked(current) {
+				return lockedError(current)
+			}
+			if nil != current {
+				// stale lock: removed only if not replaced meanwhile by another runner
+				if err = instance.removeStale(collection, current); nil != err {
+					return err
+				}
+			}
+		}
+		return ErrorMigrationsLocked
+	}
+
+	options := dbalcommons.NewTransactionOptions().Lock(collection)
+	return instance.db.WithTransaction(options, func(tx drivers.ITransaction) error {
+		current, err := tx.Get(collection, lockKey)
+		if nil != err {
+			return err
+		}
+		if isLocked(current) {
+			return lockedError(current)
+		}
+		_, err = tx.Upsert(collection, doc())
+		return err
+	})
+}
+
+// removeStale deletes the lock record only if owner and expiration are still the ones of current.
+// An unconditional remove could delete the lock just created by a runner that removed the same stale lock.
+func (instance *Migrator) removeStale(collection string, current map[string]interface{}) error {
+	return instance.removeLock(collection, qbc.Convert.ToString(current[FLD_OWNER]),
+		qbc.Convert.ToString(current[FLD_EXPIRES_AT]))
+}
+
+// removeLock deletes the lock record with a single command conditioned on owner and, if not empty, expires.
+func (instance *Migrator) removeLock(collection, owner, expires string) error {
+	if instance.isDocumentStore() {
+		command := "FOR doc IN @@collection FILTER doc._key == @key AND doc.owner == @owner "
+		bindVars := map[string]interface{}{"@collection": collection, "key": lockKey, "owner": owner}
+		if len(expires) > 0 {
+			command += "AND doc.expires_at == @expires "
+			bindVars["expires"] = expires
+		}
+		_, err := instance.db.ExecNative(command+"REMOVE doc IN @@collection", bindVars)
+		return err
+	}
+	// literals: the drivers replace the parameters of ExecNative without quoting
+	command := fmt.Sprintf("DELETE FROM %s WHERE %s = %s AND %s = %s", collection,
+		drivers.DefaultPrimaryKey, sqlString(lockKey), FLD_OWNER, sqlString(owner))
+	if len(expires) > 0 {
+		command += fmt.Sprintf(" AND %s = %s", FLD_EXPIRES_AT, sqlString(expires))
+	}
+	_, err := instance.db.ExecNative(command, nil)
+	return err
+}
+
+// unlock removes the lock record if still owned by this runner: with the same command of removeStale
+// for drivers with Insert, checking the owner into the transaction that removes the record for the others.
+func (instance *Migrator) unlock() {
+	collection := instance.lockCollection()
+	if _, b := instance.db.(inserter); b {
+		_ = instance.removeLock(collection, instance.owner, "")
+		return
+	}
+	options := dbalcommons.NewTransactionOptions().Lock(collection)
+	_ = instance.db.WithTransaction(options, func(tx drivers.ITransaction) error {
+		current, err := tx.Get(collection, lockKey)
+		if nil != err || nil == current || qbc.Convert.ToString(current[FLD_OWNER]) != instance.owner {
+			return err
+		}
+		return tx.Remove(collection, lockKey)
+	})
+}
+
+// ensureCollection creates the bookkeeping collection, or table for SQL databases
+func (instance *Migrator) ensureCollection(name string) error {
+	if instance.isDocumentStore() {
+		_, err := instance.db.EnsureCollection(name)
+		return err
+	}
+	if err := dbsql.CheckIdentifiers(name); nil != err {
+		return err
+	}
+	if instance.tableExists(name) {
+		return nil
+	}
+	var command string
+	if name == instance.collection {
+		command = fmt.Sprintf("CREATE TABLE %s (%s VARCHAR(32) NOT NULL PRIMARY KEY, %s NUMERIC(19) NOT NULL, %s VARCHAR(255), %s VARCHAR(64))",
+			name, drivers.DefaultPrimaryKey, FLD_VERSION, FLD_NAME, FLD_APPLIED_AT)
+	} else {
+		command = fmt.Sprintf("CREATE TABLE %s (%s VARCHAR(32) NOT NULL PRIMARY KEY, %s VARCHAR(64), %s VARCHAR(32))",
+			name, drivers.DefaultPrimaryKey, FLD_OWNER, FLD_EXPIRES_AT)
+	}
+	if _, err := instance.db.ExecNative(command, nil); nil != err && !instance.tableExists(name) {
+		return err // not created by a concurrent runner
+	}
+	return nil
+}
+
+func (instance *Migrator) tableExists(name string) bool {
+	_, err := instance.db.Query(name, dbalcommons.NewQuery().Paginate(0, 1))
+	return nil == err
+}
+
+func (instance *Migrator) isDocumentStore() bool {
+	switch instance.db.DriverName() {
+	case drivers.NameArango, drivers.NameBolt:
+		return true
+	}
+	return false
+}
+
+func (instance *Migrator) keyField() string {
+	if instance.isDocumentStore() {
+		return drivers.KeyFieldName
+	}
+	return drivers.DefaultPrimaryKey
+}
+
+func sqlString(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// isLocked checks the expiration of a lock, stored as string because numeric columns may be scanned as driver specific types
+func isLocked(lock map[string]interface{}) bool {
+	return nil != lock && expiresAt(lock) > time.Now().Unix()
+}
+
+func expiresAt(lock map[string]interface{}) int64 {
+	value, _ := strconv.ParseInt(qbc.Convert.ToString(lock[FLD_EXPIRES_AT]), 10, 64)
+	return value
+}
+
+func lockedError(lock map[string]interface{}) error {
+	return fmt.Errorf("%w by '%v' until %v", ErrorMigrationsLocked, lock[FLD_OWNER],
+		time.Unix(expiresAt(lock), 0).UTC().Format(time.RFC3339))
+}
@@ -0,0 +1,119 @@
+package semantic_search
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/rskvp/qb-lib/qb_dbal/commons"
+	"golang.org/x/text/unicode/norm"
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//	t y p e s
+//----------------------------------------------------------------------------------------------------------------------
+
+// semanticAnalyzer turns a text into index terms:
+// split on non alphanumeric characters, lowercase, fold accents, remove stop words and stem.
+type semanticAnalyzer struct {
+	caseSensitive bool
+	keepAccents   bool
+	stopWords     map[string]bool
+	stemmer       Stemmer
+}
+
+// semanticTerm is a term and the word of the text it comes from
+type semanticTerm struct {
+	Word string
+	Term string
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	c o n s t r u c t o r
+//----------------------------------------------------------------------------------------------------------------------
+
+func newSemanticAnalyzer(config *commons.SemanticConfig) *semanticAnalyzer {
+	instance := new(semanticAnalyzer)
+	instance.stopWords = make(map[string]bool)
+	if nil != config {
+		instance.caseSensitive = config.CaseSensitive
+		instance.keepAccents = config.KeepAccents
+		if language := GetLanguage(config.Language); nil != language {
+			instance.addStopWords(language.StopWords)
+			instance.stemmer = language.Stemmer
+		}
+		instance.addStopWords(config.StopWords)
+	}
+	return instance
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p u b l i c
+//----------------------------------------------------------------------------------------------------------------------
+
+// Terms returns the terms of text in order, with repetitions (term frequency matters to the score)
+func (instance *semanticAnalyzer) Terms(text string) []string {
+	analyzed := instance.Analyze(text)
+	response := make([]string, 0, len(analyzed))
+	for _, item := range analyzed {
+		response = append(response, item.Term)
+	}
+	return response
+}
+
+// Analyze returns the terms of text with the word each term comes from
+func (instance *semanticAnalyzer) Analyze(text string) []*semanticTerm {
+	response := make([]*semanticTerm, 0)
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.Is(unicode.Mn, r)
+	})
+	for _, word := range words {
+		if term := instance.term(word); len(term) > 0 {
+			response = append(response, &semanticTerm{Word: word, Term: term})
+		}
+	}
+	return response
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p r i v a t e
+//----------------------------------------------------------------------------------------------------------------------
+
+func (instance *semanticAnalyzer) addStopWords(words []string) {
+	for _, word := range words {
+		instance.stopWords[foldAccents(strings.ToLower(word))] = true
+	}
+}
+
+func (instance *semanticAnalyzer) term(word string) string {
+	term := word
+	if !instance.caseSensitive {
+		term = strings.ToLower(term)
+	}
+	if !instance.keepAccents {
+		term = foldAccents(term)
+	}
+	if len([]rune(term)) < 2 || instance.stopWords[foldAccents(strings.ToLower(term))] {
+		return ""
+	}
+	if nil != instance.stemmer {
+		term = instance.stemmer(term)
+	}
+	return term
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	S T A T I C
+//----------------------------------------------------------------------------------------------------------------------
+
+// foldAccents removes diacritics: "Perché" -> "Perche"
+func foldAccents(text string) string {
+	decomposed := norm.NFD.String(text)
+	var buf strings.Builder
+	buf.Grow(len(decomposed))
+	for _, r := range decomposed {
+		if !unicode.Is(unicode.Mn, r) {
+			buf.WriteRune(r)
+		}
+	}
+	return norm.NFC.String(buf.String())
+}
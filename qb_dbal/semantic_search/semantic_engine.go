@@ -1,7 +1,7 @@
 package semantic_search
 
 import (
-	"fmt"
+	"math"
 	"sort"
 	"strings"
 
@@ -15,37 +15,77 @@ import (
 //----------------------------------------------------------------------------------------------------------------------
 
 const (
-	COLLECTION = "elastic_search_indexed"
+	COLLECTION        = "elastic_search_indexed"
+	COLLECTION_TOKENS = "semantic_search_tokens" // inverted index: token -> indexed entity
+	COLLECTION_STATS  = "semantic_search_stats"  // number of entities and terms of each group
 
-	FLD_DBKEY  = "_key"
-	FLD_KEY    = "key"
-	FLD_GROUP  = "group"
-	FLD_ENTITY = "entity"
-	FLD_TAGS   = "tags"
+	FLD_DBKEY     = "_key"
+	FLD_KEY       = "key"
+	FLD_GROUP     = "group"
+	FLD_ENTITY    = "entity"
+	FLD_TAGS      = "tags"
+	FLD_TOKEN     = "token"
+	FLD_ENTITY_ID = "entity_id"
+	FLD_DOCS      = "docs"
+	FLD_TERMS     = "terms"
 
 	ANALYZER_LOWER = "semantic_lowercase"
+
+	// BM25 parameters
+	bm25K1 = 1.2
+	bm25B  = 0.75
 )
 
 type SemanticEngineData struct {
-	Score  int                    `json:"score"`
-	Key    string                 `json:"key"`
-	Group  string                 `json:"group"`
-	Entity map[string]interface{} `json:"entity"`
+	Score   float64                `json:"score"`
+	Key     string                 `json:"key"`
+	Group   string                 `json:"group"`
+	Matches []string               `json:"matches"` // words of the query found in the entity
+	Entity  map[string]interface{} `json:"entity"`
+}
+
+// SemanticItem is a text to index with PutBatch
+type SemanticItem struct {
+	Key  string `json:"key"`
+	Text string `json:"text"`
 }
 
+// SemanticReindexProgress is the state of a Reindex job
+type SemanticReindexProgress struct {
+	Group   string `json:"group"`
+	Total   int    `json:"total"`   // records of the source collection read so far
+	Indexed int    `json:"indexed"` // records indexed so far
+	Removed int    `json:"removed"` // stale entries removed from the index
+	Done    bool   `json:"done"`
+}
+
+// SemanticReindexCallback is called by Reindex after each batch and at the end
+type SemanticReindexCallback func(progress *SemanticReindexProgress)
+
+// records indexed into a single transaction by Reindex and by the upgrade of entries of previous versions
+const reindexBatchSize = 100
+
+// values of each IN condition sent to the database by search
+const queryChunkSize = 500
+
 //----------------------------------------------------------------------------------------------------------------------
 //	SemanticEngine
 //----------------------------------------------------------------------------------------------------------------------
 
+// SemanticEngine is a keyword search engine. The index is stored into any database supported by drivers
+// (Arango, Bolt, Gorm, SQL), so the engine can run embedded with no server.
 type SemanticEngine struct {
 	config     *commons.SemanticConfig
 	dbInternal drivers.IDatabase
 	dbExternal drivers.IDatabase
+	store      *semanticStore
+	analyzer   *semanticAnalyzer
 }
 
 func NewSemanticEngine(config *commons.SemanticConfig) (*SemanticEngine, error) {
 	instance := new(SemanticEngine)
 	instance.config = config
+	instance.analyzer = newSemanticAnalyzer(config)
 
 	// internal
 	if nil != config.DbInternal && config.DbInternal.IsValid() {
@@ -54,7 +94,10 @@ func NewSemanticEngine(config *commons.SemanticConfig) (*SemanticEngine, error)
 			return nil, err
 		}
 		instance.dbInternal = db
-		instance.initInternal()
+		instance.store, err = newSemanticStore(db, instance.analyzer)
+		if nil != err {
+			return nil, err
+		}
 	} else {
 		// internal db is required but not properly configured
 		return nil, commons.ErrorMismatchConfiguration
@@ -84,103 +127,214 @@ func (instance *SemanticEngine) IsCaseSensitive() bool {
 }
 
 func (instance *SemanticEngine) Put(group, key, text string) error {
-	if nil != instance && nil != instance.dbInternal {
-		_, err := instance.dbInternal.Upsert(COLLECTION, instance.prepare(group, key, text))
-		return err
+	if nil != instance && nil != instance.store {
+		return instance.store.Put(instance.prepare(group, key, text))
 	}
 	return commons.ErrorEngineNotReady
 }
 
+// Get returns the entities of group (all groups if empty) matching any word of text, sorted by BM25 score.
+// offset and count paginate the sorted result (count <= 0 returns all).
 func (instance *SemanticEngine) Get(group, text string, offset, count int) ([]*SemanticEngineData, error) {
-	if nil != instance && nil != instance.dbInternal {
-		// build filter
-		var err error
-		if len(text) > 0 {
-			bindVars := map[string]interface{}{"@collection": COLLECTION}
-			query, tokens := buildQuery(group, text, offset, count, bindVars, instance.IsCaseSensitive())
-
-			data, err := instance.dbInternal.ExecNative(query, bindVars)
-			if nil != err {
-				return nil, err
+	if nil != instance && nil != instance.store {
+		response := make([]*SemanticEngineData, 0)
+		query := instance.analyzer.Analyze(text)
+		if len(query) == 0 {
+			return response, nil
+		}
+		terms := make([]string, 0, len(query))
+		for _, item := range query {
+			terms = append(terms, item.Term)
+		}
+		entries, frequencies, err := instance.store.Find(group, terms)
+		if nil != err {
+			return nil, err
+		}
+		stats, err := instance.store.Stats(group)
+		if nil != err {
+			return nil, err
+		}
+		if stats.Docs < int64(len(entries)) {
+			// statistics not available: use the found entries
+			stats = &semanticStats{Docs: int64(len(entries))}
+			for _, entry := range entries {
+				stats.Terms += int64(len(entry.Tags))
 			}
-			if v, b := data.([]interface{}); b {
-				response := make([]*SemanticEngineData, 0)
-				for _, item := range v {
-					if entity, b := item.(map[string]interface{}); b {
-						data := new(SemanticEngineData)
-						data.Entity = entity
-						data.Key = entity[FLD_KEY].(string)
-						data.Group = entity[FLD_GROUP].(string)
-						data.Score = getScore(tokens, qbc.Reflect.GetArrayOfString(entity, FLD_TAGS), instance.IsCaseSensitive())
-
-						// need recover an entity from external?
-						if nil != instance.dbExternal {
-							item, err := instance.dbExternal.Get(data.Group, data.Key)
-							if nil != err {
-								return nil, err
-							}
-							if nil != item {
-								data.Entity = item
-								response = append(response, data)
-							} else {
-								// ENTITY NOT FOUND: remove indexed key
-								_ = instance.dbInternal.Remove(COLLECTION, entity[FLD_DBKEY].(string))
-							}
-						} else {
-							response = append(response, data)
-						}
-					}
+		}
+		for _, entry := range entries {
+			data := new(SemanticEngineData)
+			data.Key = entry.Key
+			data.Group = entry.Group
+			data.Score, data.Matches = score(query, entry.Tags, frequencies, stats)
+			data.Entity = map[string]interface{}{
+				FLD_DBKEY: entry.DbKey,
+				FLD_GROUP: entry.Group,
+				FLD_KEY:   entry.Key,
+				FLD_TAGS:  entry.Tags,
+			}
+			response = append(response, data)
+		}
+		// sort by score, then paginate
+		sort.SliceStable(response, func(i, j int) bool {
+			if response[i].Score != response[j].Score {
+				return response[i].Score > response[j].Score
+			}
+			return response[i].Group+response[i].Key < response[j].Group+response[j].Key
+		})
+		response = paginate(response, offset, count)
+
+		// need recover entities from external?
+		if nil != instance.dbExternal {
+			entities := make([]*SemanticEngineData, 0, len(response))
+			for _, data := range response {
+				item, err := instance.dbExternal.Get(data.Group, data.Key)
+				if nil != err {
+					return nil, err
+				}
+				if nil != item {
+					data.Entity = item
+					entities = append(entities, data)
+				} else {
+					// ENTITY NOT FOUND: remove indexed key
+					_ = instance.store.Remove(qbc.Convert.ToString(data.Entity[FLD_DBKEY]))
 				}
-				// sort by score
-				sort.Slice(response, func(i, j int) bool {
-					return response[i].Score > response[j].Score
-				})
-				return response, nil
 			}
+			response = entities
 		}
-		return nil, err
+		return response, nil
 	}
 	return nil, commons.ErrorEngineNotReady
 }
 
-//----------------------------------------------------------------------------------------------------------------------
-//	p r i v a t e
-//----------------------------------------------------------------------------------------------------------------------
+// PutBatch indexes items of group into a single transaction
+func (instance *SemanticEngine) PutBatch(group string, items []*SemanticItem) error {
+	if nil != instance && nil != instance.store {
+		entries := make([]*semanticEntry, 0, len(items))
+		for _, item := range items {
+			if nil != item {
+				entries = append(entries, instance.prepare(group, item.Key, item.Text))
+			}
+		}
+		return instance.store.PutBatch(entries)
+	}
+	return commons.ErrorEngineNotReady
+}
+
+// Remove deletes key of group from the index
+func (instance *SemanticEngine) Remove(group, key string) error {
+	if nil != instance && nil != instance.store {
+		return instance.store.Remove(qbc.Coding.MD5(group + key))
+	}
+	return commons.ErrorEngineNotReady
+}
 
-func (instance *SemanticEngine) initInternal() {
-	if nil != instance.dbInternal {
-		_, _ = instance.dbInternal.EnsureIndex(COLLECTION, "", []string{FLD_GROUP, FLD_TAGS}, false)
-		if arango, b := instance.dbInternal.(*drivers.DriverArango); b {
-			// https://www.arangodb.com/docs/stable/arangosearch-case-sensitivity-and-diacritics.html
-			// analyzers.save("norm_en", "norm", { locale: "en.utf-8", accent: false, case: "lower" }, []);
-			_ = arango.EnsureAnalyzer(map[string]interface{}{
-				"name": ANALYZER_LOWER,
-				"type": "norm",
-				"properties": map[string]interface{}{
-					"locale": "en.utf-8",
-					"accent": false,
-					"case":   "lower",
-				},
-			})
+// RemoveGroup deletes all the keys of group from the index
+func (instance *SemanticEngine) RemoveGroup(group string) error {
+	if nil != instance && nil != instance.store {
+		keys, err := instance.store.Keys(group)
+		if nil != err {
+			return err
+		}
+		dbKeys := make([]string, 0, len(keys))
+		for _, dbKey := range keys {
+			dbKeys = append(dbKeys, dbKey)
 		}
+		return instance.store.Remove(dbKeys...)
 	}
+	return commons.ErrorEngineNotReady
 }
 
-func (instance *SemanticEngine) prepare(group, key, text string) map[string]interface{} {
-	response := map[string]interface{}{
-		FLD_DBKEY: qbc.Coding.MD5(group + key),
-		FLD_GROUP: group,
-		FLD_KEY:   key,
-		FLD_TAGS:  ToKeywords(text),
+// Reindex rebuilds the index of group from the collection with the same name of source
+// (the external database if source is nil). The text of each record is the join of textFields.
+// Entries of group that are no longer in source are removed.
+// Records are indexed in batches and callback (optional) is notified after each batch and at the end.
+func (instance *SemanticEngine) Reindex(group string, source drivers.IDatabase, textFields []string, callback SemanticReindexCallback) (*SemanticReindexProgress, error) {
+	if nil == instance || nil == instance.store {
+		return nil, commons.ErrorEngineNotReady
+	}
+	if nil == source {
+		source = instance.dbExternal
+	}
+	if nil == source {
+		return nil, commons.ErrorDatabaseDoesNotExists
 	}
+	if len(textFields) == 0 {
+		return nil, commons.ErrorMissingTextFields
+	}
+	keyName := source.GetPrimaryKey(group)
 
-	return response
+	stale, err := instance.store.Keys(group)
+	if nil != err {
+		return nil, err
+	}
+	progress := &SemanticReindexProgress{Group: group}
+
+	// page the source: writing to the index while iterating could lock a database shared with source.
+	// Pages are read by key after the last key of the previous page, so each query starts where the
+	// previous one ended instead of skipping an offset that grows with the collection
+	query := commons.NewQuery().OrderBy(keyName).Paginate(0, reindexBatchSize)
+	for {
+		records, err := source.Query(group, query)
+		if nil != err {
+			return progress, err
+		}
+		batch := make([]*semanticEntry, 0, len(records))
+		for _, record := range records {
+			if key := qbc.Convert.ToString(record[keyName]); len(key) > 0 {
+				delete(stale, key)
+				batch = append(batch, instance.prepare(group, key, recordText(record, textFields)))
+			}
+		}
+		if err = instance.store.PutBatch(batch); nil != err {
+			return progress, err
+		}
+		progress.Total += len(records)
+		progress.Indexed += len(batch)
+		if len(records) < reindexBatchSize {
+			break
+		}
+		if nil != callback {
+			callback(progress)
+		}
+		query = commons.NewQuery().Where(keyName, commons.QueryGreater, records[len(records)-1][keyName]).
+			OrderBy(keyName).Paginate(0, reindexBatchSize)
+	}
+
+	dbKeys := make([]string, 0, len(stale))
+	for _, dbKey := range stale {
+		dbKeys = append(dbKeys, dbKey)
+	}
+	if err = instance.store.Remove(dbKeys...); nil != err {
+		return progress, err
+	}
+	progress.Removed = len(dbKeys)
+	progress.Done = true
+	if nil != callback {
+		callback(progress)
+	}
+	return progress, nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p r i v a t e
+//----------------------------------------------------------------------------------------------------------------------
+
+func (instance *SemanticEngine) prepare(group, key, text string) *semanticEntry {
+	return &semanticEntry{
+		DbKey: qbc.Coding.MD5(group + key),
+		Group: group,
+		Key:   key,
+		Tags:  instance.analyzer.Terms(text),
+	}
 }
 
 //----------------------------------------------------------------------------------------------------------------------
 //	S T A T I C
 //----------------------------------------------------------------------------------------------------------------------
 
+// ToKeywords returns the prefixes of the words of text.
+//
+// Deprecated: the engine indexes the terms of its analyzer (see SemanticConfig.Language).
 func ToKeywords(text string) []string {
 	response := make([]string, 0)
 	if len(text) > 0 {
@@ -200,47 +354,60 @@ func ToKeywords(text string) []string {
 	return response
 }
 
-func buildQuery(group, text string, offset, count int, bindVars map[string]interface{}, caseSensitive bool) (string, []string) {
-	if !caseSensitive {
-		text = strings.ToLower(text)
+// recordText joins the values of fields of record
+func recordText(record map[string]interface{}, fields []string) string {
+	values := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if value, b := record[field]; b && nil != value {
+			values = append(values, qbc.Convert.ToString(value))
+		}
 	}
-	tokens := qbc.Strings.Split(text, " ,.!?") // ToKeywords(text)
-	bindVars["tokens"] = tokens
-	query := "FOR doc IN @@collection"
-	if len(group) > 0 {
-		bindVars["group"] = group
-		query += fmt.Sprintf(" FILTER doc.%s==@group AND", FLD_GROUP)
-	} else {
-		query += " FILTER"
+	return strings.Join(values, " ")
+}
+
+func paginate(items []*SemanticEngineData, offset, count int) []*SemanticEngineData {
+	if offset < 0 {
+		offset = 0
 	}
-	if !caseSensitive {
-		query += fmt.Sprintf(" @tokens ANY IN FLATTEN(TOKENS(doc.%s,  \"%s\"))", FLD_TAGS, ANALYZER_LOWER)
-	} else {
-		query += fmt.Sprintf(" doc.%s[*] ANY IN @tokens", FLD_TAGS)
+	if offset >= len(items) {
+		return make([]*SemanticEngineData, 0)
 	}
-
-	if count > 0 {
-		query += fmt.Sprintf(" LIMIT %v, %v", offset, count)
+	items = items[offset:]
+	if count > 0 && count < len(items) {
+		items = items[:count]
 	}
-	query += " RETURN doc"
-
-	return query, tokens
+	return items
 }
 
-func getScore(keywords, tags []string, caseSensitive bool) int {
-	count := 0
-	for _, k := range keywords {
-		for _, t := range tags {
-			if caseSensitive {
-				if k == t {
-					count++
-				}
-			} else {
-				if strings.ToLower(k) == strings.ToLower(t) {
-					count++
-				}
-			}
+// score returns the BM25 score of the terms of an entity and the words of query found in the entity
+func score(query []*semanticTerm, tags []string, frequencies map[string]int, stats *semanticStats) (float64, []string) {
+	tf := make(map[string]int)
+	for _, tag := range tags {
+		tf[tag]++
+	}
+	avgLength := 1.0
+	if stats.Docs > 0 && stats.Terms > 0 {
+		avgLength = float64(stats.Terms) / float64(stats.Docs)
+	}
+	length := float64(len(tags))
+	response := 0.0
+	matches := make([]string, 0)
+	scored := make(map[string]bool)
+	for _, item := range query {
+		f := float64(tf[item.Term])
+		if f == 0 {
+			continue
+		}
+		if qbc.Arrays.IndexOf(item.Word, matches) == -1 {
+			matches = append(matches, item.Word)
+		}
+		if scored[item.Term] {
+			continue
 		}
+		scored[item.Term] = true
+		df := float64(frequencies[item.Term])
+		idf := math.Log(1 + (float64(stats.Docs)-df+0.5)/(df+0.5))
+		response += idf * f * (bm25K1 + 1) / (f + bm25K1*(1-bm25B+bm25B*length/avgLength))
 	}
-	return count
+	return response, matches
 }
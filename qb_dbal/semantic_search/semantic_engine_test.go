@@ -2,12 +2,237 @@ package semantic_search_test
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/rskvp/qb-lib/qb_dbal/commons"
+	"github.com/rskvp/qb-lib/qb_dbal/drivers"
 	"github.com/rskvp/qb-lib/qb_dbal/semantic_search"
 )
 
 func TestToKeywords(t *testing.T) {
 	keywords := semantic_search.ToKeywords("hello this is a text to tokenize in keywords!!")
 	fmt.Println(keywords)
-}
\ No newline at end of file
+}
+
+func TestStemmers(t *testing.T) {
+	words := map[string]string{
+		"caresses": "caress", "ponies": "poni", "cats": "cat", "running": "run", "hopping": "hop",
+		"relational": "relat", "generalization": "gener", "happiness": "happi", "connected": "connect",
+	}
+	for word, stem := range words {
+		if s := semantic_search.StemEnglish(word); s != stem {
+			t.Error("english", word, s, "expected", stem)
+		}
+	}
+	words = map[string]string{"libri": "libr", "libro": "libr", "amiche": "amic", "rapidamente": "rapid"}
+	for word, stem := range words {
+		if s := semantic_search.StemItalian(word); s != stem {
+			t.Error("italian", word, s, "expected", stem)
+		}
+	}
+}
+
+func TestSemanticEngine(t *testing.T) {
+	// the bolt dsn does not accept absolute paths
+	wd, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	defer func() { _ = os.Chdir(wd) }()
+	configs := map[string]string{
+		"bolt":   "root:root@file:./semantic.dat",
+		"sqlite": filepath.Join(t.TempDir(), "semantic.db"),
+	}
+	for driver, dsn := range configs {
+		config := commons.NewSemanticConfig()
+		config.DbInternal.Driver = driver
+		config.DbInternal.Dsn = dsn
+		engine, err := semantic_search.NewSemanticEngine(config)
+		if nil != err {
+			t.Error(driver, err)
+			t.FailNow()
+		}
+		_ = engine.Put("books", "1", "The Lord of the Rings")
+		_ = engine.Put("books", "2", "The Hobbit, or There and Back Again")
+		_ = engine.Put("movies", "3", "The Lord of the Rings: The Return of the King")
+		_ = engine.Put("books", "2", "The Hobbit") // replaces previous tags
+
+		data, err := engine.Get("books", "lord rings", 0, 10)
+		if nil != err {
+			t.Error(driver, err)
+			t.FailNow()
+		}
+		if len(data) != 1 || data[0].Key != "1" {
+			t.Error(driver, "Unexpected result", data)
+		}
+		if data, _ = engine.Get("", "LORD king", 0, 10); len(data) != 2 || data[0].Key != "3" {
+			t.Error(driver, "Expected 2 results sorted by score", data)
+		}
+		if data, _ = engine.Get("", "lord", 1, 10); len(data) != 1 {
+			t.Error(driver, "Expected 1 result after offset", data)
+		}
+		if data, _ = engine.Get("books", "again", 0, 10); len(data) != 0 {
+			t.Error(driver, "Expected replaced tags not found", data)
+		}
+		if data, _ = engine.Get("", "lord", 0, 1); len(data) != 1 || data[0].Key != "1" {
+			t.Error(driver, "Expected shortest title first", data)
+		}
+		if len(data) > 0 && (len(data[0].Matches) != 1 || data[0].Matches[0] != "lord") {
+			t.Error(driver, "Unexpected matches", data[0].Matches)
+		}
+	}
+}
+
+func TestSemanticEngineLanguage(t *testing.T) {
+	config := commons.NewSemanticConfig()
+	config.DbInternal.Driver = "sqlite"
+	config.DbInternal.Dsn = filepath.Join(t.TempDir(), "semantic.db")
+	config.Language = "it"
+	config.StopWords = []string{"libro"}
+	engine, err := semantic_search.NewSemanticEngine(config)
+	if nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	_ = engine.Put("docs", "1", "Il libro delle città perdute")
+	_ = engine.Put("docs", "2", "Una città sul mare, una città antica")
+	_ = engine.Put("docs", "3", "Perché il mare è blu")
+
+	data, err := engine.Get("docs", "citta", 0, 10)
+	if nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	if len(data) != 2 || data[0].Key != "2" || data[0].Score <= data[1].Score {
+		t.Error("Expected accent folding and term frequency score", data)
+	}
+	if data, _ = engine.Get("docs", "blu il mari", 0, 10); len(data) != 2 || data[0].Key != "3" {
+		t.Error("Expected stemming", data)
+	} else if len(data[0].Matches) != 2 || data[0].Matches[0] != "blu" || data[0].Matches[1] != "mari" {
+		t.Error("Unexpected matches", data[0].Matches)
+	}
+	if data, _ = engine.Get("docs", "il libro", 0, 10); len(data) != 0 {
+		t.Error("Expected stop words not indexed", data)
+	}
+}
+
+func TestSemanticEngineUpgrade(t *testing.T) {
+	// the bolt dsn does not accept absolute paths
+	wd, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	defer func() { _ = os.Chdir(wd) }()
+	dsn := "root:root@file:./semantic.dat"
+
+	// entries of previous versions: keywords without postings and statistics
+	db, err := drivers.OpenDatabase("bolt", dsn)
+	if nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	_, _ = db.EnsureCollection(semantic_search.COLLECTION)
+	for i := 0; i < 150; i++ {
+		text := fmt.Sprint("Book ", i, " of the Running Rivers")
+		_, _ = db.Upsert(semantic_search.COLLECTION, map[string]interface{}{
+			"_key": fmt.Sprint("k", i), "group": "books", "key": fmt.Sprint(i), "tags": semantic_search.ToKeywords(text),
+		})
+	}
+	_ = db.(*drivers.DriverBolt).Close()
+
+	config := commons.NewSemanticConfig()
+	config.DbInternal.Driver = "bolt"
+	config.DbInternal.Dsn = dsn
+	config.Language = "en"
+	engine, err := semantic_search.NewSemanticEngine(config)
+	if nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	if data, _ := engine.Get("books", "river run", 0, 0); len(data) != 150 {
+		t.Error("Expected all entries analyzed again", len(data))
+	}
+	if data, _ := engine.Get("books", "riv", 0, 0); len(data) != 0 {
+		t.Error("Expected keyword prefixes not indexed", len(data))
+	}
+}
+
+func TestSemanticEngineMaintenance(t *testing.T) {
+	dir := t.TempDir()
+	config := commons.NewSemanticConfig()
+	config.DbInternal.Driver = "sqlite"
+	config.DbInternal.Dsn = filepath.Join(dir, "semantic.db")
+	engine, err := semantic_search.NewSemanticEngine(config)
+	if nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	err = engine.PutBatch("notes", []*semantic_search.SemanticItem{
+		{Key: "1", Text: "green apple"}, {Key: "2", Text: "red apple"}, {Key: "3", Text: "green pear"},
+	})
+	if nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	if data, _ := engine.Get("notes", "apple", 0, 0); len(data) != 2 {
+		t.Error("Expected 2 results", data)
+	}
+	if err = engine.Remove("notes", "1"); nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	if data, _ := engine.Get("notes", "apple", 0, 0); len(data) != 1 || data[0].Key != "2" {
+		t.Error("Expected removed key not found", data)
+	}
+	if err = engine.RemoveGroup("notes"); nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	if data, _ := engine.Get("notes", "green red", 0, 0); len(data) != 0 {
+		t.Error("Expected empty group", data)
+	}
+
+	// reindex from an external collection
+	source, err := drivers.OpenDatabase("sqlite", filepath.Join(dir, "source.db"))
+	if nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	_, _ = source.ExecNative("CREATE TABLE books (id VARCHAR(32) PRIMARY KEY, title TEXT, author TEXT)", nil)
+	for i := 0; i < 150; i++ {
+		_, _ = source.Upsert("books", map[string]interface{}{"id": fmt.Sprint(i), "title": fmt.Sprint("book ", i), "author": "anonymous"})
+	}
+	_, _ = source.Upsert("books", map[string]interface{}{"id": "dune", "title": "Dune", "author": "Frank Herbert"})
+	_ = engine.Put("books", "stale", "a book that is no longer in the collection")
+	calls := 0
+	progress, err := engine.Reindex("books", source, []string{"title", "author"}, func(progress *semantic_search.SemanticReindexProgress) {
+		calls++
+	})
+	if nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	if !progress.Done || progress.Total != 151 || progress.Indexed != 151 || progress.Removed != 1 || calls != 2 {
+		t.Error("Unexpected progress", progress, calls)
+	}
+	if data, _ := engine.Get("books", "herbert", 0, 0); len(data) != 1 || data[0].Key != "dune" {
+		t.Error("Expected reindexed record", data)
+	}
+	if data, _ := engine.Get("books", "longer", 0, 0); len(data) != 0 {
+		t.Error("Expected stale entry removed", data)
+	}
+
+	// long queries are sent by chunks
+	words := make([]string, 0)
+	for i := 0; i < 1200; i++ {
+		words = append(words, fmt.Sprint("word", i))
+	}
+	if data, _ := engine.Get("books", strings.Join(words, " ")+" herbert", 0, 0); len(data) != 1 || data[0].Key != "dune" {
+		t.Error("Expected result of long query", data)
+	}
+}
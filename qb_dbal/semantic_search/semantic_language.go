@@ -0,0 +1,90 @@
+package semantic_search
+
+import (
+	"strings"
+	"sync"
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//	t y p e s
+//----------------------------------------------------------------------------------------------------------------------
+
+// Stemmer reduces a lowercase word to its stem
+type Stemmer func(word string) string
+
+// SemanticLanguage is the stop words list and the stemmer of a language.
+// Languages are chosen by name with SemanticConfig.Language.
+type SemanticLanguage struct {
+	Name      string
+	StopWords []string
+	Stemmer   Stemmer
+}
+
+var (
+	languages    = make(map[string]*SemanticLanguage)
+	languagesMux sync.RWMutex
+)
+
+func init() {
+	RegisterLanguage(&SemanticLanguage{Name: "en", StopWords: stopWordsEn, Stemmer: StemEnglish})
+	RegisterLanguage(&SemanticLanguage{Name: "it", StopWords: stopWordsIt, Stemmer: StemItalian})
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	S T A T I C
+//----------------------------------------------------------------------------------------------------------------------
+
+// RegisterLanguage adds or replaces a language
+func RegisterLanguage(language *SemanticLanguage) {
+	if nil != language && len(language.Name) > 0 {
+		languagesMux.Lock()
+		defer languagesMux.Unlock()
+		languages[strings.ToLower(language.Name)] = language
+	}
+}
+
+// GetLanguage returns a registered language or nil. Names are case-insensitive and
+// regional variants fall back to the base language ("en-US" -> "en").
+func GetLanguage(name string) *SemanticLanguage {
+	name = strings.ToLower(name)
+	languagesMux.RLock()
+	defer languagesMux.RUnlock()
+	if language, b := languages[name]; b {
+		return language
+	}
+	if i := strings.IndexAny(name, "-_"); i > 0 {
+		return languages[name[:i]]
+	}
+	return nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	s t o p   w o r d s
+//----------------------------------------------------------------------------------------------------------------------
+
+var stopWordsEn = []string{
+	"a", "about", "above", "after", "again", "against", "all", "am", "an", "and", "any", "are", "as", "at",
+	"be", "because", "been", "before", "being", "below", "between", "both", "but", "by",
+	"can", "could", "did", "do", "does", "doing", "down", "during", "each", "few", "for", "from", "further",
+	"had", "has", "have", "having", "he", "her", "here", "hers", "herself", "him", "himself", "his", "how",
+	"i", "if", "in", "into", "is", "it", "its", "itself", "just", "me", "more", "most", "my", "myself",
+	"no", "nor", "not", "now", "of", "off", "on", "once", "only", "or", "other", "our", "ours", "ourselves",
+	"out", "over", "own", "same", "she", "should", "so", "some", "such",
+	"than", "that", "the", "their", "theirs", "them", "themselves", "then", "there", "these", "they",
+	"this", "those", "through", "to", "too", "under", "until", "up", "very",
+	"was", "we", "were", "what", "when", "where", "which", "while", "who", "whom", "why", "will", "with", "would",
+	"you", "your", "yours", "yourself", "yourselves",
+}
+
+var stopWordsIt = []string{
+	"a", "ad", "agli", "ai", "al", "alla", "alle", "allo", "anche", "avere", "aveva", "c", "che", "chi", "ci",
+	"coi", "col", "come", "con", "contro", "cui", "da", "dagli", "dai", "dal", "dalla", "dalle", "dallo",
+	"degli", "dei", "del", "della", "delle", "dello", "di", "dov", "dove", "e", "ed", "era", "erano", "essere",
+	"gli", "ha", "hanno", "ho", "i", "il", "in", "io", "l", "la", "le", "lei", "li", "lo", "loro", "lui",
+	"ma", "mi", "mia", "mie", "miei", "mio", "ne", "negli", "nei", "nel", "nella", "nelle", "nello", "noi",
+	"non", "nostra", "nostre", "nostri", "nostro", "o", "per", "perche", "piu", "quale", "quanta", "quante",
+	"quanti", "quanto", "quella", "quelle", "quelli", "quello", "questa", "queste", "questi", "questo",
+	"se", "sei", "si", "sia", "siamo", "siete", "sono", "sta", "su", "sua", "sue", "sugli", "sui", "sul",
+	"sulla", "sulle", "sullo", "suo", "suoi", "ti", "tra", "tu", "tua", "tue", "tuo", "tuoi", "tutti", "tutto",
+	"un", "una", "uno", "vi", "voi", "vostra", "vostre", "vostri", "vostro",
+}
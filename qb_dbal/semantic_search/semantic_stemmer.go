@@ -0,0 +1,301 @@
+package semantic_search
+
+import "strings"
+
+//----------------------------------------------------------------------------------------------------------------------
+//	E n g l i s h
+//----------------------------------------------------------------------------------------------------------------------
+
+// StemEnglish is the Porter stemmer (M.F. Porter, 1980).
+// Words with characters other than a-z are returned unchanged.
+func StemEnglish(word string) string {
+	if len(word) <= 2 {
+		return word
+	}
+	for i := 0; i < len(word); i++ {
+		if word[i] < 'a' || word[i] > 'z' {
+			return word
+		}
+	}
+	p := &porter{b: []byte(word), k: len(word) - 1}
+	p.step1ab()
+	if p.k > 0 {
+		p.step1c()
+		p.step2()
+		p.step3()
+		p.step4()
+		p.step5()
+	}
+	return string(p.b[:p.k+1])
+}
+
+// porter works on b[0:k+1]. j is the end of the stem found by ends.
+type porter struct {
+	b    []byte
+	k, j int
+}
+
+func (p *porter) cons(i int) bool {
+	switch p.b[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		if i == 0 {
+			return true
+		}
+		return !p.cons(i - 1)
+	}
+	return true
+}
+
+// m measures the number of vowel-consonant sequences in b[0:j+1]
+func (p *porter) m() int {
+	n, i := 0, 0
+	for {
+		if i > p.j {
+			return n
+		}
+		if !p.cons(i) {
+			break
+		}
+		i++
+	}
+	i++
+	for {
+		for {
+			if i > p.j {
+				return n
+			}
+			if p.cons(i) {
+				break
+			}
+			i++
+		}
+		i++
+		n++
+		for {
+			if i > p.j {
+				return n
+			}
+			if !p.cons(i) {
+				break
+			}
+			i++
+		}
+		i++
+	}
+}
+
+func (p *porter) vowelInStem() bool {
+	for i := 0; i <= p.j; i++ {
+		if !p.cons(i) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *porter) doublec(i int) bool {
+	if i < 1 || p.b[i] != p.b[i-1] {
+		return false
+	}
+	return p.cons(i)
+}
+
+// cvc is true if b[i-2:i+1] is consonant-vowel-consonant and the last consonant is not w, x or y
+func (p *porter) cvc(i int) bool {
+	if i < 2 || !p.cons(i) || p.cons(i-1) || !p.cons(i-2) {
+		return false
+	}
+	switch p.b[i] {
+	case 'w', 'x', 'y':
+		return false
+	}
+	return true
+}
+
+func (p *porter) ends(s string) bool {
+	l := len(s)
+	if l > p.k+1 || string(p.b[p.k-l+1:p.k+1]) != s {
+		return false
+	}
+	p.j = p.k - l
+	return true
+}
+
+func (p *porter) setTo(s string) {
+	p.b = append(p.b[:p.j+1], s...)
+	p.k = p.j + len(s)
+}
+
+func (p *porter) replace(s string) {
+	if p.m() > 0 {
+		p.setTo(s)
+	}
+}
+
+// step1ab removes plurals and -ed or -ing
+func (p *porter) step1ab() {
+	if p.b[p.k] == 's' {
+		if p.ends("sses") {
+			p.k -= 2
+		} else if p.ends("ies") {
+			p.setTo("i")
+		} else if p.k >= 1 && p.b[p.k-1] != 's' {
+			p.k--
+		}
+	}
+	if p.ends("eed") {
+		if p.m() > 0 {
+			p.k--
+		}
+	} else if (p.ends("ed") || p.ends("ing")) && p.vowelInStem() {
+		p.k = p.j
+		if p.ends("at") {
+			p.setTo("ate")
+		} else if p.ends("bl") {
+			p.setTo("ble")
+		} else if p.ends("iz") {
+			p.setTo("ize")
+		} else if p.doublec(p.k) {
+			p.k--
+			switch p.b[p.k] {
+			case 'l', 's', 'z':
+				p.k++
+			}
+		} else if p.m() == 1 && p.cvc(p.k) {
+			p.setTo("e")
+		}
+	}
+}
+
+// step1c turns terminal y to i when there is another vowel in the stem
+func (p *porter) step1c() {
+	if p.ends("y") && p.vowelInStem() {
+		p.b[p.k] = 'i'
+	}
+}
+
+func (p *porter) suffixes(pairs ...string) {
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if p.ends(pairs[i]) {
+			p.replace(pairs[i+1])
+			return
+		}
+	}
+}
+
+// step2 maps double suffixes to single ones
+func (p *porter) step2() {
+	switch p.b[p.k-1] {
+	case 'a':
+		p.suffixes("ational", "ate", "tional", "tion")
+	case 'c':
+		p.suffixes("enci", "ence", "anci", "ance")
+	case 'e':
+		p.suffixes("izer", "ize")
+	case 'l':
+		p.suffixes("bli", "ble", "alli", "al", "entli", "ent", "eli", "e", "ousli", "ous")
+	case 'o':
+		p.suffixes("ization", "ize", "ation", "ate", "ator", "ate")
+	case 's':
+		p.suffixes("alism", "al", "iveness", "ive", "fulness", "ful", "ousness", "ous")
+	case 't':
+		p.suffixes("aliti", "al", "iviti", "ive", "biliti", "ble")
+	case 'g':
+		p.suffixes("logi", "log")
+	}
+}
+
+// step3 deals with -ic-, -full, -ness etc.
+func (p *porter) step3() {
+	switch p.b[p.k] {
+	case 'e':
+		p.suffixes("icate", "ic", "ative", "", "alize", "al")
+	case 'i':
+		p.suffixes("iciti", "ic")
+	case 'l':
+		p.suffixes("ical", "ic", "ful", "")
+	case 's':
+		p.suffixes("ness", "")
+	}
+}
+
+// step4 removes -ant, -ence etc. in context <c>vcvc<v>
+func (p *porter) step4() {
+	found := false
+	switch p.b[p.k-1] {
+	case 'a':
+		found = p.ends("al")
+	case 'c':
+		found = p.ends("ance") || p.ends("ence")
+	case 'e':
+		found = p.ends("er")
+	case 'i':
+		found = p.ends("ic")
+	case 'l':
+		found = p.ends("able") || p.ends("ible")
+	case 'n':
+		found = p.ends("ant") || p.ends("ement") || p.ends("ment") || p.ends("ent")
+	case 'o':
+		found = (p.ends("ion") && p.j >= 0 && (p.b[p.j] == 's' || p.b[p.j] == 't')) || p.ends("ou")
+	case 's':
+		found = p.ends("ism")
+	case 't':
+		found = p.ends("ate") || p.ends("iti")
+	case 'u':
+		found = p.ends("ous")
+	case 'v':
+		found = p.ends("ive")
+	case 'z':
+		found = p.ends("ize")
+	}
+	if found && p.m() > 1 {
+		p.k = p.j
+	}
+}
+
+// step5 removes a final -e and changes -ll to -l if m() > 1
+func (p *porter) step5() {
+	p.j = p.k
+	if p.b[p.k] == 'e' {
+		a := p.m()
+		if a > 1 || a == 1 && !p.cvc(p.k-1) {
+			p.k--
+		}
+	}
+	if p.b[p.k] == 'l' && p.doublec(p.k) && p.m() > 1 {
+		p.k--
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	I t a l i a n
+//----------------------------------------------------------------------------------------------------------------------
+
+// StemItalian is a light stemmer removing adverb, plural and gender suffixes
+// ("rapidamente" -> "rapid", "libri" -> "libr", "amiche" -> "amic").
+// Words should be accent folded.
+func StemItalian(word string) string {
+	if len([]rune(word)) <= 3 {
+		return word
+	}
+	if strings.HasSuffix(word, "mente") && len(word) > 8 {
+		word = strings.TrimSuffix(word, "mente")
+	}
+	for _, suffix := range []string{"che", "chi", "ghe", "ghi"} {
+		if strings.HasSuffix(word, suffix) {
+			return word[:len(word)-2]
+		}
+	}
+	for _, suffix := range []string{"ie", "ii"} {
+		if strings.HasSuffix(word, suffix) {
+			return word[:len(word)-2]
+		}
+	}
+	switch word[len(word)-1] {
+	case 'a', 'e', 'i', 'o':
+		return word[:len(word)-1]
+	}
+	return word
+}
@@ -0,0 +1,533 @@
+package semantic_search
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	qbc "github.com/rskvp/qb-core"
+	"github.com/rskvp/qb-lib/qb_dbal/commons"
+	"github.com/rskvp/qb-lib/qb_dbal/drivers"
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//	t y p e s
+//----------------------------------------------------------------------------------------------------------------------
+
+// semanticEntry is an indexed text
+type semanticEntry struct {
+	DbKey string
+	Group string
+	Key   string
+	Tags  []string
+}
+
+// semanticFields are the physical names of the stored fields.
+// SQL tables cannot use "_key", "group" and "key" that are reserved words.
+type semanticFields struct {
+	DbKey    string
+	Group    string
+	Key      string
+	Tags     string
+	Token    string
+	EntityId string
+	Docs     string
+	Terms    string
+}
+
+var (
+	documentFields = &semanticFields{DbKey: FLD_DBKEY, Group: FLD_GROUP, Key: FLD_KEY, Tags: FLD_TAGS, Token: FLD_TOKEN, EntityId: FLD_ENTITY_ID, Docs: FLD_DOCS, Terms: FLD_TERMS}
+	sqlFields      = &semanticFields{DbKey: drivers.DefaultPrimaryKey, Group: "group_name", Key: "entity_key", Tags: FLD_TAGS, Token: FLD_TOKEN, EntityId: FLD_ENTITY_ID, Docs: FLD_DOCS, Terms: FLD_TERMS}
+)
+
+// semanticWriter is implemented by both IDatabase and ITransaction
+type semanticWriter interface {
+	Get(collection string, key string) (map[string]interface{}, error)
+	Upsert(collection string, doc map[string]interface{}) (map[string]interface{}, error)
+	Remove(collection string, key string) error
+}
+
+// semanticStats are the number of entries and the total number of terms of a group
+type semanticStats struct {
+	Docs  int64
+	Terms int64
+}
+
+// semanticStore keeps entries and an inverted index (token -> entries) on any IDatabase.
+// Each posting of the inverted index is a record (token, group, entry key): search does not need
+// any database specific full-text feature.
+// Statistics of each group are updated with entries and are used for scoring.
+type semanticStore struct {
+	db            drivers.IDatabase
+	fields        *semanticFields
+	analyzer      *semanticAnalyzer // terms of the entries of previous versions
+	caseSensitive bool
+	mux           sync.Mutex
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	c o n s t r u c t o r
+//----------------------------------------------------------------------------------------------------------------------
+
+func newSemanticStore(db drivers.IDatabase, analyzer *semanticAnalyzer) (*semanticStore, error) {
+	instance := new(semanticStore)
+	instance.db = db
+	instance.analyzer = analyzer
+	instance.caseSensitive = analyzer.caseSensitive
+	instance.fields = sqlFields
+	if isDocumentStore(db) {
+		instance.fields = documentFields
+	}
+	if err := instance.init(); nil != err {
+		return nil, err
+	}
+	return instance, nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p u b l i c
+//----------------------------------------------------------------------------------------------------------------------
+
+// Put stores the entry replacing its postings
+func (instance *semanticStore) Put(entry *semanticEntry) error {
+	instance.mux.Lock()
+	defer instance.mux.Unlock()
+	return instance.update(func(w semanticWriter) error {
+		if err := instance.remove(w, entry.DbKey); nil != err {
+			return err
+		}
+		return instance.write(w, entry)
+	})
+}
+
+// PutBatch stores entries into a single transaction
+func (instance *semanticStore) PutBatch(entries []*semanticEntry) error {
+	instance.mux.Lock()
+	defer instance.mux.Unlock()
+	return instance.update(func(w semanticWriter) error {
+		for _, entry := range entries {
+			if err := instance.remove(w, entry.DbKey); nil != err {
+				return err
+			}
+			if err := instance.write(w, entry); nil != err {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Remove deletes the entries and their postings
+func (instance *semanticStore) Remove(dbKeys ...string) error {
+	instance.mux.Lock()
+	defer instance.mux.Unlock()
+	return instance.update(func(w semanticWriter) error {
+		for _, dbKey := range dbKeys {
+			if err := instance.remove(w, dbKey); nil != err {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Keys returns the keys of the entries of group: entry key -> db key
+func (instance *semanticStore) Keys(group string) (map[string]string, error) {
+	records, err := instance.db.Query(COLLECTION, commons.NewQuery().Where(instance.fields.Group, commons.QueryEqual, group))
+	if nil != err {
+		return nil, err
+	}
+	response := make(map[string]string)
+	for _, record := range records {
+		entry := instance.fromRecord(record)
+		response[entry.Key] = entry.DbKey
+	}
+	return response, nil
+}
+
+// Find returns the entries having at least one of tokens and the number of entries containing each token
+func (instance *semanticStore) Find(group string, tokens []string) ([]*semanticEntry, map[string]int, error) {
+	frequencies := make(map[string]int)
+	tokens = instance.tokens(tokens)
+	if len(tokens) == 0 {
+		return []*semanticEntry{}, frequencies, nil
+	}
+	postings, err := instance.postings(group, tokens)
+	if nil != err {
+		return nil, nil, err
+	}
+	keys := make([]string, 0)
+	found := make(map[string]bool)
+	for _, posting := range postings {
+		frequencies[qbc.Convert.ToString(posting[instance.fields.Token])]++
+		key := qbc.Convert.ToString(posting[instance.fields.EntityId])
+		if !found[key] {
+			found[key] = true
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return []*semanticEntry{}, frequencies, nil
+	}
+	records, err := instance.records(keys)
+	if nil != err {
+		return nil, nil, err
+	}
+	response := make([]*semanticEntry, 0, len(records))
+	for _, record := range records {
+		response = append(response, instance.fromRecord(record))
+	}
+	return response, frequencies, nil
+}
+
+// Stats returns the statistics of group, or of all groups if group is empty
+func (instance *semanticStore) Stats(group string) (*semanticStats, error) {
+	response := new(semanticStats)
+	if len(group) > 0 {
+		record, err := instance.db.Get(COLLECTION_STATS, statsKey(group))
+		if nil != err {
+			return nil, err
+		}
+		instance.addStats(response, record)
+		return response, nil
+	}
+	err := instance.db.ForEach(COLLECTION_STATS, func(record map[string]interface{}) bool {
+		instance.addStats(response, record)
+		return false
+	})
+	return response, err
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p r i v a t e
+//----------------------------------------------------------------------------------------------------------------------
+
+func (instance *semanticStore) init() error {
+	if isDocumentStore(instance.db) {
+		for _, name := range []string{COLLECTION, COLLECTION_TOKENS, COLLECTION_STATS} {
+			if _, err := instance.db.EnsureCollection(name); nil != err {
+				return err
+			}
+		}
+		_, _ = instance.db.EnsureIndex(COLLECTION_TOKENS, "persist", []string{instance.fields.Token}, false)
+	} else {
+		f := instance.fields
+		err := instance.ensureTable(COLLECTION,
+			fmt.Sprintf("CREATE TABLE %s (%s VARCHAR(32) NOT NULL PRIMARY KEY, %s VARCHAR(255), %s VARCHAR(255), %s TEXT)",
+				COLLECTION, f.DbKey, f.Group, f.Key, f.Tags))
+		if nil != err {
+			return err
+		}
+		err = instance.ensureTable(COLLECTION_TOKENS,
+			fmt.Sprintf("CREATE TABLE %s (%s VARCHAR(32) NOT NULL PRIMARY KEY, %s VARCHAR(255), %s VARCHAR(255), %s VARCHAR(32))",
+				COLLECTION_TOKENS, f.DbKey, f.Token, f.Group, f.EntityId),
+			fmt.Sprintf("CREATE INDEX idx_%s_%s ON %s (%s)", COLLECTION_TOKENS, f.Token, COLLECTION_TOKENS, f.Token))
+		if nil != err {
+			return err
+		}
+		err = instance.ensureTable(COLLECTION_STATS,
+			fmt.Sprintf("CREATE TABLE %s (%s VARCHAR(32) NOT NULL PRIMARY KEY, %s VARCHAR(255), %s BIGINT, %s BIGINT)",
+				COLLECTION_STATS, f.DbKey, f.Group, f.Docs, f.Terms))
+		if nil != err {
+			return err
+		}
+	}
+	return instance.upgrade()
+}
+
+// upgrade builds the postings and the statistics of entries stored by previous versions.
+// Entries are read by pages after the last key (writing while iterating could lock Bolt)
+// and each page is upgraded into a single transaction.
+func (instance *semanticStore) upgrade() error {
+	postings, err := instance.db.Query(COLLECTION_TOKENS, commons.NewQuery().Paginate(0, 1))
+	if nil != err {
+		return err
+	}
+	stats, err := instance.db.Query(COLLECTION_STATS, commons.NewQuery().Paginate(0, 1))
+	if nil != err || len(stats) > 0 {
+		return err
+	}
+	query := commons.NewQuery().OrderBy(instance.fields.DbKey).Paginate(0, reindexBatchSize)
+	for {
+		records, err := instance.db.Query(COLLECTION, query)
+		if nil != err {
+			return err
+		}
+		err = instance.update(func(w semanticWriter) error {
+			for _, record := range records {
+				entry := instance.fromRecord(record)
+				if len(postings) > 0 {
+					// postings are there, only the statistics are missing
+					if err := instance.updateStats(w, entry.Group, 1, int64(len(entry.Tags))); nil != err {
+						return err
+					}
+					continue
+				}
+				// tags are the keywords of the text, not the terms of the analyzer
+				entry.Tags = instance.analyzer.Terms(keywordsText(entry.Tags))
+				if err := instance.write(w, entry); nil != err {
+					return err
+				}
+			}
+			return nil
+		})
+		if nil != err || len(records) < reindexBatchSize {
+			return err
+		}
+		query = commons.NewQuery().Where(instance.fields.DbKey, commons.QueryGreater, records[len(records)-1][instance.fields.DbKey]).
+			OrderBy(instance.fields.DbKey).Paginate(0, reindexBatchSize)
+	}
+}
+
+// postings returns the postings of tokens. Bolt queries scan the whole collection: each token is
+// looked up with the index on token. Other databases are queried by chunks of tokens.
+func (instance *semanticStore) postings(group string, tokens []string) ([]map[string]interface{}, error) {
+	response := make([]map[string]interface{}, 0)
+	if instance.db.DriverName() == drivers.NameBolt {
+		for _, token := range tokens {
+			items, err := instance.db.Find(COLLECTION_TOKENS, instance.fields.Token, token)
+			if nil != err {
+				return nil, err
+			}
+			list, _ := items.([]interface{})
+			for _, item := range list {
+				posting, b := item.(map[string]interface{})
+				if b && (len(group) == 0 || qbc.Convert.ToString(posting[instance.fields.Group]) == group) {
+					response = append(response, posting)
+				}
+			}
+		}
+		return response, nil
+	}
+	for _, chunk := range chunks(tokens) {
+		query := commons.NewQuery().Where(instance.fields.Token, commons.QueryIn, chunk)
+		if len(group) > 0 {
+			query.Where(instance.fields.Group, commons.QueryEqual, group)
+		}
+		postings, err := instance.db.Query(COLLECTION_TOKENS, query)
+		if nil != err {
+			return nil, err
+		}
+		response = append(response, postings...)
+	}
+	return response, nil
+}
+
+// records returns the entries with dbKeys: by key on Bolt, by chunks of keys on other databases
+func (instance *semanticStore) records(dbKeys []string) ([]map[string]interface{}, error) {
+	response := make([]map[string]interface{}, 0, len(dbKeys))
+	if instance.db.DriverName() == drivers.NameBolt {
+		for _, dbKey := range dbKeys {
+			record, err := instance.db.Get(COLLECTION, dbKey)
+			if nil != err {
+				return nil, err
+			}
+			if len(record) > 0 {
+				response = append(response, record)
+			}
+		}
+		return response, nil
+	}
+	for _, chunk := range chunks(dbKeys) {
+		records, err := instance.db.Query(COLLECTION, commons.NewQuery().Where(instance.fields.DbKey, commons.QueryIn, chunk))
+		if nil != err {
+			return nil, err
+		}
+		response = append(response, records...)
+	}
+	return response, nil
+}
+
+func (instance *semanticStore) ensureTable(name string, commands ...string) error {
+	if _, err := instance.db.Query(name, commons.NewQuery().Paginate(0, 1)); nil == err {
+		return nil // already exists
+	}
+	for _, command := range commands {
+		if _, err := instance.db.ExecNative(command, nil); nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+// update runs callback into a transaction, if the database supports it
+func (instance *semanticStore) update(callback func(w semanticWriter) error) error {
+	options := commons.NewTransactionOptions().WriteTo(COLLECTION, COLLECTION_TOKENS, COLLECTION_STATS)
+	err := instance.db.WithTransaction(options, func(tx drivers.ITransaction) error {
+		return callback(tx)
+	})
+	if errors.Is(err, commons.ErrorTransactionNotSupported) {
+		return callback(instance.db)
+	}
+	return err
+}
+
+func (instance *semanticStore) write(w semanticWriter, entry *semanticEntry) error {
+	if _, err := w.Upsert(COLLECTION, instance.toRecord(entry)); nil != err {
+		return err
+	}
+	for _, token := range instance.tokens(entry.Tags) {
+		if _, err := w.Upsert(COLLECTION_TOKENS, instance.toPosting(entry, token)); nil != err {
+			return err
+		}
+	}
+	return instance.updateStats(w, entry.Group, 1, int64(len(entry.Tags)))
+}
+
+func (instance *semanticStore) remove(w semanticWriter, dbKey string) error {
+	record, err := w.Get(COLLECTION, dbKey)
+	if nil != err || len(record) == 0 {
+		return err
+	}
+	entry := instance.fromRecord(record)
+	for _, token := range instance.tokens(entry.Tags) {
+		if err = w.Remove(COLLECTION_TOKENS, postingKey(entry.DbKey, token)); nil != err {
+			return err
+		}
+	}
+	if err = w.Remove(COLLECTION, dbKey); nil != err {
+		return err
+	}
+	return instance.updateStats(w, entry.Group, -1, -int64(len(entry.Tags)))
+}
+
+func (instance *semanticStore) updateStats(w semanticWriter, group string, docs, terms int64) error {
+	record, err := w.Get(COLLECTION_STATS, statsKey(group))
+	if nil != err {
+		return err
+	}
+	stats := new(semanticStats)
+	instance.addStats(stats, record)
+	_, err = w.Upsert(COLLECTION_STATS, map[string]interface{}{
+		instance.fields.DbKey: statsKey(group),
+		instance.fields.Group: group,
+		instance.fields.Docs:  max64(0, stats.Docs+docs),
+		instance.fields.Terms: max64(0, stats.Terms+terms),
+	})
+	return err
+}
+
+func (instance *semanticStore) addStats(stats *semanticStats, record map[string]interface{}) {
+	if len(record) > 0 {
+		stats.Docs += toInt64(record[instance.fields.Docs])
+		stats.Terms += toInt64(record[instance.fields.Terms])
+	}
+}
+
+// tokens returns the distinct index tokens of tags
+func (instance *semanticStore) tokens(tags []string) []string {
+	response := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if !instance.caseSensitive {
+			tag = strings.ToLower(tag)
+		}
+		if len(tag) > 0 && qbc.Arrays.IndexOf(tag, response) == -1 {
+			response = append(response, tag)
+		}
+	}
+	return response
+}
+
+func (instance *semanticStore) toRecord(entry *semanticEntry) map[string]interface{} {
+	return map[string]interface{}{
+		instance.fields.DbKey: entry.DbKey,
+		instance.fields.Group: entry.Group,
+		instance.fields.Key:   entry.Key,
+		instance.fields.Tags:  entry.Tags, // JSON text in SQL tables
+	}
+}
+
+func (instance *semanticStore) toPosting(entry *semanticEntry, token string) map[string]interface{} {
+	return map[string]interface{}{
+		instance.fields.DbKey:    postingKey(entry.DbKey, token),
+		instance.fields.Token:    token,
+		instance.fields.Group:    entry.Group,
+		instance.fields.EntityId: entry.DbKey,
+	}
+}
+
+func (instance *semanticStore) fromRecord(record map[string]interface{}) *semanticEntry {
+	entry := new(semanticEntry)
+	entry.DbKey = qbc.Convert.ToString(record[instance.fields.DbKey])
+	entry.Group = qbc.Convert.ToString(record[instance.fields.Group])
+	entry.Key = qbc.Convert.ToString(record[instance.fields.Key])
+	switch tags := record[instance.fields.Tags].(type) {
+	case string:
+		_ = json.Unmarshal([]byte(tags), &entry.Tags)
+	case []byte:
+		_ = json.Unmarshal(tags, &entry.Tags)
+	case []string:
+		entry.Tags = tags
+	case []interface{}:
+		for _, tag := range tags {
+			entry.Tags = append(entry.Tags, qbc.Convert.ToString(tag))
+		}
+	}
+	if nil == entry.Tags {
+		entry.Tags = make([]string, 0)
+	}
+	return entry
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	S T A T I C
+//----------------------------------------------------------------------------------------------------------------------
+
+func postingKey(dbKey, token string) string {
+	return qbc.Coding.MD5(dbKey + "|" + token)
+}
+
+// keywordsText rebuilds the words of keywords returned by ToKeywords: each word is followed by its prefixes
+func keywordsText(keywords []string) string {
+	words := make([]string, 0, len(keywords))
+	previous := ""
+	for _, keyword := range keywords {
+		if len(keyword) >= len(previous) || !strings.HasPrefix(previous, keyword) {
+			words = append(words, keyword)
+		}
+		previous = keyword
+	}
+	return strings.Join(words, " ")
+}
+
+func statsKey(group string) string {
+	return qbc.Coding.MD5("stats|" + group)
+}
+
+// toInt64 converts a numeric field. Some sql drivers scan numbers as *interface{}.
+func toInt64(value interface{}) int64 {
+	if p, b := value.(*interface{}); b {
+		if nil == p {
+			return 0
+		}
+		value = *p
+	}
+	return qbc.Convert.ToInt64(value)
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// chunks splits the values of IN conditions: SQLite accepts 999 parameters before version 3.32
+func chunks(values []string) [][]string {
+	response := make([][]string, 0, len(values)/queryChunkSize+1)
+	for len(values) > queryChunkSize {
+		response = append(response, values[:queryChunkSize])
+		values = values[queryChunkSize:]
+	}
+	return append(response, values)
+}
+
+func isDocumentStore(db drivers.IDatabase) bool {
+	switch db.DriverName() {
+	case drivers.NameArango, drivers.NameBolt:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,466 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	qbc "github.com/rskvp/qb-core"
+	"github.com/rskvp/qb-core/qb_ticker"
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//	c o n s t
+//----------------------------------------------------------------------------------------------------------------------
+
+const (
+	KeyECDSA = "ecdsa" // P-256
+	KeyRSA   = "rsa"   // 2048 bits
+
+	caCertFile = "ca.pem"
+	caKeyFile  = "ca.key"
+)
+
+var (
+	ErrCANotOpen      = errors.New("ca_not_open")
+	ErrMissingHosts   = errors.New("missing_hosts")
+	ErrInvalidPem     = errors.New("invalid_pem")
+	ErrUnsupportedKey = errors.New("unsupported_key")
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//	t y p e s
+//----------------------------------------------------------------------------------------------------------------------
+
+type LocalCAConfig struct {
+	// Directory of the root certificate (ca.pem) and key (ca.key), created if missing
+	Dir string `json:"dir"` // default: "./ca"
+	// Organization of the root and the issued certificates
+	Organization string `json:"organization"` // default: "qb-lib local CA"
+	// Key of the issued certificates: "ecdsa" or "rsa". The root always uses ecdsa
+	KeyType string `json:"key_type"` // default: "ecdsa"
+	// Validity of the root certificate (days)
+	RootValidityDays int `json:"root_validity_days"` // default: 3650
+	// Validity of the issued certificates (days)
+	ValidityDays int `json:"validity_days"` // default: 90
+	// Issued certificates are renewed when they expire within this period (days)
+	RenewBeforeDays int `json:"renew_before_days"` // default: 30
+	// Interval of the renewal check (milliseconds)
+	CheckInterval time.Duration `json:"check_interval"` // default: 3600000
+}
+
+// LocalCA is a private certificate authority issuing certificates for internal hostnames and IPs.
+// Clients trust the issued certificates adding the root (RootPem) to their pool.
+type LocalCA struct {
+
+	//-- private --//
+	config  *LocalCAConfig
+	cert    *x509.Certificate
+	key     crypto.Signer
+	certPem []byte
+	leaves  map[string]*leaf // cert file -> leaf kept renewed
+	ticker  *qb_ticker.Ticker
+	onRenew func(certFile string, err error)
+	mux     sync.Mutex
+}
+
+type leaf struct {
+	certFile string
+	keyFile  string
+	hosts    []string
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	c o n s t r u c t o r
+//----------------------------------------------------------------------------------------------------------------------
+
+func NewLocalCAConfig() *LocalCAConfig {
+	instance := new(LocalCAConfig)
+	instance.Dir = "./ca"
+	instance.Organization = "qb-lib local CA"
+	instance.KeyType = KeyECDSA
+	instance.RootValidityDays = 3650
+	instance.ValidityDays = 90
+	instance.RenewBeforeDays = 30
+	instance.CheckInterval = 3600000
+	return instance
+}
+
+// NewLocalCA creates the CA. Zero fields of config get the defaults of NewLocalCAConfig.
+func NewLocalCA(config *LocalCAConfig) *LocalCA {
+	defaults := NewLocalCAConfig()
+	if nil == config {
+		config = defaults
+	} else {
+		c := *config
+		config = &c
+		if len(config.Dir) == 0 {
+			config.Dir = defaults.Dir
+		}
+		if len(config.Organization) == 0 {
+			config.Organization = defaults.Organization
+		}
+		if len(config.KeyType) == 0 {
+			config.KeyType = defaults.KeyType
+		}
+		if config.RootValidityDays <= 0 {
+			config.RootValidityDays = defaults.RootValidityDays
+		}
+		if config.ValidityDays <= 0 {
+			config.ValidityDays = defaults.ValidityDays
+		}
+		if config.RenewBeforeDays <= 0 {
+			config.RenewBeforeDays = defaults.RenewBeforeDays
+		}
+		if config.CheckInterval <= 0 {
+			config.CheckInterval = defaults.CheckInterval
+		}
+	}
+	instance := new(LocalCA)
+	instance.config = config
+	instance.leaves = make(map[string]*leaf)
+	return instance
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p u b l i c
+//----------------------------------------------------------------------------------------------------------------------
+
+// Open loads the root from the CA directory, or creates it
+func (instance *LocalCA) Open() error {
+	instance.mux.Lock()
+	defer instance.mux.Unlock()
+	if nil != instance.cert {
+		return nil
+	}
+	certFile := filepath.Join(instance.config.Dir, caCertFile)
+	keyFile := filepath.Join(instance.config.Dir, caKeyFile)
+	if b, _ := qbc.Paths.Exists(certFile); !b {
+		if err := instance.createRoot(certFile, keyFile); nil != err {
+			return err
+		}
+	}
+	cert, certPem, err := readCert(certFile)
+	if nil != err {
+		return err
+	}
+	key, err := readKey(keyFile)
+	if nil != err {
+		return err
+	}
+	instance.cert, instance.certPem, instance.key = cert, certPem, key
+	return nil
+}
+
+// RootPem returns the root certificate to add to the pools of the clients
+func (instance *LocalCA) RootPem() []byte {
+	return instance.certPem
+}
+
+// RootPool returns a pool trusting the root certificate
+func (instance *LocalCA) RootPool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	if nil != instance.cert {
+		pool.AddCert(instance.cert)
+	}
+	return pool
+}
+
+// Issue creates a certificate for hosts (names and IPs) signed by the root. Returns certificate and key in PEM format.
+func (instance *LocalCA) Issue(hosts ...string) ([]byte, []byte, error) {
+	if nil == instance.cert {
+		return nil, nil, ErrCANotOpen
+	}
+	if len(hosts) == 0 {
+		return nil, nil, ErrMissingHosts
+	}
+	key, err := generateKey(instance.config.KeyType)
+	if nil != err {
+		return nil, nil, err
+	}
+	serial, err := serialNumber()
+	if nil != err {
+		return nil, nil, err
+	}
+	notAfter := time.Now().Add(time.Duration(instance.config.ValidityDays) * 24 * time.Hour)
+	if notAfter.After(instance.cert.NotAfter) {
+		notAfter = instance.cert.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{instance.config.Organization},
+			CommonName:   hosts[0],
+		},
+		NotBefore:             time.Now().Add(-1 * time.Hour), // tolerate clock skew
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	if _, b := key.(*rsa.PrivateKey); b {
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); nil != ip {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, instance.cert, key.Public(), instance.key)
+	if nil != err {
+		return nil, nil, err
+	}
+	keyPem, err := encodeKey(key)
+	if nil != err {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), keyPem, nil
+}
+
+// Ensure issues a certificate for hosts into certFile and keyFile if they are missing, not issued by the CA,
+// about to expire or for different hosts. The certificate is kept renewed while the CA is started.
+// Returns true if the files have been written.
+func (instance *LocalCA) Ensure(certFile, keyFile string, hosts ...string) (bool, error) {
+	if len(hosts) == 0 {
+		return false, ErrMissingHosts
+	}
+	instance.mux.Lock()
+	instance.leaves[certFile] = &leaf{certFile: certFile, keyFile: keyFile, hosts: hosts}
+	instance.mux.Unlock()
+	return instance.ensure(certFile, keyFile, hosts)
+}
+
+// NeedsRenewal returns true if the certificate in certFile must be issued again for hosts
+func (instance *LocalCA) NeedsRenewal(certFile string, hosts ...string) bool {
+	cert, _, err := readCert(certFile)
+	if nil != err || nil == instance.cert {
+		return true
+	}
+	if _, err = cert.Verify(x509.VerifyOptions{Roots: instance.RootPool()}); nil != err {
+		return true
+	}
+	renewBefore := time.Duration(instance.config.RenewBeforeDays) * 24 * time.Hour
+	if time.Now().Add(renewBefore).After(cert.NotAfter) {
+		return true
+	}
+	return !sameHosts(cert, hosts)
+}
+
+// Renew issues again the certificates of Ensure that need renewal
+func (instance *LocalCA) Renew() []error {
+	instance.mux.Lock()
+	leaves := make([]*leaf, 0, len(instance.leaves))
+	for _, l := range instance.leaves {
+		leaves = append(leaves, l)
+	}
+	callback := instance.onRenew
+	instance.mux.Unlock()
+
+	errs := make([]error, 0)
+	for _, l := range leaves {
+		renewed, err := instance.ensure(l.certFile, l.keyFile, l.hosts)
+		if nil != err {
+			errs = append(errs, err)
+		}
+		if (renewed || nil != err) && nil != callback {
+			callback(l.certFile, err)
+		}
+	}
+	return errs
+}
+
+// OnRenew sets a callback receiving the certificates renewed (or failing renewal) by the periodic check
+func (instance *LocalCA) OnRenew(callback func(certFile string, err error)) *LocalCA {
+	instance.mux.Lock()
+	defer instance.mux.Unlock()
+	instance.onRenew = callback
+	return instance
+}
+
+// Start checks the certificates of Ensure every CheckInterval
+func (instance *LocalCA) Start() {
+	instance.mux.Lock()
+	defer instance.mux.Unlock()
+	if nil == instance.ticker {
+		instance.ticker = qb_ticker.NewTicker(instance.config.CheckInterval*time.Millisecond, func(t *qb_ticker.Ticker) {
+			instance.Renew()
+		})
+		instance.ticker.Start()
+	}
+}
+
+func (instance *LocalCA) Stop() {
+	instance.mux.Lock()
+	defer instance.mux.Unlock()
+	if nil != instance.ticker {
+		instance.ticker.Stop()
+		instance.ticker = nil
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p r i v a t e
+//----------------------------------------------------------------------------------------------------------------------
+
+func (instance *LocalCA) createRoot(certFile, keyFile string) error {
+	key, err := generateKey(KeyECDSA)
+	if nil != err {
+		return err
+	}
+	serial, err := serialNumber()
+	if nil != err {
+		return err
+	}
+	publicDer, err := x509.MarshalPKIXPublicKey(key.Public())
+	if nil != err {
+		return err
+	}
+	keyId := sha1.Sum(publicDer)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{instance.config.Organization},
+			CommonName:   instance.config.Organization,
+		},
+		NotBefore:             time.Now().Add(-1 * time.Hour),
+		NotAfter:              time.Now().Add(time.Duration(instance.config.RootValidityDays) * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+		SubjectKeyId:          keyId[:],
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if nil != err {
+		return err
+	}
+	keyPem, err := encodeKey(key)
+	if nil != err {
+		return err
+	}
+	return writeFiles(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), keyFile, keyPem)
+}
+
+func (instance *LocalCA) ensure(certFile, keyFile string, hosts []string) (bool, error) {
+	if b, _ := qbc.Paths.Exists(keyFile); b && !instance.NeedsRenewal(certFile, hosts...) {
+		return false, nil
+	}
+	certPem, keyPem, err := instance.Issue(hosts...)
+	if nil != err {
+		return false, err
+	}
+	return true, writeFiles(certFile, certPem, keyFile, keyPem)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	S T A T I C
+//----------------------------------------------------------------------------------------------------------------------
+
+func generateKey(keyType string) (crypto.Signer, error) {
+	switch strings.ToLower(keyType) {
+	case KeyRSA:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case KeyECDSA, "":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		return nil, ErrUnsupportedKey
+	}
+}
+
+func serialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func encodeKey(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if nil != err {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func readCert(filename string) (*x509.Certificate, []byte, error) {
+	data, err := os.ReadFile(filename)
+	if nil != err {
+		return nil, nil, err
+	}
+	block, _ := pem.Decode(data)
+	if nil == block || block.Type != "CERTIFICATE" {
+		return nil, nil, ErrInvalidPem
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	return cert, data, err
+}
+
+func readKey(filename string) (crypto.Signer, error) {
+	data, err := os.ReadFile(filename)
+	if nil != err {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if nil == block {
+		return nil, ErrInvalidPem
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if nil != err {
+		return nil, err
+	}
+	if signer, b := key.(crypto.Signer); b {
+		return signer, nil
+	}
+	return nil, ErrUnsupportedKey
+}
+
+// writeFiles replaces key and certificate. Each file is renamed at once: readers never get a partial file
+func writeFiles(certFile string, certPem []byte, keyFile string, keyPem []byte) error {
+	if err := writeFile(keyFile, keyPem, 0600); nil != err {
+		return err
+	}
+	return writeFile(certFile, certPem, 0644)
+}
+
+func writeFile(filename string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(filename), os.ModePerm); nil != err {
+		return err
+	}
+	tmp := filename + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); nil != err {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+func sameHosts(cert *x509.Certificate, hosts []string) bool {
+	current := make([]string, 0, len(cert.DNSNames)+len(cert.IPAddresses))
+	current = append(current, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		current = append(current, ip.String())
+	}
+	expected := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); nil != ip {
+			host = ip.String()
+		}
+		expected = append(expected, host)
+	}
+	sort.Strings(current)
+	sort.Strings(expected)
+	return strings.Join(current, ",") == strings.Join(expected, ",")
+}
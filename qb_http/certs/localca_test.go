@@ -0,0 +1,75 @@
+package certs_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rskvp/qb-lib/qb_http/certs"
+)
+
+func TestLocalCA(t *testing.T) {
+	dir := t.TempDir()
+	ca := certs.NewLocalCA(&certs.LocalCAConfig{Dir: dir, KeyType: certs.KeyRSA})
+	if err := ca.Open(); nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	// the root is loaded on next open
+	again := certs.NewLocalCA(&certs.LocalCAConfig{Dir: dir})
+	if err := again.Open(); nil != err || string(again.RootPem()) != string(ca.RootPem()) {
+		t.Error("Expected the same root", err)
+	}
+
+	certPem, keyPem, err := ca.Issue("api.internal", "10.0.0.5")
+	if nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	if _, err = tls.X509KeyPair(certPem, keyPem); nil != err {
+		t.Error("Invalid key pair", err)
+	}
+	block, _ := pem.Decode(certPem)
+	cert, _ := x509.ParseCertificate(block.Bytes)
+	if _, err = cert.Verify(x509.VerifyOptions{Roots: again.RootPool(), DNSName: "api.internal"}); nil != err {
+		t.Error("Expected a certificate trusted by the root", err)
+	}
+	if len(cert.IPAddresses) != 1 || cert.IPAddresses[0].String() != "10.0.0.5" {
+		t.Error("Missing IP address", cert.IPAddresses)
+	}
+	if _, b := cert.PublicKey.(interface{ Size() int }); !b {
+		t.Error("Expected an RSA key")
+	}
+
+	certFile, keyFile := filepath.Join(dir, "leaf.pem"), filepath.Join(dir, "leaf.key")
+	if renewed, err := ca.Ensure(certFile, keyFile, "api.internal"); nil != err || !renewed {
+		t.Error("Expected a new certificate", renewed, err)
+	}
+	if renewed, err := ca.Ensure(certFile, keyFile, "api.internal"); nil != err || renewed {
+		t.Error("Expected the certificate kept", renewed, err)
+	}
+	if !ca.NeedsRenewal(certFile, "api.internal", "web.internal") {
+		t.Error("Expected renewal for different hosts")
+	}
+
+	// a certificate expiring within the renewal period is issued again
+	short := certs.NewLocalCA(&certs.LocalCAConfig{Dir: dir, ValidityDays: 10, RenewBeforeDays: 100})
+	_ = short.Open()
+	if !short.NeedsRenewal(certFile, "api.internal") {
+		t.Error("Expected renewal of a certificate valid for 90 days")
+	}
+	if renewed, err := short.Ensure(certFile, keyFile, "api.internal"); nil != err || !renewed {
+		t.Error("Expected a renewed certificate", renewed, err)
+	}
+	// valid for 10 days, within the renewal period: renewed on each check
+	data, _ := os.ReadFile(certFile)
+	if errs := short.Renew(); len(errs) > 0 {
+		t.Error(errs)
+	}
+	if renewed, _ := os.ReadFile(certFile); string(renewed) == string(data) {
+		t.Error("Expected a renewed certificate")
+	}
+}
@@ -3,12 +3,11 @@ package client
 import (
 	"bytes"
 	"io"
-	"io/ioutil"
-	"mime/multipart"
 	"net/http"
+	"net/http/cookiejar"
+	"net/url"
 	"os"
 	"path/filepath"
-	"strings"
 	"time"
 
 	qbc "github.com/rskvp/qb-core"
@@ -25,22 +24,39 @@ const methodPost = "POST"
 const methodPut = "PUT"
 const methodDelete = "DELETE"
 
+const dialTimeout = 30 * time.Second
+
 //----------------------------------------------------------------------------------------------------------------------
 //	t y p e s
 //----------------------------------------------------------------------------------------------------------------------
 
 type HttpClient struct {
-	client *fasthttp.Client
-	header map[string]string
+	client  *fasthttp.Client
+	header  map[string]string
+	options *HttpClientOptions
+	breaker *circuitBreaker
+	jar     http.CookieJar
 }
 
 //----------------------------------------------------------------------------------------------------------------------
 //	c o n s t r u c t o r
 //----------------------------------------------------------------------------------------------------------------------
 
-func NewHttpClient() *HttpClient {
+// NewHttpClient creates a client. Without options requests are sent once, with no retry, no circuit breaker
+// and redirects are not followed.
+func NewHttpClient(options ...*HttpClientOptions) *HttpClient {
 	instance := new(HttpClient)
 	instance.header = make(map[string]string)
+	instance.options = new(HttpClientOptions)
+	if len(options) > 0 && nil != options[0] {
+		instance.options = options[0]
+	}
+	if nil != instance.options.CircuitBreaker && instance.options.CircuitBreaker.FailureThreshold > 0 {
+		instance.breaker = newCircuitBreaker(instance.options.CircuitBreaker)
+	}
+	if instance.options.Cookies {
+		instance.jar, _ = cookiejar.New(nil)
+	}
 
 	return instance
 }
@@ -61,6 +77,23 @@ func (instance *HttpClient) RemoveHeader(key string) {
 	}
 }
 
+// Cookies returns the cookies stored for url. Cookies are kept only if enabled with options.
+func (instance *HttpClient) Cookies(rawUrl string) []*http.Cookie {
+	if nil != instance && nil != instance.jar {
+		if u, err := url.Parse(rawUrl); nil == err {
+			return instance.jar.Cookies(u)
+		}
+	}
+	return nil
+}
+
+// ClearCookies removes all the stored cookies
+func (instance *HttpClient) ClearCookies() {
+	if nil != instance && nil != instance.jar {
+		instance.jar, _ = cookiejar.New(nil)
+	}
+}
+
 func (instance *HttpClient) Get(url string) (*httputils.ResponseData, error) {
 	// return instance.GetTimeout(url, time.Second*15)
 	return instance.do(methodGet, url, nil, time.Second*15)
@@ -113,69 +146,81 @@ func (instance *HttpClient) UploadTimeout(url string, filename, optParamName str
 		optParamName = "file"
 	}
 
-	// open file
 	file, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
-
-	payload := &bytes.Buffer{}
-	writer := multipart.NewWriter(payload)
-	part, err := writer.CreateFormFile(optParamName, filepath.Base(filename))
-	if err != nil {
-		return nil, err
-	}
-	_, err = io.Copy(part, file)
-	if err != nil {
-		return nil, err
-	}
-
-	for key, val := range optParams {
-		_ = writer.WriteField(key, qbc.Convert.ToString(val))
-	}
-	err = writer.Close()
-	if err != nil {
-		return nil, err
-	}
-
-	req, err := http.NewRequest("POST", url, payload)
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-	if nil != instance.header {
-		// fmt.Println(req.Header.String())
-		for k, v := range instance.header {
-			req.Header.Set(k, v)
-		}
-	}
-
-	client := &http.Client{}
-	client.Timeout = timeout
-	resp, err := client.Do(req)
-	if nil != err {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	response := httputils.NewResponseDataEmpty()
-	response.Body, _ = ioutil.ReadAll(resp.Body)
-	response.StatusCode = resp.StatusCode
-	response.Header = httputils.HttpHeaderToMap(resp.Header)
-	return response, nil
+	return instance.UploadFilesTimeout(url, []*MultipartFile{{
+		FieldName: optParamName,
+		FileName:  filepath.Base(filename),
+		Reader:    file,
+	}}, optParams, timeout)
 }
 
 //----------------------------------------------------------------------------------------------------------------------
 //	p r i v a t e
 //----------------------------------------------------------------------------------------------------------------------
 
-func (instance *HttpClient) init() *fasthttp.Client {
+func (instance *HttpClient) init() (*fasthttp.Client, error) {
 	if nil == instance.client {
-		instance.client = new(fasthttp.Client)
-		instance.client.Name = "qb_http_client/1.0"
+		client := new(fasthttp.Client)
+		client.Name = "qb_http_client/1.0"
+		if nil != instance.options {
+			if nil != instance.options.TLS {
+				config, err := instance.options.TLS.Config()
+				if nil != err {
+					return nil, err
+				}
+				client.TLSConfig = config
+			}
+			if len(instance.options.Proxy) > 0 {
+				dial, err := proxyDialer(instance.options.Proxy, dialTimeout)
+				if nil != err {
+					return nil, err
+				}
+				client.Dial = dial
+			}
+		}
+		instance.client = client
 	}
-	return instance.client
+	return instance.client, nil
+}
+
+// httpClient returns a net/http client with the same tls, proxy and cookies of the fasthttp one
+func (instance *HttpClient) httpClient(timeout time.Duration) (*http.Client, error) {
+	client := &http.Client{Timeout: timeout, Jar: instance.jar}
+	if nil != instance.options {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if nil != instance.options.TLS {
+			config, err := instance.options.TLS.Config()
+			if nil != err {
+				return nil, err
+			}
+			transport.TLSClientConfig = config
+		}
+		if len(instance.options.Proxy) > 0 {
+			proxy, err := httpProxy(instance.options.Proxy)
+			if nil != err {
+				return nil, err
+			}
+			transport.Proxy = proxy
+		}
+		client.Transport = transport
+		if nil == instance.options.Redirect {
+			client.CheckRedirect = func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			}
+		}
+	}
+	return client, nil
 }
 
 func (instance *HttpClient) do(method string, uri string, reqBody interface{}, timeout time.Duration) (*httputils.ResponseData, error) {
+	return instance.doWithHeader(method, uri, nil, reqBody, timeout)
+}
+
+// doWithHeader sends a request with the client header plus header (that wins on the client one)
+func (instance *HttpClient) doWithHeader(method string, uri string, header map[string]string, reqBody interface{}, timeout time.Duration) (*httputils.ResponseData, error) {
 	var err error
 
 	// request
@@ -189,6 +234,9 @@ func (instance *HttpClient) do(method string, uri string, reqBody interface{}, t
 			req.Header.Set(k, v)
 		}
 	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
 
 	if nil != reqBody {
 		if v, b := reqBody.(string); b {
@@ -199,6 +247,8 @@ func (instance *HttpClient) do(method string, uri string, reqBody interface{}, t
 			req.SetBody(v)
 		} else if v, b := reqBody.(map[string]interface{}); b {
 			req.SetBodyString(qbc.JSON.Stringify(v))
+		} else if v, b := reqBody.(io.Reader); b {
+			req.SetBodyStream(v, -1) // chunked
 		}
 	}
 
@@ -210,21 +260,126 @@ func (instance *HttpClient) do(method string, uri string, reqBody interface{}, t
 		fasthttp.ReleaseResponse(res)
 	}()
 
-	client := instance.init()
-	if isHttps(uri) {
-		// tlsConfig := &tls.Config{}
-		// client.TLSConfig = tlsConfig
+	client, err := instance.init()
+	if nil != err {
+		return nil, err
+	}
+	err = instance.send(client, method, req, res, timeout)
+	if nil == err {
+		err = instance.redirect(client, req, res, timeout)
 	}
-	err = client.DoTimeout(req, res, timeout)
 
 	return httputils.NewResponseData(res), err
 }
 
+// redirect follows the redirects of res as configured by the redirect policy
+func (instance *HttpClient) redirect(client *fasthttp.Client, req *fasthttp.Request, res *fasthttp.Response, timeout time.Duration) error {
+	if nil == instance.options || nil == instance.options.Redirect {
+		return nil
+	}
+	policy := instance.options.Redirect
+	for count := 0; isRedirect(res.StatusCode()); count++ {
+		location := res.Header.Peek(fasthttp.HeaderLocation)
+		if len(location) == 0 {
+			return nil
+		}
+		if count >= policy.MaxRedirects {
+			return ErrTooManyRedirects
+		}
+		target := fasthttp.AcquireURI()
+		req.URI().CopyTo(target)
+		target.UpdateBytes(location)
+		if !bytes.EqualFold(target.Host(), req.URI().Host()) {
+			if policy.SameHost {
+				fasthttp.ReleaseURI(target)
+				return nil
+			}
+			req.Header.Del(fasthttp.HeaderAuthorization)
+			req.Header.DelAllCookies()
+		}
+		req.SetURI(target)
+		fasthttp.ReleaseURI(target)
+
+		method := string(req.Header.Method())
+		switch res.StatusCode() {
+		case fasthttp.StatusMovedPermanently, fasthttp.StatusFound, fasthttp.StatusSeeOther:
+			if method != methodGet && method != fasthttp.MethodHead {
+				req.Header.SetMethod(methodGet)
+				req.ResetBody()
+				req.Header.Del(fasthttp.HeaderContentType)
+				req.Header.Del(fasthttp.HeaderContentLength)
+			}
+		}
+		if err := instance.send(client, string(req.Header.Method()), req, res, timeout); nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+// send executes the request retrying it as configured by the retry policy. timeout is for each attempt.
+func (instance *HttpClient) send(client *fasthttp.Client, method string, req *fasthttp.Request, res *fasthttp.Response, timeout time.Duration) error {
+	var policy *RetryPolicy
+	if nil != instance.options && !req.IsBodyStream() {
+		policy = instance.options.Retry // a stream cannot be sent twice
+	}
+	host := string(req.URI().Host())
+	for attempt := 0; ; attempt++ {
+		if nil != instance.breaker {
+			if err := instance.breaker.Allow(host); nil != err {
+				return err
+			}
+		}
+		if nil != instance.jar {
+			instance.setCookies(req)
+		}
+		res.Reset()
+		err := client.DoTimeout(req, res, timeout)
+		if nil == err && nil != instance.jar {
+			instance.storeCookies(req, res)
+		}
+		if nil != instance.breaker {
+			instance.breaker.Done(host, nil != err || res.StatusCode() >= 500)
+		}
+		if !policy.canRetry(method) || attempt >= policy.MaxRetries {
+			return err
+		}
+		wait := policy.backoff(attempt)
+		if nil == err {
+			if !policy.isRetryStatus(res.StatusCode()) {
+				return nil
+			}
+			if retryAfter, b := parseRetryAfter(string(res.Header.Peek(fasthttp.HeaderRetryAfter)), time.Now()); b {
+				if policy.MaxRetryAfter > 0 && retryAfter > policy.MaxRetryAfter {
+					return nil
+				}
+				wait = retryAfter
+			}
+		}
+		time.Sleep(wait)
+	}
+}
+
 func (instance *HttpClient) get(uri string, timeout time.Duration) (statusCode int, body []byte, err error) {
-	client := instance.init()
+	client, err := instance.init()
+	if nil != err {
+		return 0, nil, err
+	}
 	return client.GetTimeout(nil, uri, timeout)
 }
 
-func isHttps(uri string) bool {
-	return strings.Index(strings.ToLower(uri), "https") > -1
+func (instance *HttpClient) setCookies(req *fasthttp.Request) {
+	if u, err := url.Parse(req.URI().String()); nil == err {
+		for _, cookie := range instance.jar.Cookies(u) {
+			req.Header.SetCookie(cookie.Name, cookie.Value)
+		}
+	}
+}
+
+func (instance *HttpClient) storeCookies(req *fasthttp.Request, res *fasthttp.Response) {
+	if cookies := cookiesOf(res); len(cookies) > 0 {
+		if u, err := url.Parse(req.URI().String()); nil == err {
+			instance.jar.SetCookies(u, cookies)
+		}
+	}
 }
@@ -0,0 +1,192 @@
+package client
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	qbc "github.com/rskvp/qb-core"
+	httputils "github.com/rskvp/qb-lib/qb_http/utils"
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//	HttpError
+//----------------------------------------------------------------------------------------------------------------------
+
+// HttpError is returned by HttpRequest for responses with a status other than 2xx
+type HttpError struct {
+	StatusCode int
+	Header     map[string]string
+	Body       []byte
+}
+
+func (instance *HttpError) Error() string {
+	text := strings.TrimSpace(string(instance.Body))
+	if len(text) > 200 {
+		text = text[:200] + "..."
+	}
+	if len(text) > 0 {
+		return fmt.Sprintf("http_error: %d %s: %s", instance.StatusCode, http.StatusText(instance.StatusCode), text)
+	}
+	return fmt.Sprintf("http_error: %d %s", instance.StatusCode, http.StatusText(instance.StatusCode))
+}
+
+// BodyAsMap returns the body parsed as JSON object (i.e. an API error payload)
+func (instance *HttpError) BodyAsMap() map[string]interface{} {
+	return (&httputils.ResponseData{Body: instance.Body}).BodyAsMap()
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	HttpRequest
+//----------------------------------------------------------------------------------------------------------------------
+
+// HttpRequest builds a single request of a client:
+//
+//	var user User
+//	_, err := client.NewRequest("GET", "https://api.host/users").
+//		Query("id", 123).BearerAuth(token).Decode(&user)
+//
+// Header set on the request are added to the client header.
+type HttpRequest struct {
+	client  *HttpClient
+	method  string
+	url     string
+	query   url.Values
+	header  map[string]string
+	body    interface{}
+	timeout time.Duration
+	err     error
+}
+
+// NewRequest starts building a request
+func (instance *HttpClient) NewRequest(method, url string) *HttpRequest {
+	return &HttpRequest{
+		client:  instance,
+		method:  strings.ToUpper(method),
+		url:     url,
+		query:   make(map[string][]string),
+		header:  make(map[string]string),
+		timeout: time.Second * 15,
+	}
+}
+
+// Query adds a query parameter. Parameters already in the url are kept.
+func (instance *HttpRequest) Query(key string, value interface{}) *HttpRequest {
+	instance.query.Add(key, qbc.Convert.ToString(value))
+	return instance
+}
+
+// Header sets a header of this request only
+func (instance *HttpRequest) Header(key, value string) *HttpRequest {
+	instance.header[key] = value
+	return instance
+}
+
+func (instance *HttpRequest) BasicAuth(username, password string) *HttpRequest {
+	credentials := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+	return instance.Header("Authorization", "Basic "+credentials)
+}
+
+func (instance *HttpRequest) BearerAuth(token string) *HttpRequest {
+	return instance.Header("Authorization", "Bearer "+token)
+}
+
+// Body sets a raw body (string, []byte or io.Reader) with its content type
+func (instance *HttpRequest) Body(body interface{}, contentType string) *HttpRequest {
+	instance.body = body
+	if len(contentType) > 0 {
+		instance.Header("Content-Type", contentType)
+	}
+	return instance
+}
+
+// JSON marshals body (any value) as application/json
+func (instance *HttpRequest) JSON(body interface{}) *HttpRequest {
+	data, err := json.Marshal(body)
+	if nil != err {
+		instance.err = err
+		return instance
+	}
+	return instance.Body(data, "application/json")
+}
+
+// Form encodes values as application/x-www-form-urlencoded
+func (instance *HttpRequest) Form(values map[string]interface{}) *HttpRequest {
+	form := url.Values{}
+	for k, v := range values {
+		if items, b := v.([]string); b {
+			form[k] = append(form[k], items...)
+		} else {
+			form.Add(k, qbc.Convert.ToString(v))
+		}
+	}
+	return instance.Body(form.Encode(), "application/x-www-form-urlencoded")
+}
+
+func (instance *HttpRequest) Timeout(timeout time.Duration) *HttpRequest {
+	instance.timeout = timeout
+	return instance
+}
+
+// URL returns the url with the query parameters
+func (instance *HttpRequest) URL() (string, error) {
+	if len(instance.query) == 0 {
+		return instance.url, nil
+	}
+	u, err := url.Parse(instance.url)
+	if nil != err {
+		return "", err
+	}
+	query := u.Query()
+	for k, values := range instance.query {
+		query[k] = append(query[k], values...)
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// Do sends the request. Responses with a status other than 2xx are returned with an *HttpError.
+func (instance *HttpRequest) Do() (*httputils.ResponseData, error) {
+	if nil != instance.err {
+		return nil, instance.err
+	}
+	uri, err := instance.URL()
+	if nil != err {
+		return nil, err
+	}
+	response, err := instance.client.doWithHeader(instance.method, uri, instance.header, instance.body, instance.timeout)
+	if nil != err {
+		return response, err
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response, &HttpError{StatusCode: response.StatusCode, Header: response.Header, Body: response.Body}
+	}
+	return response, nil
+}
+
+// Decode sends the request and decodes the JSON body into target.
+// target can also be a *string or *[]byte to get the raw body.
+func (instance *HttpRequest) Decode(target interface{}) (*httputils.ResponseData, error) {
+	if _, b := instance.header["Accept"]; !b {
+		instance.Header("Accept", "application/json")
+	}
+	response, err := instance.Do()
+	if nil != err || nil == target {
+		return response, err
+	}
+	switch t := target.(type) {
+	case *string:
+		*t = string(response.Body)
+	case *[]byte:
+		*t = response.Body
+	default:
+		if len(response.Body) > 0 {
+			err = json.Unmarshal(response.Body, target)
+		}
+	}
+	return response, err
+}
@@ -0,0 +1,137 @@
+package client
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//	e r r o r s
+//----------------------------------------------------------------------------------------------------------------------
+
+var ErrCircuitOpen = errors.New("circuit_open")
+
+//----------------------------------------------------------------------------------------------------------------------
+//	RetryPolicy
+//----------------------------------------------------------------------------------------------------------------------
+
+// canRetry returns true if method can be sent again
+func (instance *RetryPolicy) canRetry(method string) bool {
+	if nil == instance || instance.MaxRetries <= 0 {
+		return false
+	}
+	switch strings.ToUpper(method) {
+	case http.MethodPost, http.MethodPatch:
+		return instance.RetryPost
+	}
+	return true
+}
+
+func (instance *RetryPolicy) isRetryStatus(statusCode int) bool {
+	for _, status := range instance.RetryStatus {
+		if status == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the wait before retry number attempt (0 based)
+func (instance *RetryPolicy) backoff(attempt int) time.Duration {
+	delay := instance.MinBackoff
+	for i := 0; i < attempt && delay < instance.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if instance.MaxBackoff > 0 && delay > instance.MaxBackoff {
+		delay = instance.MaxBackoff
+	}
+	if instance.Jitter > 0 {
+		delay -= time.Duration(rand.Float64() * instance.Jitter * float64(delay))
+	}
+	return delay
+}
+
+// parseRetryAfter reads a "Retry-After" header: seconds or HTTP date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); nil == err {
+		if seconds < 0 {
+			seconds = 0
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); nil == err {
+		if wait := date.Sub(now); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	circuitBreaker
+//----------------------------------------------------------------------------------------------------------------------
+
+// circuitBreaker keeps the state of the circuit of each host
+type circuitBreaker struct {
+	options *CircuitBreakerOptions
+	hosts   map[string]*circuitState
+	mux     sync.Mutex
+}
+
+type circuitState struct {
+	failures  int
+	openUntil time.Time
+	trial     bool // a trial request is running on the half-open circuit
+}
+
+func newCircuitBreaker(options *CircuitBreakerOptions) *circuitBreaker {
+	instance := new(circuitBreaker)
+	instance.options = options
+	instance.hosts = make(map[string]*circuitState)
+	return instance
+}
+
+// Allow returns ErrCircuitOpen if requests to host must fail fast
+func (instance *circuitBreaker) Allow(host string) error {
+	instance.mux.Lock()
+	defer instance.mux.Unlock()
+	state, b := instance.hosts[host]
+	if !b || state.failures < instance.options.FailureThreshold {
+		return nil
+	}
+	if time.Now().Before(state.openUntil) || state.trial {
+		return ErrCircuitOpen
+	}
+	state.trial = true // half-open: let a single request pass
+	return nil
+}
+
+// Done records the result of a request to host
+func (instance *circuitBreaker) Done(host string, failed bool) {
+	instance.mux.Lock()
+	defer instance.mux.Unlock()
+	if !failed {
+		delete(instance.hosts, host)
+		return
+	}
+	state, b := instance.hosts[host]
+	if !b {
+		state = new(circuitState)
+		instance.hosts[host] = state
+	}
+	state.failures++
+	state.trial = false
+	if state.failures >= instance.options.FailureThreshold {
+		state.openUntil = time.Now().Add(instance.options.OpenTimeout)
+	}
+}
@@ -0,0 +1,241 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	qbc "github.com/rskvp/qb-core"
+	httputils "github.com/rskvp/qb-lib/qb_http/utils"
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//	t y p e s
+//----------------------------------------------------------------------------------------------------------------------
+
+// ProgressCallback is notified while data is transferred. total is -1 when the size is unknown.
+type ProgressCallback func(transferred, total int64)
+
+// MultipartFile is a file part of a multipart upload. The content is read from Reader while sending.
+type MultipartFile struct {
+	FieldName   string    // default "file"
+	FileName    string    // name of the file sent to the server
+	ContentType string    // default "application/octet-stream"
+	Reader      io.Reader // closed after upload if it is an io.Closer
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p u b l i c
+//----------------------------------------------------------------------------------------------------------------------
+
+// Download writes the body of url to dst without buffering it. The returned ResponseData has no Body.
+// Downloads have no timeout: use DownloadTimeout to limit the whole transfer.
+func (instance *HttpClient) Download(url string, dst io.Writer, progress ProgressCallback) (*httputils.ResponseData, error) {
+	return instance.DownloadTimeout(url, dst, progress, 0)
+}
+
+func (instance *HttpClient) DownloadTimeout(url string, dst io.Writer, progress ProgressCallback, timeout time.Duration) (*httputils.ResponseData, error) {
+	return instance.download(url, dst, 0, progress, timeout)
+}
+
+// DownloadFile streams url to filename. If filename already exists the download is resumed
+// with a Range request: a server that does not support ranges sends the whole file again.
+func (instance *HttpClient) DownloadFile(url string, filename string, progress ProgressCallback) (*httputils.ResponseData, error) {
+	return instance.DownloadFileTimeout(url, filename, progress, 0)
+}
+
+func (instance *HttpClient) DownloadFileTimeout(url string, filename string, progress ProgressCallback, timeout time.Duration) (*httputils.ResponseData, error) {
+	if err := os.MkdirAll(filepath.Dir(filename), os.ModePerm); nil != err {
+		return nil, err
+	}
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY, 0644)
+	if nil != err {
+		return nil, err
+	}
+	defer file.Close()
+	offset, err := file.Seek(0, io.SeekEnd)
+	if nil != err {
+		return nil, err
+	}
+	return instance.download(url, file, offset, progress, timeout)
+}
+
+// UploadFiles sends files and params as multipart/form-data. The body is streamed: files are never loaded in memory.
+func (instance *HttpClient) UploadFiles(url string, files []*MultipartFile, params map[string]interface{}) (*httputils.ResponseData, error) {
+	return instance.UploadFilesTimeout(url, files, params, time.Second*120)
+}
+
+func (instance *HttpClient) UploadFilesTimeout(url string, files []*MultipartFile, params map[string]interface{}, timeout time.Duration) (*httputils.ResponseData, error) {
+	reader, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	go func() {
+		_ = writer.CloseWithError(writeMultipart(form, files, params))
+	}()
+	defer reader.Close()
+
+	return instance.Stream(methodPost, url, reader, form.FormDataContentType(), timeout)
+}
+
+// Stream sends body without buffering it. The request is never retried because the body cannot be read twice.
+func (instance *HttpClient) Stream(method, url string, body io.Reader, contentType string, timeout time.Duration) (*httputils.ResponseData, error) {
+	req, err := http.NewRequest(method, url, body)
+	if nil != err {
+		return nil, err
+	}
+	instance.setHeader(req.Header)
+	if len(contentType) > 0 {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	client, err := instance.httpClient(timeout)
+	if nil != err {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if nil != err {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	response := httputils.NewResponseDataEmpty()
+	response.Body, err = io.ReadAll(resp.Body)
+	response.StatusCode = resp.StatusCode
+	response.Header = httputils.HttpHeaderToMap(resp.Header)
+	return response, err
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p r i v a t e
+//----------------------------------------------------------------------------------------------------------------------
+
+func (instance *HttpClient) setHeader(header http.Header) {
+	for k, v := range instance.header {
+		header.Set(k, v)
+	}
+}
+
+// download writes the body of url to dst. offset > 0 requests the remaining bytes of a partial
+// download: dst must be positioned at offset and is truncated if the server sends the whole file.
+func (instance *HttpClient) download(url string, dst io.Writer, offset int64, progress ProgressCallback, timeout time.Duration) (*httputils.ResponseData, error) {
+	req, err := http.NewRequest(methodGet, url, nil)
+	if nil != err {
+		return nil, err
+	}
+	instance.setHeader(req.Header)
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	client, err := instance.httpClient(timeout)
+	if nil != err {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if nil != err {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	response := httputils.NewResponseDataEmpty()
+	response.StatusCode = resp.StatusCode
+	response.Header = httputils.HttpHeaderToMap(resp.Header)
+	switch {
+	case offset > 0 && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// already complete
+		return response, nil
+	case offset > 0 && resp.StatusCode == http.StatusOK:
+		// ranges not supported: start again
+		if file, b := dst.(*os.File); b {
+			if err = file.Truncate(0); nil == err {
+				_, err = file.Seek(0, io.SeekStart)
+			}
+			if nil != err {
+				return response, err
+			}
+		}
+		offset = 0
+	case resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent:
+		response.Body, _ = io.ReadAll(resp.Body)
+		return response, nil
+	}
+
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+	_, err = io.Copy(&progressWriter{writer: dst, transferred: offset, total: total, callback: progress}, resp.Body)
+	return response, err
+}
+
+func writeMultipart(form *multipart.Writer, files []*MultipartFile, params map[string]interface{}) error {
+	for key, val := range params {
+		if err := form.WriteField(key, qbc.Convert.ToString(val)); nil != err {
+			return err
+		}
+	}
+	for _, file := range files {
+		if nil == file || nil == file.Reader {
+			continue
+		}
+		err := writePart(form, file)
+		if closer, b := file.Reader.(io.Closer); b {
+			_ = closer.Close()
+		}
+		if nil != err {
+			return err
+		}
+	}
+	return form.Close()
+}
+
+func writePart(form *multipart.Writer, file *MultipartFile) error {
+	fieldName, contentType := file.FieldName, file.ContentType
+	if len(fieldName) == 0 {
+		fieldName = "file"
+	}
+	if len(contentType) == 0 {
+		contentType = "application/octet-stream"
+	}
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+		escapeQuotes(fieldName), escapeQuotes(file.FileName)))
+	header.Set("Content-Type", contentType)
+	part, err := form.CreatePart(header)
+	if nil != err {
+		return err
+	}
+	_, err = io.Copy(part, file.Reader)
+	return err
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	progressWriter
+//----------------------------------------------------------------------------------------------------------------------
+
+type progressWriter struct {
+	writer      io.Writer
+	transferred int64
+	total       int64
+	callback    ProgressCallback
+}
+
+func (instance *progressWriter) Write(p []byte) (int, error) {
+	n, err := instance.writer.Write(p)
+	instance.transferred += int64(n)
+	if nil != instance.callback {
+		instance.callback(instance.transferred, instance.total)
+	}
+	return n, err
+}
@@ -0,0 +1,317 @@
+package client_test
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rskvp/qb-lib/qb_http"
+	"github.com/rskvp/qb-lib/qb_http/client"
+)
+
+func testOptions() *client.HttpClientOptions {
+	options := client.NewHttpClientOptions()
+	options.Retry.MinBackoff = time.Millisecond
+	options.Retry.MaxBackoff = 5 * time.Millisecond
+	options.CircuitBreaker = nil
+	return options
+}
+
+func TestRetry(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	c := client.NewHttpClient(testOptions())
+	response, err := c.Get(server.URL)
+	if nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	if response.StatusCode != 200 || string(response.Body) != "ok" || atomic.LoadInt32(&calls) != 3 {
+		t.Error("Expected success after 2 retries", response.StatusCode, calls)
+	}
+
+	// POST is not retried unless opted in
+	atomic.StoreInt32(&calls, 0)
+	if response, _ = c.Post(server.URL, "data"); response.StatusCode != http.StatusServiceUnavailable || atomic.LoadInt32(&calls) != 1 {
+		t.Error("Expected POST not retried", response.StatusCode, calls)
+	}
+
+	// no options: single attempt
+	atomic.StoreInt32(&calls, 0)
+	if response, _ = client.NewHttpClient().Get(server.URL); response.StatusCode != http.StatusServiceUnavailable || atomic.LoadInt32(&calls) != 1 {
+		t.Error("Expected a single attempt", response.StatusCode, calls)
+	}
+}
+
+func TestOptionsParse(t *testing.T) {
+	options := client.NewHttpClientOptions()
+	err := options.Parse(`{"retry":{"max_retries":5,"min_backoff":250,"max_retry_after":30000},"circuit_breaker":{"open_timeout":1500}}`)
+	if nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	if options.Retry.MaxRetries != 5 || options.Retry.MinBackoff != 250*time.Millisecond || options.Retry.MaxRetryAfter != 30*time.Second {
+		t.Error("Unexpected retry policy", options.Retry)
+	}
+	if options.Retry.MaxBackoff != 10*time.Second || options.Retry.Jitter != 0.5 {
+		t.Error("Expected defaults kept", options.Retry)
+	}
+	if options.CircuitBreaker.OpenTimeout != 1500*time.Millisecond || options.CircuitBreaker.FailureThreshold != 5 {
+		t.Error("Unexpected circuit breaker", options.CircuitBreaker)
+	}
+	// round trip
+	parsed := new(client.HttpClientOptions)
+	if err = parsed.Parse(options.String()); nil != err || parsed.Retry.MinBackoff != options.Retry.MinBackoff ||
+		parsed.CircuitBreaker.OpenTimeout != options.CircuitBreaker.OpenTimeout || !strings.Contains(options.String(), `"min_backoff":250`) {
+		t.Error("Unexpected round trip", options.String(), err)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	options := testOptions()
+	options.Retry = nil
+	options.CircuitBreaker = &client.CircuitBreakerOptions{FailureThreshold: 2, OpenTimeout: 50 * time.Millisecond}
+	c := client.NewHttpClient(options)
+	for i := 0; i < 2; i++ {
+		if _, err := c.Get(server.URL); nil != err {
+			t.Error(err)
+		}
+	}
+	if _, err := c.Get(server.URL); !errors.Is(err, client.ErrCircuitOpen) || atomic.LoadInt32(&calls) != 2 {
+		t.Error("Expected open circuit", err, calls)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, err := c.Get(server.URL); nil != err || atomic.LoadInt32(&calls) != 3 {
+		t.Error("Expected trial request on half-open circuit", err, calls)
+	}
+	if _, err := c.Get(server.URL); !errors.Is(err, client.ErrCircuitOpen) {
+		t.Error("Expected circuit open again after failed trial", err)
+	}
+}
+
+func TestRedirectAndCookies(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/"})
+		http.Redirect(w, r, "/private", http.StatusSeeOther)
+	})
+	mux.HandleFunc("/private", func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie("session"); nil != err || c.Value != "abc" || r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte("welcome"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	options := testOptions()
+	options.Cookies = true
+	c := client.NewHttpClient(options)
+	response, err := c.Post(server.URL+"/login", "user=me")
+	if nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	if response.StatusCode != 200 || string(response.Body) != "welcome" {
+		t.Error("Expected redirect with session cookie", response.StatusCode, string(response.Body))
+	}
+	if response, _ = c.Get(server.URL + "/private"); response.StatusCode != 200 || len(c.Cookies(server.URL)) != 1 {
+		t.Error("Expected cookie kept between calls", response.StatusCode)
+	}
+
+	// no options: redirect returned to the caller
+	if response, _ = client.NewHttpClient().Post(server.URL+"/login", "user=me"); response.StatusCode != http.StatusSeeOther {
+		t.Error("Expected redirect not followed", response.StatusCode)
+	}
+}
+
+func TestTLS(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	_ = os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+	certPem, keyPem, err := qb_http.Http.GenerateCert("client")
+	if nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	_ = os.WriteFile(certFile, certPem, 0600)
+	_ = os.WriteFile(keyFile, keyPem, 0600)
+
+	options := testOptions()
+	options.Retry = nil
+	options.TLS = &client.TLSOptions{CAFiles: []string{caFile}, CertFile: certFile, KeyFile: keyFile}
+	response, err := client.NewHttpClient(options).Get(server.URL)
+	if nil != err || response.StatusCode != 200 {
+		t.Error("Expected mutual TLS with private CA", err)
+	}
+	options.TLS = &client.TLSOptions{InsecureSkipVerify: true}
+	if _, err = client.NewHttpClient(options).Get(server.URL); nil == err {
+		t.Error("Expected client certificate required")
+	}
+	if _, err = client.NewHttpClient().Get(server.URL); nil == err {
+		t.Error("Expected unknown authority")
+	}
+	options.TLS = nil
+	options.Proxy = "ftp://proxy"
+	if _, err = client.NewHttpClient(options).Get(server.URL); !errors.Is(err, client.ErrInvalidProxy) {
+		t.Error("Expected invalid proxy", err)
+	}
+}
+
+func TestDownloadAndUpload(t *testing.T) {
+	content := strings.Repeat("0123456789", 10000)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/file", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.txt", time.Now(), strings.NewReader(content))
+	})
+	mux.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); nil != err {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		sizes := make([]string, 0)
+		for _, name := range []string{"a", "b"} {
+			file, header, err := r.FormFile(name)
+			if nil != err {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			data, _ := io.ReadAll(file)
+			sizes = append(sizes, fmt.Sprint(header.Filename, ":", len(data)))
+		}
+		_, _ = w.Write([]byte(r.FormValue("user") + " " + strings.Join(sizes, " ")))
+	})
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		_, _ = w.Write(data)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// resume a partial download
+	filename := filepath.Join(t.TempDir(), "file.txt")
+	_ = os.WriteFile(filename, []byte(content[:12345]), 0644)
+	var transferred, total int64
+	c := client.NewHttpClient()
+	response, err := c.DownloadFile(server.URL+"/file", filename, func(n, size int64) {
+		transferred, total = n, size
+	})
+	if nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	data, _ := os.ReadFile(filename)
+	if response.StatusCode != http.StatusPartialContent || string(data) != content {
+		t.Error("Expected resumed download", response.StatusCode, len(data))
+	}
+	if transferred != int64(len(content)) || total != int64(len(content)) {
+		t.Error("Unexpected progress", transferred, total)
+	}
+	if response, _ = c.DownloadFile(server.URL+"/file", filename, nil); response.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Error("Expected complete download", response.StatusCode)
+	}
+
+	// multipart from readers
+	response, err = c.UploadFiles(server.URL+"/upload", []*client.MultipartFile{
+		{FieldName: "a", FileName: "a.txt", Reader: strings.NewReader("hello")},
+		{FieldName: "b", FileName: "b.bin", Reader: strings.NewReader(content)},
+	}, map[string]interface{}{"user": "me"})
+	if nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	if string(response.Body) != "me a.txt:5 b.bin:100000" {
+		t.Error("Unexpected upload response", response.StatusCode, string(response.Body))
+	}
+
+	// streaming request body
+	if response, err = c.Post(server.URL+"/echo", strings.NewReader(content)); nil != err || string(response.Body) != content {
+		t.Error("Expected streamed body", err)
+	}
+}
+
+func TestRequestBuilder(t *testing.T) {
+	type item struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/json":
+			var in item
+			if r.Header.Get("Authorization") != "Bearer token" || r.URL.Query().Get("q") != "x y" || r.URL.Query().Get("a") != "1" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_ = json.NewDecoder(r.Body).Decode(&in)
+			in.Count++
+			_ = json.NewEncoder(w).Encode(in)
+		case "/form":
+			user, password, _ := r.BasicAuth()
+			_, _ = w.Write([]byte(user + password + r.PostFormValue("field")))
+		default:
+			w.Header().Set("X-Reason", "missing")
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":"not_found"}`))
+		}
+	}))
+	defer server.Close()
+
+	c := client.NewHttpClient()
+	var out item
+	_, err := c.NewRequest("post", server.URL+"/json?a=1").Query("q", "x y").BearerAuth("token").
+		JSON(&item{Name: "apple", Count: 1}).Decode(&out)
+	if nil != err || out.Name != "apple" || out.Count != 2 {
+		t.Error("Unexpected decoded response", err, out)
+	}
+	var text string
+	if _, err = c.NewRequest("POST", server.URL+"/form").BasicAuth("me", "pwd").
+		Form(map[string]interface{}{"field": "value"}).Decode(&text); nil != err || text != "mepwdvalue" {
+		t.Error("Unexpected form response", err, text)
+	}
+	_, err = c.NewRequest("GET", server.URL+"/missing").Do()
+	var httpErr *client.HttpError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != 404 || httpErr.Header["X-Reason"] != "missing" || httpErr.BodyAsMap()["error"] != "not_found" {
+		t.Error("Expected structured error", err)
+	}
+}
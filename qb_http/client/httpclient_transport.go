@@ -0,0 +1,126 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttpproxy"
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//	e r r o r s
+//----------------------------------------------------------------------------------------------------------------------
+
+var (
+	ErrInvalidProxy     = errors.New("invalid_proxy")
+	ErrInvalidCA        = errors.New("invalid_ca")
+	ErrTooManyRedirects = errors.New("too_many_redirects")
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//	TLSOptions
+//----------------------------------------------------------------------------------------------------------------------
+
+// Config builds the tls configuration loading certificate files
+func (instance *TLSOptions) Config() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         instance.ServerName,
+		InsecureSkipVerify: instance.InsecureSkipVerify,
+		Certificates:       append([]tls.Certificate{}, instance.Certificates...),
+		RootCAs:            instance.RootCAs,
+	}
+	if len(instance.CertFile) > 0 || len(instance.KeyFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(instance.CertFile, instance.KeyFile)
+		if nil != err {
+			return nil, err
+		}
+		config.Certificates = append(config.Certificates, cert)
+	}
+	if len(instance.CAFiles) > 0 {
+		if nil == config.RootCAs {
+			pool, err := x509.SystemCertPool()
+			if nil != err {
+				pool = x509.NewCertPool()
+			}
+			config.RootCAs = pool
+		}
+		for _, filename := range instance.CAFiles {
+			data, err := os.ReadFile(filename)
+			if nil != err {
+				return nil, err
+			}
+			if !config.RootCAs.AppendCertsFromPEM(data) {
+				return nil, fmt.Errorf("%w: no certificates in '%s'", ErrInvalidCA, filename)
+			}
+		}
+	}
+	return config, nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p r i v a t e
+//----------------------------------------------------------------------------------------------------------------------
+
+// proxyDialer returns the fasthttp dialer of a proxy url
+func proxyDialer(proxy string, timeout time.Duration) (fasthttp.DialFunc, error) {
+	if strings.ToLower(proxy) == "env" {
+		return fasthttpproxy.FasthttpProxyHTTPDialerTimeout(timeout), nil
+	}
+	u, err := url.Parse(proxy)
+	if nil != err || len(u.Host) == 0 {
+		return nil, fmt.Errorf("%w: '%s'", ErrInvalidProxy, proxy)
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		address := u.Host
+		if nil != u.User {
+			address = u.User.String() + "@" + u.Host
+		}
+		return fasthttpproxy.FasthttpHTTPDialerTimeout(address, timeout), nil
+	case "socks5", "socks5h":
+		return fasthttpproxy.FasthttpSocksDialer(proxy), nil
+	}
+	return nil, fmt.Errorf("%w: unsupported scheme '%s'", ErrInvalidProxy, u.Scheme)
+}
+
+// httpProxy returns the proxy function of a net/http transport
+func httpProxy(proxy string) (func(*http.Request) (*url.URL, error), error) {
+	if len(proxy) == 0 {
+		return nil, nil
+	}
+	if strings.ToLower(proxy) == "env" {
+		return http.ProxyFromEnvironment, nil
+	}
+	u, err := url.Parse(proxy)
+	if nil != err || len(u.Host) == 0 {
+		return nil, fmt.Errorf("%w: '%s'", ErrInvalidProxy, proxy)
+	}
+	return http.ProxyURL(u), nil
+}
+
+// isRedirect returns true for the statuses with a Location to follow
+func isRedirect(statusCode int) bool {
+	switch statusCode {
+	case fasthttp.StatusMovedPermanently, fasthttp.StatusFound, fasthttp.StatusSeeOther,
+		fasthttp.StatusTemporaryRedirect, fasthttp.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// cookiesOf returns the cookies set by a response
+func cookiesOf(res *fasthttp.Response) []*http.Cookie {
+	header := http.Header{}
+	res.Header.VisitAllCookie(func(_, value []byte) {
+		header.Add("Set-Cookie", string(value))
+	})
+	return (&http.Response{Header: header}).Cookies()
+}
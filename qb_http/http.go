@@ -9,6 +9,7 @@ import (
 	"math/big"
 	"time"
 
+	"github.com/rskvp/qb-lib/qb_http/certs"
 	httpclient "github.com/rskvp/qb-lib/qb_http/client"
 )
 
@@ -25,8 +26,22 @@ func init() {
 //	p u b l i c
 //----------------------------------------------------------------------------------------------------------------------
 
-func (instance *HttpHelper) NewHttpClient() *httpclient.HttpClient {
-	return httpclient.NewHttpClient()
+// NewHttpClient creates a client. See httpclient.NewHttpClientOptions for retry and circuit breaker defaults.
+func (instance *HttpHelper) NewHttpClient(options ...*httpclient.HttpClientOptions) *httpclient.HttpClient {
+	return httpclient.NewHttpClient(options...)
+}
+
+func (instance *HttpHelper) NewHttpClientOptions() *httpclient.HttpClientOptions {
+	return httpclient.NewHttpClientOptions()
+}
+
+// NewLocalCA creates a local certificate authority issuing certificates for internal hostnames.
+// Call Open to load or create the root.
+func (instance *HttpHelper) NewLocalCA(config ...*certs.LocalCAConfig) *certs.LocalCA {
+	if len(config) > 0 {
+		return certs.NewLocalCA(config[0])
+	}
+	return certs.NewLocalCA(nil)
 }
 
 // GenerateCert generates certificate and private key based on the given host.
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -16,6 +17,9 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/requestid"
 	qbc "github.com/rskvp/qb-core"
 	"github.com/rskvp/qb-core/qb_events"
+	"github.com/rskvp/qb-lib/qb_auth0"
+	"github.com/rskvp/qb-lib/qb_http/certs"
+	"github.com/rskvp/qb-lib/qb_http/server/rewrite"
 )
 
 // https://dev.to/koddr/go-fiber-by-examples-delving-into-built-in-functions-1p3k
@@ -56,25 +60,69 @@ type HttpServer struct {
 	cfgCORS           *ConfigCORS
 	cfgCompression    *ConfigCompression
 	cfgLimiter        *ConfigLimiter
+	cfgProxy          []*ConfigProxy
+	cfgAuth           *ConfigAuth
+	cfgMetrics        *ConfigMetrics
+	cfgHealth         *ConfigHealth
+	cfgAccessLog      *ConfigAccessLog
+	cfgRewrite        *ConfigRewrite
+	cfgLocalCA        *ConfigLocalCA
+	cfgSSE            *ConfigSSE
+	cfgCache          *ConfigCache
 	cfgRoute          *httpServerConfigRoute
 	cfgMiddleware     []*httpServerConfigRouteItem
 	cfgRouteWebsocket []*httpServerConfigRouteWebsocket
 
-	middlewares []fiber.Handler
-	routers     []fiber.Router
+	middlewares       []fiber.Handler
+	routers           []fiber.Router
+	proxies           []*HttpProxy
+	auth0             *qb_auth0.Auth0
+	auth0Owned        bool // auth0 created from configuration: closed on stop
+	auth              *HttpAuth
+	metrics           *HttpMetrics
+	metricsOnce       sync.Once
+	health            *HttpHealth
+	sockets           []*HttpWebsocket
+	socketsMux        sync.RWMutex // sockets are read by the metrics while restarting
+	broker            IWebsocketBroker
+	accessLog         *HttpAccessLog
+	rewrite           fiber.Handler
+	localCA           *certs.LocalCA
+	certificates      []*tlsCertificates
+	sseHub            *HttpSSEHub
+	limiter           *HttpLimiter
+	limiterStore      ILimiterStore
+	limiterStoreOwned bool // store created from configuration: closed on stop
+	cache             *HttpCache
+	cacheStore        ICacheStore
+	cacheStoreOwned   bool // store created from configuration: closed on stop
+	listeners         map[string]*sharedListener
+	addrs             []string // addresses of the running apps
 
 	monitorFiles         []string
 	monitor              *ServerMonitor
 	callbackError        CallbackError
 	callbackLimitReached CallbackLimitReached
+	callbackAccessLog    CallbackAccessLog
 	stopChan             chan bool
 }
 
+// httpServerRuntime is what Start creates for the running apps. Restart starts a new one and drains the previous.
+type httpServerRuntime struct {
+	apps         []*fiber.App
+	addrs        []string
+	proxies      []*HttpProxy
+	sockets      []*HttpWebsocket
+	accessLog    *HttpAccessLog
+	certificates []*tlsCertificates
+}
+
 func NewHttpServer(workspace string, callbackError CallbackError, callbackLimit CallbackLimitReached) *HttpServer {
 	instance := new(HttpServer)
 	instance.workspace = qbc.Paths.Absolute(workspace)
 	instance.monitorFiles = make([]string, 0)
 	instance.apps = make([]*fiber.App, 0)
+	instance.listeners = make(map[string]*sharedListener)
 	instance.cfgServer = new(ConfigServer)
 	instance.cfgServer.EnableRequestId = true
 	instance.cfgServer.Prefork = false
@@ -86,6 +134,7 @@ func NewHttpServer(workspace string, callbackError CallbackError, callbackLimit
 	instance.cfgLimiter.Enabled = false
 	instance.cfgStatic = make([]*ConfigStatic, 0)
 	instance.cfgHosts = make([]*ConfigHost, 0)
+	instance.cfgProxy = make([]*ConfigProxy, 0)
 	instance.cfgRoute = NewHttpServerConfigRoute()
 
 	instance.stopChan = make(chan bool, 1)
@@ -95,6 +144,7 @@ func NewHttpServer(workspace string, callbackError CallbackError, callbackLimit
 
 	instance.middlewares = make([]fiber.Handler, 0)
 	instance.routers = make([]fiber.Router, 0)
+	instance.health = NewHttpHealth()
 
 	return instance
 }
@@ -111,6 +161,15 @@ func (instance *HttpServer) Configuration() *Config {
 	response.Hosts = instance.cfgHosts
 	response.Cors = instance.cfgCORS
 	response.Compression = instance.cfgCompression
+	response.Proxy = instance.cfgProxy
+	response.Auth = instance.cfgAuth
+	response.Metrics = instance.cfgMetrics
+	response.Health = instance.cfgHealth
+	response.AccessLog = instance.cfgAccessLog
+	response.Rewrite = instance.cfgRewrite
+	response.LocalCA = instance.cfgLocalCA
+	response.SSE = instance.cfgSSE
+	response.Cache = instance.cfgCache
 
 	return response
 }
@@ -196,6 +255,33 @@ func (instance *HttpServer) ConfigureFromJson(text string) error {
 		if nil != c.Cors {
 			instance.cfgCORS = c.Cors
 		}
+		if nil != c.Proxy {
+			instance.cfgProxy = c.Proxy
+		}
+		if nil != c.Auth {
+			instance.cfgAuth = c.Auth
+		}
+		if nil != c.Metrics {
+			instance.cfgMetrics = c.Metrics
+		}
+		if nil != c.Health {
+			instance.cfgHealth = c.Health
+		}
+		if nil != c.AccessLog {
+			instance.cfgAccessLog = c.AccessLog
+		}
+		if nil != c.Rewrite {
+			instance.cfgRewrite = c.Rewrite
+		}
+		if nil != c.LocalCA {
+			instance.cfgLocalCA = c.LocalCA
+		}
+		if nil != c.SSE {
+			instance.cfgSSE = c.SSE
+		}
+		if nil != c.Cache {
+			instance.cfgCache = c.Cache
+		}
 	}
 	return err
 }
@@ -236,6 +322,61 @@ func (instance *HttpServer) ConfigureStatic(settings ...map[string]interface{})
 	}
 }
 
+func (instance *HttpServer) ConfigureProxy(settings ...map[string]interface{}) {
+	instance.cfgProxy = make([]*ConfigProxy, 0)
+	for _, setting := range settings {
+		s := qbc.JSON.Stringify(setting)
+		if len(s) > 0 {
+			var c *ConfigProxy
+			err := qbc.JSON.Read(s, &c)
+			if nil == err && nil != c {
+				instance.cfgProxy = append(instance.cfgProxy, c)
+			}
+		}
+	}
+}
+
+func (instance *HttpServer) ConfigureRewrite(settings map[string]interface{}) {
+	s := qbc.JSON.Stringify(settings)
+	if len(s) > 0 {
+		var c *ConfigRewrite
+		err := qbc.JSON.Read(s, &c)
+		if nil == err && nil != c {
+			instance.cfgRewrite = c
+		}
+	}
+}
+
+func (instance *HttpServer) ConfigureCache(settings map[string]interface{}) {
+	s := qbc.JSON.Stringify(settings)
+	if len(s) > 0 {
+		var c *ConfigCache
+		err := qbc.JSON.Read(s, &c)
+		if nil == err && nil != c {
+			instance.cfgCache = c
+		}
+	}
+}
+
+func (instance *HttpServer) ConfigureAuth(settings map[string]interface{}) {
+	s := qbc.JSON.Stringify(settings)
+	if len(s) > 0 {
+		var c *ConfigAuth
+		err := qbc.JSON.Read(s, &c)
+		if nil == err && nil != c {
+			instance.cfgAuth = c
+		}
+	}
+}
+
+// SetAuth0 sets the Auth0 instance used by the auth middleware to verify tokens.
+// If not set, an instance is created from the "auth0" field of the auth configuration.
+func (instance *HttpServer) SetAuth0(auth0 *qb_auth0.Auth0) *HttpServer {
+	instance.auth0 = auth0
+	instance.auth0Owned = false
+	return instance
+}
+
 func (instance *HttpServer) ConfigureHosts(settings ...map[string]interface{}) {
 	instance.cfgHosts = make([]*ConfigHost, 0)
 	for _, setting := range settings {
@@ -250,6 +391,66 @@ func (instance *HttpServer) ConfigureHosts(settings ...map[string]interface{}) {
 	}
 }
 
+//----------------------------------------------------------------------------------------------------------------------
+//	m o n i t o r i n g
+//----------------------------------------------------------------------------------------------------------------------
+
+// Metrics returns the collector of the server metrics. Counters are kept on restart.
+func (instance *HttpServer) Metrics() *HttpMetrics {
+	// called by requests and by the monitor
+	instance.metricsOnce.Do(func() {
+		instance.metrics = NewHttpMetrics(instance.cfgMetrics)
+		instance.metrics.Gauge("websocket_connections", instance.websocketConnections)
+	})
+	return instance.metrics
+}
+
+// OnAccessLog sets a callback receiving the access log entries. The access log is enabled even if
+// the configuration has no "access_log" section: in that case entries are not written to file.
+func (instance *HttpServer) OnAccessLog(callback CallbackAccessLog) *HttpServer {
+	instance.callbackAccessLog = callback
+	return instance
+}
+
+// SetWebsocketBroker sets the fan-out of websocket broadcasts: a shared broker lets several
+// server processes share rooms. Default is an in-process broker.
+func (instance *HttpServer) SetWebsocketBroker(broker IWebsocketBroker) *HttpServer {
+	instance.broker = broker
+	return instance
+}
+
+// SetLimiterStore sets the storage of the limiter rules counters: a shared store applies
+// the limits across several server processes. Overrides the "storage" of the limiter configuration.
+func (instance *HttpServer) SetLimiterStore(store ILimiterStore) *HttpServer {
+	instance.limiterStore = store
+	return instance
+}
+
+// SetCacheStore sets the storage of the response cache: a shared store shares the cache
+// across several server processes. Overrides the "storage" of the cache configuration.
+func (instance *HttpServer) SetCacheStore(store ICacheStore) *HttpServer {
+	instance.cacheStore = store
+	return instance
+}
+
+// Cache returns the response cache, nil if the cache is not enabled or the server is not started
+func (instance *HttpServer) Cache() *HttpCache {
+	return instance.cache
+}
+
+// LocalCA returns the certificate authority of the "local_ca" configuration, nil if disabled or not started.
+// Clients trust the server certificates adding LocalCA().RootPem() to their pool.
+func (instance *HttpServer) LocalCA() *certs.LocalCA {
+	return instance.localCA
+}
+
+// Health returns the registry of liveness and readiness checks:
+//
+//	server.Health().AddReadinessCheck("database", db.Ping)
+func (instance *HttpServer) Health() *HttpHealth {
+	return instance.health
+}
+
 //----------------------------------------------------------------------------------------------------------------------
 //	m i d d l e w a r e
 //----------------------------------------------------------------------------------------------------------------------
@@ -377,6 +578,41 @@ func (instance *HttpServer) Websocket(route string, callback func(conn *HttpWebs
 	return instance
 }
 
+//----------------------------------------------------------------------------------------------------------------------
+//	s e r v e r - s e n t   e v e n t s
+//----------------------------------------------------------------------------------------------------------------------
+
+// SSE adds a route streaming Server-Sent Events. callback is invoked for each client to subscribe the
+// stream to channels of SSEHub() (i.e. from the route params) and the stream stays open after it returns:
+//
+//	server.SSE("/events/:channel", func(stream *HttpSSEStream) {
+//		stream.Subscribe(stream.Ctx().Params("channel"))
+//	})
+func (instance *HttpServer) SSE(route string, callback func(stream *HttpSSEStream)) *HttpServer {
+	if len(route) > 0 {
+		hub := instance.SSEHub()
+		instance.Get(route, func(ctx *fiber.Ctx) error {
+			// configuration read on each request: it can be set after the route
+			var heartbeat, retry time.Duration
+			if cfg := instance.cfgSSE; nil != cfg {
+				heartbeat, retry = cfg.Heartbeat*time.Millisecond, cfg.Retry*time.Millisecond
+			}
+			return hub.Handler(heartbeat, retry, callback)(ctx)
+		})
+	}
+	return instance
+}
+
+// SSEHub returns the hub publishing events to the streams of the SSE routes:
+//
+//	server.SSEHub().Publish("prices", "update", prices)
+func (instance *HttpServer) SSEHub() *HttpSSEHub {
+	if nil == instance.sseHub {
+		instance.sseHub = NewHttpSSEHub()
+	}
+	return instance.sseHub
+}
+
 //----------------------------------------------------------------------------------------------------------------------
 //	s t a r t
 //----------------------------------------------------------------------------------------------------------------------
@@ -388,14 +624,26 @@ func (instance *HttpServer) IsOpen() bool {
 	return false
 }
 
+// Restart starts the apps with the current configuration on the listeners in use, then drains the previous apps:
+// connections are accepted all along and in-flight requests are completed. If the new apps fail to start the
+// previous ones are kept.
 func (instance *HttpServer) Restart() []error {
 	response := make([]error, 0)
 	if nil != instance && instance.IsOpen() {
-		err := instance.Stop()
-		if nil != err {
-			response = append(response, err)
-		} else {
-			response = append(response, instance.Start()...)
+		previous := instance.detach()
+		// monitors the certificates of the new configuration
+		instance.stopSSLMonitor()
+		response = append(response, instance.Start()...)
+		if len(response) > 0 {
+			failed := instance.detach()
+			instance.attach(previous)
+			_ = instance.drain(failed, instance.shutdownTimeout())
+			instance.closeListeners(previous.addrs)
+			return response
+		}
+		instance.closeListeners(instance.addrs)
+		if err := instance.drain(previous, instance.shutdownTimeout()); nil != err {
+			instance.notifyError("Error draining the previous server", err, nil)
 		}
 	}
 	return response
@@ -403,11 +651,39 @@ func (instance *HttpServer) Restart() []error {
 
 func (instance *HttpServer) Start(settings ...map[string]interface{}) []error {
 	errorList := make([]error, 0)
-	instance.stopChan = make(chan bool, 1)
+	if nil == instance.stopChan {
+		// kept on restart: Join returns on stop only
+		instance.stopChan = make(chan bool, 1)
+	}
+	instance.socketsMux.Lock()
+	instance.sockets = make([]*HttpWebsocket, 0)
+	instance.socketsMux.Unlock()
 	if len(settings) > 0 {
 		instance.ConfigureHosts(settings...)
 	}
 	instance.initWsHosts()
+	if err := instance.initLocalCA(); nil != err {
+		errorList = append(errorList, err)
+	}
+	errorList = append(errorList, instance.initProxies()...)
+	if err := instance.initAuth(); nil != err {
+		errorList = append(errorList, err)
+	}
+	if err := instance.initAccessLog(); nil != err {
+		errorList = append(errorList, err)
+	}
+	if err := instance.initLimiterRules(); nil != err {
+		errorList = append(errorList, err)
+	}
+	if err := instance.initRewrite(); nil != err {
+		errorList = append(errorList, err)
+	}
+	if err := instance.initCache(); nil != err {
+		errorList = append(errorList, err)
+	}
+	if nil != instance.sseHub && nil != instance.cfgSSE && instance.cfgSSE.ReplaySize > 0 {
+		instance.sseHub.SetReplaySize(instance.cfgSSE.ReplaySize)
+	}
 	for _, host := range instance.cfgHosts {
 		err := instance.listen(host)
 		if nil != err {
@@ -422,15 +698,26 @@ func (instance *HttpServer) Start(settings ...map[string]interface{}) []error {
 	return errorList
 }
 
+// Stop drains the server waiting the shutdown timeout of the configuration
 func (instance *HttpServer) Stop() (err error) {
-	if nil != instance && len(instance.apps) > 0 {
+	if nil != instance {
+		err = instance.Shutdown(instance.shutdownTimeout())
+	}
+	return
+}
+
+// Shutdown stops accepting connections, sends a close frame to the websocket clients and waits until
+// in-flight requests and websocket clients are done or timeout expires
+func (instance *HttpServer) Shutdown(timeout time.Duration) (err error) {
+	if nil != instance && nil != instance.stopChan && len(instance.apps) > 0 {
 		instance.stopSSLMonitor()
-		for _, app := range instance.apps {
-			appErr := app.Shutdown()
-			if nil != appErr {
-				err = appErr
-			}
-		}
+		instance.stopLocalCA()
+		runtime := instance.detach()
+		instance.closeListeners(nil)
+		err = instance.drain(runtime, timeout)
+		instance.stopAuth()
+		instance.stopLimiterRules()
+		instance.stopCache()
 		instance.stopChan <- true
 		// reset stopChan
 		instance.stopChan = nil
@@ -449,6 +736,95 @@ func (instance *HttpServer) Join() (err error) {
 //	p r i v a t e
 //----------------------------------------------------------------------------------------------------------------------
 
+func (instance *HttpServer) shutdownTimeout() time.Duration {
+	if nil != instance.cfgServer && instance.cfgServer.ShutdownTimeout > 0 {
+		return instance.cfgServer.ShutdownTimeout * time.Millisecond
+	}
+	return 10 * time.Second
+}
+
+// detach removes the running apps from the server
+func (instance *HttpServer) detach() *httpServerRuntime {
+	instance.socketsMux.Lock()
+	defer instance.socketsMux.Unlock()
+	runtime := &httpServerRuntime{
+		apps:         instance.apps,
+		addrs:        instance.addrs,
+		proxies:      instance.proxies,
+		sockets:      instance.sockets,
+		accessLog:    instance.accessLog,
+		certificates: instance.certificates,
+	}
+	instance.apps = make([]*fiber.App, 0)
+	instance.addrs = nil
+	instance.proxies = nil
+	instance.sockets = make([]*HttpWebsocket, 0)
+	instance.accessLog = nil
+	instance.certificates = nil
+	return runtime
+}
+
+func (instance *HttpServer) attach(runtime *httpServerRuntime) {
+	instance.socketsMux.Lock()
+	defer instance.socketsMux.Unlock()
+	instance.apps = runtime.apps
+	instance.addrs = runtime.addrs
+	instance.proxies = runtime.proxies
+	instance.sockets = runtime.sockets
+	instance.accessLog = runtime.accessLog
+	instance.certificates = runtime.certificates
+}
+
+// drain shuts the apps down and releases the resources of a runtime
+func (instance *HttpServer) drain(runtime *httpServerRuntime, timeout time.Duration) (err error) {
+	var wg sync.WaitGroup
+	var mux sync.Mutex
+	for _, socket := range runtime.sockets {
+		wg.Add(1)
+		go func(socket *HttpWebsocket) {
+			defer wg.Done()
+			socket.Drain(timeout)
+		}(socket)
+	}
+	for _, app := range runtime.apps {
+		if nil != instance.sseHub {
+			// open streams would block the shutdown
+			instance.sseHub.closeStreams(app)
+		}
+		wg.Add(1)
+		go func(app *fiber.App) {
+			defer wg.Done()
+			if appErr := app.ShutdownWithTimeout(timeout); nil != appErr {
+				mux.Lock()
+				err = appErr
+				mux.Unlock()
+			}
+		}(app)
+	}
+	wg.Wait()
+
+	for _, p := range runtime.proxies {
+		p.Stop()
+	}
+	for _, socket := range runtime.sockets {
+		socket.Close()
+	}
+	if nil != runtime.accessLog {
+		_ = runtime.accessLog.Close()
+	}
+	return
+}
+
+// closeListeners closes the sockets of the addresses not in keep
+func (instance *HttpServer) closeListeners(keep []string) {
+	for addr, ln := range instance.listeners {
+		if qbc.Arrays.IndexOf(addr, keep) == -1 {
+			ln.close()
+			delete(instance.listeners, addr)
+		}
+	}
+}
+
 func (instance *HttpServer) initWsHosts() {
 	for _, host := range instance.cfgHosts {
 		if nil != host {
@@ -460,6 +836,170 @@ func (instance *HttpServer) initWsHosts() {
 	}
 }
 
+func (instance *HttpServer) initProxies() []error {
+	response := make([]error, 0)
+	instance.proxies = make([]*HttpProxy, 0)
+	for _, cfg := range instance.cfgProxy {
+		if nil != cfg && cfg.Enabled {
+			p, err := NewHttpProxy(cfg)
+			if nil != err {
+				instance.notifyError(qbc.Strings.Format("Error creating proxy: '%s%s'", cfg.Host, cfg.Prefix), err, nil)
+				response = append(response, err)
+				continue
+			}
+			p.Start()
+			instance.proxies = append(instance.proxies, p)
+		}
+	}
+	return response
+}
+
+func (instance *HttpServer) initAuth() error {
+	instance.auth = nil
+	if nil == instance.cfgAuth || !instance.cfgAuth.Enabled {
+		return nil
+	}
+	if nil == instance.auth0 && nil != instance.cfgAuth.Auth0 {
+		config := instance.cfgAuth.Auth0
+		if nil == config.CacheStorage {
+			config.CacheStorage = new(qb_auth0.Auth0ConfigStorage)
+		}
+		if nil == config.AuthStorage {
+			config.AuthStorage = new(qb_auth0.Auth0ConfigStorage)
+		}
+		auth0 := qb_auth0.NewAuth0(config)
+		if err := auth0.Open(); nil != err {
+			instance.notifyError("Error opening Auth0 storage", err, nil)
+			return err
+		}
+		instance.auth0, instance.auth0Owned = auth0, true
+	}
+	auth, err := NewHttpAuth(instance.cfgAuth, instance.auth0)
+	if nil != err {
+		instance.notifyError("Error creating auth middleware", err, nil)
+		return err
+	}
+	instance.auth = auth
+	return nil
+}
+
+func (instance *HttpServer) stopAuth() {
+	if instance.auth0Owned && nil != instance.auth0 {
+		_ = instance.auth0.Close()
+		instance.auth0, instance.auth0Owned = nil, false
+	}
+	instance.auth = nil
+}
+
+func (instance *HttpServer) initAccessLog() error {
+	instance.accessLog = nil
+	cfg := instance.cfgAccessLog
+	if nil == cfg || !cfg.Enabled {
+		if nil == instance.callbackAccessLog {
+			return nil
+		}
+		cfg = &ConfigAccessLog{Enabled: true}
+	}
+	accessLog, err := NewHttpAccessLog(cfg, instance.workspace, instance.callbackAccessLog)
+	if nil != err {
+		instance.notifyError(qbc.Strings.Format("Error opening access log: '%s'", cfg.Filename), err, nil)
+		return err
+	}
+	if nil != instance.cfgAuth && len(instance.cfgAuth.LocalsKey) > 0 {
+		accessLog.localsKey = instance.cfgAuth.LocalsKey
+	}
+	instance.accessLog = accessLog
+	return nil
+}
+
+func (instance *HttpServer) initLimiterRules() error {
+	instance.limiter = nil
+	cfg := instance.cfgLimiter
+	if nil == cfg || !cfg.Enabled || len(cfg.Rules) == 0 {
+		return nil
+	}
+	if nil == instance.limiterStore && nil != cfg.Storage && cfg.Storage.Type == LimiterStorageBolt {
+		filename := cfg.Storage.Filename
+		if len(filename) == 0 {
+			filename = "./limiter"
+		}
+		store, err := NewLimiterBoltStore(instance.absolutePath(filename))
+		if nil != err {
+			instance.notifyError(qbc.Strings.Format("Error opening limiter storage: '%s'", filename), err, nil)
+			return err
+		}
+		instance.limiterStore, instance.limiterStoreOwned = store, true
+	}
+	instance.limiter = NewHttpLimiter(cfg.Rules, instance.limiterStore, instance.handleLimitReached)
+	if nil != instance.cfgAuth && len(instance.cfgAuth.LocalsKey) > 0 {
+		instance.limiter.localsKey = instance.cfgAuth.LocalsKey
+	}
+	return nil
+}
+
+func (instance *HttpServer) stopLimiterRules() {
+	if instance.limiterStoreOwned && nil != instance.limiterStore {
+		_ = instance.limiterStore.Close()
+		instance.limiterStore, instance.limiterStoreOwned = nil, false
+	}
+	instance.limiter = nil
+}
+
+func (instance *HttpServer) initCache() error {
+	instance.cache = nil
+	cfg := instance.cfgCache
+	if nil == cfg || !cfg.Enabled || len(cfg.Rules) == 0 {
+		return nil
+	}
+	if nil == instance.cacheStore && nil != cfg.Storage && cfg.Storage.Type == CacheStorageBolt {
+		filename := cfg.Storage.Filename
+		if len(filename) == 0 {
+			filename = "./cache"
+		}
+		store, err := NewCacheBoltStore(instance.absolutePath(filename))
+		if nil != err {
+			instance.notifyError(qbc.Strings.Format("Error opening cache storage: '%s'", filename), err, nil)
+			return err
+		}
+		instance.cacheStore, instance.cacheStoreOwned = store, true
+	}
+	if nil == instance.cacheStore {
+		// kept on restart
+		instance.cacheStore, instance.cacheStoreOwned = NewCacheMemoryStore(), true
+	}
+	instance.cache = NewHttpCache(cfg.Rules, instance.cacheStore)
+	if nil != instance.cfgAuth && len(instance.cfgAuth.LocalsKey) > 0 {
+		instance.cache.localsKey = instance.cfgAuth.LocalsKey
+	}
+	if nil != instance.auth {
+		instance.cache.token = instance.auth.token
+	}
+	return nil
+}
+
+func (instance *HttpServer) stopCache() {
+	if instance.cacheStoreOwned && nil != instance.cacheStore {
+		_ = instance.cacheStore.Close()
+		instance.cacheStore, instance.cacheStoreOwned = nil, false
+	}
+	instance.cache = nil
+}
+
+func (instance *HttpServer) initRewrite() error {
+	instance.rewrite = nil
+	cfg := instance.cfgRewrite
+	if nil == cfg || !cfg.Enabled || len(cfg.Rules) == 0 {
+		return nil
+	}
+	if err := rewrite.Validate(cfg.Rules); nil != err {
+		instance.notifyError("Error creating rewrite rules", err, nil)
+		return err
+	}
+	// "proxy" rules are forwarded after auth and limits
+	instance.rewrite = rewrite.New(&rewrite.Config{Rules: cfg.Rules, ProxyTimeout: cfg.ProxyTimeout * time.Millisecond, DeferProxy: true})
+	return nil
+}
+
 func (instance *HttpServer) handleServerError(c *fiber.Ctx, err error) error {
 	if e := c.SendString(err.Error()); nil != e {
 		return e
@@ -468,6 +1008,7 @@ func (instance *HttpServer) handleServerError(c *fiber.Ctx, err error) error {
 }
 
 func (instance *HttpServer) handleLimitReached(c *fiber.Ctx) error {
+	instance.Metrics().IncLimiterHits()
 	// request limit reached
 	if nil != instance.callbackLimitReached {
 		return instance.callbackLimitReached(c)
@@ -507,40 +1048,41 @@ func (instance *HttpServer) listen(host *ConfigHost) error {
 		network := "tcp" // tcp, tcp4
 
 		var tlsConfig *tls.Config
-		if host.TLS && len(host.SslKey) > 0 && len(host.SslCert) > 0 {
-			pathCert := instance.absolutePath(host.SslCert)
-			pathKey := instance.absolutePath(host.SslKey)
-			cer, err := tls.LoadX509KeyPair(pathCert, pathKey)
+		if files := instance.certificateFiles(host); host.TLS && len(files) > 0 {
+			certificates, err := newTlsCertificates(files)
 			if err != nil {
-				msg := qbc.Strings.Format("Error loading Certificates: '%s' '%s'", pathCert, pathKey)
-				instance.notifyError(msg, err, nil)
-				return qbc.Errors.Prefix(err, msg)
+				instance.notifyError("Error loading Certificates", err, nil)
+				return err
 			}
-			tlsConfig = &tls.Config{Certificates: []tls.Certificate{cer}}
+			instance.certificates = append(instance.certificates, certificates)
+			tlsConfig = certificates.config()
 		}
 
-		if nil == tlsConfig {
-			// STANDARD LISTENER
-			ln, err := net.Listen(network, addr)
+		// the socket of the address is kept open on restart
+		if qbc.Arrays.IndexOf(addr, instance.addrs) > -1 {
+			msg := qbc.Strings.Format("Error creating listener: '%s'", addr)
+			instance.notifyError(msg, ErrAddressInUse, nil)
+			return qbc.Errors.Prefix(ErrAddressInUse, msg)
+		}
+		shared, b := instance.listeners[addr]
+		if !b {
+			var err error
+			shared, err = newSharedListener(network, addr)
 			if err != nil {
 				msg := qbc.Strings.Format("Error creating listener: '%s'", addr)
 				instance.notifyError(msg, err, nil)
 				return qbc.Errors.Prefix(err, msg)
-			} else {
-				go instance.listener(app, ln, addr)
 			}
-		} else {
+			instance.listeners[addr] = shared
+		}
+		instance.addrs = append(instance.addrs, addr)
+
+		var ln net.Listener = shared.listener()
+		if nil != tlsConfig {
 			// TLS LISTENER
-			ln, err := tls.Listen(network, addr, tlsConfig)
-			if err != nil {
-				msg := qbc.Strings.Format("Error creating TLS listener: '%s'", addr)
-				instance.notifyError(msg, err, nil)
-				return qbc.Errors.Prefix(err, msg)
-			} else {
-				// Start server with https/ssl enabled on http://localhost:443
-				go instance.listener(app, ln, addr)
-			}
+			ln = tls.NewListener(ln, tlsConfig)
 		}
+		go instance.listener(app, ln, addr)
 	} else {
 		// unable to create web application
 		return errors.New("nil_application")
@@ -565,6 +1107,14 @@ func (instance *HttpServer) newApp(cfgHost *ConfigHost) *fiber.App {
 		}
 		app.Use(recover.New(cfg))
 
+		// access log
+		if nil != instance.accessLog {
+			app.Use(instance.accessLog.Handler())
+		}
+
+		// metrics and health endpoints: before any limit or authentication
+		instance.initMonitoring(app)
+
 		// REQUEST-ID
 		if instance.cfgServer.EnableRequestId {
 			app.Use(requestid.New())
@@ -576,19 +1126,50 @@ func (instance *HttpServer) newApp(cfgHost *ConfigHost) *fiber.App {
 		// compression
 		initCompression(app, instance.cfgCompression)
 
+		// rewrite rules: before limits, auth, cache and routes see the rewritten path
+		if nil != instance.rewrite {
+			app.Use(instance.rewrite)
+		}
+
 		// limiter
 		initLimiter(app, instance.cfgLimiter, instance.handleLimitReached)
 
-		// prepare middlewares
+		// auth
+		if nil != instance.auth {
+			app.Use(instance.auth.Handler())
+		}
+
+		// limiter rules: after auth to limit by claim
+		if nil != instance.limiter {
+			app.Use(instance.limiter.Handler())
+		}
+
+		// response cache: after auth and limits, keys are the rewritten paths
+		if nil != instance.cache {
+			app.Use(instance.cache.Handler())
+		}
+
+		// "proxy" rewrite rules: as the proxies, behind auth and limits
+		if nil != instance.rewrite {
+			app.Use(rewrite.Forward())
+		}
+
+		// prepare middlewares: a copy, apps are created on every start
+		items := append([]*httpServerConfigRouteItem{}, instance.cfgMiddleware...)
 		for _, middleware := range instance.middlewares {
-			instance.cfgMiddleware = append(instance.cfgMiddleware, &httpServerConfigRouteItem{
+			items = append(items, &httpServerConfigRouteItem{
 				Path:     "",
 				Handlers: []fiber.Handler{middleware},
 			})
 		}
 		// Middleware
-		if len(instance.cfgMiddleware) > 0 {
-			instance.initMiddleware(app, instance.cfgMiddleware)
+		if len(items) > 0 {
+			instance.initMiddleware(app, items)
+		}
+
+		// Proxy
+		for _, p := range instance.proxies {
+			app.Use(p.Handler())
 		}
 
 		// Route
@@ -597,8 +1178,16 @@ func (instance *HttpServer) newApp(cfgHost *ConfigHost) *fiber.App {
 		}
 
 		// websocket
+		if nil == instance.broker {
+			// shared by all hosts
+			instance.broker = NewWebsocketMemoryBroker()
+		}
 		socket := NewHttpWebsocket(app, cfgHost, instance.cfgRouteWebsocket)
+		socket.SetBroker(instance.broker)
 		socket.Init()
+		instance.socketsMux.Lock()
+		instance.sockets = append(instance.sockets, socket)
+		instance.socketsMux.Unlock()
 
 		// Static
 		if len(instance.cfgStatic) > 0 {
@@ -628,6 +1217,43 @@ func (instance *HttpServer) newApp(cfgHost *ConfigHost) *fiber.App {
 	return app
 }
 
+func (instance *HttpServer) initMonitoring(app *fiber.App) {
+	if nil != instance.cfgMetrics && instance.cfgMetrics.Enabled {
+		metrics := instance.Metrics()
+		app.Use(metrics.Handler())
+		path := instance.cfgMetrics.Path
+		if len(path) == 0 {
+			path = DefaultMetricsPath
+		}
+		app.Get(path, metrics.Endpoint())
+	}
+	if nil != instance.cfgHealth && instance.cfgHealth.Enabled {
+		timeout := 5 * time.Second
+		if instance.cfgHealth.Timeout > 0 {
+			timeout = instance.cfgHealth.Timeout * time.Millisecond
+		}
+		liveness, readiness := instance.cfgHealth.LivenessPath, instance.cfgHealth.ReadinessPath
+		if len(liveness) == 0 {
+			liveness = DefaultLivenessPath
+		}
+		if len(readiness) == 0 {
+			readiness = DefaultReadinessPath
+		}
+		app.Get(liveness, instance.health.endpoint(instance.health.liveness, timeout))
+		app.Get(readiness, instance.health.endpoint(instance.health.readiness, timeout))
+	}
+}
+
+func (instance *HttpServer) websocketConnections() float64 {
+	instance.socketsMux.RLock()
+	defer instance.socketsMux.RUnlock()
+	count := 0
+	for _, socket := range instance.sockets {
+		count += socket.ClientsCount()
+	}
+	return float64(count)
+}
+
 func (instance *HttpServer) createApp(config *ConfigServer) *fiber.App {
 	if nil != config {
 
@@ -678,8 +1304,8 @@ func (instance *HttpServer) startSSLMonitor() {
 	if nil != instance && nil == instance.monitor {
 		instance.monitorFiles = make([]string, 0)
 		for _, host := range instance.cfgHosts {
-			if len(host.SslCert) > 0 && len(host.SslKey) > 0 {
-				instance.monitorFiles = append(instance.monitorFiles, host.SslKey, host.SslCert)
+			for _, pair := range instance.certificateFiles(host) {
+				instance.monitorFiles = append(instance.monitorFiles, pair[1], pair[0])
 			}
 		}
 		instance.monitor = NewMonitor(instance.monitorFiles)
@@ -696,12 +1322,85 @@ func (instance *HttpServer) stopSSLMonitor() {
 }
 
 func (instance *HttpServer) onSSLFileChanged(_ *qb_events.Event) {
-	// fmt.Println("CHANGED FILE....")
-	instance.stopSSLMonitor()
-	// waite a while to ensure certificates are well stored
-	time.Sleep(10 * time.Second)
-	// restart server
-	instance.Restart()
+	// reload certificates in place: listeners and open connections are kept
+	reloaded := false
+	for _, certificates := range instance.certificates {
+		if err := certificates.load(); nil != err {
+			// i.e. key written and certificate not yet: previous certificates are served until next change
+			instance.notifyError("Error reloading Certificates", err, nil)
+		} else {
+			reloaded = true
+		}
+	}
+	if reloaded {
+		instance.Metrics().IncTLSReloads()
+	}
+}
+
+// certificateFiles returns the absolute paths of certificate and key of a host, the default first
+func (instance *HttpServer) certificateFiles(host *ConfigHost) [][2]string {
+	response := make([][2]string, 0)
+	if len(host.SslKey) > 0 && len(host.SslCert) > 0 {
+		response = append(response, [2]string{instance.absolutePath(host.SslCert), instance.absolutePath(host.SslKey)})
+	}
+	for _, c := range host.Certificates {
+		if nil != c && len(c.Cert) > 0 && len(c.Key) > 0 {
+			response = append(response, [2]string{instance.absolutePath(c.Cert), instance.absolutePath(c.Key)})
+		}
+	}
+	return response
+}
+
+// initLocalCA issues the missing host certificates and starts their renewal
+func (instance *HttpServer) initLocalCA() error {
+	cfg := instance.cfgLocalCA
+	if nil == cfg || !cfg.Enabled {
+		return nil
+	}
+	dir := cfg.Dir
+	if len(dir) == 0 {
+		dir = "./ca"
+	}
+	dir = instance.absolutePath(dir)
+	if nil == instance.localCA {
+		config := cfg.LocalCAConfig
+		config.Dir = dir
+		localCA := certs.NewLocalCA(&config)
+		if err := localCA.Open(); nil != err {
+			instance.notifyError(qbc.Strings.Format("Error opening local CA: '%s'", config.Dir), err, nil)
+			return err
+		}
+		localCA.OnRenew(func(certFile string, err error) {
+			if nil != err {
+				instance.notifyError(qbc.Strings.Format("Error renewing certificate: '%s'", certFile), err, nil)
+			}
+			// renewed files are reloaded by the SSL monitor
+		})
+		instance.localCA = localCA
+	}
+	for _, host := range instance.cfgHosts {
+		for _, c := range host.Certificates {
+			if nil == c || len(c.Hosts) == 0 {
+				continue
+			}
+			if len(c.Cert) == 0 || len(c.Key) == 0 {
+				c.Cert = qbc.Paths.Concat(dir, c.Hosts[0]+".pem")
+				c.Key = qbc.Paths.Concat(dir, c.Hosts[0]+".key")
+			}
+			if _, err := instance.localCA.Ensure(instance.absolutePath(c.Cert), instance.absolutePath(c.Key), c.Hosts...); nil != err {
+				instance.notifyError(qbc.Strings.Format("Error issuing certificate: '%s'", c.Cert), err, nil)
+				return err
+			}
+		}
+	}
+	instance.localCA.Start()
+	return nil
+}
+
+func (instance *HttpServer) stopLocalCA() {
+	if nil != instance.localCA {
+		instance.localCA.Stop()
+	}
 }
 
 func (instance *HttpServer) staticModifyResponse(ctx *fiber.Ctx) (err error) {
@@ -769,7 +1468,8 @@ func initCompression(app *fiber.App, cfg *ConfigCompression) {
 }
 
 func initLimiter(app *fiber.App, cfg *ConfigLimiter, handler func(ctx *fiber.Ctx) error) {
-	if nil != cfg && cfg.Enabled {
+	// rules replace the global limit
+	if nil != cfg && cfg.Enabled && len(cfg.Rules) == 0 {
 		config := limiter.Config{}
 		if cfg.Max > 0 {
 			config.Max = cfg.Max
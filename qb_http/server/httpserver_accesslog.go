@@ -0,0 +1,306 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	qbc "github.com/rskvp/qb-core"
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//	c o n s t
+//----------------------------------------------------------------------------------------------------------------------
+
+const (
+	AccessLogCommon   = "common"
+	AccessLogCombined = "combined"
+	AccessLogJSON     = "json"
+
+	accessLogTimeFormat = "02/Jan/2006:15:04:05 -0700"
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//	t y p e s
+//----------------------------------------------------------------------------------------------------------------------
+
+type CallbackAccessLog func(entry *AccessLogEntry)
+
+type AccessLogEntry struct {
+	Time      time.Time     `json:"time"`
+	RequestId string        `json:"request_id,omitempty"`
+	RemoteIP  string        `json:"remote_ip"`
+	User      string        `json:"user,omitempty"`
+	Host      string        `json:"host"`
+	Method    string        `json:"method"`
+	URI       string        `json:"uri"`
+	Protocol  string        `json:"protocol"`
+	Status    int           `json:"status"`
+	Bytes     int           `json:"bytes"`
+	Latency   time.Duration `json:"-"` // written as latency_ms
+	Referer   string        `json:"referer,omitempty"`
+	UserAgent string        `json:"user_agent,omitempty"`
+}
+
+// HttpAccessLog writes an AccessLogEntry for each request to a rotating file and/or to a callback
+type HttpAccessLog struct {
+
+	//-- private --//
+	cfg       *ConfigAccessLog
+	writer    *accessLogWriter
+	callback  CallbackAccessLog
+	localsKey string // locals of the auth claims
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	c o n s t r u c t o r
+//----------------------------------------------------------------------------------------------------------------------
+
+// NewHttpAccessLog creates the access log. Filename of the configuration is relative to workspace.
+func NewHttpAccessLog(cfg *ConfigAccessLog, workspace string, callback CallbackAccessLog) (*HttpAccessLog, error) {
+	instance := new(HttpAccessLog)
+	instance.cfg = cfg
+	instance.callback = callback
+	instance.localsKey = DefaultAuthLocalsKey
+	if len(cfg.Filename) > 0 {
+		filename := cfg.Filename
+		if !qbc.Paths.IsAbs(filename) {
+			filename = qbc.Paths.Concat(workspace, filename)
+		}
+		writer, err := newAccessLogWriter(filename, int64(cfg.RotateSizeMb)*1024*1024, cfg.RotateInterval*time.Millisecond, cfg.MaxFiles)
+		if nil != err {
+			return nil, err
+		}
+		instance.writer = writer
+	}
+	return instance, nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p u b l i c
+//----------------------------------------------------------------------------------------------------------------------
+
+// Handler is the middleware logging requests
+func (instance *HttpAccessLog) Handler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		for _, skip := range instance.cfg.Skip {
+			if matchPath(skip, c.Path()) {
+				return c.Next()
+			}
+		}
+		start := time.Now()
+		err := c.Next()
+
+		entry := &AccessLogEntry{
+			Time:      start,
+			RequestId: c.GetRespHeader(fiber.HeaderXRequestID),
+			RemoteIP:  c.IP(),
+			User:      instance.user(c),
+			Host:      c.Hostname(),
+			Method:    c.Method(),
+			URI:       c.OriginalURL(),
+			Protocol:  string(c.Request().Header.Protocol()),
+			Status:    c.Response().StatusCode(),
+			Bytes:     len(c.Response().Body()),
+			Latency:   time.Since(start),
+			Referer:   c.Get(fiber.HeaderReferer),
+			UserAgent: c.Get(fiber.HeaderUserAgent),
+		}
+		if nil != err {
+			// the error handler writes the response after the middlewares
+			entry.Status = fiber.StatusInternalServerError
+			var e *fiber.Error
+			if errors.As(err, &e) {
+				entry.Status = e.Code
+			}
+		}
+		instance.Log(entry)
+		return err
+	}
+}
+
+// Log writes an entry
+func (instance *HttpAccessLog) Log(entry *AccessLogEntry) {
+	if nil != instance.writer {
+		_ = instance.writer.WriteLine(entry.Format(instance.cfg.Format))
+	}
+	if nil != instance.callback {
+		instance.callback(entry)
+	}
+}
+
+func (instance *HttpAccessLog) Close() error {
+	if nil != instance.writer {
+		return instance.writer.Close()
+	}
+	return nil
+}
+
+// Format returns the entry in "common", "combined" (default) or "json" format.
+// Request-id and latency (milliseconds) are appended to common and combined formats.
+func (instance *AccessLogEntry) Format(format string) string {
+	if format == AccessLogJSON {
+		data, _ := json.Marshal(struct {
+			*AccessLogEntry
+			Latency float64 `json:"latency_ms"`
+		}{instance, instance.latencyMs()})
+		return string(data)
+	}
+	line := fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %d",
+		instance.RemoteIP, dash(instance.User), instance.Time.Format(accessLogTimeFormat),
+		instance.Method, instance.URI, instance.Protocol, instance.Status, instance.Bytes)
+	if format != AccessLogCommon {
+		line += fmt.Sprintf(" \"%s\" \"%s\"", dash(instance.Referer), escapeQuote(instance.UserAgent))
+	}
+	return line + fmt.Sprintf(" \"%s\" %s", dash(instance.RequestId), formatFloat(instance.latencyMs()))
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p r i v a t e
+//----------------------------------------------------------------------------------------------------------------------
+
+func (instance *HttpAccessLog) user(c *fiber.Ctx) string {
+	if claims := Claims(c, instance.localsKey); nil != claims {
+		claim := instance.cfg.UserClaim
+		if len(claim) == 0 {
+			claim = "user_id"
+		}
+		if value, b := claims[claim]; b {
+			return qbc.Convert.ToString(value)
+		}
+	}
+	auth := c.Get(fiber.HeaderAuthorization)
+	if len(auth) > 6 && strings.EqualFold(auth[:6], "basic ") {
+		if data, err := base64.StdEncoding.DecodeString(auth[6:]); nil == err {
+			return strings.SplitN(string(data), ":", 2)[0]
+		}
+	}
+	return ""
+}
+
+func (instance *AccessLogEntry) latencyMs() float64 {
+	return float64(instance.Latency.Microseconds()) / 1000
+}
+
+func dash(value string) string {
+	if len(value) == 0 {
+		return "-"
+	}
+	return escapeQuote(value)
+}
+
+func escapeQuote(value string) string {
+	return strings.ReplaceAll(value, "\"", "\\\"")
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	accessLogWriter
+//----------------------------------------------------------------------------------------------------------------------
+
+// accessLogWriter appends lines to a file rotated by size and/or time.
+// Rotated files are renamed "<name>-<timestamp><ext>" in the same directory.
+type accessLogWriter struct {
+	filename string
+	maxSize  int64
+	interval time.Duration
+	maxFiles int
+	file     *os.File
+	size     int64
+	opened   time.Time
+	mux      sync.Mutex
+}
+
+func newAccessLogWriter(filename string, maxSize int64, interval time.Duration, maxFiles int) (*accessLogWriter, error) {
+	instance := &accessLogWriter{filename: filename, maxSize: maxSize, interval: interval, maxFiles: maxFiles}
+	if err := instance.open(); nil != err {
+		return nil, err
+	}
+	return instance, nil
+}
+
+func (instance *accessLogWriter) WriteLine(line string) error {
+	instance.mux.Lock()
+	defer instance.mux.Unlock()
+	if nil == instance.file {
+		return os.ErrClosed
+	}
+	data := []byte(line + "\n")
+	var rotateErr error
+	if (instance.maxSize > 0 && instance.size > 0 && instance.size+int64(len(data)) > instance.maxSize) ||
+		(instance.interval > 0 && time.Since(instance.opened) >= instance.interval) {
+		if rotateErr = instance.rotate(); nil == instance.file {
+			return rotateErr
+		}
+	}
+	n, err := instance.file.Write(data)
+	instance.size += int64(n)
+	if nil == err {
+		err = rotateErr
+	}
+	return err
+}
+
+func (instance *accessLogWriter) Close() error {
+	instance.mux.Lock()
+	defer instance.mux.Unlock()
+	if nil != instance.file {
+		err := instance.file.Close()
+		instance.file = nil
+		return err
+	}
+	return nil
+}
+
+func (instance *accessLogWriter) open() error {
+	if err := os.MkdirAll(filepath.Dir(instance.filename), os.ModePerm); nil != err {
+		return err
+	}
+	file, err := os.OpenFile(instance.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if nil != err {
+		return err
+	}
+	info, err := file.Stat()
+	if nil != err {
+		_ = file.Close()
+		return err
+	}
+	instance.file = file
+	instance.size = info.Size()
+	instance.opened = time.Now()
+	if instance.size > 0 {
+		// keep the period of an existing file
+		instance.opened = info.ModTime()
+	}
+	return nil
+}
+
+func (instance *accessLogWriter) rotate() error {
+	_ = instance.file.Close()
+	instance.file = nil
+	ext := filepath.Ext(instance.filename)
+	base := strings.TrimSuffix(instance.filename, ext)
+	target := fmt.Sprintf("%s-%s%s", base, time.Now().Format("20060102-150405.000000"), ext)
+	if err := os.Rename(instance.filename, target); nil != err {
+		// keep writing: rotation is tried again on next line
+		if openErr := instance.open(); nil != openErr {
+			return openErr
+		}
+		return err
+	}
+	if instance.maxFiles > 0 {
+		files, _ := filepath.Glob(base + "-*" + ext)
+		sort.Strings(files) // timestamp order
+		for i := 0; i < len(files)-instance.maxFiles; i++ {
+			_ = os.Remove(files[i])
+		}
+	}
+	return instance.open()
+}
@@ -0,0 +1,273 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	qbc "github.com/rskvp/qb-core"
+	"github.com/rskvp/qb-lib/qb_auth0"
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//	c o n s t
+//----------------------------------------------------------------------------------------------------------------------
+
+const (
+	TokenSourceHeader = "header"
+	TokenSourceCookie = "cookie"
+	TokenSourceQuery  = "query"
+
+	DefaultAuthLocalsKey = "claims"
+
+	errUnauthorized  = "unauthorized"
+	errForbidden     = "forbidden"
+	defaultRoleClaim = "roles"
+)
+
+var (
+	ErrMissingToken = errors.New("missing_token")
+	ErrInvalidToken = errors.New("invalid_token")
+	ErrMissingRole  = errors.New("missing_role")
+	ErrMissingClaim = errors.New("missing_claim")
+	ErrMissingAuth0 = errors.New("missing_auth0")
+	ErrRefreshToken = errors.New("refresh_token_not_allowed")
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//	t y p e s
+//----------------------------------------------------------------------------------------------------------------------
+
+// HttpAuth validates the access tokens of the requests matching the protected routes.
+// Claims of a valid token are stored in the locals of the context (see Claims()).
+type HttpAuth struct {
+
+	//-- private --//
+	cfg    *ConfigAuth
+	auth0  *qb_auth0.Auth0
+	source *ConfigAuthTokenSource
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	c o n s t r u c t o r
+//----------------------------------------------------------------------------------------------------------------------
+
+func NewHttpAuth(cfg *ConfigAuth, auth0 *qb_auth0.Auth0) (*HttpAuth, error) {
+	if nil == auth0 {
+		return nil, ErrMissingAuth0
+	}
+	instance := new(HttpAuth)
+	instance.cfg = cfg
+	instance.auth0 = auth0
+	instance.source = &ConfigAuthTokenSource{Type: TokenSourceHeader}
+	if nil != cfg.TokenSource {
+		instance.source.Type = strings.ToLower(cfg.TokenSource.Type)
+		instance.source.Name = cfg.TokenSource.Name
+	}
+	if len(instance.source.Name) == 0 {
+		if instance.source.Type == TokenSourceCookie || instance.source.Type == TokenSourceQuery {
+			instance.source.Name = "access_token"
+		} else {
+			instance.source.Name = fiber.HeaderAuthorization
+		}
+	}
+	return instance, nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p u b l i c
+//----------------------------------------------------------------------------------------------------------------------
+
+// Handler is the middleware checking the protected routes
+func (instance *HttpAuth) Handler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		route := instance.match(c)
+		if nil == route {
+			return c.Next()
+		}
+		token := instance.token(c)
+		if len(token) == 0 {
+			return authError(c, fiber.StatusUnauthorized, ErrMissingToken)
+		}
+		claims, err := instance.validate(token)
+		if nil != err {
+			return authError(c, fiber.StatusUnauthorized, err)
+		}
+		if err = instance.authorize(route, claims); nil != err {
+			return authError(c, fiber.StatusForbidden, err)
+		}
+		c.Locals(instance.localsKey(), claims)
+		return c.Next()
+	}
+}
+
+// Claims returns the claims of the token validated by the auth middleware
+func Claims(c *fiber.Ctx, localsKey ...string) map[string]interface{} {
+	key := DefaultAuthLocalsKey
+	if len(localsKey) > 0 && len(localsKey[0]) > 0 {
+		key = localsKey[0]
+	}
+	if claims, b := c.Locals(key).(map[string]interface{}); b {
+		return claims
+	}
+	return nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p r i v a t e
+//----------------------------------------------------------------------------------------------------------------------
+
+func (instance *HttpAuth) localsKey() string {
+	if len(instance.cfg.LocalsKey) > 0 {
+		return instance.cfg.LocalsKey
+	}
+	return DefaultAuthLocalsKey
+}
+
+func (instance *HttpAuth) match(c *fiber.Ctx) *ConfigAuthRoute {
+	for _, route := range instance.cfg.Routes {
+		if nil == route || !matchRoute(c, route.Path) {
+			continue
+		}
+		if len(route.Methods) == 0 {
+			return route
+		}
+		for _, method := range route.Methods {
+			if strings.EqualFold(method, c.Method()) {
+				return route
+			}
+		}
+	}
+	return nil
+}
+
+func (instance *HttpAuth) token(c *fiber.Ctx) string {
+	switch instance.source.Type {
+	case TokenSourceCookie:
+		return c.Cookies(instance.source.Name)
+	case TokenSourceQuery:
+		return c.Query(instance.source.Name)
+	default:
+		value := strings.TrimSpace(c.Get(instance.source.Name))
+		if len(value) > 7 && strings.EqualFold(value[:7], "bearer ") {
+			return strings.TrimSpace(value[7:])
+		}
+		if strings.EqualFold(instance.source.Name, fiber.HeaderAuthorization) {
+			// other schemes (i.e. Basic) are not bearer tokens
+			return ""
+		}
+		return value
+	}
+}
+
+// validate verifies the token with Auth0 and returns the claims of the payload
+func (instance *HttpAuth) validate(token string) (map[string]interface{}, error) {
+	if valid, err := instance.auth0.TokenValidate(token); !valid {
+		if nil == err {
+			err = ErrInvalidToken
+		}
+		return nil, err
+	}
+	raw := instance.auth0.TokenClaimsNoValidate(token)
+	if qbc.Reflect.GetString(raw, qb_auth0.FLD_SECRET_TYPE) == qb_auth0.RefreshSecretName {
+		return nil, ErrRefreshToken
+	}
+	return instance.auth0.TokenParse(token)
+}
+
+func (instance *HttpAuth) authorize(route *ConfigAuthRoute, claims map[string]interface{}) error {
+	if len(route.Roles) > 0 {
+		rolesClaim := instance.cfg.RolesClaim
+		if len(rolesClaim) == 0 {
+			rolesClaim = defaultRoleClaim
+		}
+		roles := claimValues(claims[rolesClaim])
+		found := false
+		for _, role := range route.Roles {
+			if qbc.Arrays.IndexOf(role, roles) > -1 {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%w: %s", ErrMissingRole, strings.Join(route.Roles, ","))
+		}
+	}
+	for key, expected := range route.Claims {
+		value, b := claims[key]
+		if !b || (qbc.Convert.ToString(expected) != "*" &&
+			qbc.Arrays.IndexOf(qbc.Convert.ToString(expected), claimValues(value)) == -1) {
+			return fmt.Errorf("%w: %s", ErrMissingClaim, key)
+		}
+	}
+	return nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	S T A T I C
+//----------------------------------------------------------------------------------------------------------------------
+
+// authError writes the JSON error of a rejected request: {"error":"unauthorized","message":"missing_token"}
+func authError(c *fiber.Ctx, status int, err error) error {
+	name := errForbidden
+	if status == fiber.StatusUnauthorized {
+		name = errUnauthorized
+		c.Set(fiber.HeaderWWWAuthenticate, "Bearer")
+	}
+	return c.Status(status).JSON(fiber.Map{"error": name, "message": err.Error()})
+}
+
+// matchRoute matches the path of the request as the router does (see routePath): a route is matched
+// whatever the case or the trailing slash of the request, unless the server is case sensitive or strict.
+func matchRoute(c *fiber.Ctx, pattern string) bool {
+	config := c.App().Config()
+	return matchPath(routePath(config, pattern), routePath(config, c.Path()))
+}
+
+// matchPath matches a path with a pattern. A pattern ending with "/*" matches the path and any sub path.
+func matchPath(pattern, value string) bool {
+	if strings.HasSuffix(pattern, "/*") {
+		prefix := strings.TrimSuffix(pattern, "/*")
+		if value == prefix || strings.HasPrefix(value, prefix+"/") {
+			return true
+		}
+	}
+	b, _ := path.Match(pattern, value)
+	return b
+}
+
+// routePath returns the path matched by the router: lowercase unless CaseSensitive,
+// without trailing slashes unless StrictRouting
+func routePath(config fiber.Config, value string) string {
+	if !config.CaseSensitive {
+		value = strings.ToLower(value)
+	}
+	if !config.StrictRouting && len(value) > 1 && strings.HasSuffix(value, "/") {
+		value = strings.TrimRight(value, "/")
+	}
+	return value
+}
+
+// claimValues returns a claim as a list of strings: arrays are converted item by item,
+// strings are split by comma
+func claimValues(value interface{}) []string {
+	response := make([]string, 0)
+	switch v := value.(type) {
+	case nil:
+	case []interface{}:
+		for _, item := range v {
+			response = append(response, qbc.Convert.ToString(item))
+		}
+	case []string:
+		response = append(response, v...)
+	case string:
+		for _, item := range strings.Split(v, ",") {
+			response = append(response, strings.TrimSpace(item))
+		}
+	default:
+		response = append(response, qbc.Convert.ToString(v))
+	}
+	return response
+}
@@ -0,0 +1,442 @@
+package server
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	qbc "github.com/rskvp/qb-core"
+	"github.com/rskvp/qb-lib/qb_dbal/bolt"
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//	c o n s t
+//----------------------------------------------------------------------------------------------------------------------
+
+const (
+	CacheStorageMemory = "memory"
+	CacheStorageBolt   = "bolt"
+
+	cacheCollection  = "http_cache"
+	cacheFieldExpire = "expire_at" // unix nano: the expire of the collection is in seconds
+)
+
+// headers of the response kept in the cache
+var cacheHeaders = []string{
+	fiber.HeaderContentType,
+	fiber.HeaderContentLanguage,
+	fiber.HeaderContentDisposition,
+	fiber.HeaderCacheControl,
+	fiber.HeaderExpires,
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	t y p e s
+//----------------------------------------------------------------------------------------------------------------------
+
+// CacheEntry is a cached response
+type CacheEntry struct {
+	Status   int               `json:"status"`
+	Headers  map[string]string `json:"headers"`
+	Body     []byte            `json:"body"`
+	ETag     string            `json:"etag"`
+	Modified int64             `json:"modified"` // Last-Modified (unix)
+	Created  int64             `json:"created"`  // unix
+	Public   bool              `json:"public"`   // "Cache-Control: public": served to authenticated requests
+}
+
+// ICacheStore keeps the cached responses. A store shared by several servers shares the cache across instances.
+type ICacheStore interface {
+	// Get returns nil if the key is missing or expired
+	Get(key string) (*CacheEntry, error)
+	// Set saves the entry, removed after ttl
+	Set(key string, entry *CacheEntry, ttl time.Duration) error
+	// DeletePrefix removes the keys starting with prefix and returns how many
+	DeletePrefix(prefix string) (int, error)
+	Close() error
+}
+
+// HttpCache caches the GET responses of the routes matching the rules
+type HttpCache struct {
+
+	//-- private --//
+	rules     []*ConfigCacheRule
+	store     ICacheStore
+	localsKey string                    // locals of the auth claims
+	token     func(c *fiber.Ctx) string // token of the auth middleware, if any
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	c o n s t r u c t o r
+//----------------------------------------------------------------------------------------------------------------------
+
+func NewHttpCache(rules []*ConfigCacheRule, store ICacheStore) *HttpCache {
+	instance := new(HttpCache)
+	instance.store = store
+	instance.localsKey = DefaultAuthLocalsKey
+	for _, rule := range rules {
+		if nil != rule && len(rule.Path) > 0 {
+			instance.rules = append(instance.rules, rule)
+		}
+	}
+	if nil == instance.store {
+		instance.store = NewCacheMemoryStore()
+	}
+	return instance
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p u b l i c
+//----------------------------------------------------------------------------------------------------------------------
+
+// Handler is the middleware caching the responses. Responses have ETag, Last-Modified and X-Cache (HIT or MISS)
+// headers, conditional requests (If-None-Match, If-Modified-Since) are answered with 304.
+// Only 200 responses without Set-Cookie and without "no-store" or "private" Cache-Control are cached.
+// Responses to authenticated requests (Authorization header, auth token or claims) are shared with
+// other users: they are cached and served only with "Cache-Control: public".
+func (instance *HttpCache) Handler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		method := c.Method()
+		if method != fiber.MethodGet && method != fiber.MethodHead {
+			return c.Next()
+		}
+		rule := instance.match(c)
+		if nil == rule {
+			return c.Next()
+		}
+		key := instance.key(c, rule)
+		authenticated := instance.authenticated(c)
+		// "Cache-Control: no-cache" of the client reloads the response
+		if !strings.Contains(strings.ToLower(c.Get(fiber.HeaderCacheControl)), "no-cache") {
+			if entry, err := instance.store.Get(key); nil == err && nil != entry && (entry.Public || !authenticated) {
+				return instance.send(c, rule, entry)
+			}
+		}
+
+		if err := c.Next(); nil != err {
+			return err
+		}
+		c.Set("X-Cache", "MISS")
+		entry := instance.entry(c)
+		if nil == entry {
+			return nil
+		}
+		if method == fiber.MethodGet && (entry.Public || !authenticated) {
+			ttl := 60 * time.Second
+			if rule.TTL > 0 {
+				ttl = rule.TTL * time.Millisecond
+			}
+			// storage failure: the response is sent anyway
+			_ = instance.store.Set(key, entry, ttl)
+		}
+		instance.validators(c, rule, entry)
+		if notModified(c, entry) {
+			c.Status(fiber.StatusNotModified)
+			c.Response().ResetBody()
+		}
+		return nil
+	}
+}
+
+// Purge removes the responses of a path of host, with its query if any, i.e. "/api/products?page=2".
+// host is the Host header of the requests, with the port if any. The responses of http and https and of
+// all the values of the Vary headers are removed.
+func (instance *HttpCache) Purge(host, key string) (int, error) {
+	path, query, _ := strings.Cut(key, "?")
+	return instance.purge(host, cacheKey(path, query)+"\n")
+}
+
+// PurgePrefix removes the responses of host whose keys start with prefix, i.e. "/api/products" removes
+// "/api/products", "/api/products?page=2" and "/api/products/1"
+func (instance *HttpCache) PurgePrefix(host, prefix string) (int, error) {
+	return instance.purge(host, prefix)
+}
+
+func (instance *HttpCache) Close() error {
+	return instance.store.Close()
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p r i v a t e
+//----------------------------------------------------------------------------------------------------------------------
+
+func (instance *HttpCache) match(c *fiber.Ctx) *ConfigCacheRule {
+	for _, rule := range instance.rules {
+		if matchRoute(c, rule.Path) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// key is the scheme, the host and the path with the sorted query, followed by a line for each header in Vary.
+// The host is part of the key: the apps and the proxies may serve different hosts on the same path.
+func (instance *HttpCache) key(c *fiber.Ctx, rule *ConfigCacheRule) string {
+	var sb strings.Builder
+	sb.WriteString(cacheOrigin(c.Protocol(), c.Hostname()))
+	sb.WriteString(cacheKey(c.Path(), string(c.Request().URI().QueryString())))
+	sb.WriteString("\n")
+	for _, name := range rule.Vary {
+		sb.WriteString(strings.ToLower(name) + ":" + c.Get(name) + "\n")
+	}
+	return sb.String()
+}
+
+func (instance *HttpCache) purge(host, prefix string) (int, error) {
+	count := 0
+	for _, scheme := range []string{"http", "https"} {
+		n, err := instance.store.DeletePrefix(cacheOrigin(scheme, host) + prefix)
+		if nil != err {
+			return count, err
+		}
+		count += n
+	}
+	return count, nil
+}
+
+// authenticated returns true if the response may depend on the identity of the client
+func (instance *HttpCache) authenticated(c *fiber.Ctx) bool {
+	if len(c.Get(fiber.HeaderAuthorization)) > 0 || nil != Claims(c, instance.localsKey) {
+		return true
+	}
+	return nil != instance.token && len(instance.token(c)) > 0
+}
+
+// entry returns the cacheable response, nil if the response cannot be cached
+func (instance *HttpCache) entry(c *fiber.Ctx) *CacheEntry {
+	response := c.Response()
+	if response.StatusCode() != fiber.StatusOK || response.IsBodyStream() ||
+		len(response.Header.Peek(fiber.HeaderSetCookie)) > 0 {
+		return nil
+	}
+	control := strings.ToLower(string(response.Header.Peek(fiber.HeaderCacheControl)))
+	if strings.Contains(control, "no-store") || strings.Contains(control, "private") {
+		return nil
+	}
+	entry := new(CacheEntry)
+	entry.Status = response.StatusCode()
+	entry.Body = append([]byte(nil), response.Body()...)
+	entry.Created = time.Now().Unix()
+	entry.Public = strings.Contains(control, "public")
+	entry.Headers = make(map[string]string)
+	for _, name := range cacheHeaders {
+		if value := response.Header.Peek(name); len(value) > 0 {
+			entry.Headers[name] = string(value)
+		}
+	}
+	entry.ETag = string(response.Header.Peek(fiber.HeaderETag))
+	if len(entry.ETag) == 0 {
+		sum := sha1.Sum(entry.Body)
+		entry.ETag = `"` + hex.EncodeToString(sum[:]) + `"`
+	}
+	entry.Modified = entry.Created
+	if t, err := http.ParseTime(string(response.Header.Peek(fiber.HeaderLastModified))); nil == err {
+		entry.Modified = t.Unix()
+	}
+	return entry
+}
+
+func (instance *HttpCache) send(c *fiber.Ctx, rule *ConfigCacheRule, entry *CacheEntry) error {
+	for name, value := range entry.Headers {
+		c.Set(name, value)
+	}
+	c.Set("X-Cache", "HIT")
+	if age := time.Now().Unix() - entry.Created; age > 0 {
+		c.Set(fiber.HeaderAge, strconv.FormatInt(age, 10))
+	}
+	instance.validators(c, rule, entry)
+	if notModified(c, entry) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+	c.Status(entry.Status)
+	return c.Send(entry.Body)
+}
+
+func (instance *HttpCache) validators(c *fiber.Ctx, rule *ConfigCacheRule, entry *CacheEntry) {
+	c.Set(fiber.HeaderETag, entry.ETag)
+	c.Set(fiber.HeaderLastModified, time.Unix(entry.Modified, 0).UTC().Format(http.TimeFormat))
+	if len(rule.Vary) > 0 {
+		c.Vary(rule.Vary...)
+	}
+}
+
+// notModified checks If-None-Match, or If-Modified-Since when If-None-Match is missing
+func notModified(c *fiber.Ctx, entry *CacheEntry) bool {
+	if match := c.Get(fiber.HeaderIfNoneMatch); len(match) > 0 {
+		for _, tag := range strings.Split(match, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == strings.TrimPrefix(entry.ETag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	if since, err := http.ParseTime(c.Get(fiber.HeaderIfModifiedSince)); nil == err {
+		return entry.Modified <= since.Unix()
+	}
+	return false
+}
+
+// cacheKey returns the path followed by the query with sorted arguments
+func cacheOrigin(scheme, host string) string {
+	return scheme + "://" + strings.ToLower(host)
+}
+
+func cacheKey(path, query string) string {
+	if len(query) > 0 {
+		if values, err := url.ParseQuery(query); nil == err && len(values) > 0 {
+			return path + "?" + values.Encode()
+		}
+	}
+	return path
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	CacheMemoryStore
+//----------------------------------------------------------------------------------------------------------------------
+
+// CacheMemoryStore keeps the responses in memory: the cache is lost on restart
+type CacheMemoryStore struct {
+	items map[string]*cacheMemoryItem
+	sets  int
+	mux   sync.Mutex
+}
+
+type cacheMemoryItem struct {
+	entry  *CacheEntry
+	expire time.Time
+}
+
+func NewCacheMemoryStore() *CacheMemoryStore {
+	instance := new(CacheMemoryStore)
+	instance.items = make(map[string]*cacheMemoryItem)
+	return instance
+}
+
+func (instance *CacheMemoryStore) Get(key string) (*CacheEntry, error) {
+	instance.mux.Lock()
+	defer instance.mux.Unlock()
+	if item, b := instance.items[key]; b && time.Now().Before(item.expire) {
+		return item.entry, nil
+	}
+	return nil, nil
+}
+
+func (instance *CacheMemoryStore) Set(key string, entry *CacheEntry, ttl time.Duration) error {
+	instance.mux.Lock()
+	defer instance.mux.Unlock()
+	now := time.Now()
+	instance.items[key] = &cacheMemoryItem{entry: entry, expire: now.Add(ttl)}
+
+	// remove expired items from time to time
+	if instance.sets++; instance.sets%1000 == 0 {
+		for k, v := range instance.items {
+			if now.After(v.expire) {
+				delete(instance.items, k)
+			}
+		}
+	}
+	return nil
+}
+
+func (instance *CacheMemoryStore) DeletePrefix(prefix string) (int, error) {
+	instance.mux.Lock()
+	defer instance.mux.Unlock()
+	count := 0
+	for k := range instance.items {
+		if strings.HasPrefix(k, prefix) {
+			delete(instance.items, k)
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (instance *CacheMemoryStore) Close() error {
+	return nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	CacheBoltStore
+//----------------------------------------------------------------------------------------------------------------------
+
+// CacheBoltStore keeps the responses in a Bolt database: the cache survives restarts
+type CacheBoltStore struct {
+	db   *bolt.BoltDatabase
+	coll *bolt.BoltCollection
+}
+
+// NewCacheBoltStore opens (or creates) the database file
+func NewCacheBoltStore(filename string) (*CacheBoltStore, error) {
+	config := bolt.NewBoltConfig()
+	config.Name = filename
+	db := bolt.NewBoltDatabase(config)
+	if err := db.Open(); nil != err {
+		return nil, err
+	}
+	coll, err := db.CollectionAutoCreate(cacheCollection)
+	if nil != err {
+		_ = db.Close()
+		return nil, err
+	}
+	coll.EnableExpire(true) // removes the expired responses
+	return &CacheBoltStore{db: db, coll: coll}, nil
+}
+
+func (instance *CacheBoltStore) Get(key string) (*CacheEntry, error) {
+	item, err := instance.coll.Get(key)
+	if nil != err {
+		return nil, err
+	}
+	m, b := item.(map[string]interface{})
+	if !b || qbc.Convert.ToInt64(m[cacheFieldExpire]) < time.Now().UnixNano() {
+		return nil, nil
+	}
+	entry := new(CacheEntry)
+	data, _ := json.Marshal(m)
+	if err = json.Unmarshal(data, entry); nil != err {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (instance *CacheBoltStore) Set(key string, entry *CacheEntry, ttl time.Duration) error {
+	var item map[string]interface{}
+	data, _ := json.Marshal(entry)
+	_ = json.Unmarshal(data, &item)
+	item["_key"] = key
+	item[cacheFieldExpire] = time.Now().Add(ttl).UnixNano()
+	item[bolt.FieldExpire] = time.Now().Add(ttl).Unix() + 1
+	return instance.coll.Upsert(item)
+}
+
+func (instance *CacheBoltStore) DeletePrefix(prefix string) (int, error) {
+	keys := make([]string, 0)
+	err := instance.coll.ForEach(func(k, v []byte) bool {
+		if strings.HasPrefix(string(k), prefix) {
+			keys = append(keys, string(k))
+		}
+		return false
+	})
+	if nil != err {
+		return 0, err
+	}
+	for i, key := range keys {
+		if err = instance.coll.Remove(key); nil != err {
+			return i, err
+		}
+	}
+	return len(keys), nil
+}
+
+func (instance *CacheBoltStore) Close() error {
+	instance.coll.EnableExpire(false)
+	return instance.db.Close()
+}
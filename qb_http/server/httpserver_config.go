@@ -1,6 +1,12 @@
 package server
 
-import "time"
+import (
+	"time"
+
+	"github.com/rskvp/qb-lib/qb_auth0"
+	"github.com/rskvp/qb-lib/qb_http/certs"
+	"github.com/rskvp/qb-lib/qb_http/server/rewrite"
+)
 
 type Config struct {
 	Server      *ConfigServer      `json:"server"`
@@ -9,6 +15,15 @@ type Config struct {
 	Limiter     *ConfigLimiter     `json:"limiter"`
 	Hosts       []*ConfigHost      `json:"hosts"`
 	Static      []*ConfigStatic    `json:"static"`
+	Proxy       []*ConfigProxy     `json:"proxy"`
+	Auth        *ConfigAuth        `json:"auth"`
+	Metrics     *ConfigMetrics     `json:"metrics"`
+	Health      *ConfigHealth      `json:"health"`
+	AccessLog   *ConfigAccessLog   `json:"access_log"`
+	Rewrite     *ConfigRewrite     `json:"rewrite"`
+	LocalCA     *ConfigLocalCA     `json:"local_ca"`
+	SSE         *ConfigSSE         `json:"sse"`
+	Cache       *ConfigCache       `json:"cache"`
 }
 
 // ConfigServer
@@ -42,6 +57,8 @@ type ConfigServer struct {
 	DisableKeepalive bool `json:"disable_keepalive"` // default: false
 	// When set to true, it will not print out debug information and startup message
 	DisableStartupMessage bool `json:"disable_startup_message"` // default false
+	// Milliseconds to wait for in-flight requests and websocket clients on stop and restart.
+	ShutdownTimeout time.Duration `json:"shutdown_timeout"` // default: 10000
 }
 
 type ConfigStatic struct {
@@ -67,10 +84,28 @@ type ConfigHost struct {
 	// TLS
 	SslCert string `json:"ssl_cert"`
 	SslKey  string `json:"ssl_key"`
+	// Certificates served on the same listener, picked by the server name (SNI) requested by the client.
+	// SslCert is the default certificate, otherwise the first of the list
+	Certificates []*ConfigHostCertificate `json:"certificates"`
 	// websocket
 	Websocket *ConfigHostWebsocket `json:"websocket"`
 }
 
+type ConfigHostCertificate struct {
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+	// Names and IPs of the certificate issued by the local CA (see ConfigLocalCA), i.e. ["api.internal", "10.0.0.5"].
+	// Empty Cert and Key are stored in the CA directory as "<first host>.pem" and "<first host>.key"
+	Hosts []string `json:"hosts"`
+}
+
+// ConfigLocalCA issues and renews the host certificates with "hosts". Clients must trust the root "ca.pem"
+type ConfigLocalCA struct {
+	Enabled bool `json:"enabled"`
+	// Dir is relative to the server workspace
+	certs.LocalCAConfig
+}
+
 type ConfigHostWebsocket struct {
 	Enabled bool `json:"enabled"`
 	// Specifies the duration for the handshake to complete.
@@ -118,4 +153,186 @@ type ConfigLimiter struct {
 	//
 	// Default: 1 * time.Minute
 	Duration time.Duration `json:"duration"`
+
+	// Rules limiting routes per key. When rules are set they replace the global limit (Max and Duration)
+	Rules []*ConfigLimiterRule `json:"rules"`
+	// Where rules store their counters. default: memory
+	Storage *ConfigLimiterStorage `json:"storage"`
+}
+
+type ConfigLimiterRule struct {
+	// Name of the rule, part of the storage keys. default: "rule_<index>"
+	Name string `json:"name"`
+	// Path pattern, i.e. "/api/*" ("*" at the end matches any sub path). The first matching rule applies
+	Path string `json:"path"`
+	// Methods limited. Empty limits all methods
+	Methods []string `json:"methods"`
+	// Key identifying the client: "ip", "header:<name>" (i.e. "header:X-Api-Key") or "claim:<name>"
+	// (i.e. "claim:user_id", requires the auth middleware). Requests without the key are limited by ip
+	Key string `json:"key"` // default: "ip"
+	// "sliding_window" or "token_bucket"
+	Algorithm string `json:"algorithm"` // default: "sliding_window"
+	// Requests allowed every Duration
+	Max int `json:"max"`
+	// Window of sliding_window, or time to refill Max tokens of token_bucket (milliseconds)
+	Duration time.Duration `json:"duration"` // default: 60000
+	// Size of the token bucket: requests allowed in a burst
+	Burst int `json:"burst"` // default: Max
+}
+
+type ConfigLimiterStorage struct {
+	// "memory" or "bolt". Bolt keeps the counters on restart
+	Type string `json:"type"` // default: "memory"
+	// Bolt database file, relative to the server workspace
+	Filename string `json:"filename"` // default: "./limiter"
+}
+
+// ConfigProxy forwards the requests matching Host and Prefix to a pool of upstream servers
+type ConfigProxy struct {
+	Enabled bool `json:"enabled"`
+	// Virtual host to match, i.e. "api.example.com". Empty matches any host
+	Host string `json:"host"`
+	// Path prefix to match, i.e. "/api". Empty matches any path
+	Prefix string `json:"prefix"`
+	// Remove Prefix from the path sent to the upstream
+	StripPrefix bool `json:"strip_prefix"`
+	// Upstream servers, i.e. "http://10.0.0.1:8080"
+	Upstreams []string `json:"upstreams"`
+	// "round_robin" or "least_conn"
+	Balancing string `json:"balancing"` // default: "round_robin"
+	// Timeout of a request to the upstream (milliseconds)
+	Timeout time.Duration `json:"timeout"` // default: 30000
+	// Send the Host header of the client instead of the upstream host
+	PreserveHost bool `json:"preserve_host"` // default: false
+	// Headers set on the request sent to the upstream. An empty value removes the header
+	RequestHeaders map[string]string `json:"request_headers"`
+	// Headers set on the response sent to the client. An empty value removes the header
+	ResponseHeaders map[string]string `json:"response_headers"`
+	// Pass websocket upgrades through to the upstream
+	Websocket bool `json:"websocket"` // default: false
+	// Upstreams failing the health check do not receive requests
+	HealthCheck *ConfigProxyHealthCheck `json:"health_check"`
+}
+
+type ConfigProxyHealthCheck struct {
+	Enabled bool   `json:"enabled"`
+	Path    string `json:"path"` // default: "/"
+	// Time between checks (milliseconds)
+	Interval time.Duration `json:"interval"` // default: 10000
+	// Timeout of a check (milliseconds). An upstream answering with an error or a 5xx status is unhealthy
+	Timeout time.Duration `json:"timeout"` // default: 2000
+}
+
+// ConfigAuth protects routes with Auth0 access tokens
+type ConfigAuth struct {
+	Enabled bool `json:"enabled"`
+	// Secrets and storages used to verify tokens. Not needed if the server uses SetAuth0()
+	Auth0 *qb_auth0.Auth0Config `json:"auth0"`
+	// Where the token is read from. default: "Authorization" header with "Bearer" scheme
+	TokenSource *ConfigAuthTokenSource `json:"token_source"`
+	// Claim containing the roles of the user (a list or a comma separated string)
+	RolesClaim string `json:"roles_claim"` // default: "roles"
+	// Key of the fiber.Ctx locals where the claims are stored
+	LocalsKey string `json:"locals_key"` // default: "claims"
+	// Protected routes. Requests not matching any route are not checked
+	Routes []*ConfigAuthRoute `json:"routes"`
+}
+
+type ConfigAuthTokenSource struct {
+	Type string `json:"type"` // "header", "cookie" or "query"
+	Name string `json:"name"` // default: "Authorization" for header, "access_token" for cookie and query
+}
+
+type ConfigAuthRoute struct {
+	// Path pattern, i.e. "/api/users/*" ("*" at the end matches any sub path)
+	Path string `json:"path"`
+	// Methods to protect. Empty protects all methods
+	Methods []string `json:"methods"`
+	// The user must have at least one of these roles
+	Roles []string `json:"roles"`
+	// Claims the token must contain with the given value. "*" only requires the claim
+	Claims map[string]interface{} `json:"claims"`
+}
+
+// ConfigMetrics exposes the server metrics in the Prometheus text format
+type ConfigMetrics struct {
+	Enabled   bool   `json:"enabled"`
+	Path      string `json:"path"`      // default: "/metrics"
+	Namespace string `json:"namespace"` // prefix of the metric names. default: "qb_http"
+	// Upper bounds (seconds) of the latency histogram buckets
+	Buckets []float64 `json:"buckets"` // default: 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10
+}
+
+// ConfigHealth exposes liveness and readiness endpoints running the checks registered on the server
+type ConfigHealth struct {
+	Enabled       bool   `json:"enabled"`
+	LivenessPath  string `json:"liveness_path"`  // default: "/healthz"
+	ReadinessPath string `json:"readiness_path"` // default: "/readyz"
+	// Timeout of each check (milliseconds). A check not completed in time fails
+	Timeout time.Duration `json:"timeout"` // default: 5000
+}
+
+// ConfigAccessLog writes a line for each request
+type ConfigAccessLog struct {
+	Enabled bool `json:"enabled"`
+	// "common", "combined" or "json"
+	Format string `json:"format"` // default: "combined"
+	// Log file, relative to the server workspace. Empty writes only to the callback set with OnAccessLog()
+	Filename string `json:"filename"` // default: ""
+	// Rotate the file when it exceeds this size (megabytes). 0 disables rotation by size
+	RotateSizeMb int `json:"rotate_size_mb"` // default: 0
+	// Rotate the file at this interval (milliseconds), i.e. 86400000 for daily files. 0 disables rotation by time
+	RotateInterval time.Duration `json:"rotate_interval"` // default: 0
+	// Number of rotated files to keep. 0 keeps all files
+	MaxFiles int `json:"max_files"` // default: 0
+	// Claim of the authenticated user written as identity. Without claims the user of basic authentication is used
+	UserClaim string `json:"user_claim"` // default: "user_id"
+	// Paths not logged, i.e. "/healthz"
+	Skip []string `json:"skip"`
+}
+
+// ConfigSSE configures the routes added with HttpServer.SSE()
+type ConfigSSE struct {
+	// Interval of the comments keeping the connections alive (milliseconds)
+	Heartbeat time.Duration `json:"heartbeat"` // default: 15000
+	// Reconnection delay suggested to the browsers (milliseconds). 0 keeps the browser default
+	Retry time.Duration `json:"retry"` // default: 0
+	// Events kept for each channel to resume the reconnecting clients from their Last-Event-ID
+	ReplaySize int `json:"replay_size"` // default: 100
+}
+
+// ConfigCache caches the GET responses of dynamic routes
+type ConfigCache struct {
+	Enabled bool `json:"enabled"`
+	// The first rule matching the path applies. Paths without rules are not cached
+	Rules []*ConfigCacheRule `json:"rules"`
+	// Where responses are stored. default: memory
+	Storage *ConfigCacheStorage `json:"storage"`
+}
+
+type ConfigCacheRule struct {
+	// Path pattern, i.e. "/api/products/*" ("*" at the end matches any sub path)
+	Path string `json:"path"`
+	// Time to live of the responses (milliseconds)
+	TTL time.Duration `json:"ttl"` // default: 60000
+	// Request headers the response depends on, i.e. "Accept-Language". They are part of the key
+	Vary []string `json:"vary"`
+}
+
+type ConfigCacheStorage struct {
+	// "memory" or "bolt". Bolt keeps the responses on restart
+	Type string `json:"type"` // default: "memory"
+	// Bolt database file, relative to the server workspace
+	Filename string `json:"filename"` // default: "./cache"
+}
+
+// ConfigRewrite declares mod_rewrite-style rules processed in order, i.e.
+//
+//	{"key": "/*", "value": "https://example.com/$1", "action": "redirect", "status": 301,
+//	 "conditions": [{"type": "host", "pattern": "^www\\.example\\.com$"}]}
+type ConfigRewrite struct {
+	Enabled bool            `json:"enabled"`
+	Rules   []*rewrite.Rule `json:"rules"`
+	// Timeout of the requests of "proxy" rules (milliseconds)
+	ProxyTimeout time.Duration `json:"proxy_timeout"` // default: 30000
 }
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//	c o n s t
+//----------------------------------------------------------------------------------------------------------------------
+
+const (
+	DefaultLivenessPath  = "/healthz"
+	DefaultReadinessPath = "/readyz"
+)
+
+var ErrHealthCheckTimeout = errors.New("health_check_timeout")
+
+//----------------------------------------------------------------------------------------------------------------------
+//	t y p e s
+//----------------------------------------------------------------------------------------------------------------------
+
+// HealthCheck returns nil if the checked resource is working
+type HealthCheck func() error
+
+// HttpHealth runs the liveness and readiness checks registered by the application
+type HttpHealth struct {
+
+	//-- private --//
+	liveness  map[string]HealthCheck
+	readiness map[string]HealthCheck
+	mux       sync.RWMutex
+}
+
+type HealthResponse struct {
+	Status string            `json:"status"` // "ok" or "fail"
+	Checks map[string]string `json:"checks"` // "ok" or the error of each check
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	c o n s t r u c t o r
+//----------------------------------------------------------------------------------------------------------------------
+
+func NewHttpHealth() *HttpHealth {
+	instance := new(HttpHealth)
+	instance.liveness = make(map[string]HealthCheck)
+	instance.readiness = make(map[string]HealthCheck)
+	return instance
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p u b l i c
+//----------------------------------------------------------------------------------------------------------------------
+
+// AddLivenessCheck registers a check of the liveness endpoint. A nil check removes name.
+func (instance *HttpHealth) AddLivenessCheck(name string, check HealthCheck) {
+	instance.set(instance.liveness, name, check)
+}
+
+// AddReadinessCheck registers a check of the readiness endpoint. A nil check removes name.
+func (instance *HttpHealth) AddReadinessCheck(name string, check HealthCheck) {
+	instance.set(instance.readiness, name, check)
+}
+
+func (instance *HttpHealth) Liveness(timeout time.Duration) *HealthResponse {
+	return instance.run(instance.liveness, timeout)
+}
+
+func (instance *HttpHealth) Readiness(timeout time.Duration) *HealthResponse {
+	return instance.run(instance.readiness, timeout)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p r i v a t e
+//----------------------------------------------------------------------------------------------------------------------
+
+func (instance *HttpHealth) set(checks map[string]HealthCheck, name string, check HealthCheck) {
+	instance.mux.Lock()
+	defer instance.mux.Unlock()
+	if nil == check {
+		delete(checks, name)
+	} else {
+		checks[name] = check
+	}
+}
+
+// run executes all checks in parallel
+func (instance *HttpHealth) run(checks map[string]HealthCheck, timeout time.Duration) *HealthResponse {
+	instance.mux.RLock()
+	names := make([]string, 0, len(checks))
+	for name := range checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	funcs := make([]HealthCheck, len(names))
+	for i, name := range names {
+		funcs[i] = checks[name]
+	}
+	instance.mux.RUnlock()
+
+	results := make([]error, len(funcs))
+	var wg sync.WaitGroup
+	for i, check := range funcs {
+		wg.Add(1)
+		go func(i int, check HealthCheck) {
+			defer wg.Done()
+			results[i] = runCheck(check, timeout)
+		}(i, check)
+	}
+	wg.Wait()
+
+	response := &HealthResponse{Status: "ok", Checks: make(map[string]string)}
+	for i, name := range names {
+		if nil != results[i] {
+			response.Status = "fail"
+			response.Checks[name] = results[i].Error()
+		} else {
+			response.Checks[name] = "ok"
+		}
+	}
+	return response
+}
+
+func (instance *HttpHealth) endpoint(checks map[string]HealthCheck, timeout time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		response := instance.run(checks, timeout)
+		if response.Status != "ok" {
+			c.Status(fiber.StatusServiceUnavailable)
+		}
+		return c.JSON(response)
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	S T A T I C
+//----------------------------------------------------------------------------------------------------------------------
+
+func runCheck(check HealthCheck, timeout time.Duration) (err error) {
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); nil != r {
+				done <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		done <- check()
+	}()
+	select {
+	case err = <-done:
+	case <-time.After(timeout):
+		err = ErrHealthCheckTimeout
+	}
+	return
+}
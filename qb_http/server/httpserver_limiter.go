@@ -0,0 +1,339 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	qbc "github.com/rskvp/qb-core"
+	"github.com/rskvp/qb-lib/qb_dbal/bolt"
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//	c o n s t
+//----------------------------------------------------------------------------------------------------------------------
+
+const (
+	LimiterSlidingWindow = "sliding_window"
+	LimiterTokenBucket   = "token_bucket"
+
+	LimiterStorageMemory = "memory"
+	LimiterStorageBolt   = "bolt"
+
+	limiterCollection = "rate_limits"
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//	t y p e s
+//----------------------------------------------------------------------------------------------------------------------
+
+// LimiterState is the state of a key of the limiter
+type LimiterState struct {
+	Tokens float64 `json:"tokens"` // token_bucket: available tokens
+	Last   int64   `json:"last"`   // token_bucket: last refill (unix nano)
+	Window int64   `json:"window"` // sliding_window: start of the current window (unix nano)
+	Count  int     `json:"count"`  // sliding_window: requests in the current window
+	Prev   int     `json:"prev"`   // sliding_window: requests in the previous window
+}
+
+// ILimiterStore keeps the limiter states. A store shared by several servers applies the limits across instances.
+type ILimiterStore interface {
+	// Update loads the state of key (an empty state if missing or expired), calls update and saves it.
+	// The whole operation must be atomic. The state can be removed after ttl.
+	Update(key string, ttl time.Duration, update func(state *LimiterState)) error
+	Close() error
+}
+
+// HttpLimiter applies the limiter rules
+type HttpLimiter struct {
+
+	//-- private --//
+	rules     []*ConfigLimiterRule
+	store     ILimiterStore
+	handler   func(ctx *fiber.Ctx) error // limit reached
+	localsKey string                     // locals of the auth claims
+}
+
+type limiterResult struct {
+	allowed   bool
+	limit     int
+	remaining int
+	reset     time.Duration // time to reset the limit
+	retry     time.Duration // time to wait before retrying a rejected request
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	c o n s t r u c t o r
+//----------------------------------------------------------------------------------------------------------------------
+
+func NewHttpLimiter(rules []*ConfigLimiterRule, store ILimiterStore, limitReached func(ctx *fiber.Ctx) error) *HttpLimiter {
+	instance := new(HttpLimiter)
+	instance.store = store
+	instance.handler = limitReached
+	instance.localsKey = DefaultAuthLocalsKey
+	for i, rule := range rules {
+		if nil == rule || rule.Max <= 0 {
+			continue
+		}
+		if len(rule.Name) == 0 {
+			rule.Name = fmt.Sprintf("rule_%d", i)
+		}
+		instance.rules = append(instance.rules, rule)
+	}
+	if nil == instance.store {
+		instance.store = NewLimiterMemoryStore()
+	}
+	return instance
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p u b l i c
+//----------------------------------------------------------------------------------------------------------------------
+
+// Handler is the middleware limiting requests. Responses have X-RateLimit-Limit, X-RateLimit-Remaining
+// and X-RateLimit-Reset (seconds) headers, rejected requests also Retry-After.
+func (instance *HttpLimiter) Handler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		rule := instance.match(c)
+		if nil == rule {
+			return c.Next()
+		}
+		result, err := instance.take(rule, rule.Name+":"+instance.key(c, rule))
+		if nil != err {
+			// storage failure: do not block the traffic
+			return c.Next()
+		}
+		c.Set("X-RateLimit-Limit", strconv.Itoa(result.limit))
+		c.Set("X-RateLimit-Remaining", strconv.Itoa(result.remaining))
+		c.Set("X-RateLimit-Reset", strconv.Itoa(seconds(result.reset)))
+		if !result.allowed {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds(result.retry)))
+			if nil != instance.handler {
+				return instance.handler(c)
+			}
+			return c.SendStatus(fiber.StatusTooManyRequests)
+		}
+		return c.Next()
+	}
+}
+
+func (instance *HttpLimiter) Close() error {
+	return instance.store.Close()
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p r i v a t e
+//----------------------------------------------------------------------------------------------------------------------
+
+func (instance *HttpLimiter) match(c *fiber.Ctx) *ConfigLimiterRule {
+	for _, rule := range instance.rules {
+		if len(rule.Path) > 0 && !matchRoute(c, rule.Path) {
+			continue
+		}
+		if len(rule.Methods) == 0 {
+			return rule
+		}
+		for _, method := range rule.Methods {
+			if strings.EqualFold(method, c.Method()) {
+				return rule
+			}
+		}
+	}
+	return nil
+}
+
+func (instance *HttpLimiter) key(c *fiber.Ctx, rule *ConfigLimiterRule) string {
+	kind, name, _ := strings.Cut(rule.Key, ":")
+	switch strings.ToLower(kind) {
+	case "header":
+		if value := c.Get(name); len(value) > 0 {
+			return "header:" + value
+		}
+	case "claim":
+		if claims := Claims(c, instance.localsKey); nil != claims {
+			if value := qbc.Convert.ToString(claims[name]); len(value) > 0 {
+				return "claim:" + value
+			}
+		}
+	}
+	return "ip:" + c.IP()
+}
+
+func (instance *HttpLimiter) take(rule *ConfigLimiterRule, key string) (*limiterResult, error) {
+	duration := 60 * time.Second
+	if rule.Duration > 0 {
+		duration = rule.Duration * time.Millisecond
+	}
+	result := &limiterResult{limit: rule.Max}
+	now := time.Now().UnixNano()
+	var update func(state *LimiterState)
+	var ttl time.Duration
+	if rule.Algorithm == LimiterTokenBucket {
+		capacity := float64(rule.Max)
+		if rule.Burst > 0 {
+			capacity = float64(rule.Burst)
+		}
+		result.limit = int(capacity)
+		rate := float64(rule.Max) / float64(duration) // tokens per nanosecond
+		ttl = time.Duration(capacity / rate)
+		update = func(state *LimiterState) {
+			if state.Last == 0 {
+				state.Tokens = capacity
+			} else if elapsed := now - state.Last; elapsed > 0 {
+				state.Tokens = math.Min(capacity, state.Tokens+float64(elapsed)*rate)
+			}
+			state.Last = now
+			if state.Tokens >= 1 {
+				state.Tokens--
+				result.allowed = true
+			} else {
+				result.retry = time.Duration((1 - state.Tokens) / rate)
+			}
+			result.remaining = int(state.Tokens)
+			result.reset = time.Duration((capacity - state.Tokens) / rate)
+		}
+	} else {
+		window := int64(duration)
+		ttl = 2 * duration
+		update = func(state *LimiterState) {
+			start := now - now%window
+			switch {
+			case state.Window == start:
+			case state.Window == start-window:
+				state.Prev, state.Count = state.Count, 0
+			default:
+				state.Prev, state.Count = 0, 0
+			}
+			state.Window = start
+			// requests of the previous window weighted by its overlap with the sliding window
+			weight := float64(window-(now-start)) / float64(window)
+			estimated := int(math.Floor(float64(state.Prev)*weight)) + state.Count
+			if estimated < rule.Max {
+				state.Count++
+				estimated++
+				result.allowed = true
+			} else {
+				result.retry = time.Duration(start + window - now)
+			}
+			result.remaining = rule.Max - estimated
+			if result.remaining < 0 {
+				result.remaining = 0
+			}
+			result.reset = time.Duration(start + window - now)
+		}
+	}
+	err := instance.store.Update(key, ttl, update)
+	return result, err
+}
+
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	LimiterMemoryStore
+//----------------------------------------------------------------------------------------------------------------------
+
+// LimiterMemoryStore keeps the states in memory: limits are lost on restart
+type LimiterMemoryStore struct {
+	items   map[string]*limiterMemoryItem
+	updates int
+	mux     sync.Mutex
+}
+
+type limiterMemoryItem struct {
+	state  LimiterState
+	expire time.Time
+}
+
+func NewLimiterMemoryStore() *LimiterMemoryStore {
+	instance := new(LimiterMemoryStore)
+	instance.items = make(map[string]*limiterMemoryItem)
+	return instance
+}
+
+func (instance *LimiterMemoryStore) Update(key string, ttl time.Duration, update func(state *LimiterState)) error {
+	instance.mux.Lock()
+	defer instance.mux.Unlock()
+	now := time.Now()
+	item, b := instance.items[key]
+	if !b || now.After(item.expire) {
+		item = new(limiterMemoryItem)
+		instance.items[key] = item
+	}
+	update(&item.state)
+	item.expire = now.Add(ttl)
+
+	// remove expired items from time to time
+	if instance.updates++; instance.updates%1000 == 0 {
+		for k, v := range instance.items {
+			if now.After(v.expire) {
+				delete(instance.items, k)
+			}
+		}
+	}
+	return nil
+}
+
+func (instance *LimiterMemoryStore) Close() error {
+	return nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	LimiterBoltStore
+//----------------------------------------------------------------------------------------------------------------------
+
+// LimiterBoltStore keeps the states in a Bolt database: limits survive restarts
+type LimiterBoltStore struct {
+	db   *bolt.BoltDatabase
+	coll *bolt.BoltCollection
+}
+
+// NewLimiterBoltStore opens (or creates) the database file
+func NewLimiterBoltStore(filename string) (*LimiterBoltStore, error) {
+	config := bolt.NewBoltConfig()
+	config.Name = filename
+	db := bolt.NewBoltDatabase(config)
+	if err := db.Open(); nil != err {
+		return nil, err
+	}
+	coll, err := db.CollectionAutoCreate(limiterCollection)
+	if nil != err {
+		_ = db.Close()
+		return nil, err
+	}
+	coll.EnableExpire(true) // removes the expired states
+	return &LimiterBoltStore{db: db, coll: coll}, nil
+}
+
+func (instance *LimiterBoltStore) Update(key string, ttl time.Duration, update func(state *LimiterState)) error {
+	return instance.db.Update(func(tx *bolt.BoltTransaction) error {
+		state := new(LimiterState)
+		item, err := tx.Get(limiterCollection, key)
+		if nil != err {
+			return err
+		}
+		if m, b := item.(map[string]interface{}); b && qbc.Convert.ToInt64(m[bolt.FieldExpire]) >= time.Now().Unix() {
+			data, _ := json.Marshal(m)
+			_ = json.Unmarshal(data, state)
+		}
+		update(state)
+
+		var entity map[string]interface{}
+		data, _ := json.Marshal(state)
+		_ = json.Unmarshal(data, &entity)
+		entity["_key"] = key
+		entity[bolt.FieldExpire] = time.Now().Add(ttl).Unix() + 1
+		return tx.Upsert(limiterCollection, entity)
+	})
+}
+
+func (instance *LimiterBoltStore) Close() error {
+	instance.coll.EnableExpire(false)
+	return instance.db.Close()
+}
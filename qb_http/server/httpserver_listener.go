@@ -0,0 +1,129 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var ErrAddressInUse = errors.New("address_in_use")
+
+//----------------------------------------------------------------------------------------------------------------------
+//	t y p e s
+//----------------------------------------------------------------------------------------------------------------------
+
+// sharedListener owns the socket of an address. The apps accept from virtual listeners on top of it:
+// on restart the new apps accept on the same socket before the previous ones are drained, so no
+// connection is refused.
+type sharedListener struct {
+	addr      string
+	ln        net.Listener
+	conns     chan net.Conn
+	done      chan bool
+	closeOnce sync.Once
+}
+
+// virtualListener is the listener of an app. Closing it stops the app from accepting, the socket is kept open.
+type virtualListener struct {
+	shared    *sharedListener
+	closed    chan bool
+	closeOnce sync.Once
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	c o n s t r u c t o r
+//----------------------------------------------------------------------------------------------------------------------
+
+func newSharedListener(network, addr string) (*sharedListener, error) {
+	ln, err := net.Listen(network, addr)
+	if nil != err {
+		return nil, err
+	}
+	instance := new(sharedListener)
+	instance.addr = addr
+	instance.ln = ln
+	instance.conns = make(chan net.Conn)
+	instance.done = make(chan bool)
+	go instance.accept()
+	return instance, nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	sharedListener
+//----------------------------------------------------------------------------------------------------------------------
+
+func (instance *sharedListener) listener() *virtualListener {
+	return &virtualListener{shared: instance, closed: make(chan bool)}
+}
+
+// close stops accepting: the connections already accepted are not affected
+func (instance *sharedListener) close() {
+	instance.closeOnce.Do(func() {
+		close(instance.done)
+		_ = instance.ln.Close()
+	})
+}
+
+func (instance *sharedListener) accept() {
+	for {
+		conn, err := instance.ln.Accept()
+		if nil != err {
+			select {
+			case <-instance.done:
+				return
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				instance.close()
+				return
+			}
+			// i.e. too many open files
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		instance.handOver(conn)
+	}
+}
+
+// handOver waits for an app accepting the connection
+func (instance *sharedListener) handOver(conn net.Conn) {
+	select {
+	case instance.conns <- conn:
+	case <-instance.done:
+		_ = conn.Close()
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	virtualListener
+//----------------------------------------------------------------------------------------------------------------------
+
+func (instance *virtualListener) Accept() (net.Conn, error) {
+	select {
+	case <-instance.closed:
+		return nil, net.ErrClosed
+	case <-instance.shared.done:
+		return nil, net.ErrClosed
+	case conn := <-instance.shared.conns:
+		select {
+		case <-instance.closed:
+			// closed while waiting: the connection goes to another app
+			go instance.shared.handOver(conn)
+			return nil, net.ErrClosed
+		default:
+		}
+		return conn, nil
+	}
+}
+
+func (instance *virtualListener) Close() error {
+	instance.closeOnce.Do(func() {
+		close(instance.closed)
+	})
+	return nil
+}
+
+func (instance *virtualListener) Addr() net.Addr {
+	return instance.shared.ln.Addr()
+}
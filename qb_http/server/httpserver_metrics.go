@@ -0,0 +1,253 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//	c o n s t
+//----------------------------------------------------------------------------------------------------------------------
+
+const (
+	DefaultMetricsPath      = "/metrics"
+	DefaultMetricsNamespace = "qb_http"
+)
+
+var defaultMetricsBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	t y p e s
+//----------------------------------------------------------------------------------------------------------------------
+
+// HttpMetrics collects the metrics of a server and writes them in the Prometheus text format
+type HttpMetrics struct {
+
+	//-- private --//
+	namespace   string
+	buckets     []float64
+	requests    map[metricsKey]*metricsHistogram
+	mux         sync.Mutex
+	inFlight    int64
+	limiterHits int64
+	tlsReloads  int64
+	gauges      map[string]func() float64
+}
+
+type metricsKey struct {
+	method string
+	route  string
+	status int
+}
+
+type metricsHistogram struct {
+	counts []uint64 // one per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	c o n s t r u c t o r
+//----------------------------------------------------------------------------------------------------------------------
+
+func NewHttpMetrics(cfg *ConfigMetrics) *HttpMetrics {
+	instance := new(HttpMetrics)
+	instance.namespace = DefaultMetricsNamespace
+	instance.buckets = defaultMetricsBuckets
+	if nil != cfg {
+		if len(cfg.Namespace) > 0 {
+			instance.namespace = cfg.Namespace
+		}
+		if len(cfg.Buckets) > 0 {
+			instance.buckets = append([]float64{}, cfg.Buckets...)
+			sort.Float64s(instance.buckets)
+		}
+	}
+	instance.requests = make(map[metricsKey]*metricsHistogram)
+	instance.gauges = make(map[string]func() float64)
+	return instance
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p u b l i c
+//----------------------------------------------------------------------------------------------------------------------
+
+// Handler is the middleware measuring requests
+func (instance *HttpMetrics) Handler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		atomic.AddInt64(&instance.inFlight, 1)
+		defer atomic.AddInt64(&instance.inFlight, -1)
+
+		err := c.Next()
+
+		status := c.Response().StatusCode()
+		if nil != err {
+			status = fiber.StatusInternalServerError
+			var e *fiber.Error
+			if errors.As(err, &e) {
+				status = e.Code
+			}
+		}
+		instance.Observe(c.Method(), c.Route().Path, status, time.Since(start))
+		return err
+	}
+}
+
+// Observe records a request
+func (instance *HttpMetrics) Observe(method, route string, status int, duration time.Duration) {
+	key := metricsKey{method: method, route: route, status: status}
+	seconds := duration.Seconds()
+
+	instance.mux.Lock()
+	defer instance.mux.Unlock()
+	histogram, b := instance.requests[key]
+	if !b {
+		histogram = &metricsHistogram{counts: make([]uint64, len(instance.buckets))}
+		instance.requests[key] = histogram
+	}
+	for i, bound := range instance.buckets {
+		if seconds <= bound {
+			histogram.counts[i]++
+			break
+		}
+	}
+	histogram.count++
+	histogram.sum += seconds
+}
+
+func (instance *HttpMetrics) IncLimiterHits() {
+	atomic.AddInt64(&instance.limiterHits, 1)
+}
+
+func (instance *HttpMetrics) IncTLSReloads() {
+	atomic.AddInt64(&instance.tlsReloads, 1)
+}
+
+// Gauge registers a value read each time the metrics are written, i.e. Gauge("queue_size", queue.Len)
+func (instance *HttpMetrics) Gauge(name string, value func() float64) {
+	instance.mux.Lock()
+	defer instance.mux.Unlock()
+	if nil == value {
+		delete(instance.gauges, name)
+	} else {
+		instance.gauges[name] = value
+	}
+}
+
+// InFlight returns the number of requests in progress
+func (instance *HttpMetrics) InFlight() int64 {
+	return atomic.LoadInt64(&instance.inFlight)
+}
+
+// Write writes all metrics in the Prometheus text format
+func (instance *HttpMetrics) Write(w io.Writer) error {
+	buf := new(bytes.Buffer)
+	ns := instance.namespace
+
+	instance.mux.Lock()
+	keys := make([]metricsKey, 0, len(instance.requests))
+	for key := range instance.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.status < b.status
+	})
+
+	writeHeader(buf, ns+"_requests_total", "counter", "Total number of HTTP requests.")
+	for _, key := range keys {
+		fmt.Fprintf(buf, "%s_requests_total{%s} %d\n", ns, key.labels(), instance.requests[key].count)
+	}
+	writeHeader(buf, ns+"_request_duration_seconds", "histogram", "Latency of HTTP requests.")
+	for _, key := range keys {
+		histogram := instance.requests[key]
+		labels := key.labels()
+		var cumulative uint64
+		for i, bound := range instance.buckets {
+			cumulative += histogram.counts[i]
+			fmt.Fprintf(buf, "%s_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", ns, labels, formatFloat(bound), cumulative)
+		}
+		fmt.Fprintf(buf, "%s_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", ns, labels, histogram.count)
+		fmt.Fprintf(buf, "%s_request_duration_seconds_sum{%s} %s\n", ns, labels, formatFloat(histogram.sum))
+		fmt.Fprintf(buf, "%s_request_duration_seconds_count{%s} %d\n", ns, labels, histogram.count)
+	}
+	gauges := make(map[string]func() float64, len(instance.gauges))
+	for name, value := range instance.gauges {
+		gauges[name] = value
+	}
+	instance.mux.Unlock()
+
+	writeHeader(buf, ns+"_requests_in_flight", "gauge", "Number of HTTP requests in progress.")
+	fmt.Fprintf(buf, "%s_requests_in_flight %d\n", ns, atomic.LoadInt64(&instance.inFlight))
+	writeHeader(buf, ns+"_limiter_hits_total", "counter", "Number of requests rejected by the limiter.")
+	fmt.Fprintf(buf, "%s_limiter_hits_total %d\n", ns, atomic.LoadInt64(&instance.limiterHits))
+	writeHeader(buf, ns+"_tls_reloads_total", "counter", "Number of reloads of the TLS certificates after a change of the files.")
+	fmt.Fprintf(buf, "%s_tls_reloads_total %d\n", ns, atomic.LoadInt64(&instance.tlsReloads))
+
+	names := make([]string, 0, len(gauges))
+	for name := range gauges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		writeHeader(buf, ns+"_"+name, "gauge", "")
+		fmt.Fprintf(buf, "%s_%s %s\n", ns, name, formatFloat(gauges[name]()))
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// Endpoint returns the handler writing the metrics
+func (instance *HttpMetrics) Endpoint() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderContentType, "text/plain; version=0.0.4; charset=utf-8")
+		return instance.Write(c)
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p r i v a t e
+//----------------------------------------------------------------------------------------------------------------------
+
+func (instance metricsKey) labels() string {
+	return fmt.Sprintf("method=\"%s\",route=\"%s\",status=\"%d\"", escapeLabel(instance.method), escapeLabel(instance.route), instance.status)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	S T A T I C
+//----------------------------------------------------------------------------------------------------------------------
+
+func writeHeader(buf *bytes.Buffer, name, kind, help string) {
+	if len(help) > 0 {
+		fmt.Fprintf(buf, "# HELP %s %s\n", name, help)
+	}
+	fmt.Fprintf(buf, "# TYPE %s %s\n", name, kind)
+}
+
+var labelEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
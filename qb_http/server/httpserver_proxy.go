@@ -0,0 +1,319 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/proxy"
+	"github.com/gofiber/websocket/v2"
+	"github.com/valyala/fasthttp"
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//	c o n s t
+//----------------------------------------------------------------------------------------------------------------------
+
+const (
+	BalancingRoundRobin = "round_robin"
+	BalancingLeastConn  = "least_conn"
+)
+
+var ErrNoHealthyUpstream = errors.New("no_healthy_upstream")
+
+//----------------------------------------------------------------------------------------------------------------------
+//	t y p e s
+//----------------------------------------------------------------------------------------------------------------------
+
+// HttpProxy forwards requests to a pool of upstream servers
+type HttpProxy struct {
+
+	//-- private --//
+	cfg       *ConfigProxy
+	upstreams []*proxyUpstream
+	client    *fasthttp.Client
+	timeout   time.Duration
+	next      uint64
+	stopChan  chan bool
+	mux       sync.Mutex
+}
+
+type proxyUpstream struct {
+	url     *url.URL
+	base    string // scheme://host/path without trailing "/"
+	active  int64  // requests in progress
+	healthy int32  // 1 healthy, 0 unhealthy
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	c o n s t r u c t o r
+//----------------------------------------------------------------------------------------------------------------------
+
+func NewHttpProxy(cfg *ConfigProxy) (*HttpProxy, error) {
+	instance := new(HttpProxy)
+	instance.cfg = cfg
+	instance.timeout = 30 * time.Second
+	if cfg.Timeout > 0 {
+		instance.timeout = cfg.Timeout * time.Millisecond
+	}
+	instance.client = &fasthttp.Client{
+		NoDefaultUserAgentHeader: true,
+		DisablePathNormalizing:   true,
+		TLSConfig:                &tls.Config{},
+	}
+	for _, address := range cfg.Upstreams {
+		u, err := url.Parse(address)
+		if nil != err || len(u.Host) == 0 {
+			return nil, errors.New("invalid_upstream: " + address)
+		}
+		instance.upstreams = append(instance.upstreams, &proxyUpstream{
+			url:     u,
+			base:    strings.TrimSuffix(u.Scheme+"://"+u.Host+u.Path, "/"),
+			healthy: 1,
+		})
+	}
+	if len(instance.upstreams) == 0 {
+		return nil, errors.New("missing_upstreams")
+	}
+	return instance, nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p u b l i c
+//----------------------------------------------------------------------------------------------------------------------
+
+// Match returns true if the request must be forwarded
+func (instance *HttpProxy) Match(c *fiber.Ctx) bool {
+	if len(instance.cfg.Host) > 0 && !strings.EqualFold(hostName(c.Hostname()), instance.cfg.Host) {
+		return false
+	}
+	if len(instance.cfg.Prefix) > 0 {
+		path := c.Path()
+		prefix := strings.TrimSuffix(instance.cfg.Prefix, "/")
+		return path == prefix || strings.HasPrefix(path, prefix+"/")
+	}
+	return true
+}
+
+// Handler is the middleware forwarding matching requests
+func (instance *HttpProxy) Handler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !instance.Match(c) {
+			return c.Next()
+		}
+		upstream := instance.pick()
+		if nil == upstream {
+			return fiber.NewError(fiber.StatusBadGateway, ErrNoHealthyUpstream.Error())
+		}
+		atomic.AddInt64(&upstream.active, 1)
+		defer atomic.AddInt64(&upstream.active, -1)
+
+		instance.rewriteRequest(c, upstream)
+		if instance.cfg.Websocket && websocket.IsWebSocketUpgrade(c) {
+			return instance.tunnel(c, upstream)
+		}
+		err := proxy.DoTimeout(c, upstream.base+instance.path(c), instance.timeout, instance.client)
+		if nil != err {
+			if errors.Is(err, fasthttp.ErrTimeout) {
+				return fiber.NewError(fiber.StatusGatewayTimeout, err.Error())
+			}
+			return fiber.NewError(fiber.StatusBadGateway, err.Error())
+		}
+		for k, v := range instance.cfg.ResponseHeaders {
+			setHeader(&c.Response().Header, k, v)
+		}
+		return nil
+	}
+}
+
+// Start runs the health checks, if enabled
+func (instance *HttpProxy) Start() {
+	instance.mux.Lock()
+	defer instance.mux.Unlock()
+	check := instance.cfg.HealthCheck
+	if nil == check || !check.Enabled || nil != instance.stopChan {
+		return
+	}
+	interval, timeout := 10*time.Second, 2*time.Second
+	if check.Interval > 0 {
+		interval = check.Interval * time.Millisecond
+	}
+	if check.Timeout > 0 {
+		timeout = check.Timeout * time.Millisecond
+	}
+	stop := make(chan bool)
+	instance.stopChan = stop
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			instance.checkHealth(check.Path, timeout)
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (instance *HttpProxy) Stop() {
+	instance.mux.Lock()
+	defer instance.mux.Unlock()
+	if nil != instance.stopChan {
+		close(instance.stopChan)
+		instance.stopChan = nil
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p r i v a t e
+//----------------------------------------------------------------------------------------------------------------------
+
+func (instance *HttpProxy) pick() *proxyUpstream {
+	healthy := make([]*proxyUpstream, 0, len(instance.upstreams))
+	for _, upstream := range instance.upstreams {
+		if atomic.LoadInt32(&upstream.healthy) == 1 {
+			healthy = append(healthy, upstream)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil
+	}
+	if instance.cfg.Balancing == BalancingLeastConn {
+		var response *proxyUpstream
+		for _, upstream := range healthy {
+			if nil == response || atomic.LoadInt64(&upstream.active) < atomic.LoadInt64(&response.active) {
+				response = upstream
+			}
+		}
+		return response
+	}
+	n := atomic.AddUint64(&instance.next, 1)
+	return healthy[(n-1)%uint64(len(healthy))]
+}
+
+// path returns path and query to append to the upstream url
+func (instance *HttpProxy) path(c *fiber.Ctx) string {
+	path := c.Path()
+	if instance.cfg.StripPrefix && len(instance.cfg.Prefix) > 0 {
+		path = strings.TrimPrefix(path, strings.TrimSuffix(instance.cfg.Prefix, "/"))
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+	}
+	if query := c.Context().QueryArgs().QueryString(); len(query) > 0 {
+		path += "?" + string(query)
+	}
+	return path
+}
+
+func (instance *HttpProxy) rewriteRequest(c *fiber.Ctx, upstream *proxyUpstream) {
+	header := &c.Request().Header
+	if forwarded := string(header.Peek(fiber.HeaderXForwardedFor)); len(forwarded) > 0 {
+		header.Set(fiber.HeaderXForwardedFor, forwarded+", "+c.IP())
+	} else {
+		header.Set(fiber.HeaderXForwardedFor, c.IP())
+	}
+	header.Set(fiber.HeaderXForwardedHost, c.Hostname())
+	header.Set(fiber.HeaderXForwardedProto, c.Protocol())
+	if !instance.cfg.PreserveHost {
+		header.SetHost(upstream.url.Host)
+	}
+	for k, v := range instance.cfg.RequestHeaders {
+		setHeader(header, k, v)
+	}
+}
+
+// tunnel passes a websocket upgrade through: the raw connection of the client is piped to the upstream
+func (instance *HttpProxy) tunnel(c *fiber.Ctx, upstream *proxyUpstream) error {
+	conn, err := dialUpstream(upstream.url, instance.timeout)
+	if nil != err {
+		return fiber.NewError(fiber.StatusBadGateway, err.Error())
+	}
+	header := new(fasthttp.RequestHeader)
+	c.Request().Header.CopyTo(header)
+	header.SetRequestURI(strings.TrimSuffix(upstream.url.Path, "/") + instance.path(c))
+	raw := header.Header()
+
+	atomic.AddInt64(&upstream.active, 1) // the tunnel outlives the handler
+	c.Context().HijackSetNoResponse(true)
+	c.Context().Hijack(func(client net.Conn) {
+		defer atomic.AddInt64(&upstream.active, -1)
+		defer conn.Close()
+		if _, err := conn.Write(raw); nil != err {
+			return
+		}
+		done := make(chan bool, 1)
+		go func() {
+			_, _ = io.Copy(conn, client)
+			done <- true
+		}()
+		_, _ = io.Copy(client, conn)
+		_ = client.Close()
+		<-done
+	})
+	return nil
+}
+
+func (instance *HttpProxy) checkHealth(path string, timeout time.Duration) {
+	if len(path) == 0 {
+		path = "/"
+	}
+	for _, upstream := range instance.upstreams {
+		status, _, err := instance.client.GetTimeout(nil, upstream.base+path, timeout)
+		if nil == err && status < 500 {
+			atomic.StoreInt32(&upstream.healthy, 1)
+		} else {
+			atomic.StoreInt32(&upstream.healthy, 0)
+		}
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	S T A T I C
+//----------------------------------------------------------------------------------------------------------------------
+
+type headerSetter interface {
+	Set(key, value string)
+	Del(key string)
+}
+
+func setHeader(header headerSetter, key, value string) {
+	if len(value) == 0 {
+		header.Del(key)
+	} else {
+		header.Set(key, value)
+	}
+}
+
+func hostName(host string) string {
+	if h, _, err := net.SplitHostPort(host); nil == err {
+		return h
+	}
+	return host
+}
+
+func dialUpstream(u *url.URL, timeout time.Duration) (net.Conn, error) {
+	address := u.Host
+	secure := u.Scheme == "https" || u.Scheme == "wss"
+	if len(u.Port()) == 0 {
+		if secure {
+			address += ":443"
+		} else {
+			address += ":80"
+		}
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	if secure {
+		return tls.DialWithDialer(dialer, "tcp", address, &tls.Config{ServerName: u.Hostname()})
+	}
+	return dialer.Dial("tcp", address)
+}
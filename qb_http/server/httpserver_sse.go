@@ -0,0 +1,483 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	qbc "github.com/rskvp/qb-core"
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//	c o n s t
+//----------------------------------------------------------------------------------------------------------------------
+
+const (
+	DefaultSSEHeartbeat  = 15 * time.Second
+	DefaultSSEReplaySize = 100
+
+	sseQueueSize = 256
+)
+
+var ErrSSEClosed = errors.New("sse_closed")
+
+//----------------------------------------------------------------------------------------------------------------------
+//	t y p e s
+//----------------------------------------------------------------------------------------------------------------------
+
+// SSEEvent is a Server-Sent Event. Empty fields are not written.
+type SSEEvent struct {
+	Id    string        `json:"id"`
+	Event string        `json:"event"` // name of the event, "message" for the browser if empty
+	Data  string        `json:"data"`  // multi-line data is written as several "data:" lines
+	Retry time.Duration `json:"-"`     // reconnection delay suggested to the browser
+
+	seq uint64 // order of the events published by the hub
+}
+
+// HttpSSEStream is the event stream of a client. It is open until the client disconnects or Close is called.
+type HttpSSEStream struct {
+	UUID        string
+	LastEventId string // "Last-Event-ID" header sent by a reconnecting browser
+
+	//-- private --//
+	ctx       *fiber.Ctx
+	app       *fiber.App
+	hub       *HttpSSEHub
+	queue     chan []byte
+	replay    [][]byte  // events to resume, written before the queue
+	replayed  chan bool // signals a new replay to run
+	closed    chan bool
+	closeOnce sync.Once
+	channels  map[string]bool
+	mux       sync.Mutex
+}
+
+// HttpSSEHub delivers the events published on a channel to all the streams subscribed to it.
+// The last events of each channel are kept to resume the reconnecting streams from their Last-Event-ID.
+type HttpSSEHub struct {
+
+	//-- private --//
+	channels   map[string]*sseChannel
+	streams    map[string]*HttpSSEStream
+	replaySize int
+	seq        uint64
+	mux        sync.Mutex
+}
+
+type sseChannel struct {
+	buffer      []*SSEEvent // last events, oldest first
+	subscribers map[string]*HttpSSEStream
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	c o n s t r u c t o r
+//----------------------------------------------------------------------------------------------------------------------
+
+func NewHttpSSEHub() *HttpSSEHub {
+	instance := new(HttpSSEHub)
+	instance.channels = make(map[string]*sseChannel)
+	instance.streams = make(map[string]*HttpSSEStream)
+	instance.replaySize = DefaultSSEReplaySize
+	return instance
+}
+
+func newSSEStream(c *fiber.Ctx, hub *HttpSSEHub) *HttpSSEStream {
+	instance := new(HttpSSEStream)
+	instance.UUID = qbc.Rnd.Uuid()
+	instance.LastEventId = c.Get("Last-Event-ID")
+	if len(instance.LastEventId) == 0 {
+		// polyfills without custom headers
+		instance.LastEventId = c.Query("lastEventId")
+	}
+	instance.ctx = c
+	instance.app = c.App()
+	instance.hub = hub
+	instance.queue = make(chan []byte, sseQueueSize)
+	instance.replayed = make(chan bool, 1)
+	instance.closed = make(chan bool)
+	instance.channels = make(map[string]bool)
+	return instance
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p u b l i c
+//----------------------------------------------------------------------------------------------------------------------
+
+// Handler returns the route streaming events. callback is invoked for each client, i.e. to subscribe
+// the stream to channels, and the stream stays open after it returns.
+func (instance *HttpSSEHub) Handler(heartbeat, retry time.Duration, callback func(stream *HttpSSEStream)) fiber.Handler {
+	if heartbeat <= 0 {
+		heartbeat = DefaultSSEHeartbeat
+	}
+	return func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderContentType, "text/event-stream")
+		c.Set(fiber.HeaderCacheControl, "no-cache")
+		c.Set(fiber.HeaderConnection, "keep-alive")
+		c.Set("X-Accel-Buffering", "no") // disable the buffering of nginx
+
+		stream := newSSEStream(c, instance)
+		instance.add(stream)
+		if nil != callback {
+			callback(stream)
+		}
+		stream.ctx = nil
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			stream.run(w, heartbeat, retry)
+		})
+		return nil
+	}
+}
+
+// Format returns the event in the text/event-stream format
+func (instance *SSEEvent) Format() []byte {
+	var sb strings.Builder
+	if len(instance.Id) > 0 {
+		sb.WriteString("id: " + singleLine(instance.Id) + "\n")
+	}
+	if len(instance.Event) > 0 {
+		sb.WriteString("event: " + singleLine(instance.Event) + "\n")
+	}
+	if instance.Retry > 0 {
+		sb.WriteString("retry: " + strconv.FormatInt(instance.Retry.Milliseconds(), 10) + "\n")
+	}
+	if len(instance.Data) > 0 {
+		for _, line := range strings.Split(strings.ReplaceAll(instance.Data, "\r\n", "\n"), "\n") {
+			sb.WriteString("data: " + line + "\n")
+		}
+	}
+	sb.WriteString("\n")
+	return []byte(sb.String())
+}
+
+// Ctx returns the request opening the stream. It is valid only during the callback of the SSE route.
+func (instance *HttpSSEStream) Ctx() *fiber.Ctx {
+	return instance.ctx
+}
+
+// Subscribe adds the stream to the channels of the hub. If the client is reconnecting, the events
+// published after LastEventId and still in the replay buffer are sent first.
+func (instance *HttpSSEStream) Subscribe(channels ...string) {
+	instance.mux.Lock()
+	for _, channel := range channels {
+		instance.channels[channel] = true
+	}
+	instance.mux.Unlock()
+	instance.hub.subscribe(instance, channels, instance.LastEventId)
+}
+
+func (instance *HttpSSEStream) Unsubscribe(channels ...string) {
+	instance.mux.Lock()
+	for _, channel := range channels {
+		delete(instance.channels, channel)
+	}
+	instance.mux.Unlock()
+	instance.hub.unsubscribe(instance, channels)
+}
+
+// Channels returns the subscribed channels
+func (instance *HttpSSEStream) Channels() []string {
+	instance.mux.Lock()
+	defer instance.mux.Unlock()
+	response := make([]string, 0, len(instance.channels))
+	for channel := range instance.channels {
+		response = append(response, channel)
+	}
+	sort.Strings(response)
+	return response
+}
+
+// Send writes an event to this stream only
+func (instance *HttpSSEStream) Send(event *SSEEvent) error {
+	return instance.write(event.Format())
+}
+
+// Comment writes a comment line, ignored by the browser
+func (instance *HttpSSEStream) Comment(text string) error {
+	return instance.write([]byte(": " + singleLine(text) + "\n\n"))
+}
+
+// Done is closed when the stream is closed
+func (instance *HttpSSEStream) Done() <-chan bool {
+	return instance.closed
+}
+
+func (instance *HttpSSEStream) Close() {
+	instance.closeOnce.Do(func() {
+		close(instance.closed)
+		instance.hub.remove(instance)
+	})
+}
+
+// SetReplaySize sets the number of events kept for each channel. 0 disables the replay
+func (instance *HttpSSEHub) SetReplaySize(size int) {
+	instance.mux.Lock()
+	defer instance.mux.Unlock()
+	if size < 0 {
+		size = 0
+	}
+	instance.replaySize = size
+	for _, channel := range instance.channels {
+		channel.trim(size)
+	}
+}
+
+// Publish sends an event to the subscribers of channel. Data is sent as is if a string or []byte,
+// otherwise as JSON. Returns the id assigned to the event.
+func (instance *HttpSSEHub) Publish(channel, event string, data interface{}) (string, error) {
+	var text string
+	switch v := data.(type) {
+	case string:
+		text = v
+	case []byte:
+		text = string(v)
+	case nil:
+	default:
+		b, err := json.Marshal(v)
+		if nil != err {
+			return "", err
+		}
+		text = string(b)
+	}
+	return instance.PublishEvent(channel, &SSEEvent{Event: event, Data: text}), nil
+}
+
+// PublishEvent sends an event to the subscribers of channel. Events without Id get an incremental id.
+func (instance *HttpSSEHub) PublishEvent(channel string, event *SSEEvent) string {
+	instance.mux.Lock()
+	instance.seq++
+	e := *event
+	e.seq = instance.seq
+	if len(e.Id) == 0 {
+		e.Id = strconv.FormatUint(e.seq, 10)
+	}
+	ch := instance.channel(channel)
+	if instance.replaySize > 0 {
+		ch.buffer = append(ch.buffer, &e)
+		ch.trim(instance.replaySize)
+	}
+	targets := make([]*HttpSSEStream, 0, len(ch.subscribers))
+	for _, stream := range ch.subscribers {
+		targets = append(targets, stream)
+	}
+	instance.mux.Unlock()
+
+	data := e.Format()
+	for _, stream := range targets {
+		_ = stream.write(data)
+	}
+	return e.Id
+}
+
+// Subscribers returns the number of streams subscribed to channel
+func (instance *HttpSSEHub) Subscribers(channel string) int {
+	instance.mux.Lock()
+	defer instance.mux.Unlock()
+	if ch, b := instance.channels[channel]; b {
+		return len(ch.subscribers)
+	}
+	return 0
+}
+
+// StreamsCount returns the number of open streams
+func (instance *HttpSSEHub) StreamsCount() int {
+	instance.mux.Lock()
+	defer instance.mux.Unlock()
+	return len(instance.streams)
+}
+
+// CloseStreams closes all the open streams
+func (instance *HttpSSEHub) CloseStreams() {
+	instance.closeStreams(nil)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p r i v a t e
+//----------------------------------------------------------------------------------------------------------------------
+
+// closeStreams closes the streams opened on app, all the streams if app is nil
+func (instance *HttpSSEHub) closeStreams(app *fiber.App) {
+	instance.mux.Lock()
+	streams := make([]*HttpSSEStream, 0, len(instance.streams))
+	for _, stream := range instance.streams {
+		if nil == app || stream.app == app {
+			streams = append(streams, stream)
+		}
+	}
+	instance.mux.Unlock()
+	for _, stream := range streams {
+		stream.Close()
+	}
+}
+
+// write queues data. A client too slow to consume the queue is disconnected.
+func (instance *HttpSSEStream) write(data []byte) error {
+	select {
+	case <-instance.closed:
+		return ErrSSEClosed
+	default:
+	}
+	select {
+	case instance.queue <- data:
+		return nil
+	default:
+		instance.Close()
+		return ErrSSEClosed
+	}
+}
+
+// resume adds events to the replay. The replay is not limited by the size of the queue.
+func (instance *HttpSSEStream) resume(events []*SSEEvent) {
+	if len(events) == 0 {
+		return
+	}
+	instance.mux.Lock()
+	for _, event := range events {
+		instance.replay = append(instance.replay, event.Format())
+	}
+	instance.mux.Unlock()
+	select {
+	case instance.replayed <- true:
+	default:
+	}
+}
+
+func (instance *HttpSSEStream) writeReplay(w *bufio.Writer) {
+	instance.mux.Lock()
+	replay := instance.replay
+	instance.replay = nil
+	instance.mux.Unlock()
+	for _, data := range replay {
+		_, _ = w.Write(data)
+	}
+}
+
+// run writes the queue to the client until the stream is closed or the client disconnects
+func (instance *HttpSSEStream) run(w *bufio.Writer, heartbeat time.Duration, retry time.Duration) {
+	defer instance.Close()
+	if retry > 0 {
+		_, _ = w.Write((&SSEEvent{Retry: retry}).Format())
+	}
+	// send the headers to the client
+	if err := w.Flush(); nil != err {
+		return
+	}
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-instance.closed:
+			return
+		case <-instance.replayed:
+			instance.writeReplay(w)
+		case data := <-instance.queue:
+			// events queued after a subscription follow its replay
+			instance.writeReplay(w)
+			_, _ = w.Write(data)
+			// write the queued events at once
+			for pending := len(instance.queue); pending > 0; pending-- {
+				_, _ = w.Write(<-instance.queue)
+			}
+		case <-ticker.C:
+			_, _ = w.WriteString(": ping\n\n")
+		}
+		if err := w.Flush(); nil != err {
+			// client disconnected
+			return
+		}
+	}
+}
+
+func (instance *HttpSSEHub) add(stream *HttpSSEStream) {
+	instance.mux.Lock()
+	defer instance.mux.Unlock()
+	instance.streams[stream.UUID] = stream
+}
+
+func (instance *HttpSSEHub) remove(stream *HttpSSEStream) {
+	instance.mux.Lock()
+	defer instance.mux.Unlock()
+	delete(instance.streams, stream.UUID)
+	for name, ch := range instance.channels {
+		delete(ch.subscribers, stream.UUID)
+		if len(ch.subscribers) == 0 && len(ch.buffer) == 0 {
+			delete(instance.channels, name)
+		}
+	}
+}
+
+// subscribe replays to the stream the events published after lastEventId on any of the channels.
+// The replay is added under the lock of the hub, before any event published later is queued.
+func (instance *HttpSSEHub) subscribe(stream *HttpSSEStream, channels []string, lastEventId string) {
+	instance.mux.Lock()
+	defer instance.mux.Unlock()
+	response := make([]*SSEEvent, 0)
+	var last uint64
+	if len(lastEventId) > 0 {
+		last = instance.lookup(lastEventId)
+	}
+	for _, name := range channels {
+		ch := instance.channel(name)
+		ch.subscribers[stream.UUID] = stream
+		if last > 0 {
+			for _, event := range ch.buffer {
+				if event.seq > last {
+					response = append(response, event)
+				}
+			}
+		}
+	}
+	sort.Slice(response, func(i, j int) bool {
+		return response[i].seq < response[j].seq
+	})
+	stream.resume(response)
+}
+
+func (instance *HttpSSEHub) unsubscribe(stream *HttpSSEStream, channels []string) {
+	instance.mux.Lock()
+	defer instance.mux.Unlock()
+	for _, name := range channels {
+		if ch, b := instance.channels[name]; b {
+			delete(ch.subscribers, stream.UUID)
+		}
+	}
+}
+
+// lookup returns the sequence of an event id. Ids no longer in the buffers are parsed as sequences:
+// the replay resumes from the oldest event kept.
+func (instance *HttpSSEHub) lookup(id string) uint64 {
+	for _, ch := range instance.channels {
+		for _, event := range ch.buffer {
+			if event.Id == id {
+				return event.seq
+			}
+		}
+	}
+	seq, _ := strconv.ParseUint(id, 10, 64)
+	return seq
+}
+
+func (instance *HttpSSEHub) channel(name string) *sseChannel {
+	ch, b := instance.channels[name]
+	if !b {
+		ch = &sseChannel{subscribers: make(map[string]*HttpSSEStream)}
+		instance.channels[name] = ch
+	}
+	return ch
+}
+
+func (instance *sseChannel) trim(size int) {
+	if len(instance.buffer) > size {
+		instance.buffer = append([]*SSEEvent{}, instance.buffer[len(instance.buffer)-size:]...)
+	}
+}
+
+func singleLine(value string) string {
+	return strings.NewReplacer("\r", "", "\n", " ").Replace(value)
+}
@@ -0,0 +1,1110 @@
+package server_test
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rskvp/qb-lib/qb_auth0"
+	"github.com/rskvp/qb-lib/qb_auth0/jwt"
+	"github.com/rskvp/qb-lib/qb_auth0/jwt/signing"
+	"github.com/rskvp/qb-lib/qb_http/server"
+)
+
+func freePort(t *testing.T) int {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func startServer(t *testing.T, settings map[string]interface{}) (*server.HttpServer, string) {
+	port := freePort(t)
+	settings["hosts"] = []interface{}{map[string]interface{}{"addr": fmt.Sprintf("127.0.0.1:%d", port)}}
+	if _, b := settings["server"]; !b {
+		settings["server"] = map[string]interface{}{}
+	}
+	settings["server"].(map[string]interface{})["disable_startup_message"] = true
+	s := server.NewHttpServer(t.TempDir(), nil, nil)
+	if err := s.ConfigureFromMap(settings); nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	return s, fmt.Sprintf("127.0.0.1:%d", port)
+}
+
+func get(t *testing.T, url string, header map[string]string) (int, http.Header, string) {
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	if host, b := header["Host"]; b {
+		req.Host = host
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, resp.Header, string(data)
+}
+
+func TestProxy(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	upstream := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/ws" {
+				conn, err := upgrader.Upgrade(w, r, nil)
+				if nil != err {
+					return
+				}
+				defer conn.Close()
+				for {
+					mt, message, err := conn.ReadMessage()
+					if nil != err {
+						return
+					}
+					_ = conn.WriteMessage(mt, []byte(name+":"+string(message)))
+				}
+			}
+			w.Header().Set("X-Internal", "secret")
+			_, _ = w.Write([]byte(name + " " + r.URL.RequestURI() + " " + r.Header.Get("X-Api-Key") + " " + r.Header.Get("X-Forwarded-Host")))
+		}))
+	}
+	a, b := upstream("a"), upstream("b")
+	defer a.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	s, addr := startServer(t, map[string]interface{}{
+		"proxy": []interface{}{
+			map[string]interface{}{
+				"enabled":          true,
+				"prefix":           "/api",
+				"strip_prefix":     true,
+				"upstreams":        []string{a.URL, b.URL, down.URL},
+				"request_headers":  map[string]string{"X-Api-Key": "key"},
+				"response_headers": map[string]string{"X-Internal": "", "X-Proxy": "qb"},
+				"websocket":        true,
+				"health_check":     map[string]interface{}{"enabled": true, "interval": 50},
+			},
+			map[string]interface{}{
+				"enabled":   true,
+				"host":      "b.local",
+				"upstreams": []string{b.URL},
+				"timeout":   1000,
+			},
+		},
+	})
+	s.Get("/hello", func(ctx *fiber.Ctx) error { return ctx.SendString("local") })
+	if errs := s.Start(); len(errs) > 0 {
+		t.Error(errs)
+		t.FailNow()
+	}
+	defer s.Stop()
+
+	// round-robin on healthy upstreams only
+	seen := map[string]int{}
+	for i := 0; i < 4; i++ {
+		status, header, body := get(t, "http://"+addr+"/api/items?id=1", nil)
+		if status != 200 || !strings.HasSuffix(body, " /items?id=1 key "+addr) {
+			t.Error("Unexpected proxy response", status, body)
+			t.FailNow()
+		}
+		if header.Get("X-Internal") != "" || header.Get("X-Proxy") != "qb" {
+			t.Error("Expected response headers rewritten", header)
+		}
+		seen[body[:1]]++
+	}
+	if seen["a"] != 2 || seen["b"] != 2 {
+		t.Error("Expected requests balanced on healthy upstreams", seen)
+	}
+
+	// virtual host and local routes
+	if _, _, body := get(t, "http://"+addr+"/hello", map[string]string{"Host": "b.local"}); !strings.HasPrefix(body, "b /hello") {
+		t.Error("Expected virtual host forwarded", body)
+	}
+	if _, _, body := get(t, "http://"+addr+"/hello", nil); body != "local" {
+		t.Error("Expected local route", body)
+	}
+
+	// websocket pass-through
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/api/ws", nil)
+	if nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	_ = conn.WriteMessage(websocket.TextMessage, []byte("ping"))
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, message, err := conn.ReadMessage(); nil != err || !strings.HasSuffix(string(message), ":ping") {
+		t.Error("Expected websocket echo", err, string(message))
+	}
+	_ = conn.Close()
+
+	// no healthy upstream
+	a.Close()
+	down.Close()
+	b.Close()
+	time.Sleep(200 * time.Millisecond)
+	if status, _, _ := get(t, "http://"+addr+"/api/items", nil); status != http.StatusBadGateway {
+		t.Error("Expected bad gateway", status)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	secrets := map[string]string{"access": "access-secret", "refresh": "refresh-secret"}
+	token := func(secretType string, payload map[string]interface{}, duration time.Duration) string {
+		claims := &qb_auth0.Auth0Claims{UserId: "u1", Payload: payload, SecretType: secretType}
+		claims.ExpiresAt = time.Now().Add(duration).Unix()
+		signed, err := jwt.NewWithClaims(signing.SigningMethodHS256, claims).SignedString([]byte(secrets[secretType]))
+		if nil != err {
+			t.Error(err)
+			t.FailNow()
+		}
+		return signed
+	}
+	s, addr := startServer(t, map[string]interface{}{
+		"auth": map[string]interface{}{
+			"enabled": true,
+			"auth0":   map[string]interface{}{"secrets": secrets},
+			"routes": []interface{}{
+				map[string]interface{}{"path": "/admin/*", "roles": []string{"admin"}},
+				map[string]interface{}{"path": "/team/*", "methods": []string{"POST"}, "claims": map[string]interface{}{"team": "blue"}},
+				map[string]interface{}{"path": "/api/*"},
+				map[string]interface{}{"path": "/secret"},
+			},
+		},
+	})
+	handler := func(ctx *fiber.Ctx) error {
+		claims := server.Claims(ctx)
+		return ctx.SendString(fmt.Sprintf("%v%v", claims["user_id"], claims["team"]))
+	}
+	s.Get("/public", handler)
+	s.Get("/api/me", handler)
+	s.Get("/admin/users", handler)
+	s.Post("/team/tasks", handler)
+	s.Get("/secret", handler)
+	if errs := s.Start(); len(errs) > 0 {
+		t.Error(errs)
+		t.FailNow()
+	}
+	defer s.Stop()
+
+	user := token("access", map[string]interface{}{"roles": []string{"user"}, "team": "blue"}, time.Minute)
+	admin := token("access", map[string]interface{}{"roles": "user,admin", "team": "red"}, time.Minute)
+	bearer := func(token string) map[string]string {
+		return map[string]string{"Authorization": "Bearer " + token}
+	}
+	request := func(method, path string, header map[string]string) (int, string) {
+		req, _ := http.NewRequest(method, "http://"+addr+path, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if nil != err {
+			t.Error(err)
+			t.FailNow()
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+
+	tests := []struct {
+		method, path string
+		header       map[string]string
+		status       int
+		body         string
+	}{
+		{"GET", "/public", nil, 200, "<nil><nil>"},
+		{"GET", "/api/me", nil, 401, `{"error":"unauthorized","message":"missing_token"}`},
+		{"GET", "/api/me", map[string]string{"Authorization": "Basic dTpw"}, 401, `{"error":"unauthorized","message":"missing_token"}`},
+		{"GET", "/api/me", bearer(user), 200, "u1blue"},
+		{"GET", "/api/me", bearer(token("access", nil, -time.Minute)), 401, ""},
+		{"GET", "/api/me", bearer(token("refresh", nil, time.Minute)), 401, `{"error":"unauthorized","message":"refresh_token_not_allowed"}`},
+		{"GET", "/admin/users", bearer(user), 403, `{"error":"forbidden","message":"missing_role: admin"}`},
+		{"GET", "/admin/users", bearer(admin), 200, "u1red"},
+		{"POST", "/team/tasks", bearer(admin), 403, `{"error":"forbidden","message":"missing_claim: team"}`},
+		{"POST", "/team/tasks", bearer(user), 200, "u1blue"},
+		// routes match whatever the case and the trailing slash
+		{"GET", "/ADMIN/users", nil, 401, `{"error":"unauthorized","message":"missing_token"}`},
+		{"GET", "/Admin/users", bearer(user), 403, ""},
+		{"GET", "/admin/users/", nil, 401, ""},
+		{"GET", "/secret", nil, 401, ""},
+		{"GET", "/secret/", nil, 401, ""},
+		{"GET", "/SECRET", nil, 401, ""},
+		{"GET", "/secret", bearer(user), 200, "u1blue"},
+	}
+	for _, test := range tests {
+		status, body := request(test.method, test.path, test.header)
+		if status != test.status || (len(test.body) > 0 && body != test.body) {
+			t.Error("Unexpected response", test.method, test.path, status, body)
+		}
+	}
+}
+
+func TestMetricsAndHealth(t *testing.T) {
+	s, addr := startServer(t, map[string]interface{}{
+		"metrics": map[string]interface{}{"enabled": true, "buckets": []float64{0.5, 0.1}},
+		"health":  map[string]interface{}{"enabled": true, "timeout": 100},
+		"limiter": map[string]interface{}{"enabled": true, "max": 4},
+	})
+	s.Get("/hello/:name", func(ctx *fiber.Ctx) error { return ctx.SendString("hello") })
+	s.Websocket("/ws", func(conn *server.HttpWebsocketConn) {})
+	var ready atomic.Bool
+	s.Health().AddLivenessCheck("self", func() error { return nil })
+	s.Health().AddReadinessCheck("database", func() error {
+		if !ready.Load() {
+			return errors.New("not_connected")
+		}
+		return nil
+	})
+	s.Health().AddReadinessCheck("slow", func() error {
+		if !ready.Load() {
+			time.Sleep(time.Second)
+		}
+		return nil
+	})
+	if errs := s.Start(); len(errs) > 0 {
+		t.Error(errs)
+		t.FailNow()
+	}
+	defer s.Stop()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/ws", nil)
+	if nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	defer conn.Close()
+	for i := 0; i < 4; i++ {
+		get(t, "http://"+addr+"/hello/"+fmt.Sprint(i), nil)
+	}
+
+	status, _, body := get(t, "http://"+addr+"/metrics", nil)
+	for _, line := range []string{
+		`qb_http_requests_total{method="GET",route="/hello/:name",status="200"} 3`,
+		`qb_http_requests_total{method="GET",route="/ws",status="101"} 1`,
+		`qb_http_requests_total{method="GET",route="/",status="429"} 1`,
+		`qb_http_request_duration_seconds_bucket{method="GET",route="/hello/:name",status="200",le="0.1"} 3`,
+		`qb_http_request_duration_seconds_bucket{method="GET",route="/hello/:name",status="200",le="+Inf"} 3`,
+		`qb_http_request_duration_seconds_count{method="GET",route="/hello/:name",status="200"} 3`,
+		"qb_http_requests_in_flight 1",
+		"qb_http_limiter_hits_total 1",
+		"qb_http_tls_reloads_total 0",
+		"qb_http_websocket_connections 1",
+	} {
+		if status != 200 || !strings.Contains(body, line+"\n") {
+			t.Error("Missing metric", line, "\n", body)
+			t.FailNow()
+		}
+	}
+
+	if status, _, body = get(t, "http://"+addr+"/healthz", nil); status != 200 || body != `{"status":"ok","checks":{"self":"ok"}}` {
+		t.Error("Unexpected liveness", status, body)
+	}
+	if status, _, body = get(t, "http://"+addr+"/readyz", nil); status != http.StatusServiceUnavailable ||
+		body != `{"status":"fail","checks":{"database":"not_connected","slow":"health_check_timeout"}}` {
+		t.Error("Unexpected readiness", status, body)
+	}
+	ready.Store(true)
+	if status, _, _ = get(t, "http://"+addr+"/readyz", nil); status != 200 {
+		t.Error("Expected ready", status)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	dir := t.TempDir()
+	s, addr := startServer(t, map[string]interface{}{
+		"server": map[string]interface{}{"enable_request_id": true},
+		"access_log": map[string]interface{}{
+			"enabled":         true,
+			"format":          "json",
+			"filename":        filepath.Join(dir, "access.log"),
+			"rotate_interval": 1,
+			"max_files":       2,
+			"skip":            []string{"/skip/*"},
+		},
+	})
+	entries := make(chan *server.AccessLogEntry, 10)
+	s.OnAccessLog(func(entry *server.AccessLogEntry) {
+		entries <- entry
+	})
+	s.Get("/hello", func(ctx *fiber.Ctx) error { return ctx.SendString("hello") })
+	s.Get("/skip/me", func(ctx *fiber.Ctx) error { return ctx.SendString("skip") })
+	if errs := s.Start(); len(errs) > 0 {
+		t.Error(errs)
+		t.FailNow()
+	}
+	defer s.Stop()
+
+	get(t, "http://"+addr+"/skip/me", nil)
+	get(t, "http://"+addr+"/hello?x=1", map[string]string{"Authorization": "Basic bWU6cHdk", "User-Agent": "test"})
+	entry := <-entries
+	if entry.URI != "/hello?x=1" || entry.Status != 200 || entry.Bytes != 5 || entry.User != "me" ||
+		entry.RemoteIP != "127.0.0.1" || entry.UserAgent != "test" || len(entry.RequestId) == 0 {
+		t.Error("Unexpected entry", entry)
+	}
+	line := entry.Format(server.AccessLogCombined)
+	if !strings.HasPrefix(line, "127.0.0.1 - me [") || !strings.Contains(line, "] \"GET /hello?x=1 HTTP/1.1\" 200 5 \"-\" \"test\" \""+entry.RequestId+"\" ") {
+		t.Error("Unexpected combined format", line)
+	}
+	for i := 0; i < 3; i++ {
+		time.Sleep(2 * time.Millisecond)
+		get(t, "http://"+addr+"/missing", nil)
+		if entry = <-entries; entry.Status != 404 {
+			t.Error("Expected not found", entry.Status)
+		}
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "access-*.log"))
+	data, err := os.ReadFile(filepath.Join(dir, "access.log"))
+	if nil != err || len(files) != 2 {
+		t.Error("Expected rotated files", err, files)
+		t.FailNow()
+	}
+	var current map[string]interface{}
+	if err = json.Unmarshal(data, &current); nil != err || current["uri"] != "/missing" || current["status"] != float64(404) {
+		t.Error("Unexpected log file", err, string(data))
+	}
+
+	// a failed rotation does not stop the log
+	_ = os.Remove(filepath.Join(dir, "access.log"))
+	time.Sleep(2 * time.Millisecond)
+	get(t, "http://"+addr+"/hello", nil)
+	<-entries
+	if data, err = os.ReadFile(filepath.Join(dir, "access.log")); nil != err || !strings.Contains(string(data), "/hello") {
+		t.Error("Expected log written after failed rotation", err, string(data))
+	}
+}
+
+func TestWebsocketRooms(t *testing.T) {
+	s, addr := startServer(t, map[string]interface{}{})
+	s.Websocket("/ws", func(ws *server.HttpWebsocketConn) {
+		ws.OnEvent("join", func(ws *server.HttpWebsocketConn, envelope *server.WebsocketEnvelope) (interface{}, error) {
+			ws.JoinRoom(fmt.Sprint(envelope.Data))
+			return ws.Rooms(), nil
+		})
+		ws.OnEvent("say", func(ws *server.HttpWebsocketConn, envelope *server.WebsocketEnvelope) (interface{}, error) {
+			return nil, ws.EmitRoom(envelope.Room, "said", envelope.Data)
+		})
+		ws.OnEvent("ask", func(ws *server.HttpWebsocketConn, envelope *server.WebsocketEnvelope) (interface{}, error) {
+			response, err := ws.Request("question", envelope.Data, time.Second)
+			if nil != err {
+				return nil, err
+			}
+			return response.Data, nil
+		})
+		ws.OnEvent("fail", func(ws *server.HttpWebsocketConn, envelope *server.WebsocketEnvelope) (interface{}, error) {
+			return nil, errors.New("failed")
+		})
+	})
+	if errs := s.Start(); len(errs) > 0 {
+		t.Error(errs)
+		t.FailNow()
+	}
+	defer s.Stop()
+
+	dial := func() *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/ws", nil)
+		if nil != err {
+			t.Error(err)
+			t.FailNow()
+		}
+		return conn
+	}
+	send := func(conn *websocket.Conn, envelope *server.WebsocketEnvelope) {
+		if err := conn.WriteJSON(envelope); nil != err {
+			t.Error(err)
+			t.FailNow()
+		}
+	}
+	// read returns the next envelope (pong messages are skipped), nil on timeout
+	read := func(conn *websocket.Conn, timeout time.Duration) *server.WebsocketEnvelope {
+		_ = conn.SetReadDeadline(time.Now().Add(timeout))
+		for {
+			mt, data, err := conn.ReadMessage()
+			if nil != err {
+				return nil
+			}
+			if mt == websocket.TextMessage {
+				var envelope server.WebsocketEnvelope
+				_ = json.Unmarshal(data, &envelope)
+				return &envelope
+			}
+		}
+	}
+	a, b, c := dial(), dial(), dial()
+	defer a.Close()
+	defer b.Close()
+	defer c.Close()
+
+	for i, conn := range []*websocket.Conn{a, b} {
+		send(conn, &server.WebsocketEnvelope{Event: "join", Id: fmt.Sprint("j", i), Data: "room1"})
+		if ack := read(conn, time.Second); nil == ack || ack.Ack != fmt.Sprint("j", i) || fmt.Sprint(ack.Data) != "[room1]" {
+			t.Error("Unexpected join acknowledge", ack)
+			t.FailNow()
+		}
+	}
+
+	// room broadcast
+	send(a, &server.WebsocketEnvelope{Event: "say", Room: "room1", Data: "hello"})
+	for _, conn := range []*websocket.Conn{a, b} {
+		if envelope := read(conn, time.Second); nil == envelope || envelope.Event != "said" || envelope.Room != "room1" || envelope.Data != "hello" {
+			t.Error("Expected room message", envelope)
+		}
+	}
+	if envelope := read(c, 200*time.Millisecond); nil != envelope {
+		t.Error("Unexpected message out of room", envelope)
+	}
+	c.Close()
+	c = dial()
+
+	// broadcast to all
+	send(b, &server.WebsocketEnvelope{Event: "say", Data: "all"})
+	for _, conn := range []*websocket.Conn{a, b, c} {
+		if envelope := read(conn, time.Second); nil == envelope || envelope.Data != "all" {
+			t.Error("Expected message to all", envelope)
+		}
+	}
+
+	// request from server to client inside a request from client to server
+	send(a, &server.WebsocketEnvelope{Event: "ask", Id: "q1", Data: "meaning"})
+	question := read(a, time.Second)
+	if nil == question || question.Event != "question" || len(question.Id) == 0 || question.Data != "meaning" {
+		t.Error("Expected question", question)
+		t.FailNow()
+	}
+	send(a, &server.WebsocketEnvelope{Ack: question.Id, Data: "42"})
+	if ack := read(a, time.Second); nil == ack || ack.Ack != "q1" || ack.Data != "42" {
+		t.Error("Expected answer", ack)
+	}
+	send(a, &server.WebsocketEnvelope{Event: "fail", Id: "f1"})
+	if ack := read(a, time.Second); nil == ack || ack.Ack != "f1" || ack.Error != "failed" {
+		t.Error("Expected error acknowledge", ack)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	settings := map[string]interface{}{
+		"limiter": map[string]interface{}{
+			"enabled": true,
+			"rules": []interface{}{
+				map[string]interface{}{"name": "api", "path": "/api/*", "key": "header:X-Api-Key", "max": 2, "duration": 3600000},
+				map[string]interface{}{"name": "bucket", "path": "/bucket", "algorithm": "token_bucket", "max": 1, "duration": 3600000, "burst": 2},
+			},
+			"storage": map[string]interface{}{"type": "bolt", "filename": filepath.Join(t.TempDir(), "limiter")},
+		},
+	}
+	s, addr := startServer(t, settings)
+	s.Get("/api/items", func(ctx *fiber.Ctx) error { return ctx.SendString("items") })
+	s.Get("/bucket", func(ctx *fiber.Ctx) error { return ctx.SendString("bucket") })
+	s.Get("/free", func(ctx *fiber.Ctx) error { return ctx.SendString("free") })
+	if errs := s.Start(); len(errs) > 0 {
+		t.Error(errs)
+		t.FailNow()
+	}
+
+	key := map[string]string{"X-Api-Key": "key-1"}
+	for i := 0; i < 2; i++ {
+		status, header, _ := get(t, "http://"+addr+"/api/items", key)
+		if status != 200 || header.Get("X-RateLimit-Limit") != "2" || header.Get("X-RateLimit-Remaining") != fmt.Sprint(1-i) ||
+			len(header.Get("X-RateLimit-Reset")) == 0 {
+			t.Error("Unexpected response", i, status, header)
+		}
+	}
+	status, header, _ := get(t, "http://"+addr+"/api/items", key)
+	if status != 429 || header.Get("X-RateLimit-Remaining") != "0" || len(header.Get("Retry-After")) == 0 {
+		t.Error("Expected limit reached", status, header)
+	}
+	// the rule applies whatever the case and the trailing slash
+	for _, path := range []string{"/API/items", "/api/items/", "/Api/Items/"} {
+		if status, _, _ = get(t, "http://"+addr+path, key); status != 429 {
+			t.Error("Expected limit reached", path, status)
+		}
+	}
+	if status, _, _ = get(t, "http://"+addr+"/api/items", map[string]string{"X-Api-Key": "key-2"}); status != 200 {
+		t.Error("Expected a limit per key", status)
+	}
+	for i := 0; i < 3; i++ {
+		status, header, _ = get(t, "http://"+addr+"/bucket", nil)
+		if (i < 2 && status != 200) || (i == 2 && status != 429) || header.Get("X-RateLimit-Limit") != "2" {
+			t.Error("Unexpected token bucket response", i, status, header)
+		}
+	}
+	if status, header, _ = get(t, "http://"+addr+"/free", nil); status != 200 || len(header.Get("X-RateLimit-Limit")) > 0 {
+		t.Error("Expected a path without limits", status, header)
+	}
+	_ = s.Stop()
+
+	// counters survive the restart
+	s, addr = startServer(t, settings)
+	s.Get("/api/items", func(ctx *fiber.Ctx) error { return ctx.SendString("items") })
+	if errs := s.Start(); len(errs) > 0 {
+		t.Error(errs)
+		t.FailNow()
+	}
+	defer s.Stop()
+	if status, _, _ = get(t, "http://"+addr+"/api/items", key); status != 429 {
+		t.Error("Expected limit kept on restart", status)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("upstream " + r.URL.RequestURI() + " " + r.Header.Get("X-Api-Key")))
+	}))
+	defer upstream.Close()
+
+	s, addr := startServer(t, map[string]interface{}{
+		"rewrite": map[string]interface{}{
+			"enabled": true,
+			"rules": []interface{}{
+				map[string]interface{}{"key": "/*", "action": "headers", "headers": map[string]string{"X-Beta": "yes"},
+					"conditions": []interface{}{map[string]interface{}{"type": "cookie", "name": "beta", "pattern": "^1$"}}},
+				map[string]interface{}{"key": "/old/*", "value": "/new/$1", "action": "redirect", "status": 301,
+					"conditions": []interface{}{map[string]interface{}{"type": "method", "pattern": "^GET$"}}},
+				map[string]interface{}{"key": "/secret", "value": "/login", "action": "redirect",
+					"conditions": []interface{}{map[string]interface{}{"type": "header", "name": "X-Token", "pattern": ".+", "negate": true}}},
+				map[string]interface{}{"key": "/legacy", "value": "/items/%1",
+					"conditions": []interface{}{map[string]interface{}{"type": "query", "name": "v", "pattern": "^(\\d+)$"}}},
+				map[string]interface{}{"key": "/a", "value": "/b", "flags": []string{"next"}},
+				map[string]interface{}{"key": "/b", "value": "/c"},
+				map[string]interface{}{"key": "/up/*", "value": upstream.URL + "/$1", "action": "proxy",
+					"headers": map[string]string{"X-Via": "rewrite"}, "request_headers": map[string]string{"X-Api-Key": "key"}},
+				map[string]interface{}{"key": "/v1/*", "value": "/$1"},
+				map[string]interface{}{"key": "/admin/remote/*", "value": upstream.URL + "/$1", "action": "proxy"},
+			},
+		},
+		"auth": map[string]interface{}{
+			"enabled": true,
+			"auth0":   map[string]interface{}{"secrets": map[string]string{"access": "access-secret"}},
+			"routes":  []interface{}{map[string]interface{}{"path": "/admin/*"}},
+		},
+	})
+	s.Get("/items/:id", func(ctx *fiber.Ctx) error { return ctx.SendString("item " + ctx.Params("id")) })
+	s.Get("/c", func(ctx *fiber.Ctx) error { return ctx.SendString("c") })
+	s.Get("/secret", func(ctx *fiber.Ctx) error { return ctx.SendString("secret") })
+	s.Get("/admin/users", func(ctx *fiber.Ctx) error { return ctx.SendString("users") })
+	if errs := s.Start(); len(errs) > 0 {
+		t.Error(errs)
+		t.FailNow()
+	}
+	defer s.Stop()
+
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get("http://" + addr + "/old/page?q=1")
+	if nil != err || resp.StatusCode != 301 || resp.Header.Get("Location") != "/new/page?q=1" {
+		t.Error("Expected redirect", err, resp)
+	}
+	resp, err = client.Get("http://" + addr + "/secret")
+	if nil != err || resp.StatusCode != 302 || resp.Header.Get("Location") != "/login" {
+		t.Error("Expected redirect without token", err, resp)
+	}
+	if status, _, body := get(t, "http://"+addr+"/secret", map[string]string{"X-Token": "t"}); status != 200 || body != "secret" {
+		t.Error("Expected no redirect with token", status, body)
+	}
+	if status, header, body := get(t, "http://"+addr+"/legacy?v=7", map[string]string{"Cookie": "beta=1"}); status != 200 ||
+		body != "item 7" || header.Get("X-Beta") != "yes" {
+		t.Error("Unexpected query rewrite", status, header, body)
+	}
+	if status, header, _ := get(t, "http://"+addr+"/legacy?v=x", nil); status != 404 || len(header.Get("X-Beta")) > 0 {
+		t.Error("Expected conditions not satisfied", status, header)
+	}
+	if status, _, body := get(t, "http://"+addr+"/a", nil); status != 200 || body != "c" {
+		t.Error("Expected chained rules", status, body)
+	}
+	if status, header, body := get(t, "http://"+addr+"/up/data?x=1", nil); status != 200 ||
+		body != "upstream /data?x=1 key" || header.Get("X-Via") != "rewrite" {
+		t.Error("Unexpected proxy", status, header, body)
+	}
+	// auth applies to the rewritten path
+	if status, _, body := get(t, "http://"+addr+"/v1/admin/users", nil); status != 401 || body == "users" {
+		t.Error("Expected protected rewritten path", status, body)
+	}
+	// and to the requests forwarded by proxy rules
+	if status, _, body := get(t, "http://"+addr+"/admin/remote/data", nil); status != 401 || strings.HasPrefix(body, "upstream") {
+		t.Error("Expected protected proxy rule", status, body)
+	}
+}
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+	s, addr := startServer(t, map[string]interface{}{
+		"local_ca": map[string]interface{}{"enabled": true, "dir": filepath.Join(dir, "ca"), "key_type": "rsa"},
+	})
+	s.ConfigureHosts(map[string]interface{}{
+		"addr": addr,
+		"tls":  true,
+		"certificates": []interface{}{
+			map[string]interface{}{"hosts": []string{"a.internal", "127.0.0.1"}},
+			map[string]interface{}{"cert": filepath.Join(dir, "b.pem"), "key": filepath.Join(dir, "b.key"), "hosts": []string{"b.internal"}},
+		},
+	})
+	s.Get("/hello", func(ctx *fiber.Ctx) error { return ctx.SendString("hello") })
+	if errs := s.Start(); len(errs) > 0 {
+		t.Error(errs)
+		t.FailNow()
+	}
+	defer s.Stop()
+
+	request := func(serverName string) (string, error) {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: s.LocalCA().RootPool(), ServerName: serverName},
+		}}
+		resp, err := client.Get("https://" + addr + "/hello")
+		if nil != err {
+			return "", err
+		}
+		defer resp.Body.Close()
+		return resp.TLS.PeerCertificates[0].Subject.CommonName, nil
+	}
+	for _, name := range []string{"a.internal", "b.internal", "127.0.0.1"} {
+		if cn, err := request(name); nil != err {
+			t.Error("Unexpected error", name, err)
+		} else if expected := strings.Replace(name, "127.0.0.1", "a.internal", 1); cn != expected {
+			t.Error("Unexpected certificate", name, cn)
+		}
+	}
+	if _, err := request("c.internal"); nil == err {
+		t.Error("Expected an invalid certificate for an unknown name")
+	}
+
+	// certificates are reloaded without restarting the listener
+	certPem, keyPem, err := s.LocalCA().Issue("b.internal", "c.internal")
+	if nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	_ = os.WriteFile(filepath.Join(dir, "b.key"), keyPem, 0600)
+	_ = os.WriteFile(filepath.Join(dir, "b.pem"), certPem, 0600)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if cn, err := request("c.internal"); nil == err && cn == "b.internal" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Error("Expected reloaded certificates")
+			break
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+func TestSSE(t *testing.T) {
+	s, addr := startServer(t, map[string]interface{}{
+		"sse": map[string]interface{}{"heartbeat": 100, "retry": 1500, "replay_size": 3},
+	})
+	s.SSE("/events/:channel", func(stream *server.HttpSSEStream) {
+		stream.Subscribe(stream.Ctx().Params("channel"))
+		_ = stream.Send(&server.SSEEvent{Event: "welcome", Data: stream.LastEventId})
+	})
+	s.SSE("/multi", func(stream *server.HttpSSEStream) {
+		stream.Subscribe("a", "b")
+		_ = stream.Send(&server.SSEEvent{Event: "welcome", Data: stream.LastEventId})
+	})
+	if errs := s.Start(); len(errs) > 0 {
+		t.Error(errs)
+		t.FailNow()
+	}
+	defer s.Stop()
+	hub := s.SSEHub()
+
+	connect := func(lastEventId string, path ...string) (*http.Response, func(ping bool) string) {
+		url := "http://" + addr + "/events/news"
+		if len(path) > 0 {
+			url = "http://" + addr + path[0]
+		}
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		if len(lastEventId) > 0 {
+			req.Header.Set("Last-Event-ID", lastEventId)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if nil != err {
+			t.Error(err)
+			t.FailNow()
+		}
+		if resp.Header.Get("Content-Type") != "text/event-stream" {
+			t.Error("Unexpected content type", resp.Header)
+		}
+		reader := bufio.NewReader(resp.Body)
+		// next returns the next event, skipping heartbeats unless ping
+		next := func(ping bool) string {
+			for {
+				lines := make([]string, 0)
+				for {
+					line, err := reader.ReadString('\n')
+					if nil != err {
+						return ""
+					}
+					if line = strings.TrimRight(line, "\n"); len(line) == 0 {
+						break
+					}
+					lines = append(lines, line)
+				}
+				if block := strings.Join(lines, "|"); ping || block != ": ping" {
+					return block
+				}
+			}
+		}
+		return resp, next
+	}
+
+	resp, next := connect("")
+	if event := next(false); event != "retry: 1500" {
+		t.Error("Expected retry", event)
+	}
+	if event := next(false); event != "event: welcome" {
+		t.Error("Expected welcome", event)
+	}
+	_, _ = hub.Publish("news", "update", "line1\nline2")
+	_, _ = hub.Publish("news", "", map[string]int{"value": 2})
+	_, _ = hub.Publish("other", "update", "not subscribed")
+	if event := next(false); event != "id: 1|event: update|data: line1|data: line2" {
+		t.Error("Unexpected event", event)
+	}
+	if event := next(false); event != "id: 2|data: {\"value\":2}" {
+		t.Error("Unexpected event", event)
+	}
+	if event := next(true); event != ": ping" {
+		t.Error("Expected heartbeat", event)
+	}
+	_ = resp.Body.Close()
+	for i := 0; hub.Subscribers("news") > 0 && i < 50; i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if hub.Subscribers("news") > 0 || hub.StreamsCount() > 0 {
+		t.Error("Expected closed stream")
+	}
+
+	// missed events still in the replay buffer are sent on reconnection
+	for i := 4; i <= 7; i++ {
+		_, _ = hub.Publish("news", "update", fmt.Sprint(i))
+	}
+	resp, next = connect("2")
+	defer resp.Body.Close()
+	next(false) // retry
+	for _, id := range []string{"5", "6", "7"} {
+		if event := next(false); event != "id: "+id+"|event: update|data: "+id {
+			t.Error("Unexpected replay", id, event)
+		}
+	}
+	if event := next(false); event != "event: welcome|data: 2" {
+		t.Error("Expected welcome after replay", event)
+	}
+
+	// the replay of several channels is not limited by the queue of the stream
+	hub.SetReplaySize(300)
+	first, _ := hub.Publish("a", "", "first")
+	for i := 0; i < 300; i++ {
+		_, _ = hub.Publish("a", "", fmt.Sprint("a", i))
+		_, _ = hub.Publish("b", "", fmt.Sprint("b", i))
+	}
+	resp, next = connect(first, "/multi")
+	defer resp.Body.Close()
+	next(false) // retry
+	for i := 0; i < 600; i++ {
+		if event := next(false); !strings.HasPrefix(event, "id: ") {
+			t.Error("Unexpected replay", i, event)
+			t.FailNow()
+		}
+	}
+	if event := next(false); event != "event: welcome|data: "+first {
+		t.Error("Expected welcome after replay", event)
+	}
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	s, addr := startServer(t, map[string]interface{}{
+		"server": map[string]interface{}{"shutdown_timeout": 3000},
+	})
+	s.Get("/slow", func(ctx *fiber.Ctx) error {
+		time.Sleep(500 * time.Millisecond)
+		return ctx.SendString("done")
+	})
+	s.Get("/fast", func(ctx *fiber.Ctx) error { return ctx.SendString("ok") })
+	s.Websocket("/ws", func(conn *server.HttpWebsocketConn) {})
+	if errs := s.Start(); len(errs) > 0 {
+		t.Error(errs)
+		t.FailNow()
+	}
+	defer s.Stop()
+
+	slow := func() chan string {
+		response := make(chan string, 1)
+		go func() {
+			resp, err := http.Get("http://" + addr + "/slow")
+			if nil != err {
+				response <- err.Error()
+				return
+			}
+			defer resp.Body.Close()
+			data, _ := io.ReadAll(resp.Body)
+			response <- string(data)
+		}()
+		time.Sleep(100 * time.Millisecond) // in flight
+		return response
+	}
+
+	// restart under load: no request is dropped
+	var count, failures atomic.Int32
+	stop := make(chan bool)
+	done := make(chan bool)
+	for i := 0; i < 4; i++ {
+		go func() {
+			for {
+				select {
+				case <-stop:
+					done <- true
+					return
+				default:
+				}
+				resp, err := http.Get("http://" + addr + "/fast")
+				if nil != err || resp.StatusCode != 200 {
+					t.Log(err)
+					failures.Add(1)
+				}
+				if nil != resp {
+					_, _ = io.Copy(io.Discard, resp.Body)
+					_ = resp.Body.Close()
+				}
+				count.Add(1)
+			}
+		}()
+	}
+	response := slow()
+	if errs := s.Restart(); len(errs) > 0 {
+		t.Error(errs)
+	}
+	time.Sleep(200 * time.Millisecond)
+	close(stop)
+	for i := 0; i < 4; i++ {
+		<-done
+	}
+	if body := <-response; body != "done" {
+		t.Error("Expected completed request", body)
+	}
+	if count.Load() == 0 || failures.Load() > 0 {
+		t.Error("Unexpected failures on restart", failures.Load(), count.Load())
+	}
+	if !s.IsOpen() {
+		t.Error("Expected open server")
+	}
+
+	// stop: websocket clients receive a close frame and in-flight requests are completed
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/ws", nil)
+	if nil != err {
+		t.Error(err)
+		t.FailNow()
+	}
+	defer conn.Close()
+	closed := make(chan error, 1)
+	go func() {
+		_, _, err := conn.ReadMessage()
+		closed <- err
+	}()
+	response = slow()
+	start := time.Now()
+	if err = s.Stop(); nil != err {
+		t.Error(err)
+	}
+	if body := <-response; body != "done" {
+		t.Error("Expected completed request", body)
+	}
+	if err = <-closed; !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Error("Expected close frame", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Error("Unexpected shutdown time", elapsed)
+	}
+	if c, err := net.DialTimeout("tcp", addr, time.Second); nil == err {
+		_ = c.Close()
+		t.Error("Expected closed listener")
+	}
+	if s.IsOpen() {
+		t.Error("Expected closed server")
+	}
+}
+
+func TestCache(t *testing.T) {
+	for _, storage := range []string{server.CacheStorageMemory, server.CacheStorageBolt} {
+		t.Run(storage, func(t *testing.T) {
+			s, addr := startServer(t, map[string]interface{}{
+				"cache": map[string]interface{}{
+					"enabled": true,
+					"rules": []interface{}{
+						map[string]interface{}{"path": "/items/*", "vary": []string{"Accept-Language"}},
+						map[string]interface{}{"path": "/private", "ttl": 60000},
+						map[string]interface{}{"path": "/short", "ttl": 300},
+					},
+					"storage": map[string]interface{}{"type": storage},
+				},
+			})
+			var calls atomic.Int32
+			s.Get("/items/*", func(ctx *fiber.Ctx) error {
+				calls.Add(1)
+				return ctx.SendString("items " + ctx.Query("a") + ctx.Query("b") + " " + ctx.Get("Accept-Language"))
+			})
+			s.Get("/private", func(ctx *fiber.Ctx) error {
+				calls.Add(1)
+				ctx.Set(fiber.HeaderCacheControl, "private")
+				return ctx.SendString("private")
+			})
+			s.Get("/short", func(ctx *fiber.Ctx) error {
+				calls.Add(1)
+				return ctx.SendString("short")
+			})
+			if errs := s.Start(); len(errs) > 0 {
+				t.Error(errs)
+				t.FailNow()
+			}
+			defer s.Stop()
+
+			status, header, body := get(t, "http://"+addr+"/items?b=2&a=1", nil)
+			etag, modified := header.Get("ETag"), header.Get("Last-Modified")
+			if status != 200 || body != "items 12 " || header.Get("X-Cache") != "MISS" || len(etag) == 0 || len(modified) == 0 {
+				t.Error("Unexpected response", status, body, header)
+			}
+			status, header, body = get(t, "http://"+addr+"/items?a=1&b=2", nil)
+			if status != 200 || body != "items 12 " || header.Get("X-Cache") != "HIT" || header.Get("ETag") != etag ||
+				!strings.Contains(header.Get("Vary"), "Accept-Language") || calls.Load() != 1 {
+				t.Error("Expected cached response", status, body, header, calls.Load())
+			}
+			if _, header, body = get(t, "http://"+addr+"/items?a=1&b=2", map[string]string{"Accept-Language": "it"}); body != "items 12 it" ||
+				header.Get("X-Cache") != "MISS" {
+				t.Error("Expected response by Vary", body, header)
+			}
+
+			// conditional requests
+			if status, _, body = get(t, "http://"+addr+"/items?a=1&b=2", map[string]string{"If-None-Match": etag}); status != 304 || len(body) > 0 {
+				t.Error("Expected not modified", status, body)
+			}
+			if status, _, _ = get(t, "http://"+addr+"/items?a=1&b=2", map[string]string{"If-None-Match": `"other"`}); status != 200 {
+				t.Error("Expected modified", status)
+			}
+			if status, _, _ = get(t, "http://"+addr+"/items?a=1&b=2", map[string]string{"If-Modified-Since": modified}); status != 304 {
+				t.Error("Expected not modified since", status)
+			}
+
+			// purge
+			if count, err := s.Cache().Purge(addr, "/items?b=2&a=1"); nil != err || count != 2 {
+				t.Error("Unexpected purge", count, err)
+			}
+			calls.Store(0)
+			get(t, "http://"+addr+"/items/1", nil)
+			get(t, "http://"+addr+"/items?a=1", nil)
+			if _, header, _ = get(t, "http://"+addr+"/items?a=1&b=2", nil); header.Get("X-Cache") != "MISS" || calls.Load() != 3 {
+				t.Error("Expected purged response", header, calls.Load())
+			}
+			if count, err := s.Cache().PurgePrefix(addr, "/items"); nil != err || count != 3 {
+				t.Error("Unexpected purge by prefix", count, err)
+			}
+
+			// responses are cached by host
+			calls.Store(0)
+			if _, _, body = get(t, "http://"+addr+"/items/x", map[string]string{"Host": "a.example"}); body != "items  " {
+				t.Error("Unexpected response", body)
+			}
+			if _, header, body = get(t, "http://"+addr+"/items/x", map[string]string{"Host": "b.example"}); body != "items  " ||
+				header.Get("X-Cache") != "MISS" || calls.Load() != 2 {
+				t.Error("Expected response of the host", body, header, calls.Load())
+			}
+			if count, err := s.Cache().PurgePrefix("a.example", "/items"); nil != err || count != 1 {
+				t.Error("Unexpected purge of the host", count, err)
+			}
+			if _, header, _ = get(t, "http://"+addr+"/items/x", map[string]string{"Host": "b.example"}); header.Get("X-Cache") != "HIT" {
+				t.Error("Expected response of the other host kept", header)
+			}
+
+			// not cacheable and expired responses
+			calls.Store(0)
+			get(t, "http://"+addr+"/private", nil)
+			get(t, "http://"+addr+"/private", nil)
+			get(t, "http://"+addr+"/short", nil)
+			get(t, "http://"+addr+"/short", nil)
+			if calls.Load() != 3 {
+				t.Error("Unexpected calls", calls.Load())
+			}
+			time.Sleep(1100 * time.Millisecond)
+			if _, header, _ = get(t, "http://"+addr+"/short", nil); header.Get("X-Cache") != "MISS" {
+				t.Error("Expected expired response", header)
+			}
+		})
+	}
+}
+
+func TestCacheAuthenticated(t *testing.T) {
+	secrets := map[string]string{"access": "access-secret", "refresh": "refresh-secret"}
+	bearer := func(userId string) map[string]string {
+		claims := &qb_auth0.Auth0Claims{UserId: userId, SecretType: "access"}
+		claims.ExpiresAt = time.Now().Add(time.Minute).Unix()
+		signed, err := jwt.NewWithClaims(signing.SigningMethodHS256, claims).SignedString([]byte(secrets["access"]))
+		if nil != err {
+			t.Error(err)
+			t.FailNow()
+		}
+		return map[string]string{"Authorization": "Bearer " + signed}
+	}
+	s, addr := startServer(t, map[string]interface{}{
+		"auth": map[string]interface{}{
+			"enabled": true,
+			"auth0":   map[string]interface{}{"secrets": secrets},
+			"routes":  []interface{}{map[string]interface{}{"path": "/api/*"}},
+		},
+		"cache": map[string]interface{}{
+			"enabled": true,
+			"rules":   []interface{}{map[string]interface{}{"path": "/*"}},
+		},
+	})
+	s.Get("/api/me", func(ctx *fiber.Ctx) error {
+		return ctx.SendString(fmt.Sprintf("profile of %v", server.Claims(ctx)["user_id"]))
+	})
+	s.Get("/api/catalog", func(ctx *fiber.Ctx) error {
+		ctx.Set(fiber.HeaderCacheControl, "public, max-age=60")
+		return ctx.SendString("catalog")
+	})
+	if errs := s.Start(); len(errs) > 0 {
+		t.Error(errs)
+		t.FailNow()
+	}
+	defer s.Stop()
+
+	if _, header, body := get(t, "http://"+addr+"/api/me", bearer("alice")); body != "profile of alice" || header.Get("X-Cache") != "MISS" {
+		t.Error("Unexpected response", body, header)
+	}
+	if _, header, body := get(t, "http://"+addr+"/api/me", bearer("bob")); body != "profile of bob" || header.Get("X-Cache") != "MISS" {
+		t.Error("Expected response of the user", body, header)
+	}
+	if status, _, _ := get(t, "http://"+addr+"/api/me", nil); status != 401 {
+		t.Error("Expected unauthorized", status)
+	}
+
+	// public responses are shared
+	get(t, "http://"+addr+"/api/catalog", bearer("alice"))
+	if _, header, body := get(t, "http://"+addr+"/api/catalog", bearer("bob")); body != "catalog" || header.Get("X-Cache") != "HIT" {
+		t.Error("Expected public cached response", body, header)
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"sync"
+
+	qbc "github.com/rskvp/qb-core"
+)
+
+var ErrMissingCertificate = errors.New("missing_certificate")
+
+//----------------------------------------------------------------------------------------------------------------------
+//	t y p e s
+//----------------------------------------------------------------------------------------------------------------------
+
+// tlsCertificates keeps the certificates of a TLS listener. The certificate is picked by SNI
+// (server name of the client hello) and files are reloaded without closing the listener.
+type tlsCertificates struct {
+	files        [][2]string // cert and key; the first is the default certificate
+	certificates []*tls.Certificate
+	mux          sync.RWMutex
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	c o n s t r u c t o r
+//----------------------------------------------------------------------------------------------------------------------
+
+func newTlsCertificates(files [][2]string) (*tlsCertificates, error) {
+	if len(files) == 0 {
+		return nil, ErrMissingCertificate
+	}
+	instance := new(tlsCertificates)
+	instance.files = files
+	if err := instance.load(); nil != err {
+		return nil, err
+	}
+	return instance, nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p r i v a t e
+//----------------------------------------------------------------------------------------------------------------------
+
+// load reads all files. On error the certificates in use are kept.
+func (instance *tlsCertificates) load() error {
+	certificates := make([]*tls.Certificate, 0, len(instance.files))
+	for _, pair := range instance.files {
+		cert, err := tls.LoadX509KeyPair(pair[0], pair[1])
+		if nil != err {
+			return qbc.Errors.Prefix(err, qbc.Strings.Format("Error loading Certificates: '%s' '%s'", pair[0], pair[1]))
+		}
+		if nil == cert.Leaf && len(cert.Certificate) > 0 {
+			// parsed once: used to match the server name
+			cert.Leaf, _ = x509.ParseCertificate(cert.Certificate[0])
+		}
+		certificates = append(certificates, &cert)
+	}
+	instance.mux.Lock()
+	instance.certificates = certificates
+	instance.mux.Unlock()
+	return nil
+}
+
+func (instance *tlsCertificates) config() *tls.Config {
+	return &tls.Config{GetCertificate: instance.get}
+}
+
+// get returns the first certificate valid for the server name of the client hello, or the default certificate
+func (instance *tlsCertificates) get(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	instance.mux.RLock()
+	defer instance.mux.RUnlock()
+	if len(instance.certificates) > 1 && len(hello.ServerName) > 0 {
+		for _, cert := range instance.certificates {
+			if nil == hello.SupportsCertificate(cert) {
+				return cert, nil
+			}
+		}
+	}
+	if len(instance.certificates) > 0 {
+		return instance.certificates[0], nil
+	}
+	return nil, ErrMissingCertificate
+}
@@ -18,6 +18,8 @@ type HttpWebsocket struct {
 	cfgHost   *ConfigHost
 	cfgRoutes []*httpServerConfigRouteWebsocket
 	pool      map[string]*HttpWebsocketConn
+	broker    IWebsocketBroker
+	hub       *websocketHub
 }
 
 //----------------------------------------------------------------------------------------------------------------------
@@ -36,7 +38,14 @@ func NewHttpWebsocket(app *fiber.App, cfgHost *ConfigHost, cfgRoutes []*httpServ
 	return instance
 }
 
+// SetBroker sets the fan-out of broadcasts. Must be called before Init. Default is in process.
+func (instance *HttpWebsocket) SetBroker(broker IWebsocketBroker) *HttpWebsocket {
+	instance.broker = broker
+	return instance
+}
+
 func (instance *HttpWebsocket) Init() {
+	instance.hub = newWebsocketHub(instance.pool, instance.broker)
 	app := instance.app
 	routes := instance.cfgRoutes
 	cfgHost := instance.cfgHost
@@ -79,7 +88,7 @@ func (instance *HttpWebsocket) Init() {
 			if len(route.Path) > 0 && nil != route.Handler {
 				app.Get(route.Path, websocket.New(func(c *websocket.Conn) {
 					if nil != route.Handler {
-						ws := newConnection(c, instance.pool)
+						ws := newConnection(c, instance.pool, instance.hub)
 						route.Handler(ws)
 						ws.Join() // lock waiting close
 					}
@@ -89,11 +98,52 @@ func (instance *HttpWebsocket) Init() {
 	}
 }
 
+// Close stops receiving broadcasts
+func (instance *HttpWebsocket) Close() {
+	if nil != instance.hub {
+		instance.hub.close()
+	}
+}
+
+// Drain sends a close frame (going away) to the clients and waits until they disconnect or timeout expires.
+// The clients still connected at the deadline are closed.
+func (instance *HttpWebsocket) Drain(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	message := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server_shutdown")
+	for _, conn := range instance.clients() {
+		_ = conn.conn.WriteControl(websocket.CloseMessage, message, deadline)
+	}
+	for instance.ClientsCount() > 0 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	for _, conn := range instance.clients() {
+		_ = conn.Shutdown(ErrWebsocketShutdown)
+	}
+}
+
+// ClientsCount returns the number of open connections, same as HttpWebsocketConn.ClientsCount
+func (instance *HttpWebsocket) ClientsCount() int {
+	poolMux.Lock()
+	defer poolMux.Unlock()
+	return len(instance.pool)
+}
+
 //----------------------------------------------------------------------------------------------------------------------
 //	p r i v a t e
 //----------------------------------------------------------------------------------------------------------------------
 
-func newConnection(c *websocket.Conn, pool map[string]*HttpWebsocketConn) *HttpWebsocketConn {
+func (instance *HttpWebsocket) clients() []*HttpWebsocketConn {
+	poolMux.Lock()
+	defer poolMux.Unlock()
+	response := make([]*HttpWebsocketConn, 0, len(instance.pool))
+	for _, conn := range instance.pool {
+		response = append(response, conn)
+	}
+	return response
+}
+
+func newConnection(c *websocket.Conn, pool map[string]*HttpWebsocketConn, hub *websocketHub) *HttpWebsocketConn {
 	ws := NewHttpWebsocketConn(c, pool)
+	ws.hub = hub
 	return ws
 }
@@ -1,7 +1,10 @@
 package server
 
 import (
+	"encoding/json"
+	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gofiber/websocket/v2"
@@ -44,6 +47,10 @@ const (
 
 var (
 	poolMux sync.Mutex
+
+	ErrWebsocketTimeout  = errors.New("websocket_timeout")
+	ErrWebsocketClosed   = errors.New("websocket_closed")
+	ErrWebsocketShutdown = errors.New("websocket_shutdown")
 )
 
 //----------------------------------------------------------------------------------------------------------------------
@@ -54,11 +61,16 @@ type HttpWebsocketConn struct {
 	UUID string
 
 	//-- pr i v a t e --//
-	pool   map[string]*HttpWebsocketConn
-	conn   *websocket.Conn
-	events *qb_events.Emitter
-	queue  []*Message
-	alive  bool
+	pool     map[string]*HttpWebsocketConn
+	hub      *websocketHub
+	conn     *websocket.Conn
+	events   *qb_events.Emitter
+	queue    []*Message
+	alive    atomic.Bool
+	closed   sync.Once // Shutdown is called by reader, writer and drain
+	mux      sync.Mutex
+	handlers map[string]WebsocketEventHandler
+	pending  map[string]chan *WebsocketEnvelope
 }
 
 type HttpWebsocketEventPayload struct {
@@ -72,6 +84,23 @@ type Message struct {
 	Data []byte
 }
 
+// WebsocketEnvelope is the JSON message exchanged with Emit, Request and OnEvent:
+//
+//	{"event":"chat", "id":"1", "data":{"text":"hello"}}   request expecting an acknowledge
+//	{"ack":"1", "data":"received"}                        acknowledge of request "1"
+type WebsocketEnvelope struct {
+	Event string      `json:"event,omitempty"`
+	Id    string      `json:"id,omitempty"`  // set on requests expecting an acknowledge
+	Ack   string      `json:"ack,omitempty"` // id of the acknowledged request
+	Room  string      `json:"room,omitempty"`
+	Data  interface{} `json:"data,omitempty"`
+	Error string      `json:"error,omitempty"`
+}
+
+// WebsocketEventHandler handles an event received from the client. If the client expects an
+// acknowledge, the returned data (or error) is sent back with the id of the request.
+type WebsocketEventHandler func(ws *HttpWebsocketConn, envelope *WebsocketEnvelope) (interface{}, error)
+
 type httpServerConfigRouteWebsocket struct {
 	Path    string
 	Handler func(c *HttpWebsocketConn)
@@ -87,6 +116,8 @@ func NewHttpWebsocketConn(conn *websocket.Conn, pool map[string]*HttpWebsocketCo
 	instance.conn = conn
 	instance.pool = pool
 	instance.events = qbc.Events.NewEmitter()
+	instance.handlers = make(map[string]WebsocketEventHandler)
+	instance.pending = make(map[string]chan *WebsocketEnvelope)
 
 	instance.register()
 
@@ -108,30 +139,35 @@ func (instance *HttpWebsocketConn) Locals(key string) interface{} {
 }
 
 func (instance *HttpWebsocketConn) Join() {
-	instance.alive = true
+	instance.alive.Store(true)
 	instance.run()
 }
 
 func (instance *HttpWebsocketConn) IsAlive() bool {
 	if nil != instance {
-		return instance.alive
+		return instance.alive.Load()
 	}
 
 	return false
 }
 
-func (instance *HttpWebsocketConn) Shutdown(err error) error {
+func (instance *HttpWebsocketConn) Shutdown(err error) (response error) {
 	if nil != instance && nil != instance.conn {
-		instance.unregister()
-		// close event
-		instance.events.Emit(OnDisconnectEvent, err)
-		instance.events.Clear()
-		// stop tickers
-		instance.alive = false
+		instance.closed.Do(func() {
+			instance.unregister()
+			if nil != instance.hub {
+				instance.hub.leaveAll(instance.UUID)
+			}
+			// close event
+			instance.events.Emit(OnDisconnectEvent, err)
+			instance.events.Clear()
+			// stop tickers
+			instance.alive.Store(false)
 
-		return instance.conn.Close()
+			response = instance.conn.Close()
+		})
 	}
-	return nil
+	return
 }
 
 func (instance *HttpWebsocketConn) Send(messageType int, data []byte) {
@@ -202,6 +238,118 @@ func (instance *HttpWebsocketConn) ClientByUUID(uuid string) *HttpWebsocketConn
 	return nil
 }
 
+//----------------------------------------------------------------------------------------------------------------------
+//	r o o m s
+//----------------------------------------------------------------------------------------------------------------------
+
+// JoinRoom adds the client to a room. Rooms are left on disconnect.
+func (instance *HttpWebsocketConn) JoinRoom(room string) {
+	if nil != instance && nil != instance.hub && len(room) > 0 {
+		instance.hub.join(room, instance.UUID)
+	}
+}
+
+func (instance *HttpWebsocketConn) LeaveRoom(room string) {
+	if nil != instance && nil != instance.hub {
+		instance.hub.leave(room, instance.UUID)
+	}
+}
+
+// Rooms returns the rooms joined by the client
+func (instance *HttpWebsocketConn) Rooms() []string {
+	if nil != instance && nil != instance.hub {
+		return instance.hub.roomsOf(instance.UUID)
+	}
+	return []string{}
+}
+
+// RoomClients returns the UUIDs of the clients of a room connected to this server
+func (instance *HttpWebsocketConn) RoomClients(room string) []string {
+	if nil != instance && nil != instance.hub {
+		return instance.hub.members(room)
+	}
+	return []string{}
+}
+
+// Broadcast sends a message to all clients, including the sender
+func (instance *HttpWebsocketConn) Broadcast(messageType int, data []byte) error {
+	return instance.publish("", messageType, data, "")
+}
+
+// BroadcastRoom sends a message to the clients of a room, including the sender
+func (instance *HttpWebsocketConn) BroadcastRoom(room string, messageType int, data []byte) error {
+	if len(room) == 0 {
+		return nil
+	}
+	return instance.publish(room, messageType, data, "")
+}
+
+// BroadcastRoomOthers sends a message to the clients of a room, except the sender
+func (instance *HttpWebsocketConn) BroadcastRoomOthers(room string, messageType int, data []byte) error {
+	if len(room) == 0 {
+		return nil
+	}
+	return instance.publish(room, messageType, data, instance.UUID)
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	e n v e l o p e s
+//----------------------------------------------------------------------------------------------------------------------
+
+// Emit sends an event to the client
+func (instance *HttpWebsocketConn) Emit(event string, data interface{}) error {
+	return instance.sendEnvelope(&WebsocketEnvelope{Event: event, Data: data})
+}
+
+// EmitRoom sends an event to the clients of a room. An empty room sends the event to all clients.
+func (instance *HttpWebsocketConn) EmitRoom(room, event string, data interface{}) error {
+	message, err := json.Marshal(&WebsocketEnvelope{Event: event, Room: room, Data: data})
+	if nil != err {
+		return err
+	}
+	return instance.publish(room, TextMessage, message, "")
+}
+
+// Request sends an event to the client and waits for its acknowledge
+func (instance *HttpWebsocketConn) Request(event string, data interface{}, timeout time.Duration) (*WebsocketEnvelope, error) {
+	if nil == instance || !instance.alive.Load() {
+		return nil, ErrWebsocketClosed
+	}
+	id := qbc.Rnd.Uuid()
+	ch := make(chan *WebsocketEnvelope, 1)
+	instance.mux.Lock()
+	instance.pending[id] = ch
+	instance.mux.Unlock()
+	defer func() {
+		instance.mux.Lock()
+		delete(instance.pending, id)
+		instance.mux.Unlock()
+	}()
+
+	if err := instance.sendEnvelope(&WebsocketEnvelope{Event: event, Id: id, Data: data}); nil != err {
+		return nil, err
+	}
+	select {
+	case response := <-ch:
+		if len(response.Error) > 0 {
+			return response, errors.New(response.Error)
+		}
+		return response, nil
+	case <-time.After(timeout):
+		return nil, ErrWebsocketTimeout
+	}
+}
+
+// OnEvent registers the handler of an event sent by the client with a WebsocketEnvelope.
+// Messages that are not envelopes are still notified to OnMessage.
+func (instance *HttpWebsocketConn) OnEvent(event string, handler WebsocketEventHandler) {
+	if nil != instance && nil != handler {
+		instance.mux.Lock()
+		instance.handlers[event] = handler
+		instance.mux.Unlock()
+	}
+}
+
 //----------------------------------------------------------------------------------------------------------------------
 //	e v e n t s
 //----------------------------------------------------------------------------------------------------------------------
@@ -250,6 +398,65 @@ func (instance *HttpWebsocketConn) unregister() {
 	poolMux.Unlock()
 }
 
+func (instance *HttpWebsocketConn) publish(room string, messageType int, data []byte, exclude string) error {
+	if nil == instance || nil == instance.hub {
+		return ErrWebsocketClosed
+	}
+	return instance.hub.publish(&WebsocketBroadcast{Room: room, Type: messageType, Data: data, Exclude: exclude})
+}
+
+func (instance *HttpWebsocketConn) sendEnvelope(envelope *WebsocketEnvelope) error {
+	message, err := json.Marshal(envelope)
+	if nil != err {
+		return err
+	}
+	instance.write(TextMessage, message)
+	return nil
+}
+
+// dispatch handles a message if it is an envelope with an event or an acknowledge
+func (instance *HttpWebsocketConn) dispatch(messageType int, data []byte) {
+	if messageType != TextMessage || len(data) == 0 || data[0] != '{' {
+		return
+	}
+	var envelope WebsocketEnvelope
+	if err := json.Unmarshal(data, &envelope); nil != err {
+		return
+	}
+	if len(envelope.Ack) > 0 {
+		instance.mux.Lock()
+		ch, b := instance.pending[envelope.Ack]
+		instance.mux.Unlock()
+		if b {
+			select {
+			case ch <- &envelope:
+			default:
+				// already acknowledged
+			}
+		}
+		return
+	}
+	if len(envelope.Event) == 0 {
+		return
+	}
+	instance.mux.Lock()
+	handler, b := instance.handlers[envelope.Event]
+	instance.mux.Unlock()
+	if !b {
+		return
+	}
+	go func() {
+		response, err := handler(instance, &envelope)
+		if len(envelope.Id) > 0 {
+			ack := &WebsocketEnvelope{Ack: envelope.Id, Data: response}
+			if nil != err {
+				ack.Error = err.Error()
+			}
+			_ = instance.sendEnvelope(ack)
+		}
+	}()
+}
+
 func (instance *HttpWebsocketConn) clientGet(uuid string) *HttpWebsocketConn {
 	var ws *HttpWebsocketConn
 	if nil != instance && nil != instance.pool {
@@ -267,23 +474,29 @@ func (instance *HttpWebsocketConn) run() {
 	// start sending a test message to client
 	go instance.pong()
 	// start reading incoming messages
-	go instance.read()
+	reading := make(chan bool)
+	go func() {
+		instance.read()
+		close(reading)
+	}()
 
 	// read message queue until the end and write to client stream
 	for range time.Tick(1 * time.Millisecond) {
 		if nil != instance {
-			if instance.alive {
-				if len(instance.queue) > 0 {
+			if instance.alive.Load() {
+				instance.mux.Lock()
+				queue := instance.queue
+				instance.queue = nil
+				instance.mux.Unlock()
+				if len(queue) > 0 {
 					// start loop on message buffer
-					for _, message := range instance.queue {
+					for _, message := range queue {
 						// write to client
 						err := instance.conn.WriteMessage(message.Type, message.Data)
 						if err != nil {
 							_ = instance.Shutdown(err)
 						}
 					}
-					// empty message buffer
-					instance.queue = nil
 				}
 			} else {
 				break
@@ -292,13 +505,14 @@ func (instance *HttpWebsocketConn) run() {
 			break
 		}
 	}
-	// exit, no more alive
+	// exit, no more alive: the connection is released when the reader is done with it
+	<-reading
 }
 
 func (instance *HttpWebsocketConn) pong() {
 	for range time.Tick(5 * time.Second) {
 		if nil != instance {
-			if instance.alive {
+			if instance.alive.Load() {
 				// test client is alive
 				instance.write(PongMessage, []byte{})
 			} else {
@@ -311,16 +525,18 @@ func (instance *HttpWebsocketConn) pong() {
 }
 
 func (instance *HttpWebsocketConn) write(mType int, data []byte) {
+	instance.mux.Lock()
 	instance.queue = append(instance.queue, &Message{
 		Type: mType,
 		Data: data,
 	})
+	instance.mux.Unlock()
 }
 
 func (instance *HttpWebsocketConn) read() {
 	for range time.Tick(10 * time.Millisecond) {
 		if nil != instance {
-			if instance.alive {
+			if instance.alive.Load() {
 				t, m, e := instance.conn.ReadMessage()
 				if e != nil {
 					_ = instance.Shutdown(e)
@@ -334,6 +550,7 @@ func (instance *HttpWebsocketConn) read() {
 					_ = instance.Shutdown(nil)
 					break
 				default:
+					instance.dispatch(t, m)
 					instance.events.Emit(OnMessageEvent, t, m)
 				}
 			} else {
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"sort"
+	"sync"
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//	t y p e s
+//----------------------------------------------------------------------------------------------------------------------
+
+// WebsocketBroadcast is a message sent to the clients of a room, or to all clients if Room is empty
+type WebsocketBroadcast struct {
+	Room    string `json:"room"`
+	Type    int    `json:"type"`
+	Data    []byte `json:"data"`
+	Exclude string `json:"exclude"` // UUID of a client not receiving the message (i.e. the sender)
+}
+
+// IWebsocketBroker fans out broadcasts to all the websocket servers sharing it.
+// The default implementation works in process: a distributed implementation (i.e. on redis or nats)
+// lets several server processes share rooms.
+type IWebsocketBroker interface {
+	Publish(message *WebsocketBroadcast) error
+	// Subscribe registers a callback receiving all published messages and returns the function removing it
+	Subscribe(callback func(message *WebsocketBroadcast)) (unsubscribe func())
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	WebsocketMemoryBroker
+//----------------------------------------------------------------------------------------------------------------------
+
+// WebsocketMemoryBroker is the in-process IWebsocketBroker
+type WebsocketMemoryBroker struct {
+	subscribers map[int]func(message *WebsocketBroadcast)
+	next        int
+	mux         sync.RWMutex
+}
+
+func NewWebsocketMemoryBroker() *WebsocketMemoryBroker {
+	instance := new(WebsocketMemoryBroker)
+	instance.subscribers = make(map[int]func(message *WebsocketBroadcast))
+	return instance
+}
+
+func (instance *WebsocketMemoryBroker) Publish(message *WebsocketBroadcast) error {
+	instance.mux.RLock()
+	callbacks := make([]func(message *WebsocketBroadcast), 0, len(instance.subscribers))
+	for _, callback := range instance.subscribers {
+		callbacks = append(callbacks, callback)
+	}
+	instance.mux.RUnlock()
+	for _, callback := range callbacks {
+		callback(message)
+	}
+	return nil
+}
+
+func (instance *WebsocketMemoryBroker) Subscribe(callback func(message *WebsocketBroadcast)) func() {
+	instance.mux.Lock()
+	defer instance.mux.Unlock()
+	id := instance.next
+	instance.next++
+	instance.subscribers[id] = callback
+	return func() {
+		instance.mux.Lock()
+		delete(instance.subscribers, id)
+		instance.mux.Unlock()
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	websocketHub
+//----------------------------------------------------------------------------------------------------------------------
+
+// websocketHub keeps the rooms of a connection pool and delivers the broadcasts received from the broker
+type websocketHub struct {
+	pool        map[string]*HttpWebsocketConn
+	rooms       map[string]map[string]bool // room -> client UUIDs
+	mux         sync.RWMutex
+	broker      IWebsocketBroker
+	unsubscribe func()
+}
+
+func newWebsocketHub(pool map[string]*HttpWebsocketConn, broker IWebsocketBroker) *websocketHub {
+	instance := new(websocketHub)
+	instance.pool = pool
+	instance.rooms = make(map[string]map[string]bool)
+	if nil == broker {
+		broker = NewWebsocketMemoryBroker()
+	}
+	instance.broker = broker
+	instance.unsubscribe = broker.Subscribe(instance.deliver)
+	return instance
+}
+
+func (instance *websocketHub) close() {
+	if nil != instance.unsubscribe {
+		instance.unsubscribe()
+		instance.unsubscribe = nil
+	}
+}
+
+func (instance *websocketHub) join(room, uuid string) {
+	instance.mux.Lock()
+	defer instance.mux.Unlock()
+	members, b := instance.rooms[room]
+	if !b {
+		members = make(map[string]bool)
+		instance.rooms[room] = members
+	}
+	members[uuid] = true
+}
+
+func (instance *websocketHub) leave(room, uuid string) {
+	instance.mux.Lock()
+	defer instance.mux.Unlock()
+	instance.remove(room, uuid)
+}
+
+func (instance *websocketHub) leaveAll(uuid string) {
+	instance.mux.Lock()
+	defer instance.mux.Unlock()
+	for room := range instance.rooms {
+		instance.remove(room, uuid)
+	}
+}
+
+func (instance *websocketHub) remove(room, uuid string) {
+	if members, b := instance.rooms[room]; b {
+		delete(members, uuid)
+		if len(members) == 0 {
+			delete(instance.rooms, room)
+		}
+	}
+}
+
+// roomsOf returns the rooms joined by a client
+func (instance *websocketHub) roomsOf(uuid string) []string {
+	instance.mux.RLock()
+	defer instance.mux.RUnlock()
+	response := make([]string, 0)
+	for room, members := range instance.rooms {
+		if members[uuid] {
+			response = append(response, room)
+		}
+	}
+	sort.Strings(response)
+	return response
+}
+
+// members returns the UUIDs of the local clients of a room
+func (instance *websocketHub) members(room string) []string {
+	instance.mux.RLock()
+	defer instance.mux.RUnlock()
+	response := make([]string, 0, len(instance.rooms[room]))
+	for uuid := range instance.rooms[room] {
+		response = append(response, uuid)
+	}
+	sort.Strings(response)
+	return response
+}
+
+func (instance *websocketHub) publish(message *WebsocketBroadcast) error {
+	return instance.broker.Publish(message)
+}
+
+// deliver sends a broadcast to the local clients
+func (instance *websocketHub) deliver(message *WebsocketBroadcast) {
+	targets := make([]*HttpWebsocketConn, 0)
+	poolMux.Lock()
+	if len(message.Room) == 0 {
+		for _, ws := range instance.pool {
+			targets = append(targets, ws)
+		}
+	} else {
+		instance.mux.RLock()
+		for uuid := range instance.rooms[message.Room] {
+			if ws, b := instance.pool[uuid]; b {
+				targets = append(targets, ws)
+			}
+		}
+		instance.mux.RUnlock()
+	}
+	poolMux.Unlock()
+	for _, ws := range targets {
+		if ws.UUID != message.Exclude {
+			ws.write(message.Type, message.Data)
+		}
+	}
+}
@@ -1,12 +1,15 @@
 package rewrite
 
 import (
+	"errors"
 	"regexp"
-	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/proxy"
 	qbc "github.com/rskvp/qb-core"
+	"github.com/valyala/fasthttp"
 )
 
 /// fork of https://github.com/gofiber/rewrite
@@ -16,6 +19,9 @@ const actionRoutes = "#ROUTES:" // only routes. Exclude files
 
 var actions = []string{actionIgnore, actionRoutes}
 
+// localsProxy is the locals of the request forwarded by Forward
+const localsProxy = "rewrite_proxy"
+
 // Config ...
 type Config struct {
 	// Filter defines a function to skip middleware.
@@ -29,22 +35,57 @@ type Config struct {
 	// "/api/*":            "/$1",
 	// "/js/*":             "/public/javascripts/$1",
 	// "/users/*/orders/*": "/user/$1/order/$2",
-	Rules []*Rule
+	Rules []*Rule `json:"rules"`
+
+	// ProxyTimeout is the timeout of the requests of "proxy" rules.
+	// Optional. Default: 30 seconds
+	ProxyTimeout time.Duration `json:"-"`
+
+	// DeferProxy leaves "proxy" rules to the Forward handler, i.e. to forward the requests after the
+	// authentication: the rules only choose the origin.
+	// Optional. Default: false, requests are forwarded by the rewrite handler
+	DeferProxy bool `json:"-"`
 
 	rulesRegex []*RegexWrapper // keep rules order
 }
 
+// Rule rewrites the requests with a path matching Key and satisfying all the Conditions.
+// A rule with only Key and Value rewrites the path.
 type Rule struct {
-	Key   string
-	Value string
+	Key   string `json:"key"`   // path pattern, i.e. "/old/*"
+	Value string `json:"value"` // new path, redirect location or proxy origin. Supports $n and %n captures
+	// Conditions on host, method, headers, query string and cookies. All must match
+	Conditions []*Condition `json:"conditions"`
+	// "rewrite", "redirect", "proxy" or "headers"
+	Action string `json:"action"` // default: "rewrite"
+	// Status of "redirect": 301, 302, 307 or 308
+	Status int `json:"status"` // default: 302
+	// Response headers set when the rule matches. An empty value removes the header
+	Headers map[string]string `json:"headers"`
+	// Request headers set when the rule matches (i.e. before a "proxy"). An empty value removes the header
+	RequestHeaders map[string]string `json:"request_headers"`
+	// "last", "next" or "continue"
+	Flags []string `json:"flags"`
+}
+
+// proxyTarget is a request to forward, chosen by a "proxy" rule
+type proxyTarget struct {
+	target   string
+	timeout  time.Duration
+	replacer *strings.Replacer
+	headers  map[string]string
 }
 
 type RegexWrapper struct {
 	matcher *regexp.Regexp
 	replace string
 	action  string
+	rule    *Rule
 }
 
+// maxIterations stops rules restarting forever with the "next" flag
+const maxIterations = 10
+
 // New fiber handler
 // app := fiber.New()
 //
@@ -69,14 +110,14 @@ func New(config ...interface{}) fiber.Handler {
 				Rules: make([]*Rule, 0),
 			}
 			for k, v := range m {
-				cfg.Rules = append(cfg.Rules, &Rule{k, qbc.Convert.ToString(v)})
+				cfg.Rules = append(cfg.Rules, &Rule{Key: k, Value: qbc.Convert.ToString(v)})
 			}
 		} else if m, ok := param.(map[string]string); ok {
 			cfg = Config{
 				Rules: make([]*Rule, 0),
 			}
 			for k, v := range m {
-				cfg.Rules = append(cfg.Rules, &Rule{k, v})
+				cfg.Rules = append(cfg.Rules, &Rule{Key: k, Value: v})
 			}
 		} else if a, ok := param.([]*Rule); ok {
 			cfg = Config{
@@ -86,17 +127,23 @@ func New(config ...interface{}) fiber.Handler {
 			cfg = Config{
 				Rules: make([]*Rule, 0),
 			}
-			for _, rule := range a {
-				cfg.Rules = append(cfg.Rules, &Rule{rule.Key, rule.Value})
+			for i := range a {
+				cfg.Rules = append(cfg.Rules, &a[i])
 			}
 		} else {
 			cfg = Config{}
 		}
+		if cfg.ProxyTimeout <= 0 {
+			cfg.ProxyTimeout = 30 * time.Second
+		}
 
 		cfg.rulesRegex = make([]*RegexWrapper, 0)
 
 		// Initialize
 		for _, rule := range cfg.Rules {
+			if nil == rule {
+				continue
+			}
 			k := rule.Key
 			v := rule.Value
 			var action string
@@ -108,11 +155,17 @@ func New(config ...interface{}) fiber.Handler {
 					break
 				}
 			}
+			if len(action) == 0 {
+				action = strings.ToLower(rule.Action)
+			}
+			for _, condition := range rule.Conditions {
+				condition.matcher = regexp.MustCompile(condition.Pattern)
+			}
 
 			k = strings.Replace(k, "*", "(.*)", -1)
 			k = k + "$"
 			rx := regexp.MustCompile(k)
-			cfg.rulesRegex = append(cfg.rulesRegex, &RegexWrapper{rx, v, action})
+			cfg.rulesRegex = append(cfg.rulesRegex, &RegexWrapper{rx, v, action, rule})
 		}
 
 		// Middleware function
@@ -122,28 +175,55 @@ func New(config ...interface{}) fiber.Handler {
 				return c.Next()
 			}
 			// Rewrite
-			for _, r := range cfg.rulesRegex {
+			iterations := 0
+			for i := 0; i < len(cfg.rulesRegex); i++ {
+				r := cfg.rulesRegex[i]
 				rx := r.matcher
 				action := r.action
 				v := r.replace
-				replacer := captureTokens(rx, c.Path())
-				if replacer != nil {
-					switch action {
-					case actionIgnore:
-						// do not rewrite due exclusion
+				groups := rx.FindStringSubmatch(c.Path())
+				if nil == groups {
+					continue
+				}
+				captures, matched := r.matchConditions(c)
+				if !matched {
+					continue
+				}
+				replacer := tokens(groups[1:], captures)
+				setHeaders(&c.Request().Header, replacer, r.rule.RequestHeaders)
+				setHeaders(&c.Response().Header, replacer, r.rule.Headers)
+				handled := true
+				switch action {
+				case actionIgnore:
+					// do not rewrite due exclusion
+					goto exit
+				case actionRoutes:
+					// rewrite only if path is not a file
+					ext := qbc.Paths.Extension(c.Path())
+					handled = len(ext) == 0 && rewrite(c, replacer, v)
+				case ActionRedirect:
+					return redirect(c, replacer, v, r.rule.Status)
+				case ActionProxy:
+					target := &proxyTarget{target: withQuery(c, replacer.Replace(v)), timeout: cfg.ProxyTimeout,
+						replacer: replacer, headers: r.rule.Headers}
+					if cfg.DeferProxy {
+						c.Locals(localsProxy, target)
+						return c.Next()
+					}
+					return target.forward(c)
+				case ActionHeaders:
+					handled = r.hasFlag(FlagLast) || r.hasFlag(FlagNext)
+				default:
+					handled = rewrite(c, replacer, v)
+				}
+				if handled {
+					if r.hasFlag(FlagNext) && iterations < maxIterations {
+						iterations++
+						i = -1 // restart from the first rule
+						continue
+					}
+					if !r.hasFlag(FlagContinue) {
 						goto exit
-					case actionRoutes:
-						// rewrite only if path is not a file
-						ext := qbc.Paths.Extension(c.Path())
-						if len(ext) == 0 {
-							if rewrite(c, replacer, v) {
-								goto exit
-							}
-						}
-					default:
-						if rewrite(c, replacer, v) {
-							goto exit
-						}
 					}
 				}
 			}
@@ -169,18 +249,58 @@ func rewrite(c *fiber.Ctx, replacer *strings.Replacer, v string) bool {
 	return false
 }
 
-// https://github.com/labstack/echo/blob/master/middleware/rewrite.go
-func captureTokens(pattern *regexp.Regexp, input string) *strings.Replacer {
-	groups := pattern.FindAllStringSubmatch(input, -1)
-	if groups == nil {
-		return nil
+// redirect sends the client to the location. The query string is kept if the location has none.
+func redirect(c *fiber.Ctx, replacer *strings.Replacer, v string, status int) error {
+	switch status {
+	case fiber.StatusMovedPermanently, fiber.StatusFound, fiber.StatusTemporaryRedirect, fiber.StatusPermanentRedirect:
+	default:
+		status = fiber.StatusFound
+	}
+	return c.Redirect(withQuery(c, replacer.Replace(v)), status)
+}
+
+// Forward is the handler forwarding the requests of the "proxy" rules when the Config has DeferProxy.
+// The other requests continue the chain.
+func Forward() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if p, b := c.Locals(localsProxy).(*proxyTarget); b {
+			return p.forward(c)
+		}
+		return c.Next()
 	}
-	values := groups[0][1:]
-	replace := make([]string, 2*len(values))
-	for i, v := range values {
-		j := 2 * i
-		replace[j] = "$" + strconv.Itoa(i+1)
-		replace[j+1] = v
+}
+
+// forward proxies the request to the origin, i.e. "http://backend:8080/$1"
+func (instance *proxyTarget) forward(c *fiber.Ctx) error {
+	if err := proxy.DoTimeout(c, instance.target, instance.timeout); nil != err {
+		if errors.Is(err, fasthttp.ErrTimeout) {
+			return c.SendStatus(fiber.StatusGatewayTimeout)
+		}
+		return c.SendStatus(fiber.StatusBadGateway)
+	}
+	// the upstream response replaced the headers set before
+	setHeaders(&c.Response().Header, instance.replacer, instance.headers)
+	return nil
+}
+
+func withQuery(c *fiber.Ctx, location string) string {
+	if query := c.Request().URI().QueryString(); len(query) > 0 && !strings.Contains(location, "?") {
+		return location + "?" + string(query)
+	}
+	return location
+}
+
+type headerSetter interface {
+	Set(key, value string)
+	Del(key string)
+}
+
+func setHeaders(header headerSetter, replacer *strings.Replacer, headers map[string]string) {
+	for k, v := range headers {
+		if len(v) == 0 {
+			header.Del(k)
+		} else {
+			header.Set(k, replacer.Replace(v))
+		}
 	}
-	return strings.NewReplacer(replace...)
 }
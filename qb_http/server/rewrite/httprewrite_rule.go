@@ -0,0 +1,149 @@
+package rewrite
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//	c o n s t
+//----------------------------------------------------------------------------------------------------------------------
+
+const (
+	ActionRewrite  = "rewrite"  // rewrite the path (default)
+	ActionRedirect = "redirect" // external redirect to Value
+	ActionProxy    = "proxy"    // forward the request to the origin in Value
+	ActionHeaders  = "headers"  // only set the headers
+
+	FlagLast     = "last"     // stop processing the rules (default for rewrite, redirect and proxy)
+	FlagNext     = "next"     // restart processing from the first rule with the rewritten path
+	FlagContinue = "continue" // process the following rules (default for headers)
+
+	ConditionHost   = "host"
+	ConditionMethod = "method"
+	ConditionHeader = "header"
+	ConditionQuery  = "query"
+	ConditionCookie = "cookie"
+	ConditionPath   = "path"
+)
+
+//----------------------------------------------------------------------------------------------------------------------
+//	t y p e s
+//----------------------------------------------------------------------------------------------------------------------
+
+// Condition matches a part of the request against a regular expression.
+// Groups captured by the last matching condition are available in the rule values as %1, %2 and so on.
+type Condition struct {
+	// "host", "method", "header", "query", "cookie" or "path"
+	Type string `json:"type"`
+	// Name of the header, query argument or cookie. Empty "query" matches the whole query string
+	Name string `json:"name"`
+	// Regular expression, i.e. "^(www\\.)?example\\.com$". Missing values are matched as empty strings
+	Pattern string `json:"pattern"`
+	// Negate matches when the pattern does not match
+	Negate bool `json:"negate"`
+
+	matcher *regexp.Regexp
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p u b l i c
+//----------------------------------------------------------------------------------------------------------------------
+
+// Match returns the captured groups, or false if the condition is not satisfied
+func (instance *Condition) Match(c *fiber.Ctx) ([]string, bool) {
+	matcher := instance.matcher // compiled by New
+	if nil == matcher {
+		matcher = regexp.MustCompile(instance.Pattern)
+	}
+	groups := matcher.FindStringSubmatch(instance.value(c))
+	if instance.Negate {
+		return nil, nil == groups
+	}
+	if nil == groups {
+		return nil, false
+	}
+	return groups[1:], true
+}
+
+// Validate checks the regular expressions of the rules: New panics on invalid expressions
+func Validate(rules []*Rule) error {
+	for _, rule := range rules {
+		if nil == rule {
+			continue
+		}
+		if _, err := regexp.Compile(strings.Replace(rule.Key, "*", "(.*)", -1) + "$"); nil != err {
+			return err
+		}
+		for _, condition := range rule.Conditions {
+			if _, err := regexp.Compile(condition.Pattern); nil != err {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+//	p r i v a t e
+//----------------------------------------------------------------------------------------------------------------------
+
+func (instance *Condition) value(c *fiber.Ctx) string {
+	switch strings.ToLower(instance.Type) {
+	case ConditionHost:
+		return c.Hostname()
+	case ConditionMethod:
+		return c.Method()
+	case ConditionHeader:
+		return c.Get(instance.Name)
+	case ConditionQuery:
+		if len(instance.Name) == 0 {
+			return string(c.Request().URI().QueryString())
+		}
+		return c.Query(instance.Name)
+	case ConditionCookie:
+		return c.Cookies(instance.Name)
+	default:
+		return c.Path()
+	}
+}
+
+func (instance *RegexWrapper) hasFlag(flag string) bool {
+	for _, f := range instance.rule.Flags {
+		if strings.EqualFold(f, flag) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchConditions returns the groups captured by the last condition with captures
+func (instance *RegexWrapper) matchConditions(c *fiber.Ctx) ([]string, bool) {
+	var response []string
+	for _, condition := range instance.rule.Conditions {
+		groups, b := condition.Match(c)
+		if !b {
+			return nil, false
+		}
+		if len(groups) > 0 {
+			response = groups
+		}
+	}
+	return response, true
+}
+
+// tokens replaces $n with the groups of the path and %n with the groups of the conditions
+func tokens(path, conditions []string) *strings.Replacer {
+	replace := make([]string, 0, 2*(len(path)+len(conditions)))
+	// higher indexes first: $10 must not be replaced as $1
+	for i := len(path) - 1; i >= 0; i-- {
+		replace = append(replace, "$"+strconv.Itoa(i+1), path[i])
+	}
+	for i := len(conditions) - 1; i >= 0; i-- {
+		replace = append(replace, "%"+strconv.Itoa(i+1), conditions[i])
+	}
+	return strings.NewReplacer(replace...)
+}
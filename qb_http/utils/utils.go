@@ -24,7 +24,7 @@ func NewResponseDataEmpty() *ResponseData {
 func NewResponseData(res *fasthttp.Response) *ResponseData {
 	instance := new(ResponseData)
 	instance.StatusCode = res.StatusCode()
-	instance.Body = res.Body()
+	instance.Body = append([]byte(nil), res.Body()...) // res is released by the caller
 	instance.Header = responseHeaderToMap(res.Header.String())
 
 	return instance
@@ -106,7 +106,7 @@ func responseHeaderToMap(text string) map[string]string {
 		for _, v := range tokens {
 			pair := qbc.Strings.Split(v, ":")
 			if len(pair) > 1 {
-				response[pair[0]] = strings.Join(pair[1:], ":")
+				response[strings.TrimSpace(pair[0])] = strings.TrimSpace(strings.Join(pair[1:], ":"))
 			} else {
 				response["http"] = v
 			}
@@ -128,6 +128,47 @@ func (instance *JsElasticEngine) get(call goja.FunctionCall) goja.Value {
 	return goja.Undefined()
 }
 
+func (instance *JsElasticEngine) remove(call goja.FunctionCall) goja.Value {
+	if nil != instance {
+		err := instance.init()
+		if nil != err {
+			panic(instance.runtime.NewTypeError(err.Error()))
+		}
+
+		var group, key string
+		switch len(call.Arguments) {
+		case 2:
+			group = commons.GetString(call, 0)
+			key = commons.GetString(call, 1)
+		default:
+			panic(instance.runtime.NewTypeError(commons.ErrorMissingParam))
+		}
+		err = instance.engine.Remove(group, key)
+		if nil != err {
+			panic(instance.runtime.NewTypeError(err.Error()))
+		}
+	}
+	return goja.Undefined()
+}
+
+func (instance *JsElasticEngine) removeGroup(call goja.FunctionCall) goja.Value {
+	if nil != instance {
+		err := instance.init()
+		if nil != err {
+			panic(instance.runtime.NewTypeError(err.Error()))
+		}
+
+		if len(call.Arguments) != 1 {
+			panic(instance.runtime.NewTypeError(commons.ErrorMissingParam))
+		}
+		err = instance.engine.RemoveGroup(commons.GetString(call, 0))
+		if nil != err {
+			panic(instance.runtime.NewTypeError(err.Error()))
+		}
+	}
+	return goja.Undefined()
+}
+
 //----------------------------------------------------------------------------------------------------------------------
 //	p r i v a t e
 //----------------------------------------------------------------------------------------------------------------------
@@ -154,6 +195,8 @@ func (instance *JsElasticEngine) export() {
 	_ = o.Set("close", instance.close)
 	_ = o.Set("put", instance.put)
 	_ = o.Set("get", instance.get)
+	_ = o.Set("remove", instance.remove)
+	_ = o.Set("removeGroup", instance.removeGroup)
 }
 
 //----------------------------------------------------------------------------------------------------------------------